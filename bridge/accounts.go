@@ -0,0 +1,159 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// accountSeparator separates the account name from the Hostex conversation
+// ID in the portal IDs of additional accounts. The main account's portals use
+// the plain conversation ID.
+const accountSeparator = "/"
+
+// hostexAccount is an additional Hostex account with its own poller.
+type hostexAccount struct {
+    Name   string
+    Client HostexAPI
+}
+
+// AddAccount registers an additional Hostex account. It must be called
+// before Start.
+func (b *Bridge) AddAccount(name string, client HostexAPI) {
+    b.accounts = append(b.accounts, &hostexAccount{Name: name, Client: client})
+}
+
+// accountNames returns the names of all accounts, with an empty string for
+// the main account.
+func (b *Bridge) accountNames() []string {
+    names := []string{""}
+    for _, account := range b.accounts {
+        names = append(names, account.Name)
+    }
+    return names
+}
+
+// hostexClient returns the API client of an account, which is the main
+// client for the main account or an unknown one.
+func (b *Bridge) hostexClient(account string) HostexAPI {
+    for _, acc := range b.accounts {
+        if acc.Name == account {
+            return acc.Client
+        }
+    }
+    return b.HostexClient
+}
+
+// getAllConversations returns the conversations of every account, with
+// portal IDs as conversation IDs.
+func (b *Bridge) getAllConversations(ctx context.Context) ([]hostexapi.Conversation, error) {
+    var all []hostexapi.Conversation
+    for _, account := range b.accountNames() {
+        conversations, err := b.hostexClient(account).GetConversations(ctx)
+        if err != nil {
+            return nil, fmt.Errorf("%s account: %w", accountLabel(account), err)
+        }
+        for _, conv := range conversations {
+            conv.ID = portalKey(account, conv.ID)
+            all = append(all, conv)
+        }
+    }
+    return all, nil
+}
+
+// getAllReservations returns the reservations of every account between the
+// dates, with portal IDs as conversation IDs.
+func (b *Bridge) getAllReservations(ctx context.Context, start, end string) ([]hostexapi.Reservation, error) {
+    var all []hostexapi.Reservation
+    for _, account := range b.accountNames() {
+        reservations, err := b.getAccountReservations(ctx, account, start, end)
+        if err != nil {
+            return nil, fmt.Errorf("%s account: %w", accountLabel(account), err)
+        }
+        all = append(all, reservations...)
+    }
+    return all, nil
+}
+
+// getAccountReservations returns the reservations of an account between the
+// dates, with portal IDs as conversation IDs.
+func (b *Bridge) getAccountReservations(ctx context.Context, account, start, end string) ([]hostexapi.Reservation, error) {
+    reservations, err := b.hostexClient(account).GetReservations(ctx, start, end)
+    if err != nil {
+        return nil, err
+    }
+    for i, res := range reservations {
+        if res.ConversationID != "" {
+            reservations[i].ConversationID = portalKey(account, res.ConversationID)
+        }
+    }
+    return reservations, nil
+}
+
+// getReservation looks up a reservation by its code in every account, and
+// returns it with the portal ID as conversation ID, or nil if there's none.
+func (b *Bridge) getReservation(ctx context.Context, code string) (*hostexapi.Reservation, error) {
+    for _, account := range b.accountNames() {
+        res, err := b.hostexClient(account).GetReservation(ctx, code)
+        if err != nil {
+            return nil, fmt.Errorf("%s account: %w", accountLabel(account), err)
+        } else if res != nil {
+            if res.ConversationID != "" {
+                res.ConversationID = portalKey(account, res.ConversationID)
+            }
+            return res, nil
+        }
+    }
+    return nil, nil
+}
+
+// reservationAccount returns the account a reservation seen by the
+// reservation watcher belongs to, or the main account if it's unknown.
+func (b *Bridge) reservationAccount(reservationCode string) (string, error) {
+    hostexID, err := b.DB.GetReservationConversation(reservationCode)
+    if err != nil {
+        return "", err
+    }
+    account, _ := splitPortalKey(hostexID)
+    return account, nil
+}
+
+func portalKey(account, conversationID string) string {
+    if account == "" {
+        return conversationID
+    }
+    return account + accountSeparator + conversationID
+}
+
+func splitPortalKey(key string) (account, conversationID string) {
+    if account, conversationID, ok := strings.Cut(key, accountSeparator); ok {
+        return account, conversationID
+    }
+    return "", key
+}
+
+// Account returns the name of the Hostex account of the portal, or an empty
+// string for the main account.
+func (p *Portal) Account() string {
+    account, _ := splitPortalKey(p.ID)
+    return account
+}
+
+// conversationID returns the Hostex conversation ID of the portal.
+func (p *Portal) conversationID() string {
+    _, conversationID := splitPortalKey(p.ID)
+    return conversationID
+}
+
+func (p *Portal) client() HostexAPI {
+    return p.bridge.hostexClient(p.Account())
+}
+
+func accountLabel(account string) string {
+    if account == "" {
+        return "main"
+    }
+    return account
+}
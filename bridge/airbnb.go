@@ -0,0 +1,76 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+const channelAirbnb = "airbnb"
+
+// requireAirbnb tells the room and returns false if the portal isn't an
+// Airbnb conversation.
+func (p *Portal) requireAirbnb(ctx context.Context, command string) bool {
+    if !strings.EqualFold(p.Info.ChannelType, channelAirbnb) {
+        p.sendNotice(ctx, fmt.Sprintf("%s only works in Airbnb conversations, this one is from %s.", command, p.Info.ChannelType))
+        return false
+    }
+    return true
+}
+
+func (p *Portal) preapprove(ctx context.Context) {
+    if !p.requireAirbnb(ctx, "!preapprove") {
+        return
+    }
+    err := p.client().PreapproveInquiry(ctx, p.conversationID())
+    if err != nil {
+        p.bridge.Logger.Error("Failed to pre-approve inquiry", zap.String("hostex_id", p.ID), zap.Error(err))
+        p.sendNotice(ctx, fmt.Sprintf("Failed to pre-approve the inquiry: %v", err))
+        return
+    }
+    p.sendNotice(ctx, fmt.Sprintf("Pre-approved %s's inquiry for %s to %s.", p.Info.Guest.Name, p.Info.CheckInDate, p.Info.CheckOutDate))
+}
+
+// sendSpecialOffer sends an Airbnb special offer for the inquiry's dates, or
+// other dates given after the price.
+func (p *Portal) sendSpecialOffer(ctx context.Context, args []string) {
+    const usage = "Usage: !specialoffer <total price> [check-in YYYY-MM-DD] [check-out YYYY-MM-DD]"
+    if !p.requireAirbnb(ctx, "!specialoffer") {
+        return
+    }
+    if len(args) != 1 && len(args) != 3 {
+        p.sendNotice(ctx, usage)
+        return
+    }
+    price, err := strconv.ParseFloat(args[0], 64)
+    if err != nil || price <= 0 {
+        p.sendNotice(ctx, "Invalid price. "+usage)
+        return
+    }
+    checkIn, checkOut := p.Info.CheckInDate, p.Info.CheckOutDate
+    if len(args) == 3 {
+        checkIn, checkOut = args[1], args[2]
+    }
+    start, err := time.Parse(dateLayout, checkIn)
+    if err != nil {
+        p.sendNotice(ctx, "Invalid or missing check-in date. "+usage)
+        return
+    }
+    end, err := time.Parse(dateLayout, checkOut)
+    if err != nil || !end.After(start) {
+        p.sendNotice(ctx, "Invalid or missing check-out date, it has to be after the check-in. "+usage)
+        return
+    }
+
+    err = p.client().SendSpecialOffer(ctx, p.conversationID(), checkIn, checkOut, price)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to send special offer", zap.String("hostex_id", p.ID), zap.Error(err))
+        p.sendNotice(ctx, fmt.Sprintf("Failed to send the special offer: %v", err))
+        return
+    }
+    p.sendNotice(ctx, fmt.Sprintf("Sent %s a special offer of %s for %s to %s.", p.Info.Guest.Name, strconv.FormatFloat(price, 'f', -1, 64), checkIn, checkOut))
+}
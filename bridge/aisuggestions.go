@@ -0,0 +1,227 @@
+package bridge
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// maxAIResponseSize limits how much of a chat completions response is read.
+const maxAIResponseSize = 1024 * 1024
+
+// aiSuggestion is a suggested reply waiting for approval.
+type aiSuggestion struct {
+    eventID id.EventID
+    text    string
+}
+
+type chatMessage struct {
+    Role    string `json:"role"`
+    Content string `json:"content"`
+}
+
+type chatCompletionRequest struct {
+    Model    string        `json:"model"`
+    Messages []chatMessage `json:"messages"`
+}
+
+type chatCompletionResponse struct {
+    Choices []struct {
+        Message chatMessage `json:"message"`
+    } `json:"choices"`
+    Error *struct {
+        Message string `json:"message"`
+    } `json:"error"`
+}
+
+// aiPrompt builds the chat for a reply suggestion: the system prompt with
+// the guest's details, followed by the latest messages of the conversation.
+func (p *Portal) aiPrompt() ([]chatMessage, error) {
+    cfg := p.bridge.Config().AISuggestions
+    history, err := p.bridge.DB.GetMessagesBetween(p.ID, time.Time{}, time.Time{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to get message history: %w", err)
+    }
+    if len(history) > cfg.History {
+        history = history[len(history)-cfg.History:]
+    }
+
+    var details strings.Builder
+    details.WriteString(cfg.SystemPrompt)
+    details.WriteString(fmt.Sprintf("\n\nGuest: %s\nProperty: %s\nChannel: %s", p.Info.Guest.Name, p.Info.PropertyTitle, p.Info.ChannelType))
+    if p.Info.CheckInDate != "" {
+        details.WriteString(fmt.Sprintf("\nStay: %s to %s", p.Info.CheckInDate, p.Info.CheckOutDate))
+    }
+    if p.Info.ReservationStatus != "" {
+        details.WriteString("\nReservation status: " + p.Info.ReservationStatus)
+    }
+
+    messages := []chatMessage{{Role: "system", Content: details.String()}}
+    for _, msg := range history {
+        if msg.Content == "" {
+            continue
+        }
+        role := "user"
+        if isHostSender(msg.Sender) {
+            role = "assistant"
+        }
+        messages = append(messages, chatMessage{Role: role, Content: msg.Content})
+    }
+    return messages, nil
+}
+
+func (b *Bridge) requestChatCompletion(ctx context.Context, messages []chatMessage) (string, error) {
+    cfg := b.Config().AISuggestions
+    body, err := json.Marshal(&chatCompletionRequest{Model: cfg.Model, Messages: messages})
+    if err != nil {
+        return "", err
+    }
+    ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL+"/chat/completions", bytes.NewReader(body))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if cfg.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+    }
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxAIResponseSize))
+    if err != nil {
+        return "", fmt.Errorf("failed to read response: %w", err)
+    }
+    var completion chatCompletionResponse
+    err = json.Unmarshal(data, &completion)
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        if err == nil && completion.Error != nil {
+            return "", fmt.Errorf("API returned HTTP %d: %s", resp.StatusCode, completion.Error.Message)
+        }
+        return "", fmt.Errorf("API returned HTTP %d", resp.StatusCode)
+    } else if err != nil {
+        return "", fmt.Errorf("invalid response: %w", err)
+    } else if len(completion.Choices) == 0 {
+        return "", fmt.Errorf("response has no choices")
+    }
+    return strings.TrimSpace(completion.Choices[0].Message.Content), nil
+}
+
+// suggestAIReply asks the AI API for a reply to the guest message in the
+// background and posts it in a thread of the message. It replaces any
+// earlier suggestion that wasn't sent.
+func (p *Portal) suggestAIReply(guestEventID id.EventID, sentAt time.Time) {
+    if p.RoomID == "" || guestEventID == "" || time.Since(sentAt) > p.bridge.Config().AISuggestions.MaxAge {
+        return
+    }
+    p.bridge.goTask(func() {
+        ctx := p.bridge.ctx
+        messages, err := p.aiPrompt()
+        if err != nil {
+            p.bridge.Logger.Error("Failed to build AI prompt", zap.String("hostex_id", p.ID), zap.Error(err))
+            return
+        }
+        text, err := p.bridge.requestChatCompletion(ctx, messages)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to get AI reply suggestion", zap.String("hostex_id", p.ID), zap.Error(err))
+            return
+        } else if text == "" {
+            return
+        }
+
+        content := &event.MessageEventContent{
+            MsgType: event.MsgNotice,
+            Body: fmt.Sprintf("Suggested reply: %s\n\nReact with %s or use !send-suggestion to send it.",
+                text, p.bridge.Config().AISuggestions.ApproveReaction),
+        }
+        content.RelatesTo = (&event.RelatesTo{}).SetThread(guestEventID, guestEventID)
+        resp, err := p.bridge.MatrixClient.SendMessageEvent(ctx, p.RoomID, event.EventMessage, content)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to post AI reply suggestion", zap.String("hostex_id", p.ID), zap.Error(err))
+            return
+        }
+        p.aiSuggestionLock.Lock()
+        p.aiSuggestion = &aiSuggestion{eventID: resp.EventID, text: text}
+        p.aiSuggestionLock.Unlock()
+    })
+}
+
+// sendAISuggestion sends the pending suggestion to the guest on behalf of
+// sender. If eventID isn't empty, it has to be the suggestion's notice.
+func (p *Portal) sendAISuggestion(ctx context.Context, sender id.UserID, eventID id.EventID) {
+    p.aiSuggestionLock.Lock()
+    suggestion := p.aiSuggestion
+    if suggestion == nil || (eventID != "" && suggestion.eventID != eventID) {
+        p.aiSuggestionLock.Unlock()
+        if eventID == "" {
+            p.sendNotice(ctx, "There is no suggested reply to send.")
+        }
+        return
+    }
+    p.aiSuggestion = nil
+    p.aiSuggestionLock.Unlock()
+
+    err := p.sendBridgeMessage(ctx, sender, suggestion.text)
+    if err != nil {
+        p.sendNotice(ctx, fmt.Sprintf("Failed to send the suggested reply: %v", err))
+    }
+}
+
+// clearAISuggestion drops the pending suggestion once the host has replied,
+// so it can't be approved after the fact.
+func (p *Portal) clearAISuggestion() {
+    p.aiSuggestionLock.Lock()
+    p.aiSuggestion = nil
+    p.aiSuggestionLock.Unlock()
+}
+
+// handleMatrixReaction sends the pending AI suggestion when someone reacts to
+// it with the approve reaction, and marks the conversation as done on the
+// done reaction.
+func (b *Bridge) handleMatrixReaction(evt *event.Event) {
+    if evt.Sender == b.botUserID() || b.isGhost(evt.Sender) || !b.IsLeader() {
+        return
+    }
+    portal, ok := b.getPortalByMXID(evt.RoomID)
+    if !ok {
+        return
+    }
+    content, ok := evt.Content.Parsed.(*event.ReactionEventContent)
+    if !ok {
+        return
+    }
+    switch key := content.RelatesTo.Key; {
+    case b.Config().AISuggestions.Enable && reactionKeyMatches(key, b.Config().AISuggestions.ApproveReaction):
+        if b.permissionLevel(evt.Sender, evt.RoomID) < PermissionAdmin {
+            b.Logger.Warn("Ignoring suggestion approval from user without admin permission", zap.String("sender", evt.Sender.String()))
+            return
+        }
+        portal.sendAISuggestion(b.ctx, evt.Sender, content.RelatesTo.EventID)
+    case b.Config().DoneReaction.Enable && reactionKeyMatches(key, b.Config().DoneReaction.Key):
+        if b.permissionLevel(evt.Sender, evt.RoomID) < PermissionAdmin {
+            b.Logger.Warn("Ignoring done reaction from user without admin permission", zap.String("sender", evt.Sender.String()))
+            return
+        }
+        portal.markDone(b.ctx, evt.Sender)
+    }
+}
+
+// reactionKeyMatches compares a reaction key with a configured one. Keys may
+// or may not have the emoji variation selector.
+func reactionKeyMatches(key, configured string) bool {
+    return strings.TrimSuffix(key, "\ufe0f") == strings.TrimSuffix(configured, "\ufe0f")
+}
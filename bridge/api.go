@@ -0,0 +1,84 @@
+package bridge
+
+import (
+    "context"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// HostexAPI is the part of the Hostex API the bridge uses. It's implemented
+// by *hostexapi.Client, and can be replaced when embedding the bridge, e.g.
+// with a client that goes through a shared proxy.
+type HostexAPI interface {
+    GetConversations(ctx context.Context) ([]hostexapi.Conversation, error)
+    GetMessages(ctx context.Context, conversationID string, since time.Time, limit int) ([]hostexapi.Message, error)
+    SendMessage(ctx context.Context, conversationID, content string) (string, error)
+    SendMessageBatch(ctx context.Context, messages []hostexapi.BatchMessage) ([]hostexapi.BatchMessageResult, error)
+    ArchiveConversation(ctx context.Context, conversationID string) error
+
+    GetProperties(ctx context.Context) ([]hostexapi.Property, error)
+    GetReservations(ctx context.Context, startDate, endDate string) ([]hostexapi.Reservation, error)
+    GetReservation(ctx context.Context, code string) (*hostexapi.Reservation, error)
+    GetCalendar(ctx context.Context, propertyID, startDate, endDate string) ([]hostexapi.CalendarDay, error)
+    GetPrices(ctx context.Context, propertyID, startDate, endDate string) ([]hostexapi.Price, error)
+    UpdatePrice(ctx context.Context, propertyID, startDate, endDate string, price float64) error
+    GetReviews(ctx context.Context, startDate, endDate string) ([]hostexapi.Review, error)
+    ReplyToReview(ctx context.Context, reservationCode, reply string) error
+    GetPayouts(ctx context.Context, startDate, endDate string) ([]hostexapi.Payout, error)
+    PreapproveInquiry(ctx context.Context, conversationID string) error
+    SendSpecialOffer(ctx context.Context, conversationID, checkInDate, checkOutDate string, price float64) error
+    AddConversationTag(ctx context.Context, conversationID, tag string) error
+    RemoveConversationTag(ctx context.Context, conversationID, tag string) error
+
+    SetToken(token string)
+    HasToken() bool
+    BudgetUsage() (int, int)
+    RateLimitState() (time.Time, int)
+    EndpointHealth() map[string]bool
+}
+
+var _ HostexAPI = (*hostexapi.Client)(nil)
+
+// MatrixClient is the part of the Matrix client API the bridge bot uses. It's
+// implemented by *mautrix.Client. Receiving events by syncing needs a
+// *mautrix.Client, other clients have to use the appservice listener.
+type MatrixClient interface {
+    CreateRoom(ctx context.Context, req *mautrix.ReqCreateRoom) (*mautrix.RespCreateRoom, error)
+    InviteUser(ctx context.Context, roomID id.RoomID, req *mautrix.ReqInviteUser) (*mautrix.RespInviteUser, error)
+    KickUser(ctx context.Context, roomID id.RoomID, req *mautrix.ReqKickUser) (*mautrix.RespKickUser, error)
+    LeaveRoom(ctx context.Context, roomID id.RoomID, optionalReq ...*mautrix.ReqLeave) (*mautrix.RespLeaveRoom, error)
+    ForgetRoom(ctx context.Context, roomID id.RoomID) (*mautrix.RespForgetRoom, error)
+    JoinedRooms(ctx context.Context) (*mautrix.RespJoinedRooms, error)
+    JoinedMembers(ctx context.Context, roomID id.RoomID) (*mautrix.RespJoinedMembers, error)
+
+    SendMessageEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, contentJSON interface{}, extra ...mautrix.ReqSendEvent) (*mautrix.RespSendEvent, error)
+    SendStateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, contentJSON interface{}) (*mautrix.RespSendEvent, error)
+    SendReaction(ctx context.Context, roomID id.RoomID, eventID id.EventID, reaction string) (*mautrix.RespSendEvent, error)
+    RedactEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID, extra ...mautrix.ReqRedact) (*mautrix.RespSendEvent, error)
+    BatchSend(ctx context.Context, roomID id.RoomID, req *mautrix.ReqBatchSend) (*mautrix.RespBatchSend, error)
+
+    StateEvent(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, outContent interface{}) error
+    GetEvent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.Event, error)
+    Messages(ctx context.Context, roomID id.RoomID, from, to string, dir mautrix.Direction, filter *mautrix.FilterPart, limit int) (*mautrix.RespMessages, error)
+    Context(ctx context.Context, roomID id.RoomID, eventID id.EventID, filter *mautrix.FilterPart, limit int) (*mautrix.RespContext, error)
+
+    GetTags(ctx context.Context, roomID id.RoomID) (event.TagEventContent, error)
+    AddTag(ctx context.Context, roomID id.RoomID, tag event.RoomTag, order float64) error
+    RemoveTag(ctx context.Context, roomID id.RoomID, tag event.RoomTag) error
+    GetAccountData(ctx context.Context, name string, output interface{}) error
+    SetAccountData(ctx context.Context, name string, data interface{}) error
+
+    UploadLink(ctx context.Context, link string) (*mautrix.RespMediaUpload, error)
+    UploadBytesWithName(ctx context.Context, data []byte, contentType, fileName string) (*mautrix.RespMediaUpload, error)
+    DownloadBytes(ctx context.Context, mxcURL id.ContentURI) ([]byte, error)
+
+    Register(ctx context.Context, req *mautrix.ReqRegister) (*mautrix.RespRegister, *mautrix.RespUserInteractive, error)
+    Versions(ctx context.Context) (*mautrix.RespVersions, error)
+}
+
+var _ MatrixClient = (*mautrix.Client)(nil)
@@ -0,0 +1,166 @@
+package bridge
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "go.uber.org/zap"
+)
+
+// transactionRetention is how long handled transaction IDs are remembered,
+// which only has to cover the homeserver retrying a transaction.
+const transactionRetention = 24 * time.Hour
+
+type appserviceTransaction struct {
+    Events []*event.Event `json:"events"`
+}
+
+// startAppservice listens for transactions pushed by the homeserver, which
+// replaces /sync as the source of Matrix events.
+func (b *Bridge) startAppservice() {
+    defer b.wg.Done()
+
+    err := b.DB.PruneTransactions(time.Now().Add(-transactionRetention))
+    if err != nil {
+        b.Logger.Warn("Failed to prune appservice transactions", zap.Error(err))
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("PUT /_matrix/app/v1/transactions/{txnID}", b.handleTransaction)
+    mux.HandleFunc("PUT /transactions/{txnID}", b.handleTransaction)
+    mux.HandleFunc("POST /_matrix/app/v1/ping", b.handleAppservicePing)
+    mux.HandleFunc("GET /_matrix/app/v1/users/{userID}", b.handleAppserviceQuery)
+    mux.HandleFunc("GET /_matrix/app/v1/rooms/{alias}", b.handleAppserviceQuery)
+
+    server := &http.Server{
+        Addr:    b.Config().Appservice.Listen,
+        Handler: b.AccessLog.Wrap("appservice", mux),
+    }
+    go func() {
+        <-b.stop
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        server.Shutdown(ctx)
+    }()
+
+    b.Logger.Info("Listening for appservice transactions", zap.String("address", server.Addr))
+    err = server.ListenAndServe()
+    if err != nil && err != http.ErrServerClosed {
+        b.Logger.Error("Appservice listener failed", zap.Error(err))
+    }
+}
+
+// checkHomeserverToken verifies that a request comes from the homeserver,
+// which authenticates with the hs_token.
+func (b *Bridge) checkHomeserverToken(w http.ResponseWriter, r *http.Request) bool {
+    token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if token == "" {
+        token = r.URL.Query().Get("access_token")
+    }
+    if token == "" {
+        writeMatrixError(w, http.StatusUnauthorized, "M_MISSING_TOKEN", "Missing access token")
+        return false
+    }
+    if subtle.ConstantTimeCompare([]byte(token), []byte(b.Config().Appservice.HSToken)) != 1 {
+        writeMatrixError(w, http.StatusForbidden, "M_FORBIDDEN", "Invalid access token")
+        return false
+    }
+    return true
+}
+
+func (b *Bridge) handleTransaction(w http.ResponseWriter, r *http.Request) {
+    if !b.checkHomeserverToken(w, r) {
+        return
+    }
+
+    txnID := r.PathValue("txnID")
+    var txn appserviceTransaction
+    err := json.NewDecoder(r.Body).Decode(&txn)
+    if err != nil {
+        writeMatrixError(w, http.StatusBadRequest, "M_NOT_JSON", "Invalid transaction body")
+        return
+    }
+
+    // The transaction is only recorded once all of its events are handled,
+    // so a retry after a crash mid-transaction is handled again. The lock
+    // keeps a retry that arrives in the meantime from being handled twice.
+    b.transactionLock.Lock()
+    defer b.transactionLock.Unlock()
+    handled, err := b.DB.IsTransactionHandled(txnID)
+    if err != nil {
+        b.Logger.Error("Failed to check appservice transaction", zap.String("txn_id", txnID), zap.Error(err))
+        writeMatrixError(w, http.StatusInternalServerError, "M_UNKNOWN", "Failed to check transaction")
+        return
+    }
+    if handled {
+        b.Logger.Debug("Ignoring repeated appservice transaction", zap.String("txn_id", txnID))
+        writeJSON(w, http.StatusOK, struct{}{})
+        return
+    }
+
+    receivedAt := time.Now()
+    for _, evt := range txn.Events {
+        b.handleAppserviceEvent(evt)
+    }
+    err = b.DB.MarkTransactionHandled(txnID, receivedAt)
+    if err != nil {
+        b.Logger.Error("Failed to store appservice transaction", zap.String("txn_id", txnID), zap.Error(err))
+    }
+    writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (b *Bridge) handleAppserviceEvent(evt *event.Event) {
+    if evt.StateKey != nil {
+        evt.Type.Class = event.StateEventType
+    } else {
+        evt.Type.Class = event.MessageEventType
+    }
+    if evt.Type != event.EventMessage && evt.Type != event.EventReaction {
+        return
+    }
+
+    err := evt.Content.ParseRaw(evt.Type)
+    if err != nil {
+        b.Logger.Warn("Failed to parse event content", zap.String("event_id", evt.ID.String()), zap.Error(err))
+        return
+    }
+    if evt.Type == event.EventReaction {
+        b.handleMatrixReaction(evt)
+        return
+    }
+    b.handleMatrixMessage(evt)
+}
+
+func (b *Bridge) handleAppservicePing(w http.ResponseWriter, r *http.Request) {
+    if !b.checkHomeserverToken(w, r) {
+        return
+    }
+    writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// handleAppserviceQuery answers user and alias queries. The bridge doesn't
+// create users or rooms on demand, so nothing ever exists.
+func (b *Bridge) handleAppserviceQuery(w http.ResponseWriter, r *http.Request) {
+    if !b.checkHomeserverToken(w, r) {
+        return
+    }
+    writeMatrixError(w, http.StatusNotFound, "M_NOT_FOUND", "Not found")
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(data)
+}
+
+func writeMatrixError(w http.ResponseWriter, status int, errcode, message string) {
+    writeJSON(w, status, map[string]string{
+        "errcode": errcode,
+        "error":   message,
+    })
+}
@@ -0,0 +1,22 @@
+package bridge
+
+import (
+    "context"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// archiveMessages moves messages older than the configured age out of the
+// message table into per-year archive tables.
+func (b *Bridge) archiveMessages(ctx context.Context) {
+    before := time.Now().AddDate(0, 0, -b.Config().Archive.AfterDays)
+    archived, err := b.DB.ArchiveMessages(before)
+    if err != nil {
+        b.Logger.Error("Failed to archive messages", zap.Error(err))
+        return
+    }
+    if archived > 0 {
+        b.Logger.Info("Archived old messages", zap.Int64("count", archived), zap.Time("before", before))
+    }
+}
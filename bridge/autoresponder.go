@@ -0,0 +1,137 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "text/template"
+    "time"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/config"
+)
+
+type autoReply struct {
+    rule     config.AutoReplyRule
+    template *template.Template
+}
+
+func parseAutoReplies(rules []config.AutoReplyRule) ([]autoReply, error) {
+    replies := make([]autoReply, len(rules))
+    for i, rule := range rules {
+        tmpl, err := template.New(rule.Name).Parse(rule.Template)
+        if err != nil {
+            return nil, fmt.Errorf("invalid template of auto_responder rule %s: %w", rule.Name, err)
+        }
+        replies[i] = autoReply{rule: rule, template: tmpl}
+    }
+    return replies, nil
+}
+
+// matches reports whether the rule applies to a guest message in the portal
+// at the given time, apart from the first_message trigger, which needs the
+// stored messages.
+func (reply autoReply) matches(p *Portal, message string, now time.Time) bool {
+    rule := reply.rule
+    if rule.Start != "" && !isNight(now, rule.Start, rule.End) {
+        return false
+    }
+    if len(rule.Properties) > 0 {
+        found := false
+        for _, property := range rule.Properties {
+            if strings.EqualFold(property, p.Info.PropertyTitle) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+    if len(rule.Languages) > 0 {
+        language := baseLanguage(p.guestLanguage())
+        found := false
+        for _, ruleLanguage := range rule.Languages {
+            if baseLanguage(ruleLanguage) == language {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return false
+        }
+    }
+    if rule.Trigger == "keyword" {
+        lower := strings.ToLower(message)
+        for _, keyword := range rule.Keywords {
+            if strings.Contains(lower, strings.ToLower(keyword)) {
+                return true
+            }
+        }
+        return false
+    }
+    return true
+}
+
+// isFirstMessage reports whether the guest message sent at the given time
+// is the first stored message of the conversation.
+func (p *Portal) isFirstMessage(sentAt time.Time) (bool, error) {
+    first, err := p.bridge.DB.GetFirstMessageTimestamp(p.ID)
+    if err != nil {
+        return false, err
+    }
+    return first.Unix() == sentAt.Unix(), nil
+}
+
+// autoRespond replies to the newest guest message with the first auto-reply
+// rule that matches it, unless the message is too old or the rule already
+// replied in the conversation within the cooldown.
+func (p *Portal) autoRespond(ctx context.Context, message string, sentAt time.Time) {
+    cfg := p.bridge.Config().AutoResponder
+    if p.RoomID == "" || time.Since(sentAt) > cfg.MaxAge {
+        return
+    }
+
+    now := time.Now().In(p.bridge.location())
+    for _, reply := range p.bridge.autoReplies {
+        if !reply.matches(p, message, now) {
+            continue
+        }
+        if reply.rule.Trigger == "first_message" {
+            first, err := p.isFirstMessage(sentAt)
+            if err != nil {
+                p.bridge.Logger.Error("Failed to check for the first message", zap.String("hostex_id", p.ID), zap.Error(err))
+                return
+            } else if !first {
+                continue
+            }
+        }
+        lastSent, err := p.bridge.DB.GetAutoReplySentAt(p.ID, reply.rule.Name)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to get last auto-reply", zap.String("hostex_id", p.ID), zap.Error(err))
+            return
+        } else if time.Since(lastSent) < cfg.Cooldown {
+            continue
+        }
+
+        var text strings.Builder
+        err = reply.template.Execute(&text, p.replyTemplateData())
+        if err != nil {
+            p.bridge.Logger.Error("Failed to render auto-reply", zap.String("rule", reply.rule.Name), zap.Error(err))
+            continue
+        }
+        // The guest just wrote, so the reply isn't held during quiet hours
+        err = p.sendAutomated(ctx, text.String(), true)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to send auto-reply", zap.String("hostex_id", p.ID), zap.String("rule", reply.rule.Name), zap.Error(err))
+            return
+        }
+        p.bridge.Logger.Info("Sent auto-reply", zap.String("hostex_id", p.ID), zap.String("rule", reply.rule.Name))
+        err = p.bridge.DB.SetAutoReplySent(p.ID, reply.rule.Name, time.Now())
+        if err != nil {
+            p.bridge.Logger.Error("Failed to store auto-reply", zap.Error(err))
+        }
+        return
+    }
+}
@@ -0,0 +1,135 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "html"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// parseMonth parses a month given as YYYY-MM or a month name, which refers
+// to the next occurrence of that month. An empty string is the current month.
+func parseMonth(value string, now time.Time) (time.Time, error) {
+    thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+    if value == "" {
+        return thisMonth, nil
+    }
+    if month, err := time.ParseInLocation("2006-01", value, now.Location()); err == nil {
+        return month, nil
+    }
+    for _, layout := range []string{"January", "Jan"} {
+        month, err := time.Parse(layout, value)
+        if err != nil {
+            continue
+        }
+        result := time.Date(now.Year(), month.Month(), 1, 0, 0, 0, 0, now.Location())
+        if result.Before(thisMonth) {
+            result = result.AddDate(1, 0, 0)
+        }
+        return result, nil
+    }
+    return time.Time{}, fmt.Errorf("invalid month %q, expected YYYY-MM or a month name", value)
+}
+
+// buildAvailabilityTable renders one row per day of the month with the
+// booking or availability of the property.
+func (b *Bridge) buildAvailabilityTable(ctx context.Context, propertyID string, month time.Time) (string, error) {
+    first := month.Format(dateLayout)
+    last := month.AddDate(0, 1, -1).Format(dateLayout)
+
+    days, err := b.HostexClient.GetCalendar(ctx, propertyID, first, last)
+    if err != nil {
+        return "", fmt.Errorf("failed to get calendar: %w", err)
+    }
+    // Start a month back so stays that began in the previous month are included
+    reservations, err := b.HostexClient.GetReservations(ctx, month.AddDate(0, -1, 0).Format(dateLayout), last)
+    if err != nil {
+        return "", fmt.Errorf("failed to get reservations: %w", err)
+    }
+
+    guests := make(map[string]string)
+    for _, res := range reservations {
+        if res.PropertyID != propertyID || strings.EqualFold(res.Status, "cancelled") {
+            continue
+        }
+        for day := res.CheckInDate; day < res.CheckOutDate; {
+            guests[day] = res.GuestName
+            date, err := time.Parse(dateLayout, day)
+            if err != nil {
+                break
+            }
+            day = date.AddDate(0, 0, 1).Format(dateLayout)
+        }
+    }
+
+    var table strings.Builder
+    table.WriteString(fmt.Sprintf("%-14s | %-9s | %s\n", "Date", "Status", "Details"))
+    table.WriteString(strings.Repeat("-", 14) + "-+-" + strings.Repeat("-", 9) + "-+-" + strings.Repeat("-", 20) + "\n")
+    for _, day := range days {
+        date, err := time.Parse(dateLayout, day.Date)
+        if err != nil {
+            continue
+        }
+        label := date.Format("Mon Jan 02")
+        switch {
+        case guests[day.Date] != "":
+            table.WriteString(fmt.Sprintf("%-14s | %-9s | %s\n", label, "Booked", guests[day.Date]))
+        case !day.Available:
+            table.WriteString(fmt.Sprintf("%-14s | %-9s |\n", label, "Blocked"))
+        default:
+            details := fmt.Sprintf("%.2f", day.Price)
+            if day.MinStay > 1 {
+                details += fmt.Sprintf(", min %d nights", day.MinStay)
+            }
+            table.WriteString(fmt.Sprintf("%-14s | %-9s | %s\n", label, "Available", details))
+        }
+    }
+    return table.String(), nil
+}
+
+func (u *User) showCalendar(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !calendar <property> [month]")
+        return
+    }
+
+    // The month is optional, so only treat the last argument as one if it parses
+    now := time.Now().In(u.bridge.location())
+    propertyArgs := args
+    month, _ := parseMonth("", now)
+    if len(args) > 1 {
+        if parsed, err := parseMonth(args[len(args)-1], now); err == nil {
+            month = parsed
+            propertyArgs = args[:len(args)-1]
+        }
+    }
+
+    property, err := u.bridge.findProperty(ctx, strings.Join(propertyArgs, " "))
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to find property: %v", err))
+        return
+    }
+
+    table, err := u.bridge.buildAvailabilityTable(ctx, property.ID, month)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to build calendar: %v", err))
+        return
+    }
+
+    title := fmt.Sprintf("%s, %s", property.Title, month.Format("January 2006"))
+    content := &event.MessageEventContent{
+        MsgType:       event.MsgNotice,
+        Body:          title + "\n\n" + table,
+        Format:        event.FormatHTML,
+        FormattedBody: fmt.Sprintf("<b>%s</b><pre>%s</pre>", html.EscapeString(title), html.EscapeString(table)),
+    }
+    _, err = u.bridge.MatrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+    if err != nil {
+        u.bridge.Logger.Error("Failed to send calendar", zap.Error(err))
+    }
+}
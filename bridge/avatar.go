@@ -0,0 +1,84 @@
+package bridge
+
+import (
+    "context"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+const (
+    avatarRetryMinDelay = 5 * time.Minute
+    avatarRetryMaxDelay = 24 * time.Hour
+)
+
+// avatarRetry is the backoff state of a guest photo that couldn't be
+// uploaded, so a broken link isn't downloaded again on every poll.
+type avatarRetry struct {
+    source      string
+    attempts    int
+    nextAttempt time.Time
+}
+
+// avatarSource returns the guest's profile photo URL if Hostex has one, or
+// else the configured avatar of the channel.
+func (p *Portal) avatarSource() string {
+    if p.Info.Guest.Avatar != "" {
+        return p.Info.Guest.Avatar
+    }
+    return string(p.bridge.Config().ChannelAvatars[strings.ToLower(p.Info.ChannelType)])
+}
+
+// updateAvatar sets the room avatar if its source changed since it was last
+// set. Guest photos are uploaded to the homeserver first.
+func (p *Portal) updateAvatar(ctx context.Context) {
+    source := p.avatarSource()
+    if source == "" || source == p.avatar {
+        return
+    }
+
+    if p.avatarRetry.source == source && time.Now().Before(p.avatarRetry.nextAttempt) {
+        return
+    }
+
+    avatarURL := id.ContentURIString(source)
+    if !strings.HasPrefix(source, "mxc://") {
+        resp, err := p.bridge.MatrixClient.UploadLink(ctx, source)
+        if err != nil {
+            delay := p.avatarUploadFailed(source)
+            p.bridge.Logger.Warn("Failed to upload guest avatar", zap.String("hostex_id", p.ID), zap.Duration("retry_in", delay), zap.Error(err))
+            return
+        }
+        avatarURL = resp.ContentURI.CUString()
+    }
+    p.avatarRetry = avatarRetry{}
+
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, event.StateRoomAvatar, "", &event.RoomAvatarEventContent{URL: avatarURL})
+    if err != nil {
+        p.bridge.Logger.Error("Failed to update portal avatar", zap.String("room_id", p.RoomID.String()), zap.Error(err))
+        return
+    }
+    err = p.bridge.DB.SetPortalAvatar(p.ID, source)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store portal avatar", zap.Error(err))
+    }
+    p.avatar = source
+}
+
+// avatarUploadFailed backs off uploading the source again and returns the
+// delay until the next attempt.
+func (p *Portal) avatarUploadFailed(source string) time.Duration {
+    if p.avatarRetry.source != source {
+        p.avatarRetry = avatarRetry{source: source}
+    }
+    p.avatarRetry.attempts++
+    delay := avatarRetryMinDelay << (p.avatarRetry.attempts - 1)
+    if delay > avatarRetryMaxDelay || delay <= 0 {
+        delay = avatarRetryMaxDelay
+    }
+    p.avatarRetry.nextAttempt = time.Now().Add(delay)
+    return delay
+}
@@ -0,0 +1,203 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+const backfillQueueInterval = time.Minute
+
+func (u *User) enqueueBackfill(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !backfill <conversation ID|room ID|guest name> [from YYYY-MM-DD]")
+        return
+    }
+
+    var from time.Time
+    if len(args) > 1 {
+        parsed, err := time.ParseInLocation(dateLayout, args[len(args)-1], u.bridge.location())
+        if err == nil {
+            from = parsed
+            args = args[:len(args)-1]
+        }
+    }
+    query := strings.Join(args, " ")
+    portal := u.bridge.findPortal(query)
+    if portal == nil || portal.RoomID == "" {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No bridged conversation found for %q.", query))
+        return
+    }
+
+    // Only the history before the oldest bridged message is missing
+    to, err := u.bridge.DB.GetFirstMessageTimestamp(portal.ID)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get the oldest bridged message: %v", err))
+        return
+    }
+    if to.IsZero() {
+        to = time.Now()
+    }
+    if !from.Before(to) {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("The conversation with %s is already bridged since %s.", portal.Info.Guest.Name, to.In(u.bridge.location()).Format(dateLayout)))
+        return
+    }
+
+    _, err = u.bridge.DB.EnqueueBackfill(&database.BackfillTask{
+        HostexID:  portal.ID,
+        From:      from,
+        To:        to,
+        CreatedAt: time.Now(),
+    })
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to queue the backfill: %v", err))
+        return
+    }
+    u.bridge.wakeBackfillQueue()
+    u.sendNotice(ctx, roomID, fmt.Sprintf("Queued the history of %s before %s. Use !status to follow the progress.",
+        portal.Info.Guest.Name, to.In(u.bridge.location()).Format(dateLayout)))
+}
+
+// backfillQueueStatus describes the queued backfills for !status.
+func (b *Bridge) backfillQueueStatus() string {
+    tasks, err := b.DB.GetBackfillTasks()
+    if err != nil {
+        b.Logger.Error("Failed to get backfill queue", zap.Error(err))
+        return "unknown"
+    }
+    if len(tasks) == 0 {
+        return "empty"
+    }
+    var running []string
+    for _, task := range tasks {
+        if task.Status != database.BackfillRunning {
+            continue
+        }
+        name := task.HostexID
+        if portal, ok := b.getPortalByID(task.HostexID); ok {
+            name = portal.Info.Guest.Name
+        }
+        running = append(running, fmt.Sprintf("%s (at %s, until %s)", name,
+            task.From.In(b.location()).Format(dateLayout), task.To.In(b.location()).Format(dateLayout)))
+    }
+    status := fmt.Sprintf("%d pending", len(tasks)-len(running))
+    if len(running) > 0 {
+        status += ", running: " + strings.Join(running, ", ")
+    }
+    return status
+}
+
+func (b *Bridge) wakeBackfillQueue() {
+    select {
+    case b.backfillWake <- struct{}{}:
+    default:
+    }
+}
+
+// startBackfillQueue bridges the queued history one task at a time. Tasks
+// that were running when the bridge or the previous leader stopped are
+// resumed.
+func (b *Bridge) startBackfillQueue() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(backfillQueueInterval)
+    defer ticker.Stop()
+
+    wasLeader := false
+    for {
+        isLeader := b.IsLeader()
+        if isLeader && !wasLeader {
+            err := b.DB.ResetBackfillQueue()
+            if err != nil {
+                b.Logger.Error("Failed to reset backfill queue", zap.Error(err))
+            }
+        }
+        wasLeader = isLeader
+        if isLeader {
+            b.processBackfillQueue(b.ctx)
+        }
+
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+        case <-b.backfillWake:
+        }
+    }
+}
+
+func (b *Bridge) processBackfillQueue(ctx context.Context) {
+    for b.IsLeader() {
+        tasks, err := b.DB.GetBackfillTasks()
+        if err != nil {
+            b.Logger.Error("Failed to get backfill queue", zap.Error(err))
+            return
+        }
+        if len(tasks) == 0 {
+            return
+        }
+        task := tasks[0]
+        b.runBackfillTask(ctx, task)
+        if ctx.Err() != nil {
+            return
+        }
+        select {
+        case <-b.stop:
+            return
+        default:
+        }
+    }
+}
+
+// runBackfillTask bridges the history of a task, storing how far it got after
+// every page.
+func (b *Bridge) runBackfillTask(ctx context.Context, task *database.BackfillTask) {
+    log := b.Logger.With(zap.Int64("task_id", task.ID), zap.String("hostex_id", task.HostexID))
+    portal, ok := b.getPortalByID(task.HostexID)
+    if !ok || portal.RoomID == "" {
+        log.Warn("Dropping backfill of conversation that isn't bridged")
+        err := b.DB.UpdateBackfillTask(task.ID, task.From, database.BackfillFailed)
+        if err != nil {
+            log.Error("Failed to update backfill task", zap.Error(err))
+        }
+        return
+    }
+
+    err := b.DB.UpdateBackfillTask(task.ID, task.From, database.BackfillRunning)
+    if err != nil {
+        log.Error("Failed to update backfill task", zap.Error(err))
+        return
+    }
+    log.Info("Backfilling queued history", zap.Time("from", task.From), zap.Time("to", task.To))
+    err = portal.backfill(hostexapi.WithPriority(ctx, hostexapi.PriorityBackfill), backfillParams{
+        since:      task.From,
+        until:      task.To,
+        historical: true,
+        progress: func(cursor time.Time) error {
+            return b.DB.UpdateBackfillTask(task.ID, cursor, database.BackfillRunning)
+        },
+    })
+
+    status := database.BackfillDone
+    if ctx.Err() != nil {
+        // The task stays running and is resumed after the restart
+        return
+    } else if err != nil {
+        log.Error("Queued backfill failed", zap.Error(err))
+        status = database.BackfillFailed
+        b.sendManagementNotice(ctx, fmt.Sprintf("Backfilling the history of %s failed: %v", portal.Info.Guest.Name, err))
+    } else {
+        b.sendManagementNotice(ctx, fmt.Sprintf("Backfilled the history of %s before %s.", portal.Info.Guest.Name, task.To.In(b.location()).Format(dateLayout)))
+    }
+    err = b.DB.UpdateBackfillTask(task.ID, task.From, status)
+    if err != nil {
+        log.Error("Failed to update backfill task", zap.Error(err))
+    }
+}
@@ -0,0 +1,155 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+const (
+    // batchSendFeature is the unstable feature flag of MSC2716 in /versions.
+    batchSendFeature = "org.matrix.msc2716"
+    // batchSendRoomVersion is the room version that allows historical
+    // events, which portal rooms are created with when batch send is used.
+    batchSendRoomVersion = "org.matrix.msc2716v3"
+)
+
+// checkBatchSend enables MSC2716 batch send for the initial history if it's
+// configured and the homeserver supports it.
+func (b *Bridge) checkBatchSend(ctx context.Context) {
+    if !b.Config().Backfill.BatchSend {
+        return
+    }
+    versions, err := b.MatrixClient.Versions(ctx)
+    if err != nil {
+        b.Logger.Warn("Failed to check homeserver for batch send support, backfilling with regular messages", zap.Error(err))
+        return
+    }
+    if !SupportsBatchSend(versions) {
+        b.Logger.Warn("Homeserver doesn't support MSC2716 batch send, backfilling with regular messages")
+        return
+    }
+    b.batchSend = true
+}
+
+// historyInsertionPoint returns the event that a historical batch of
+// messages up to the given time is inserted after: the event before the
+// oldest bridged message that is newer than the batch, the end of the
+// room's initial state if nothing was bridged yet, or else the newest event.
+func (p *Portal) historyInsertionPoint(ctx context.Context, newest time.Time) (id.EventID, error) {
+    nextEventID, err := p.bridge.DB.GetFirstMessageEventAfter(p.ID, newest)
+    if err != nil {
+        return "", fmt.Errorf("failed to get next bridged message: %w", err)
+    }
+    if nextEventID != "" {
+        resp, err := p.bridge.MatrixClient.Context(ctx, p.RoomID, nextEventID, nil, 2)
+        if err != nil {
+            return "", fmt.Errorf("failed to get events before the next bridged message: %w", err)
+        }
+        if len(resp.EventsBefore) == 0 {
+            return "", fmt.Errorf("no events before the next bridged message %s", nextEventID)
+        }
+        return resp.EventsBefore[0].ID, nil
+    }
+
+    lastTimestamp, err := p.bridge.DB.GetLastMessageTimestamp(p.ID)
+    if err != nil {
+        return "", fmt.Errorf("failed to get last message timestamp: %w", err)
+    }
+    if lastTimestamp.IsZero() {
+        return p.initialStateEventID(ctx)
+    }
+    return p.latestEventID(ctx)
+}
+
+// initialStateEventID returns the last event of the state the portal room
+// was created with, i.e. the last state event before anything else was sent.
+func (p *Portal) initialStateEventID(ctx context.Context) (id.EventID, error) {
+    resp, err := p.bridge.MatrixClient.Messages(ctx, p.RoomID, "", "", mautrix.DirectionForward, nil, 50)
+    if err != nil {
+        return "", fmt.Errorf("failed to get room creation events: %w", err)
+    }
+    var last id.EventID
+    for _, evt := range resp.Chunk {
+        if evt.StateKey == nil || evt.Sender != p.bridge.botUserID() {
+            break
+        }
+        last = evt.ID
+    }
+    if last == "" {
+        return "", fmt.Errorf("room has no initial state events")
+    }
+    return last, nil
+}
+
+// SupportsBatchSend reports whether the homeserver supports the MSC2716
+// batch send the bridge uses. Beeper's batch sending is a different API, so
+// it doesn't count.
+func SupportsBatchSend(versions *mautrix.RespVersions) bool {
+    return versions.UnstableFeatures[batchSendFeature]
+}
+
+// latestEventID returns the ID of the newest event in the portal room.
+func (p *Portal) latestEventID(ctx context.Context) (id.EventID, error) {
+    resp, err := p.bridge.MatrixClient.Messages(ctx, p.RoomID, "", "", mautrix.DirectionBackward, nil, 1)
+    if err != nil {
+        return "", fmt.Errorf("failed to get newest room event: %w", err)
+    }
+    if len(resp.Chunk) == 0 {
+        return "", fmt.Errorf("room has no events")
+    }
+    return resp.Chunk[0].ID, nil
+}
+
+// sendHistoricalBatch sends messages as MSC2716 historical events at
+// prevEventID. Batches are sent newest first, each one before the batch of
+// the given batchID, and the returned batch ID is where the next older batch
+// goes.
+func (p *Portal) sendHistoricalBatch(ctx context.Context, prevEventID id.EventID, batchID id.BatchID, messages []hostexapi.Message) ([]id.EventID, id.BatchID, error) {
+    req := &mautrix.ReqBatchSend{
+        PrevEventID: prevEventID,
+        BatchID:     batchID,
+        Events:      make([]*event.Event, len(messages)),
+    }
+    // Every sender needs a membership at the start of the batch
+    members := make(map[id.UserID]bool)
+    for i, msg := range messages {
+        _, sender := p.messageClient(ctx, msg.Sender)
+        if !members[sender] {
+            members[sender] = true
+            memberKey := sender.String()
+            req.StateEventsAtStart = append(req.StateEventsAtStart, &event.Event{
+                Type:      event.StateMember,
+                Sender:    sender,
+                StateKey:  &memberKey,
+                Timestamp: messages[0].Timestamp.UnixMilli(),
+                Content:   event.Content{Parsed: &event.MemberEventContent{Membership: event.MembershipJoin}},
+            })
+        }
+        req.Events[i] = &event.Event{
+            Type:      event.EventMessage,
+            Sender:    sender,
+            Timestamp: msg.Timestamp.UnixMilli(),
+            Content: event.Content{
+                Raw:    map[string]interface{}{"org.matrix.msc2716.historical": true},
+                Parsed: p.messageContent(ctx, msg),
+            },
+        }
+    }
+
+    resp, err := p.bridge.MatrixClient.BatchSend(ctx, p.RoomID, req)
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to batch send messages: %w", err)
+    }
+    if len(resp.EventIDs) != len(messages) {
+        return nil, "", fmt.Errorf("homeserver returned %d event IDs for %d messages", len(resp.EventIDs), len(messages))
+    }
+    return resp.EventIDs, resp.NextBatchID, nil
+}
@@ -0,0 +1,163 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+    "unicode"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// suppressionBlocked is the suppression category of messages from blocked
+// guests.
+const suppressionBlocked = "blocked"
+
+// minPhoneDigits is how many digits a phone number needs to be compared, so
+// placeholders like "0" don't match every guest.
+const minPhoneDigits = 6
+
+func normalizePhone(phone string) string {
+    return strings.Map(func(r rune) rune {
+        if unicode.IsDigit(r) {
+            return r
+        }
+        return -1
+    }, phone)
+}
+
+// matchBlockedGuest returns the blocked guest of another conversation with
+// the same email address or phone number as the conversation, if any.
+func matchBlockedGuest(conv hostexapi.Conversation, blocked []*database.BlockedGuest) (*database.BlockedGuest, string) {
+    email := strings.ToLower(strings.TrimSpace(conv.Guest.Email))
+    phone := normalizePhone(conv.Guest.Phone)
+    for _, guest := range blocked {
+        if guest.HostexID == conv.ID {
+            continue
+        }
+        if email != "" && strings.EqualFold(guest.Email, email) {
+            return guest, "email " + conv.Guest.Email
+        }
+        if len(phone) >= minPhoneDigits && normalizePhone(guest.Phone) == phone {
+            return guest, "phone " + conv.Guest.Phone
+        }
+    }
+    return nil, ""
+}
+
+// checkBlockedGuest warns in the management room when a new conversation is
+// from a guest who was blocked in another conversation.
+func (b *Bridge) checkBlockedGuest(ctx context.Context, conv hostexapi.Conversation) {
+    if conv.Guest.Email == "" && conv.Guest.Phone == "" {
+        return
+    }
+    blocked, err := b.DB.GetBlockedGuests()
+    if err != nil {
+        b.Logger.Error("Failed to get blocked guests", zap.Error(err))
+        return
+    }
+    guest, match := matchBlockedGuest(conv, blocked)
+    if guest == nil {
+        return
+    }
+    text := fmt.Sprintf("Warning: the new conversation with %s at %s (%s) has the same %s as %s, who was blocked on %s.",
+        conv.Guest.Name, conv.PropertyTitle, conv.ChannelType, match, guest.GuestName, guest.BlockedAt.In(b.location()).Format(dateLayout))
+    if guest.Reason != "" {
+        text += "\nReason: " + guest.Reason
+    }
+    b.sendManagementNotice(ctx, text)
+}
+
+// block stops bridging the guest's messages, which are only stored as
+// suppressed messages from now on.
+func (p *Portal) block(ctx context.Context, sender id.UserID, reason string) string {
+    if p.blocked {
+        return fmt.Sprintf("%s is already blocked.", p.Info.Guest.Name)
+    }
+    err := p.bridge.DB.BlockGuest(&database.BlockedGuest{
+        HostexID:  p.ID,
+        GuestName: p.Info.Guest.Name,
+        Email:     strings.ToLower(strings.TrimSpace(p.Info.Guest.Email)),
+        Phone:     p.Info.Guest.Phone,
+        Reason:    reason,
+        BlockedBy: sender,
+        BlockedAt: time.Now(),
+    })
+    if err != nil {
+        p.bridge.Logger.Error("Failed to block guest", zap.String("hostex_id", p.ID), zap.Error(err))
+        return fmt.Sprintf("Failed to block the guest: %v", err)
+    }
+    p.blocked = true
+    p.setAssignee("")
+    p.bridge.Logger.Info("Blocked guest", zap.String("hostex_id", p.ID), zap.String("sender", sender.String()))
+    if p.RoomID != "" {
+        p.sendNotice(ctx, fmt.Sprintf("%s blocked %s. New messages from the guest are logged but not bridged, see !show-suppressed in the management room.", sender, p.Info.Guest.Name))
+    }
+    return fmt.Sprintf("Blocked %s. New messages are logged but not bridged, and you'll be warned if their email or phone number shows up in another conversation.", p.Info.Guest.Name)
+}
+
+func (p *Portal) unblock(ctx context.Context, sender id.UserID) string {
+    if !p.blocked {
+        return fmt.Sprintf("%s isn't blocked.", p.Info.Guest.Name)
+    }
+    err := p.bridge.DB.UnblockGuest(p.ID)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to unblock guest", zap.String("hostex_id", p.ID), zap.Error(err))
+        return fmt.Sprintf("Failed to unblock the guest: %v", err)
+    }
+    p.blocked = false
+    p.bridge.Logger.Info("Unblocked guest", zap.String("hostex_id", p.ID), zap.String("sender", sender.String()))
+    if p.RoomID != "" {
+        p.sendNotice(ctx, fmt.Sprintf("%s unblocked %s. New messages are bridged again.", sender, p.Info.Guest.Name))
+    }
+    return fmt.Sprintf("Unblocked %s. Messages received while blocked can be seen with !show-suppressed.", p.Info.Guest.Name)
+}
+
+func (b *Bridge) listBlockedGuests() string {
+    blocked, err := b.DB.GetBlockedGuests()
+    if err != nil {
+        b.Logger.Error("Failed to get blocked guests", zap.Error(err))
+        return fmt.Sprintf("Failed to get blocked guests: %v", err)
+    }
+    if len(blocked) == 0 {
+        return "No guests are blocked."
+    }
+
+    var sb strings.Builder
+    sb.WriteString("Blocked guests:\n")
+    for _, guest := range blocked {
+        sb.WriteString(fmt.Sprintf("- %s (%s), blocked by %s on %s", guest.GuestName, guest.HostexID, guest.BlockedBy, guest.BlockedAt.In(b.location()).Format(dateLayout)))
+        if guest.Reason != "" {
+            sb.WriteString(": " + guest.Reason)
+        }
+        sb.WriteString("\n")
+    }
+    return sb.String()
+}
+
+func (u *User) blockCommand(ctx context.Context, roomID id.RoomID, args []string, block bool) {
+    if len(args) == 0 {
+        if block {
+            u.sendNotice(ctx, roomID, "Usage: !block <conversation ID|room ID|guest name>, or !block [reason] in the portal room")
+        } else {
+            u.sendNotice(ctx, roomID, "Usage: !unblock <conversation ID|room ID|guest name>")
+        }
+        return
+    }
+    query := strings.Join(args, " ")
+    portal := u.bridge.findPortal(query)
+    if portal == nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No conversation found for %q.", query))
+        return
+    }
+    if block {
+        u.sendNotice(ctx, roomID, portal.block(ctx, u.MXID, ""))
+    } else {
+        u.sendNotice(ctx, roomID, portal.unblock(ctx, u.MXID))
+    }
+}
@@ -2,10 +2,18 @@ package bridge
 
 import (
     "context"
+    "errors"
     "fmt"
+    "io"
+    "net/http"
+    "strings"
     "sync"
+    "sync/atomic"
     "time"
 
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
     "maunium.net/go/mautrix"
     "maunium.net/go/mautrix/event"
     "maunium.net/go/mautrix/id"
@@ -13,100 +21,372 @@ import (
 
     "github.com/keithah/hostex-bridge-go/config"
     "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/email"
     "github.com/keithah/hostex-bridge-go/hostexapi"
+    "github.com/keithah/hostex-bridge-go/logging"
+    "github.com/keithah/hostex-bridge-go/redisstore"
+    "github.com/keithah/hostex-bridge-go/translate"
 )
 
 type Bridge struct {
-    Config       *config.Config
+    // cfg is the current config. It's replaced, never modified, when an
+    // option is changed with !config set, so readers don't need a lock.
+    cfg          atomic.Pointer[config.Config]
+    cfgLock      sync.Mutex
     DB           *database.Database
-    HostexClient *hostexapi.Client
-    MatrixClient *mautrix.Client
+    HostexClient HostexAPI
+    MatrixClient MatrixClient
+    // AdminClient is the optional client of the admin's account, used to
+    // set room tags, which only the user themselves can set.
+    AdminClient  MatrixClient
     Logger       *zap.Logger
+    AccessLog    *logging.AccessLog
+    // Redis is optional. When set, it's used for the portal metadata cache
+    // and HA coordination instead of the database.
+    Redis *redisstore.Store
+    // Email is the optional email gateway for guests who don't use an OTA.
+    Email *email.Client
+    // Translator is the optional machine translation of guest messages.
+    Translator translate.Translator
 
     usersByMXID    map[id.UserID]*User
+    usersLock      sync.Mutex
     portalsByID    map[string]*Portal
+    portalsByMXID  map[id.RoomID]*Portal
+    portalRetries  map[string]*portalRetry
+    portalsLock    sync.RWMutex
     managementRoom id.RoomID
     spaceRoom      id.RoomID
-
+    calendarRoom   id.RoomID
+    reviewsRoom    id.RoomID
+    archiveSpace   id.RoomID
+
+    accounts   []*hostexAccount
+    pollLock   sync.Mutex
+    invariants *invariantChecker
+    recovery   *downtimeRecovery
+    // names are the ghost and room name templates from the config.
+    names nameTemplates
+    // autoReplies are the auto-responder rules from the config.
+    autoReplies []autoReply
+    // batchSend is whether the initial history is sent with MSC2716 batch
+    // send, which is checked against the homeserver at startup.
+    batchSend bool
+
+    propertySpaces     map[string]id.RoomID
+    propertySpacesLock sync.Mutex
+
+    emailThreads       map[string]*database.EmailThread
+    emailThreadsByMXID map[id.RoomID]*database.EmailThread
+    emailLock          sync.Mutex
+
+    ctx           context.Context
+    cancel        context.CancelFunc
     stop          chan struct{}
+    outboxWake    chan struct{}
+    backfillWake  chan struct{}
     wg            sync.WaitGroup
-    lastPollTime  time.Time
+    stopOnce      sync.Once
+    readyOnce     sync.Once
+    tasks         sync.WaitGroup
+    draining      bool
+    drainLock     sync.Mutex
+    closers       []io.Closer
+    // lastActivity is the unix time in nanoseconds of the last message,
+    // which the adaptive poll interval is based on
+    lastActivity  atomic.Int64
+    lastPolls     map[string]time.Time
+    polled        map[string]bool
+    polledLock    sync.Mutex
+    vacancyGaps   []vacancyGap
+
+    properties         []hostexapi.Property
+    propertiesLock     sync.Mutex
+    selectedProperties atomic.Pointer[[]string]
+
+    leaderLock sync.Mutex
+    leaseUntil time.Time
+
+    // transactionLock serializes appservice transactions, so a transaction
+    // that's redelivered while it's being handled waits for it
+    transactionLock sync.Mutex
 }
 
-func NewBridge(cfg *config.Config, db *database.Database, hostexClient *hostexapi.Client, matrixClient *mautrix.Client, logger *zap.Logger) *Bridge {
-    return &Bridge{
-        Config:       cfg,
-        DB:           db,
-        HostexClient: hostexClient,
-        MatrixClient: matrixClient,
-        Logger:       logger,
-        usersByMXID:  make(map[id.UserID]*User),
-        portalsByID:  make(map[string]*Portal),
-        stop:         make(chan struct{}),
+func NewBridge(cfg *config.Config, db *database.Database, hostexClient HostexAPI, matrixClient MatrixClient, logger *zap.Logger) *Bridge {
+    ctx, cancel := context.WithCancel(context.Background())
+    b := &Bridge{
+        DB:            db,
+        HostexClient:  hostexClient,
+        MatrixClient:  matrixClient,
+        Logger:        logger,
+        usersByMXID:   make(map[id.UserID]*User),
+        portalsByID:   make(map[string]*Portal),
+        portalsByMXID: make(map[id.RoomID]*Portal),
+        portalRetries: make(map[string]*portalRetry),
+        lastPolls:     make(map[string]time.Time),
+        polled:        make(map[string]bool),
+        invariants:    newInvariantChecker(),
+
+        propertySpaces: make(map[string]id.RoomID),
+
+        emailThreads:       make(map[string]*database.EmailThread),
+        emailThreadsByMXID: make(map[id.RoomID]*database.EmailThread),
+
+        ctx:           ctx,
+        cancel:        cancel,
+        stop:          make(chan struct{}),
+        outboxWake:    make(chan struct{}, 1),
+        backfillWake:  make(chan struct{}, 1),
     }
+    b.cfg.Store(cfg)
+    return b
 }
 
 func (b *Bridge) Start() error {
     b.Logger.Info("Starting Hostex bridge")
 
-    ctx := context.Background()
+    ctx := b.ctx
+
+    err := b.applySettings()
+    if err != nil {
+        return fmt.Errorf("failed to load settings: %w", err)
+    }
+    b.names, err = parseNameTemplates(b.Config())
+    if err != nil {
+        return err
+    }
+    if b.Config().AutoResponder.Enable {
+        b.autoReplies, err = parseAutoReplies(b.Config().AutoResponder.Rules)
+        if err != nil {
+            return err
+        }
+    }
 
     // Create or find management room
-    var err error
     b.managementRoom, err = b.createOrFindManagementRoom(ctx)
     if err != nil {
         return fmt.Errorf("failed to create or find management room: %w", err)
     }
 
     // Create personal filtering space if enabled
-    if b.Config.PersonalSpaceEnable {
+    if b.Config().PersonalSpaceEnable {
         b.spaceRoom, err = b.createOrFindPersonalSpace(ctx)
         if err != nil {
             return fmt.Errorf("failed to create or find personal space: %w", err)
         }
     }
 
-    // Start syncing
+    b.checkDowntime(ctx)
+    b.checkBatchSend(ctx)
+
+    // Acquire the leader lease before doing any work in HA mode
+    if b.Config().HA.Enable {
+        b.renewLease()
+        b.wg.Add(1)
+        go b.startLeaderElection()
+    }
+
+    // Start receiving Matrix events, preferably pushed by the homeserver
+    b.wg.Add(1)
+    if b.Config().Appservice.Listen != "" {
+        go b.startAppservice()
+    } else {
+        go b.startSyncing()
+    }
+
+    // Start the profiling listener
+    if b.Config().PProf.Enable {
+        b.wg.Add(1)
+        go b.startPProf()
+    }
+
+    // Start polling, with a poller per Hostex account
+    for _, account := range b.accountNames() {
+        b.wg.Add(1)
+        go b.startPolling(account)
+    }
+
+    // Start delivering queued messages
+    b.wg.Add(1)
+    go b.startOutbox()
+
+    // Start bridging history requested with !backfill
     b.wg.Add(1)
-    go b.startSyncing()
+    go b.startBackfillQueue()
 
-    // Start polling
+    // Start sending messages scheduled with !schedule
     b.wg.Add(1)
-    go b.startPolling()
+    go b.startScheduler()
+
+    // Start watching for new bookings and cancellations
+    if b.Config().ReservationNotices.Enable {
+        b.wg.Add(1)
+        go b.startReservationWatcher()
+    }
+
+    // Start watching for failed payments, deposits and payouts
+    if b.Config().PaymentNotices.Enable {
+        b.wg.Add(1)
+        go b.startPaymentWatcher()
+    }
+
+    // Start posting guest reviews
+    if b.Config().Reviews.Enable {
+        if b.Config().Reviews.DedicatedRoom {
+            b.reviewsRoom, err = b.createOrFindReviewsRoom(ctx)
+            if err != nil {
+                return fmt.Errorf("failed to create or find reviews room: %w", err)
+            }
+        }
+        b.wg.Add(1)
+        go b.startReviewWatcher()
+    }
+
+    // Start check-in and check-out reminders
+    if b.Config().Reminders.Enable {
+        reminders, err := parseReminders(b.Config().Reminders.Rules)
+        if err != nil {
+            return err
+        }
+        b.wg.Add(1)
+        go b.startReminders(reminders)
+    }
+
+    // Start sending upsell offers
+    if b.Config().Upsells.Enable {
+        offers, err := parseUpsellOffers(b.Config().Upsells.Offers)
+        if err != nil {
+            return err
+        }
+        b.wg.Add(1)
+        go b.startDaily(func() string { return b.Config().Upsells.Time }, func(ctx context.Context) {
+            b.sendUpsells(ctx, offers)
+        })
+    }
+
+    // Start mid-stay satisfaction check-ins
+    if b.Config().SatisfactionPulse.Enable {
+        b.wg.Add(1)
+        go b.startDaily(func() string { return b.Config().SatisfactionPulse.Time }, b.sendSatisfactionPulses)
+    }
+
+    // Start archiving portals after checkout
+    if b.Config().PortalArchive.Enable {
+        b.wg.Add(1)
+        go b.startDaily(func() string { return b.Config().PortalArchive.Time }, b.archivePortals)
+    }
+
+    // Start archiving old messages
+    if b.Config().Archive.Enable {
+        b.wg.Add(1)
+        go b.startDaily(func() string { return b.Config().Archive.Time }, b.archiveMessages)
+    }
+
+    // Start deleting messages past the retention period
+    if b.Config().Retention.Enable {
+        b.wg.Add(1)
+        go b.startDaily(func() string { return b.Config().Retention.Time }, b.pruneMessages)
+    }
+
+    // Start email gateway
+    if b.Email != nil {
+        err = b.loadEmailThreads()
+        if err != nil {
+            return fmt.Errorf("failed to load email threads: %w", err)
+        }
+        b.wg.Add(1)
+        go b.startEmailGateway()
+    }
+
+    // Start daily digest
+    if b.Config().Digest.Enable {
+        b.wg.Add(1)
+        go b.startDaily(b.digestTime, b.SendDigest)
+    }
+
+    // Start calendar feed
+    if b.Config().CalendarFeed.Enable {
+        b.calendarRoom, err = b.createOrFindCalendarRoom(ctx)
+        if err != nil {
+            return fmt.Errorf("failed to create or find calendar room: %w", err)
+        }
+        b.wg.Add(1)
+        go b.startDaily(func() string { return b.Config().CalendarFeed.Time }, func(ctx context.Context) {
+            b.PostCalendarFeed(hostexapi.WithPriority(ctx, hostexapi.PriorityBulk))
+        })
+    }
+
+    // Ping the systemd watchdog while the bridge runs
+    if interval := watchdogInterval(); interval > 0 {
+        b.wg.Add(1)
+        go b.startWatchdog(interval)
+    }
 
     // Send setup message
     b.sendSetupMessage(ctx)
+    if !b.HostexClient.HasToken() {
+        b.sendManagementNotice(ctx, "The Hostex API token isn't configured yet. Type !setup to get started.")
+    }
 
+    b.markReady()
     return nil
 }
 
+// Stop stops accepting new work, gives the in-flight backfills and sends
+// until the shutdown timeout to finish, flushes the database and closes the
+// resources created by New. It's safe to call more than once.
 func (b *Bridge) Stop() {
-    b.Logger.Info("Stopping Hostex bridge")
-    close(b.stop)
-    b.wg.Wait()
+    b.stopOnce.Do(func() {
+        b.Logger.Info("Stopping Hostex bridge", zap.Duration("timeout", b.Config().Shutdown.Timeout))
+        b.sdNotify("STOPPING=1")
+        b.drain()
+        if b.Config().HA.Enable {
+            b.releaseLease()
+        }
+        b.recordCleanShutdown()
+        err := b.DB.Flush()
+        if err != nil {
+            b.Logger.Warn("Failed to flush database", zap.Error(err))
+        }
+        for _, closer := range b.closers {
+            err := closer.Close()
+            if err != nil {
+                b.Logger.Warn("Failed to close bridge resource", zap.Error(err))
+            }
+        }
+    })
 }
 
-func (b *Bridge) createOrFindManagementRoom(ctx context.Context) (id.RoomID, error) {
+// findRoomByName returns the first joined room with the given name, or an
+// empty room ID if there is none.
+func (b *Bridge) findRoomByName(ctx context.Context, name string) (id.RoomID, error) {
     rooms, err := b.MatrixClient.JoinedRooms(ctx)
     if err != nil {
         return "", err
     }
 
     for _, roomID := range rooms.JoinedRooms {
-        // Check if this is the management room
         var nameContent event.RoomNameEventContent
         err := b.MatrixClient.StateEvent(ctx, roomID, event.StateRoomName, "", &nameContent)
-        if err == nil && nameContent.Name == "Hostex Bridge Management" {
+        if err == nil && nameContent.Name == name {
             return roomID, nil
         }
     }
+    return "", nil
+}
+
+func (b *Bridge) createOrFindManagementRoom(ctx context.Context) (id.RoomID, error) {
+    roomID, err := b.findRoomByName(ctx, "Hostex Bridge Management")
+    if err != nil || roomID != "" {
+        return roomID, err
+    }
 
     // If not found, create a new management room
     createRoom := &mautrix.ReqCreateRoom{
         Visibility: "private",
         Name:       "Hostex Bridge Management",
         Topic:      "Management room for Hostex bridge",
-        Invite:     []id.UserID{id.UserID(b.Config.Admin.UserID)},
+        Invite:     b.managementInvites(),
     }
     resp, err := b.MatrixClient.CreateRoom(ctx, createRoom)
     if err != nil {
@@ -165,18 +445,30 @@ func (b *Bridge) createOrFindPersonalSpace(ctx context.Context) (id.RoomID, erro
 func (b *Bridge) startSyncing() {
     defer b.wg.Done()
 
-    syncer := b.MatrixClient.Syncer.(*mautrix.DefaultSyncer)
-    syncer.OnEventType(event.EventMessage, func(evt *event.Event) {
+    client, ok := b.MatrixClient.(*mautrix.Client)
+    if !ok {
+        b.Logger.Error("The Matrix client can't sync, set appservice.listen to receive Matrix events")
+        return
+    }
+    syncer := client.Syncer.(*mautrix.DefaultSyncer)
+    syncer.OnEventType(event.EventMessage, func(ctx context.Context, evt *event.Event) {
         b.handleMatrixMessage(evt)
     })
+    syncer.OnEventType(event.EventReaction, func(ctx context.Context, evt *event.Event) {
+        b.handleMatrixReaction(evt)
+    })
+    go func() {
+        <-b.stop
+        client.StopSync()
+    }()
 
     for {
         select {
         case <-b.stop:
             return
         default:
-            err := b.MatrixClient.Sync()
-            if err != nil {
+            err := client.SyncWithContext(b.ctx)
+            if err != nil && b.ctx.Err() == nil {
                 b.Logger.Error("Sync error", zap.Error(err))
                 time.Sleep(5 * time.Second)
             }
@@ -184,72 +476,236 @@ func (b *Bridge) startSyncing() {
     }
 }
 
-func (b *Bridge) startPolling() {
+func (b *Bridge) startPolling(account string) {
     defer b.wg.Done()
 
-    ticker := time.NewTicker(b.Config.PollInterval)
-    defer ticker.Stop()
+    timer := time.NewTimer(b.pollInterval())
+    defer timer.Stop()
 
+    // The first poll syncs every conversation and backfills the new ones,
+    // so it runs at bulk priority to leave budget for the live requests
+    ctx := hostexapi.WithPriority(b.ctx, hostexapi.PriorityBulk)
     for {
         select {
         case <-b.stop:
             return
-        case <-ticker.C:
-            b.pollHostex()
+        case <-timer.C:
+            b.pollHostex(ctx, account)
+            ctx = b.ctx
+            timer.Reset(b.pollInterval())
         }
     }
 }
 
-func (b *Bridge) pollHostex() {
-    b.lastPollTime = time.Now()
-    conversations, err := b.HostexClient.GetConversations()
+// pollHostex fetches the conversations of an account and bridges the ones
+// with new activity. Accounts are polled separately, but their conversations
+// are handled one poll at a time.
+func (b *Bridge) pollHostex(ctx context.Context, account string) {
+    client := b.hostexClient(account)
+    if !b.IsLeader() || !client.HasToken() {
+        return
+    }
+    ctx, span := tracer.Start(ctx, "pollHostex", trace.WithAttributes(attribute.String("hostex.account", accountLabel(account))))
+    defer span.End()
+
+    b.setLastPollTime(account, time.Now())
+    conversations, err := client.GetConversations(ctx)
+    b.recordPoll(err)
     if err != nil {
-        b.Logger.Error("Failed to get conversations", zap.Error(err))
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.SetAttributes(attribute.Int("hostex.conversations", len(conversations)))
+    if errors.Is(err, hostexapi.ErrRateLimited) {
+        b.Logger.Warn("Skipping poll while rate limited by Hostex", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    } else if err != nil {
+        b.Logger.Error("Failed to get conversations", zap.String("account", accountLabel(account)), zap.Error(err))
         return
     }
 
+    b.pollLock.Lock()
+    defer b.pollLock.Unlock()
     for _, conv := range conversations {
-        b.handleHostexConversation(conv)
+        if !b.isPropertySelected(conv.PropertyTitle) {
+            continue
+        }
+        conv.ID = portalKey(account, conv.ID)
+        b.handleHostexConversation(ctx, conv)
+    }
+    b.markPolled(account)
+    if b.Config().Invariants.Enable {
+        b.checkInvariants(ctx)
     }
+    b.finishRecovery(ctx)
+}
+
+func (b *Bridge) setLastPollTime(account string, at time.Time) {
+    b.polledLock.Lock()
+    defer b.polledLock.Unlock()
+    b.lastPolls[account] = at
+}
+
+// GetLastPollTime returns when the account was last polled, or the zero time
+// if it wasn't polled since the bridge started.
+func (b *Bridge) GetLastPollTime(account string) time.Time {
+    b.polledLock.Lock()
+    defer b.polledLock.Unlock()
+    return b.lastPolls[account]
+}
+
+// touchActivity records that a message was just sent or received.
+func (b *Bridge) touchActivity() {
+    b.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (b *Bridge) markPolled(account string) {
+    b.polledLock.Lock()
+    defer b.polledLock.Unlock()
+    b.polled[account] = true
+}
+
+// hasPolled reports whether the conversations of the account were loaded by
+// a successful poll since the bridge started.
+func (b *Bridge) hasPolled(account string) bool {
+    b.polledLock.Lock()
+    defer b.polledLock.Unlock()
+    return b.polled[account]
 }
 
-func (b *Bridge) handleHostexConversation(conv hostexapi.Conversation) {
-    portal, ok := b.portalsByID[conv.ID]
+func (b *Bridge) handleHostexConversation(ctx context.Context, conv hostexapi.Conversation) {
+    portal, ok := b.getPortalByID(conv.ID)
     if !ok {
+        deleted, err := b.DB.IsPortalDeleted(conv.ID)
+        if err != nil {
+            b.Logger.Error("Failed to check if portal was deleted", zap.Error(err))
+            return
+        } else if deleted {
+            return
+        }
+        alias, err := b.DB.GetPortalAlias(conv.ID)
+        if err != nil {
+            b.Logger.Error("Failed to check portal aliases", zap.Error(err))
+            return
+        } else if alias != "" {
+            // The conversation was merged into a newer one
+            return
+        }
+        existingRoomID, err := b.DB.GetPortal(conv.ID)
+        if err != nil {
+            b.Logger.Error("Failed to get portal", zap.Error(err))
+            return
+        }
+        if continued := b.findContinuedPortal(conv); continued != nil && existingRoomID == "" {
+            err = b.mergePortal(ctx, continued, conv)
+            if err != nil {
+                b.Logger.Error("Failed to merge conversation into existing portal", zap.String("hostex_id", conv.ID), zap.Error(err))
+                return
+            }
+            b.handleHostexConversation(ctx, conv)
+            return
+        }
         portal = NewPortal(b, conv.ID)
-        b.portalsByID[conv.ID] = portal
+        err = portal.loadLastMessageAt()
+        if err != nil {
+            b.Logger.Error("Failed to load portal state", zap.Error(err))
+            return
+        }
+        portal.archived, err = b.DB.IsPortalArchived(conv.ID)
+        if err != nil {
+            b.Logger.Error("Failed to load portal state", zap.Error(err))
+            return
+        }
+        portal.blocked, err = b.DB.IsGuestBlocked(conv.ID)
+        if err != nil {
+            b.Logger.Error("Failed to load portal state", zap.Error(err))
+            return
+        }
+        if added := b.addPortal(portal); added != portal {
+            portal = added
+        } else if existingRoomID == "" && !portal.blocked {
+            b.checkBlockedGuest(ctx, conv)
+        }
     }
 
-    portal.UpdateInfo(conv)
-    err := portal.CreateMatrixRoom()
+    portal.UpdateInfo(ctx, conv)
+    if portal.RoomID == "" && !b.portalRetryDue(conv.ID) {
+        return
+    }
+    // Archived portals whose room was left only get a new room once the
+    // guest writes again
+    if portal.RoomID == "" && portal.archived && !conv.LastMessageAt.After(portal.lastMessageAt) {
+        return
+    }
+    err := portal.CreateMatrixRoom(ctx)
     if err != nil {
-        b.Logger.Error("Failed to create Matrix room", zap.Error(err))
+        b.portalCreationFailed(ctx, conv.ID, err)
+        return
+    }
+    b.clearPortalRetry(conv.ID)
+    b.setPortalRoom(portal)
+
+    // Only fetch messages for conversations with new activity
+    if !conv.LastMessageAt.IsZero() && !conv.LastMessageAt.After(portal.lastMessageAt) {
         return
     }
+    if b.recovery != nil && conv.LastMessageAt.After(b.recovery.since) {
+        b.recovery.conversations++
+    }
+    b.touchActivity()
 
-    err = portal.BackfillMessages()
+    err = portal.BackfillMessages(ctx)
     if err != nil {
         b.Logger.Error("Failed to backfill messages", zap.Error(err))
+        return
+    }
+
+    err = portal.setLastMessageAt(conv.LastMessageAt)
+    if err != nil {
+        b.Logger.Error("Failed to store last message time", zap.Error(err))
     }
 }
 
 func (b *Bridge) handleMatrixMessage(evt *event.Event) {
-    if evt.RoomID == b.managementRoom {
+    if evt.Sender == b.botUserID() || b.isGhost(evt.Sender) {
+        return
+    }
+    if !b.IsLeader() {
+        // Standby instances still answer !status, so each instance can
+        // report its own role
+        if content, ok := evt.Content.Parsed.(*event.MessageEventContent); ok && evt.RoomID == b.managementRoom &&
+            strings.TrimSpace(content.Body) == "!status" && b.permissionLevel(evt.Sender, evt.RoomID) >= PermissionAdmin {
+            b.getUser(evt.Sender).sendStatusMessage(b.ctx, evt.RoomID)
+        }
+        return
+    }
+
+    if evt.RoomID == b.managementRoom || (evt.RoomID == b.reviewsRoom && b.reviewsRoom != "") {
         b.handleManagementCommand(evt)
         return
     }
 
-    portal, ok := b.portalsByID[evt.RoomID.String()]
+    portal, ok := b.getPortalByMXID(evt.RoomID)
     if !ok {
+        b.emailLock.Lock()
+        thread, isEmail := b.emailThreadsByMXID[evt.RoomID]
+        b.emailLock.Unlock()
+        if isEmail {
+            b.touchActivity()
+            b.handleEmailRoomMessage(evt, thread)
+            return
+        }
         b.Logger.Warn("Received message for unknown portal", zap.String("room_id", evt.RoomID.String()))
         return
     }
+    b.touchActivity()
 
     portal.HandleMatrixMessage(evt)
 }
 
 func (b *Bridge) handleManagementCommand(evt *event.Event) {
-    if evt.Sender != id.UserID(b.Config.Admin.UserID) {
+    level := b.permissionLevel(evt.Sender, evt.RoomID)
+    if level < PermissionAdmin {
         b.Logger.Warn("Unauthorized management command", zap.String("sender", evt.Sender.String()))
         return
     }
@@ -259,15 +715,35 @@ func (b *Bridge) handleManagementCommand(evt *event.Event) {
         return
     }
 
-    user, ok := b.usersByMXID[evt.Sender]
-    if !ok {
-        user = NewUser(b, evt.Sender)
-        b.usersByMXID[evt.Sender] = user
+    if fields := strings.Fields(content.Body); len(fields) > 0 && !b.checkOwnerCommand(b.ctx, evt.RoomID, fields[0], level) {
+        return
     }
 
+    user := b.getUser(evt.Sender)
+    if user.pendingImport != nil && content.MsgType == event.MsgFile {
+        user.handleImportFile(b.ctx, evt.RoomID, content)
+        return
+    }
+    if user.setup != nil && !strings.HasPrefix(content.Body, "!") && evt.RoomID == b.managementRoom {
+        user.handleSetupAnswer(b.ctx, evt, content.Body)
+        return
+    }
     user.HandleCommand(evt.RoomID, content.Body)
 }
 
+func (b *Bridge) getUser(mxid id.UserID) *User {
+    b.usersLock.Lock()
+    defer b.usersLock.Unlock()
+
+    user, ok := b.usersByMXID[mxid]
+    if !ok {
+        user = NewUser(b, mxid)
+        user.loadPreferences(b.ctx)
+        b.usersByMXID[mxid] = user
+    }
+    return user
+}
+
 func (b *Bridge) sendSetupMessage(ctx context.Context) {
     content := &event.MessageEventContent{
         MsgType: event.MsgText,
@@ -279,12 +755,30 @@ func (b *Bridge) sendSetupMessage(ctx context.Context) {
     }
 }
 
-func (b *Bridge) GetLastPollTime() time.Time {
-    return b.lastPollTime
+func (b *Bridge) sendManagementNotice(ctx context.Context, message string) {
+    b.sendNotice(ctx, b.managementRoom, message)
 }
 
-func (b *Bridge) ForceSyncConversations() {
-    b.pollHostex()
+func (b *Bridge) sendNotice(ctx context.Context, roomID id.RoomID, message string) {
+    content := &event.MessageEventContent{
+        MsgType: event.MsgNotice,
+        Body:    message,
+    }
+    _, err := b.MatrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+    if err != nil {
+        b.Logger.Error("Failed to send notice", zap.String("room_id", roomID.String()), zap.Error(err))
+    }
+}
+
+func (b *Bridge) ForceSyncConversations(ctx context.Context) {
+    for _, account := range b.accountNames() {
+        b.pollHostex(ctx, account)
+    }
+}
+
+// botUserID is the Matrix user the bridge bot acts as.
+func (b *Bridge) botUserID() id.UserID {
+    return id.UserID(b.Config().User.UserID)
 }
 
 func NewMatrixClient(homeserverURL, userID, accessToken string) (*mautrix.Client, error) {
@@ -292,5 +786,10 @@ func NewMatrixClient(homeserverURL, userID, accessToken string) (*mautrix.Client
     if err != nil {
         return nil, err
     }
+    transport := client.Client.Transport
+    if transport == nil {
+        transport = http.DefaultTransport
+    }
+    client.Client.Transport = &tracingTransport{base: transport}
     return client, nil
 }
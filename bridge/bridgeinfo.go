@@ -0,0 +1,75 @@
+package bridge
+
+import (
+    "context"
+
+    "maunium.net/go/mautrix/event"
+    "go.uber.org/zap"
+)
+
+// bridgeInfoVersion is bumped when the content of the bridge info state
+// changes, so existing portal rooms get the new one on the next start.
+const bridgeInfoVersion = 1
+
+var hostexProtocol = event.BridgeInfoSection{
+    ID:          "hostex",
+    DisplayName: "Hostex",
+    ExternalURL: "https://hostex.io",
+}
+
+type portalBridgeInfo struct {
+    stateKey string
+    content  *event.BridgeEventContent
+}
+
+// bridgeInfo returns the m.bridge state of the portal room, which tells
+// clients and integrations the room is bridged to a Hostex conversation on
+// the guest's channel.
+func (p *Portal) bridgeInfo() portalBridgeInfo {
+    content := &event.BridgeEventContent{
+        BridgeBot: p.bridge.botUserID(),
+        Protocol:  hostexProtocol,
+        Channel: event.BridgeInfoSection{
+            ID:          p.conversationID(),
+            DisplayName: p.Info.Guest.Name,
+        },
+    }
+    if p.Info.ChannelType != "" {
+        content.Network = &event.BridgeInfoSection{
+            ID:          p.Info.ChannelType,
+            DisplayName: p.Info.ChannelType,
+        }
+    }
+    return portalBridgeInfo{
+        stateKey: "hostex://hostex/" + p.ID,
+        content:  content,
+    }
+}
+
+// updateBridgeInfo sends the bridge info state to the portal room, as both
+// m.bridge and the older uk.half-shot.bridge. Unless force is set, it's only
+// sent if the room has an older version of it.
+func (p *Portal) updateBridgeInfo(ctx context.Context, force bool) {
+    if !force {
+        version, err := p.bridge.DB.GetPortalBridgeInfoVersion(p.ID)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to get bridge info version", zap.Error(err))
+            return
+        } else if version >= bridgeInfoVersion {
+            return
+        }
+    }
+
+    info := p.bridgeInfo()
+    for _, evtType := range []event.Type{event.StateBridge, event.StateHalfShotBridge} {
+        _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, evtType, info.stateKey, info.content)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to update bridge info", zap.String("room_id", p.RoomID.String()), zap.String("type", evtType.Type), zap.Error(err))
+            return
+        }
+    }
+    err := p.bridge.DB.SetPortalBridgeInfoVersion(p.ID, bridgeInfoVersion)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store bridge info version", zap.Error(err))
+    }
+}
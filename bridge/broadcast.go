@@ -0,0 +1,147 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+const dateLayout = "2006-01-02"
+
+// isCurrentGuest reports whether the portal's guest is staying at the
+// property on the given day.
+func (p *Portal) isCurrentGuest(now time.Time) bool {
+    checkIn, err := time.ParseInLocation(dateLayout, p.Info.CheckInDate, now.Location())
+    if err != nil {
+        return false
+    }
+    checkOut, err := time.ParseInLocation(dateLayout, p.Info.CheckOutDate, now.Location())
+    if err != nil {
+        return false
+    }
+    return !now.Before(checkIn) && now.Before(checkOut.AddDate(0, 0, 1))
+}
+
+// selectPortals expands an audience selector into the matching portals.
+// Supported selectors are current-guests, arriving:<today|tomorrow|date>
+// and property:<name>.
+func (b *Bridge) selectPortals(selector string) ([]*Portal, error) {
+    now := time.Now().In(b.location())
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+    kind, value, _ := strings.Cut(selector, ":")
+    var match func(portal *Portal) bool
+    switch strings.ToLower(kind) {
+    case "current-guests":
+        match = func(portal *Portal) bool {
+            return portal.isCurrentGuest(now)
+        }
+    case "arriving":
+        var date string
+        switch strings.ToLower(value) {
+        case "today":
+            date = today.Format(dateLayout)
+        case "tomorrow":
+            date = today.AddDate(0, 0, 1).Format(dateLayout)
+        default:
+            _, err := time.Parse(dateLayout, value)
+            if err != nil {
+                return nil, fmt.Errorf("invalid arrival date %q, expected today, tomorrow or YYYY-MM-DD", value)
+            }
+            date = value
+        }
+        match = func(portal *Portal) bool {
+            return portal.Info.CheckInDate == date
+        }
+    case "property":
+        if value == "" {
+            return nil, fmt.Errorf("missing property name")
+        }
+        value = strings.ToLower(value)
+        match = func(portal *Portal) bool {
+            return strings.Contains(strings.ToLower(portal.Info.PropertyTitle), value)
+        }
+    default:
+        return nil, fmt.Errorf("unknown audience %q", selector)
+    }
+
+    var portals []*Portal
+    for _, portal := range b.allPortals() {
+        if portal.RoomID != "" && match(portal) {
+            portals = append(portals, portal)
+        }
+    }
+    return portals, nil
+}
+
+// SendBroadcast posts the message in every given portal room on behalf of
+// sender and queues it in the outbox, which delivers it with retries and a
+// delivery status like any other reply. It returns the errors of the portals
+// the message couldn't be queued for, by portal ID.
+func (b *Bridge) SendBroadcast(ctx context.Context, sender id.UserID, portals []*Portal, message string) map[string]error {
+    failed := make(map[string]error)
+    for _, portal := range portals {
+        err := portal.sendBridgeMessage(ctx, sender, message)
+        if err != nil {
+            b.Logger.Warn("Failed to queue broadcast", zap.String("hostex_id", portal.ID), zap.Error(err))
+            failed[portal.ID] = err
+        }
+    }
+    return failed
+}
+
+func (u *User) broadcast(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) < 2 {
+        u.sendNotice(ctx, roomID, "Usage: !broadcast <current-guests|arriving:<day>|property:<name>> <message>")
+        return
+    }
+
+    selector := args[0]
+    message := strings.Join(args[1:], " ")
+
+    portals, err := u.bridge.selectPortals(selector)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Invalid audience: %v", err))
+        return
+    }
+    if len(portals) == 0 {
+        u.sendNotice(ctx, roomID, "No conversations match that audience.")
+        return
+    }
+
+    var preview strings.Builder
+    preview.WriteString(fmt.Sprintf("Broadcast to %d conversations (%s):\n", len(portals), selector))
+    for _, portal := range portals {
+        preview.WriteString(fmt.Sprintf("- %s (%s, %s)\n", portal.Info.Guest.Name, portal.Info.PropertyTitle, portal.Info.ChannelType))
+    }
+    preview.WriteString(fmt.Sprintf("\nMessage: %s", message))
+
+    u.requestConfirmation(ctx, roomID, preview.String(), func(ctx context.Context) {
+        started := u.bridge.goTask(func() {
+            u.sendBroadcast(ctx, roomID, portals, message)
+        })
+        if !started {
+            u.sendNotice(ctx, roomID, "The bridge is shutting down, broadcast not sent.")
+        }
+    })
+}
+
+func (u *User) sendBroadcast(ctx context.Context, roomID id.RoomID, portals []*Portal, message string) {
+    failed := u.bridge.SendBroadcast(ctx, u.MXID, portals, message)
+
+    report := fmt.Sprintf("Broadcast queued for %d of %d conversations. The delivery status is shown in each room.", len(portals)-len(failed), len(portals))
+    if len(failed) > 0 {
+        var failures strings.Builder
+        for _, portal := range portals {
+            if err, ok := failed[portal.ID]; ok {
+                failures.WriteString(fmt.Sprintf("- %s: %v\n", portal.Info.Guest.Name, err))
+            }
+        }
+        report += "\nFailed:\n" + failures.String()
+    }
+    u.sendNotice(ctx, roomID, report)
+}
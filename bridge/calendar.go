@@ -0,0 +1,162 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// CalendarContentKey is the key of the structured event list included in
+// calendar feed messages for widgets and bots.
+const CalendarContentKey = "com.hostex.calendar"
+
+type calendarEvent struct {
+    Type            string `json:"type"`
+    Date            string `json:"date"`
+    ReservationCode string `json:"reservation_code"`
+    PropertyID      string `json:"property_id"`
+    PropertyTitle   string `json:"property_title"`
+    GuestName       string `json:"guest_name"`
+    ChannelType     string `json:"channel_type"`
+}
+
+func (ce calendarEvent) summary() string {
+    action := "Check-in"
+    if ce.Type == "check_out" {
+        action = "Check-out"
+    }
+    return fmt.Sprintf("%s: %s at %s", action, ce.GuestName, ce.PropertyTitle)
+}
+
+func (b *Bridge) createOrFindCalendarRoom(ctx context.Context) (id.RoomID, error) {
+    roomID, err := b.findRoomByName(ctx, "Hostex Calendar")
+    if err != nil || roomID != "" {
+        return roomID, err
+    }
+
+    createRoom := &mautrix.ReqCreateRoom{
+        Visibility: "private",
+        Name:       "Hostex Calendar",
+        Topic:      "Upcoming check-ins and check-outs",
+        Invite:     b.managementInvites(),
+    }
+    resp, err := b.MatrixClient.CreateRoom(ctx, createRoom)
+    if err != nil {
+        return "", err
+    }
+    return resp.RoomID, nil
+}
+
+func (b *Bridge) upcomingCalendarEvents(ctx context.Context) ([]calendarEvent, error) {
+    now := time.Now().In(b.location())
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    first := today.Format(dateLayout)
+    last := today.AddDate(0, 0, b.Config().CalendarFeed.HorizonDays).Format(dateLayout)
+
+    // Start a month back so check-outs of ongoing stays are included
+    reservations, err := b.getAllReservations(ctx, today.AddDate(0, -1, 0).Format(dateLayout), last)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get reservations: %w", err)
+    }
+
+    var events []calendarEvent
+    for _, res := range reservations {
+        if strings.EqualFold(res.Status, "cancelled") {
+            continue
+        }
+        for _, evt := range []calendarEvent{
+            {Type: "check_in", Date: res.CheckInDate},
+            {Type: "check_out", Date: res.CheckOutDate},
+        } {
+            if evt.Date < first || evt.Date > last {
+                continue
+            }
+            evt.ReservationCode = res.ReservationCode
+            evt.PropertyID = res.PropertyID
+            evt.PropertyTitle = res.PropertyTitle
+            evt.GuestName = res.GuestName
+            evt.ChannelType = res.ChannelType
+            events = append(events, evt)
+        }
+    }
+
+    sort.Slice(events, func(i, j int) bool {
+        return events[i].Date < events[j].Date
+    })
+    return events, nil
+}
+
+func escapeICS(value string) string {
+    return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
+
+func buildICS(events []calendarEvent) []byte {
+    var ics strings.Builder
+    ics.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Hostex Bridge//Calendar Feed//EN\r\n")
+    stamp := time.Now().UTC().Format("20060102T150405Z")
+    for _, evt := range events {
+        date, err := time.Parse(dateLayout, evt.Date)
+        if err != nil {
+            continue
+        }
+        ics.WriteString("BEGIN:VEVENT\r\n")
+        ics.WriteString(fmt.Sprintf("UID:%s-%s@hostex-bridge\r\n", evt.ReservationCode, evt.Type))
+        ics.WriteString(fmt.Sprintf("DTSTAMP:%s\r\n", stamp))
+        ics.WriteString(fmt.Sprintf("DTSTART;VALUE=DATE:%s\r\n", date.Format("20060102")))
+        ics.WriteString(fmt.Sprintf("DTEND;VALUE=DATE:%s\r\n", date.AddDate(0, 0, 1).Format("20060102")))
+        ics.WriteString(fmt.Sprintf("SUMMARY:%s\r\n", escapeICS(evt.summary())))
+        ics.WriteString(fmt.Sprintf("LOCATION:%s\r\n", escapeICS(evt.PropertyTitle)))
+        ics.WriteString("END:VEVENT\r\n")
+    }
+    ics.WriteString("END:VCALENDAR\r\n")
+    return []byte(ics.String())
+}
+
+// PostCalendarFeed posts the upcoming check-ins and check-outs to the calendar
+// room, both as a notice with structured content and as an .ics file.
+func (b *Bridge) PostCalendarFeed(ctx context.Context) {
+    events, err := b.upcomingCalendarEvents(ctx)
+    if err != nil {
+        b.Logger.Error("Failed to build calendar feed", zap.Error(err))
+        return
+    }
+
+    var body strings.Builder
+    body.WriteString(fmt.Sprintf("Upcoming check-ins and check-outs (next %d days):\n", b.Config().CalendarFeed.HorizonDays))
+    if len(events) == 0 {
+        body.WriteString("Nothing scheduled.\n")
+    }
+    for _, evt := range events {
+        body.WriteString(fmt.Sprintf("- %s %s\n", evt.Date, evt.summary()))
+    }
+
+    content := &event.Content{
+        Parsed: &event.MessageEventContent{
+            MsgType: event.MsgNotice,
+            Body:    body.String(),
+        },
+        Raw: map[string]interface{}{
+            CalendarContentKey: map[string]interface{}{
+                "events": events,
+            },
+        },
+    }
+    _, err = b.MatrixClient.SendMessageEvent(ctx, b.calendarRoom, event.EventMessage, content)
+    if err != nil {
+        b.Logger.Error("Failed to send calendar feed", zap.Error(err))
+        return
+    }
+
+    ics := buildICS(events)
+    err = b.sendFile(ctx, b.calendarRoom, "hostex-calendar.ics", "text/calendar", ics)
+    if err != nil {
+        b.Logger.Error("Failed to send calendar file", zap.Error(err))
+    }
+}
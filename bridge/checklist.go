@@ -0,0 +1,114 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "go.uber.org/zap"
+)
+
+var ChecklistEventType = event.Type{Type: "com.hostex.checklist", Class: event.StateEventType}
+
+type checklistItem struct {
+    Key   string
+    Label string
+}
+
+var checklistItems = []checklistItem{
+    {"instructions", "Instructions sent"},
+    {"id", "ID verified"},
+    {"deposit", "Deposit collected"},
+    {"review", "Review left"},
+}
+
+// ChecklistEventContent is the content of the com.hostex.checklist state event.
+// Items maps each checked item key to the unix millisecond time it was checked.
+type ChecklistEventContent struct {
+    Items map[string]int64 `json:"items"`
+}
+
+func isChecklistItem(key string) bool {
+    for _, item := range checklistItems {
+        if item.Key == key {
+            return true
+        }
+    }
+    return false
+}
+
+func (p *Portal) loadChecklist() error {
+    checklist, err := p.bridge.DB.GetChecklist(p.ID)
+    if err != nil {
+        return fmt.Errorf("failed to load checklist: %w", err)
+    }
+    p.Checklist = checklist
+    return nil
+}
+
+func (p *Portal) SetChecklistItem(ctx context.Context, item string, checked bool) error {
+    err := p.bridge.DB.SetChecklistItem(p.ID, item, checked)
+    if err != nil {
+        return fmt.Errorf("failed to store checklist item: %w", err)
+    }
+    if checked {
+        p.Checklist[item] = time.Now()
+    } else {
+        delete(p.Checklist, item)
+    }
+
+    content := &ChecklistEventContent{Items: make(map[string]int64)}
+    for key, checkedAt := range p.Checklist {
+        content.Items[key] = checkedAt.UnixMilli()
+    }
+    _, err = p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, ChecklistEventType, "", content)
+    if err != nil {
+        return fmt.Errorf("failed to send checklist state event: %w", err)
+    }
+    return nil
+}
+
+func (p *Portal) formatChecklist() string {
+    var sb strings.Builder
+    for _, item := range checklistItems {
+        mark := " "
+        if _, ok := p.Checklist[item.Key]; ok {
+            mark = "x"
+        }
+        sb.WriteString(fmt.Sprintf("[%s] %s (%s)\n", mark, item.Label, item.Key))
+    }
+    return sb.String()
+}
+
+func (p *Portal) checklistProgress() string {
+    var done int
+    for _, item := range checklistItems {
+        if _, ok := p.Checklist[item.Key]; ok {
+            done++
+        }
+    }
+    return fmt.Sprintf("%d/%d", done, len(checklistItems))
+}
+
+func (p *Portal) handleCheckCommand(ctx context.Context, args []string, checked bool) {
+    if len(args) == 0 {
+        p.sendNotice(ctx, "Checklist:\n"+p.formatChecklist())
+        return
+    }
+
+    item := strings.ToLower(args[0])
+    if !isChecklistItem(item) {
+        p.sendNotice(ctx, "Unknown checklist item. Available items:\n"+p.formatChecklist())
+        return
+    }
+
+    err := p.SetChecklistItem(ctx, item, checked)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to update checklist", zap.Error(err))
+        p.sendNotice(ctx, fmt.Sprintf("Failed to update checklist: %v", err))
+        return
+    }
+    p.sendNotice(ctx, "Checklist updated:\n"+p.formatChecklist())
+}
@@ -0,0 +1,285 @@
+package bridge
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v2"
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/config"
+)
+
+// configSettingPrefix is prepended to the setting key of config overrides
+// made with !config set.
+const configSettingPrefix = "config."
+
+// configOption is a config value that can be changed in chat. set validates
+// the value and applies it to the running bridge, and is also used to apply
+// the stored overrides on startup.
+type configOption struct {
+    description string
+    get         func(b *Bridge) string
+    set         func(b *Bridge, value string) error
+}
+
+var configOptions = map[string]configOption{
+    "poll_interval": {
+        description: "How often Hostex is polled, e.g. 30s",
+        get:         func(b *Bridge) string { return b.Config().PollInterval.String() },
+        set: func(b *Bridge, value string) error {
+            interval, err := time.ParseDuration(value)
+            if err != nil {
+                return err
+            } else if interval < time.Second {
+                return fmt.Errorf("poll interval must be at least 1s")
+            }
+            b.updateConfig(func(cfg *config.Config) {
+                cfg.PollInterval = interval
+            })
+            return nil
+        },
+    },
+    "digest.time": {
+        description: "When the daily digest is sent (HH:MM)",
+        get:         func(b *Bridge) string { return b.Config().Digest.Time },
+        set: func(b *Bridge, value string) error {
+            err := validateClock(value)
+            if err != nil {
+                return err
+            }
+            b.updateConfig(func(cfg *config.Config) {
+                cfg.Digest.Time = value
+            })
+            return nil
+        },
+    },
+    "quiet_hours.enable": {
+        description: "Whether quiet hours are enabled (true or false)",
+        get:         func(b *Bridge) string { return strconv.FormatBool(b.Config().QuietHours.Enable) },
+        set: func(b *Bridge, value string) error {
+            enable, err := strconv.ParseBool(value)
+            if err != nil {
+                return err
+            }
+            b.updateConfig(func(cfg *config.Config) {
+                cfg.QuietHours.Enable = enable
+            })
+            return nil
+        },
+    },
+    "quiet_hours.start": {
+        description: "When quiet hours start (HH:MM)",
+        get:         func(b *Bridge) string { return b.Config().QuietHours.Start },
+        set: func(b *Bridge, value string) error {
+            err := validateClock(value)
+            if err != nil {
+                return err
+            }
+            b.updateConfig(func(cfg *config.Config) {
+                cfg.QuietHours.Start = value
+            })
+            return nil
+        },
+    },
+    "quiet_hours.end": {
+        description: "When quiet hours end (HH:MM)",
+        get:         func(b *Bridge) string { return b.Config().QuietHours.End },
+        set: func(b *Bridge, value string) error {
+            err := validateClock(value)
+            if err != nil {
+                return err
+            }
+            b.updateConfig(func(cfg *config.Config) {
+                cfg.QuietHours.End = value
+            })
+            return nil
+        },
+    },
+    "quiet_hours.urgent_keywords": {
+        description: "Keywords that are bridged during quiet hours, as a YAML list, e.g. [urgent, \"locked out\"]",
+        get:         func(b *Bridge) string { return formatList(b.Config().QuietHours.UrgentKeywords) },
+        set: func(b *Bridge, value string) error {
+            keywords, err := parseList(value)
+            if err != nil {
+                return err
+            }
+            b.updateConfig(func(cfg *config.Config) {
+                cfg.QuietHours.UrgentKeywords = keywords
+            })
+            return nil
+        },
+    },
+    "properties": {
+        description: "Titles of the properties to bridge as a YAML list, e.g. [Beach House, \"Loft, Downtown\"], or all",
+        get: func(b *Bridge) string {
+            selected := b.getSelectedProperties()
+            if len(selected) == 0 {
+                return "all"
+            }
+            return formatList(selected)
+        },
+        set: func(b *Bridge, value string) error {
+            var selected []string
+            if !strings.EqualFold(strings.TrimSpace(value), "all") {
+                var err error
+                selected, err = parseList(value)
+                if err != nil {
+                    return err
+                }
+            }
+            b.selectedProperties.Store(&selected)
+            return nil
+        },
+    },
+}
+
+func validateClock(value string) error {
+    _, err := time.Parse("15:04", value)
+    if err != nil {
+        return fmt.Errorf("invalid time %q, expected HH:MM", value)
+    }
+    return nil
+}
+
+// parseList parses a YAML list option, like [a, "b, c"]. A single value
+// without brackets is a list of one item.
+func parseList(value string) ([]string, error) {
+    var items []string
+    err := yaml.Unmarshal([]byte(value), &items)
+    if err != nil {
+        var item string
+        if yaml.Unmarshal([]byte(value), &item) != nil {
+            return nil, fmt.Errorf("invalid list %q, expected e.g. [a, b]", value)
+        }
+        items = []string{item}
+    }
+    var list []string
+    for _, item := range items {
+        if item = strings.TrimSpace(item); item != "" {
+            list = append(list, item)
+        }
+    }
+    return list, nil
+}
+
+// formatList formats a list option so that parseList reads it back.
+func formatList(items []string) string {
+    if items == nil {
+        items = []string{}
+    }
+    // JSON is a subset of YAML, and quotes the items that need it
+    value, _ := json.Marshal(items)
+    return string(value)
+}
+
+// Config returns the current config. It must not be modified, changes go
+// through updateConfig.
+func (b *Bridge) Config() *config.Config {
+    return b.cfg.Load()
+}
+
+// updateConfig replaces the config with a copy changed by fn, so goroutines
+// that are reading the current config aren't affected.
+func (b *Bridge) updateConfig(fn func(cfg *config.Config)) {
+    b.cfgLock.Lock()
+    defer b.cfgLock.Unlock()
+    cfg := *b.cfg.Load()
+    fn(&cfg)
+    b.cfg.Store(&cfg)
+}
+
+// getSelectedProperties returns the titles of the properties chosen in the
+// setup wizard or with !config set properties.
+func (b *Bridge) getSelectedProperties() []string {
+    selected := b.selectedProperties.Load()
+    if selected == nil {
+        return nil
+    }
+    return *selected
+}
+
+// applyConfigOverrides applies the values changed with !config set on top of
+// the config file.
+func (b *Bridge) applyConfigOverrides() error {
+    for key, option := range configOptions {
+        value, err := b.DB.GetSetting(configSettingPrefix + key)
+        if err != nil {
+            return err
+        } else if value == "" {
+            continue
+        }
+        err = option.set(b, value)
+        if err != nil {
+            return fmt.Errorf("invalid override for %s: %w", key, err)
+        }
+    }
+    return nil
+}
+
+// setConfigOption validates, applies and stores a config override.
+func (b *Bridge) setConfigOption(key, value string) error {
+    option, ok := configOptions[key]
+    if !ok {
+        return fmt.Errorf("unknown option %q", key)
+    }
+    err := option.set(b, value)
+    if err != nil {
+        return err
+    }
+    return b.DB.SetSetting(configSettingPrefix+key, value)
+}
+
+func (u *User) handleConfigCommand(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !config <list|get|set> [key] [value]")
+        return
+    }
+
+    switch strings.ToLower(args[0]) {
+    case "list":
+        keys := make([]string, 0, len(configOptions))
+        for key := range configOptions {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        var list strings.Builder
+        list.WriteString("Options that can be changed with !config set:\n")
+        for _, key := range keys {
+            option := configOptions[key]
+            list.WriteString(fmt.Sprintf("- %s = %s\n  %s\n", key, option.get(u.bridge), option.description))
+        }
+        u.sendNotice(ctx, roomID, list.String())
+    case "get":
+        if len(args) != 2 {
+            u.sendNotice(ctx, roomID, "Usage: !config get <key>")
+            return
+        }
+        option, ok := configOptions[args[1]]
+        if !ok {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Unknown option %q. Use !config list to see the available options.", args[1]))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("%s = %s", args[1], option.get(u.bridge)))
+    case "set":
+        if len(args) < 3 {
+            u.sendNotice(ctx, roomID, "Usage: !config set <key> <value>")
+            return
+        }
+        key, value := args[1], strings.Join(args[2:], " ")
+        err := u.bridge.setConfigOption(key, value)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to set %s: %v", key, err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Set %s to %s. The change overrides the config file and survives restarts.", key, configOptions[key].get(u.bridge)))
+    default:
+        u.sendNotice(ctx, roomID, "Usage: !config <list|get|set> [key] [value]")
+    }
+}
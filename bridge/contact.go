@@ -0,0 +1,42 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+// phoneDigits strips everything but digits from a phone number, as wa.me
+// links require the number in international format without "+" or spaces.
+func phoneDigits(phone string) string {
+    var digits strings.Builder
+    for _, r := range phone {
+        if r >= '0' && r <= '9' {
+            digits.WriteRune(r)
+        }
+    }
+    return digits.String()
+}
+
+// sendContactLinks posts click-to-chat links for reaching the guest outside
+// the OTA, built from the contact details Hostex has for them.
+func (p *Portal) sendContactLinks(ctx context.Context) {
+    guest := p.Info.Guest
+    var links strings.Builder
+    if digits := phoneDigits(guest.Phone); digits != "" {
+        links.WriteString(fmt.Sprintf("WhatsApp: https://wa.me/%s\n", digits))
+        links.WriteString(fmt.Sprintf("Phone: tel:+%s\n", digits))
+        links.WriteString(fmt.Sprintf("SMS: sms:+%s\n", digits))
+    }
+    if guest.Email != "" {
+        subject := url.PathEscape(fmt.Sprintf("Your stay at %s", p.Info.PropertyTitle))
+        links.WriteString(fmt.Sprintf("Email: mailto:%s?subject=%s\n", guest.Email, subject))
+    }
+
+    if links.Len() == 0 {
+        p.sendNotice(ctx, "Hostex has no phone number or email address for this guest.")
+        return
+    }
+    p.sendNotice(ctx, fmt.Sprintf("Contact %s outside %s:\n%s", guest.Name, p.Info.ChannelType, links.String()))
+}
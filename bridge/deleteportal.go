@@ -0,0 +1,110 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+func (u *User) deletePortal(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !delete-portal <conversation ID|room ID|guest name>")
+        return
+    }
+    portal := u.bridge.findPortal(strings.Join(args, " "))
+    if portal == nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No portal found for %q.", strings.Join(args, " ")))
+        return
+    }
+
+    u.requestConfirmation(ctx, roomID, fmt.Sprintf("This will delete the room of %s (%s) and all bridged messages of the conversation. It won't be bridged again unless you use !create-portal.",
+        portal.Info.Guest.Name, portal.ID), func(ctx context.Context) {
+        err := portal.delete(ctx)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to delete portal: %v", err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Deleted the portal of %s.", portal.Info.Guest.Name))
+    })
+}
+
+// delete tears down the portal: everyone else is kicked from the room, the
+// room is removed from spaces, left and forgotten, and the portal is deleted
+// from the database.
+func (p *Portal) delete(ctx context.Context) error {
+    if p.RoomID != "" {
+        p.cleanupRoom(ctx)
+    }
+
+    err := p.bridge.DB.DeletePortal(p.ID)
+    if err != nil {
+        return err
+    }
+    if p.RoomID != "" {
+        p.bridge.emailLock.Lock()
+        if thread, ok := p.bridge.emailThreadsByMXID[p.RoomID]; ok {
+            delete(p.bridge.emailThreads, thread.Address)
+            delete(p.bridge.emailThreadsByMXID, p.RoomID)
+        }
+        p.bridge.emailLock.Unlock()
+    }
+    if p.bridge.Redis != nil {
+        err = p.bridge.Redis.SetPortalRoomID(p.ID, "")
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to clear cached portal room ID", zap.Error(err))
+        }
+    }
+    p.bridge.removePortal(p)
+    p.bridge.Logger.Info("Deleted portal", zap.String("hostex_id", p.ID), zap.String("room_id", p.RoomID.String()))
+    p.RoomID = ""
+    return nil
+}
+
+// cleanupRoom kicks the other members of the portal room, removes it from
+// spaces, and leaves and forgets it. Failures are only logged so that the
+// portal can be deleted even if the room is already gone.
+func (p *Portal) cleanupRoom(ctx context.Context) {
+    members, err := p.bridge.MatrixClient.JoinedMembers(ctx, p.RoomID)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to get portal room members", zap.Error(err))
+    } else {
+        for userID := range members.Joined {
+            if userID == p.bridge.botUserID() {
+                continue
+            }
+            _, err = p.bridge.MatrixClient.KickUser(ctx, p.RoomID, &mautrix.ReqKickUser{UserID: userID, Reason: "Portal deleted"})
+            if err != nil {
+                p.bridge.Logger.Warn("Failed to kick user from portal room", zap.String("user_id", userID.String()), zap.Error(err))
+            }
+        }
+    }
+
+    if p.bridge.Config().PersonalSpaceEnable {
+        spaceRoom, err := p.personalSpace(ctx)
+        if err == nil {
+            err = p.removeFromSpace(ctx, spaceRoom)
+        }
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to remove portal room from space", zap.Error(err))
+        }
+        if p.archived && p.bridge.Config().PortalArchive.Space {
+            err = p.removeFromArchiveSpace(ctx)
+            if err != nil {
+                p.bridge.Logger.Warn("Failed to remove portal room from the archive space", zap.Error(err))
+            }
+        }
+    }
+
+    _, err = p.bridge.MatrixClient.LeaveRoom(ctx, p.RoomID)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to leave portal room", zap.Error(err))
+    }
+    _, err = p.bridge.MatrixClient.ForgetRoom(ctx, p.RoomID)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to forget portal room", zap.Error(err))
+    }
+}
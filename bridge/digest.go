@@ -0,0 +1,95 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "go.uber.org/zap"
+)
+
+// startDaily calls fn once a day, as soon as the time of day returned by at
+// (HH:MM in the bridge timezone) has passed.
+func (b *Bridge) startDaily(at func() string, fn func(ctx context.Context)) {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    var lastRunDate string
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            now := time.Now().In(b.location())
+            today := now.Format("2006-01-02")
+            if lastRunDate == today || now.Format("15:04") < at() || !b.IsLeader() {
+                continue
+            }
+            lastRunDate = today
+            fn(b.ctx)
+        }
+    }
+}
+
+func (b *Bridge) location() *time.Location {
+    timezone := b.Config().Timezone
+    if prefs := b.adminPreferences(); prefs.Timezone != "" {
+        timezone = prefs.Timezone
+    }
+    loc, err := time.LoadLocation(timezone)
+    if err != nil {
+        b.Logger.Error("Failed to load timezone", zap.Error(err))
+        return time.UTC
+    }
+    return loc
+}
+
+func (b *Bridge) buildDigest(ctx context.Context) string {
+    var digest strings.Builder
+    digest.WriteString(fmt.Sprintf("Daily digest for %s\n\n", time.Now().In(b.location()).Format("Monday, January 2")))
+
+    var count int
+    for _, portal := range b.allPortals() {
+        if portal.RoomID == "" {
+            continue
+        }
+        count++
+        digest.WriteString(fmt.Sprintf("- %s (%s) at %s\n  Stay: %s to %s\n  Checklist: %s\n",
+            portal.Info.Guest.Name,
+            portal.Info.ChannelType,
+            portal.Info.PropertyTitle,
+            portal.Info.CheckInDate,
+            portal.Info.CheckOutDate,
+            portal.checklistProgress()))
+    }
+    if count == 0 {
+        digest.WriteString("No active conversations.\n")
+    }
+
+    if b.Config().SatisfactionPulse.Enable {
+        digest.WriteString("\n")
+        digest.WriteString(b.buildSatisfactionDigest())
+    }
+
+    if b.Config().VacancyGaps.Enable {
+        digest.WriteString("\n")
+        digest.WriteString(b.buildVacancyGapDigest(ctx))
+    }
+
+    return digest.String()
+}
+
+func (b *Bridge) SendDigest(ctx context.Context) {
+    content := &event.MessageEventContent{
+        MsgType: event.MsgNotice,
+        Body:    b.buildDigest(ctx),
+    }
+    _, err := b.MatrixClient.SendMessageEvent(ctx, b.managementRoom, event.EventMessage, content)
+    if err != nil {
+        b.Logger.Error("Failed to send digest", zap.Error(err))
+    }
+}
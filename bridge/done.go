@@ -0,0 +1,37 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// markDone archives the conversation in Hostex and then the portal room like
+// the portal archive does, so it's out of both inboxes until the guest
+// writes again.
+func (p *Portal) markDone(ctx context.Context, sender id.UserID) {
+    err := p.client().ArchiveConversation(ctx, p.conversationID())
+    if err != nil {
+        p.bridge.Logger.Error("Failed to archive conversation in Hostex", zap.String("hostex_id", p.ID), zap.Error(err))
+        p.sendNotice(ctx, fmt.Sprintf("Failed to archive the conversation in Hostex: %v", err))
+        return
+    }
+    p.setAssignee("")
+    p.bridge.Logger.Info("Marked conversation as done",
+        zap.String("hostex_id", p.ID),
+        zap.String("sender", sender.String()))
+
+    if p.archived {
+        p.sendNotice(ctx, "Archived the conversation in Hostex.")
+        return
+    }
+    // Announce it first, as the archive may leave the room
+    p.sendNotice(ctx, fmt.Sprintf("Marked as done by %s. The conversation is archived until %s writes again.", sender, p.Info.Guest.Name))
+    err = p.archive(ctx)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to archive portal", zap.String("hostex_id", p.ID), zap.Error(err))
+        p.sendNotice(ctx, fmt.Sprintf("The conversation was archived in Hostex, but archiving the room failed: %v", err))
+    }
+}
@@ -0,0 +1,77 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// SettingCleanShutdown stores the time of the last clean shutdown.
+const SettingCleanShutdown = "shutdown.clean_at"
+
+// downtimeRecovery tracks catching up after a downtime, until the first poll
+// after startup has finished.
+type downtimeRecovery struct {
+    since   time.Time
+    started time.Time
+
+    conversations int
+    messages      int
+}
+
+// checkDowntime compares the last poll before startup with the last clean
+// shutdown, and announces the recovery in the management room if the bridge
+// crashed or was down for long.
+func (b *Bridge) checkDowntime(ctx context.Context) {
+    lastPoll, err := b.DB.GetLastPollTime()
+    if err != nil {
+        b.Logger.Warn("Failed to get last poll time", zap.Error(err))
+        return
+    } else if lastPoll.IsZero() {
+        return
+    }
+    cleanAt, err := b.DB.GetSetting(SettingCleanShutdown)
+    if err != nil {
+        b.Logger.Warn("Failed to get last clean shutdown", zap.Error(err))
+        return
+    }
+    unclean := true
+    if unix, err := strconv.ParseInt(cleanAt, 10, 64); err == nil {
+        unclean = time.Unix(unix, 0).Before(lastPoll)
+    }
+
+    downtime := time.Since(lastPoll)
+    if !unclean && downtime < b.Config().Downtime.NoticeAfter {
+        return
+    }
+    b.recovery = &downtimeRecovery{since: lastPoll, started: time.Now()}
+
+    reason := "after a clean shutdown"
+    if unclean {
+        reason = "after an unclean shutdown"
+    }
+    b.sendManagementNotice(ctx, fmt.Sprintf("The bridge is back %s. It was down for %s, since %s. Catching up on Hostex conversations...",
+        reason, downtime.Round(time.Second), lastPoll.In(b.location()).Format("2006-01-02 15:04")))
+}
+
+// finishRecovery posts the catch-up summary after the first poll.
+func (b *Bridge) finishRecovery(ctx context.Context) {
+    recovery := b.recovery
+    if recovery == nil {
+        return
+    }
+    b.recovery = nil
+    b.sendManagementNotice(ctx, fmt.Sprintf("Caught up in %s: %d conversations had activity while the bridge was down, %d messages were backfilled.",
+        time.Since(recovery.started).Round(time.Second), recovery.conversations, recovery.messages))
+}
+
+// recordCleanShutdown marks the shutdown as clean for the next startup.
+func (b *Bridge) recordCleanShutdown() {
+    err := b.DB.SetSetting(SettingCleanShutdown, strconv.FormatInt(time.Now().Unix(), 10))
+    if err != nil {
+        b.Logger.Error("Failed to record clean shutdown", zap.Error(err))
+    }
+}
@@ -0,0 +1,90 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+// saveDraft keeps a reply that couldn't be delivered to Hostex, so it can be
+// resent later with !send-draft instead of being lost.
+func (p *Portal) saveDraft(ctx context.Context, eventID id.EventID, sender id.UserID, body string, sendErr error) {
+    draftID, err := p.bridge.DB.StoreDraft(&database.Draft{
+        HostexID:      p.ID,
+        MatrixEventID: eventID,
+        Sender:        sender,
+        Content:       body,
+        Error:         sendErr.Error(),
+        CreatedAt:     time.Now(),
+    })
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store draft", zap.Error(err))
+        p.sendNotice(ctx, fmt.Sprintf("Failed to deliver your message to Hostex (%v), and it couldn't be saved as a draft.", sendErr))
+        return
+    }
+    p.sendNotice(ctx, fmt.Sprintf("Failed to deliver your message to Hostex (%v). It was saved as draft #%d, use !send-draft %d to retry.", sendErr, draftID, draftID))
+}
+
+func (b *Bridge) listDrafts(hostexID string) string {
+    drafts, err := b.DB.GetDrafts(hostexID)
+    if err != nil {
+        b.Logger.Error("Failed to get drafts", zap.Error(err))
+        return fmt.Sprintf("Failed to get drafts: %v", err)
+    }
+    if len(drafts) == 0 {
+        return "No drafts."
+    }
+
+    var sb strings.Builder
+    sb.WriteString("Drafts:\n")
+    for _, draft := range drafts {
+        guest := draft.HostexID
+        if portal, ok := b.getPortalByID(draft.HostexID); ok {
+            guest = portal.Info.Guest.Name
+        }
+        sb.WriteString(fmt.Sprintf("#%d to %s at %s: %s\n", draft.ID, guest, draft.CreatedAt.In(b.location()).Format(time.RFC3339), draft.Content))
+    }
+    return sb.String()
+}
+
+// sendDraftCommand resends a draft. If hostexID isn't empty, only drafts of
+// that conversation can be sent.
+func (b *Bridge) sendDraftCommand(ctx context.Context, hostexID string, args []string) string {
+    if len(args) == 0 {
+        return "Usage: !send-draft <number>"
+    }
+    draftID, err := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64)
+    if err != nil {
+        return "Invalid draft number."
+    }
+
+    draft, err := b.DB.GetDraft(draftID)
+    if err != nil {
+        return fmt.Sprintf("Failed to get draft: %v", err)
+    } else if draft == nil || (hostexID != "" && draft.HostexID != hostexID) {
+        return fmt.Sprintf("Draft #%d not found.", draftID)
+    }
+
+    portal, ok := b.getPortalByID(draft.HostexID)
+    if !ok {
+        return fmt.Sprintf("The conversation of draft #%d isn't bridged.", draftID)
+    }
+
+    err = portal.sendToHostex(ctx, draft.MatrixEventID, draft.Sender, draft.Content, draft.CreatedAt)
+    if err != nil {
+        return fmt.Sprintf("Failed to send draft #%d: %v", draftID, err)
+    }
+
+    err = b.DB.DeleteDraft(draftID)
+    if err != nil {
+        b.Logger.Error("Failed to delete sent draft", zap.Error(err))
+    }
+    return fmt.Sprintf("Draft #%d sent.", draftID)
+}
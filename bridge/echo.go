@@ -0,0 +1,87 @@
+package bridge
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// echoWindow is how long a message sent from Matrix is remembered while
+// waiting for it to show up again in the Hostex message list.
+const echoWindow = time.Hour
+
+type sentMessage struct {
+    messageID   string
+    fingerprint string
+    sentAt      time.Time
+}
+
+// echoTracker remembers messages this bridge sent to Hostex so they aren't
+// bridged back into the portal when the next poll returns them.
+type echoTracker struct {
+    lock sync.Mutex
+    sent []sentMessage
+}
+
+func newEchoTracker() *echoTracker {
+    return &echoTracker{}
+}
+
+func messageFingerprint(content string) string {
+    hash := sha256.Sum256([]byte(strings.TrimSpace(content)))
+    return hex.EncodeToString(hash[:])
+}
+
+// Add records a sent message. The Hostex message ID is used for matching when
+// the API returned one, otherwise the content fingerprint is used instead.
+func (et *echoTracker) Add(messageID, content string) {
+    et.lock.Lock()
+    defer et.lock.Unlock()
+
+    et.sent = append(et.sent, sentMessage{
+        messageID:   messageID,
+        fingerprint: messageFingerprint(content),
+        sentAt:      time.Now(),
+    })
+}
+
+// IsEcho reports whether the given Hostex message was sent by this bridge.
+// A matched message is forgotten, so each sent message suppresses at most one
+// incoming copy.
+func (et *echoTracker) IsEcho(msg hostexapi.Message) bool {
+    et.lock.Lock()
+    defer et.lock.Unlock()
+
+    et.prune()
+
+    fingerprint := messageFingerprint(msg.Content)
+    for i, sent := range et.sent {
+        var match bool
+        if sent.messageID != "" {
+            match = sent.messageID == msg.ID
+        } else {
+            diff := msg.Timestamp.Sub(sent.sentAt)
+            match = sent.fingerprint == fingerprint && diff > -echoWindow && diff < echoWindow
+        }
+        if match {
+            et.sent = append(et.sent[:i], et.sent[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+func (et *echoTracker) prune() {
+    cutoff := time.Now().Add(-echoWindow)
+    kept := et.sent[:0]
+    for _, sent := range et.sent {
+        if sent.sentAt.After(cutoff) {
+            kept = append(kept, sent)
+        }
+    }
+    et.sent = kept
+}
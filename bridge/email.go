@@ -0,0 +1,224 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/email"
+)
+
+func (b *Bridge) loadEmailThreads() error {
+    threads, err := b.DB.GetEmailThreads()
+    if err != nil {
+        return err
+    }
+
+    b.emailLock.Lock()
+    defer b.emailLock.Unlock()
+    for _, thread := range threads {
+        b.emailThreads[thread.Address] = thread
+        b.emailThreadsByMXID[thread.RoomID] = thread
+    }
+    return nil
+}
+
+func (b *Bridge) startEmailGateway() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(b.Config().Email.PollInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            b.pollEmail(b.ctx)
+        }
+    }
+}
+
+func (b *Bridge) pollEmail(ctx context.Context) {
+    if !b.IsLeader() {
+        return
+    }
+
+    messages, err := b.Email.FetchUnseen()
+    if err != nil {
+        b.Logger.Error("Failed to fetch emails", zap.Error(err))
+    }
+    for _, msg := range messages {
+        b.handleEmail(ctx, msg)
+    }
+}
+
+// portalByGuestEmail returns the portal of the Hostex conversation with a
+// guest using the given email address, if there is one.
+func (b *Bridge) portalByGuestEmail(address string) *Portal {
+    for _, portal := range b.allPortals() {
+        if portal.RoomID != "" && strings.EqualFold(portal.Info.Guest.Email, address) {
+            return portal
+        }
+    }
+    return nil
+}
+
+func (b *Bridge) handleEmail(ctx context.Context, msg email.Message) {
+    b.emailLock.Lock()
+    defer b.emailLock.Unlock()
+
+    thread, ok := b.emailThreads[msg.From]
+    if !ok {
+        thread = &database.EmailThread{
+            Address: msg.From,
+            Name:    msg.FromName,
+        }
+        if portal := b.portalByGuestEmail(msg.From); portal != nil {
+            thread.RoomID = portal.RoomID
+        } else {
+            roomID, err := b.createEmailRoom(ctx, thread)
+            if err != nil {
+                b.Logger.Error("Failed to create email room", zap.String("address", msg.From), zap.Error(err))
+                return
+            }
+            thread.RoomID = roomID
+        }
+    }
+
+    body := msg.Body
+    if msg.Subject != "" {
+        body = fmt.Sprintf("Email: %s\n\n%s", msg.Subject, msg.Body)
+    }
+    content := &event.MessageEventContent{
+        MsgType: event.MsgText,
+        Body:    body,
+    }
+    if b.notificationLevel() == NotificationLevelQuiet {
+        content.MsgType = event.MsgNotice
+    }
+    _, err := b.MatrixClient.SendMessageEvent(ctx, thread.RoomID, event.EventMessage, content, mautrix.ReqSendEvent{Timestamp: msg.Date.UnixMilli()})
+    if err != nil {
+        b.Logger.Error("Failed to bridge email", zap.String("address", msg.From), zap.Error(err))
+        return
+    }
+    b.touchActivity()
+
+    if msg.Subject != "" {
+        thread.Subject = msg.Subject
+    }
+    if msg.MessageID != "" {
+        thread.LastMessageID = msg.MessageID
+    }
+    err = b.DB.StoreEmailThread(thread)
+    if err != nil {
+        b.Logger.Error("Failed to store email thread", zap.Error(err))
+    }
+    b.emailThreads[thread.Address] = thread
+    b.emailThreadsByMXID[thread.RoomID] = thread
+}
+
+func (b *Bridge) createEmailRoom(ctx context.Context, thread *database.EmailThread) (id.RoomID, error) {
+    name := thread.Name
+    if name == "" {
+        name = thread.Address
+    }
+    resp, err := b.MatrixClient.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+        Visibility: "private",
+        Name:       fmt.Sprintf("Email - %s", name),
+        Topic:      fmt.Sprintf("Email conversation with %s", thread.Address),
+        Invite:     b.managementInvites(),
+    })
+    if err != nil {
+        return "", err
+    }
+
+    if b.Config().PersonalSpaceEnable {
+        _, err = b.MatrixClient.SendStateEvent(ctx, b.spaceRoom, event.StateSpaceChild, resp.RoomID.String(), &event.SpaceChildEventContent{
+            Via: []string{b.Config().Homeserver.Domain},
+        })
+        if err != nil {
+            b.Logger.Error("Failed to add email room to personal space", zap.Error(err))
+        }
+    }
+    return resp.RoomID, nil
+}
+
+// sendEmailReply emails a reply to the thread's guest, threaded under the
+// last email they sent.
+func (b *Bridge) sendEmailReply(thread *database.EmailThread, body string) error {
+    if b.Email == nil {
+        return fmt.Errorf("the email gateway isn't enabled")
+    }
+    subject := thread.Subject
+    if subject == "" {
+        subject = "Your stay"
+    }
+    if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+        subject = "Re: " + subject
+    }
+    return b.Email.Send(thread.Address, subject, body, thread.LastMessageID)
+}
+
+func (b *Bridge) handleEmailRoomMessage(evt *event.Event, thread *database.EmailThread) {
+    content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+    if !ok || content.Body == "" {
+        return
+    }
+    if b.permissionLevel(evt.Sender, evt.RoomID) < PermissionUser {
+        b.Logger.Warn("Ignoring message from user without permission to relay", zap.String("sender", evt.Sender.String()))
+        return
+    }
+
+    ctx := b.ctx
+    err := b.sendEmailReply(thread, content.Body)
+    if err != nil {
+        b.Logger.Error("Failed to send email reply", zap.String("address", thread.Address), zap.Error(err))
+        b.sendNotice(ctx, evt.RoomID, fmt.Sprintf("Failed to send your reply by email: %v", err))
+        return
+    }
+    _, err = b.MatrixClient.SendReaction(ctx, evt.RoomID, evt.ID, StatusSent)
+    if err != nil {
+        b.Logger.Warn("Failed to send status reaction", zap.Error(err))
+    }
+}
+
+// emailGuest replies by email to the guest of a Hostex conversation, for
+// direct bookings where the guest uses email instead of the OTA inbox.
+func (p *Portal) emailGuest(ctx context.Context, args []string) {
+    if len(args) == 0 {
+        p.sendNotice(ctx, "Usage: !email <message>")
+        return
+    }
+
+    address := strings.ToLower(p.Info.Guest.Email)
+    p.bridge.emailLock.Lock()
+    thread, ok := p.bridge.emailThreads[address]
+    p.bridge.emailLock.Unlock()
+    if !ok {
+        if address == "" {
+            p.sendNotice(ctx, "This guest has no email address.")
+            return
+        }
+        thread = &database.EmailThread{
+            Address: address,
+            RoomID:  p.RoomID,
+            Name:    p.Info.Guest.Name,
+            Subject: fmt.Sprintf("Your stay at %s", p.Info.PropertyTitle),
+        }
+    }
+
+    err := p.bridge.sendEmailReply(thread, strings.Join(args, " "))
+    if err != nil {
+        p.sendNotice(ctx, fmt.Sprintf("Failed to send email: %v", err))
+        return
+    }
+    p.sendNotice(ctx, fmt.Sprintf("Email sent to %s.", thread.Address))
+}
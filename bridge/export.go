@@ -0,0 +1,215 @@
+package bridge
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "html/template"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+type transcript struct {
+    ExportedAt    time.Time                `json:"exported_at"`
+    From          *time.Time               `json:"from,omitempty"`
+    To            *time.Time               `json:"to,omitempty"`
+    Conversations []*transcriptConversation `json:"conversations"`
+}
+
+type transcriptConversation struct {
+    ConversationID string               `json:"conversation_id"`
+    Account        string               `json:"account,omitempty"`
+    Guest          string               `json:"guest,omitempty"`
+    Channel        string               `json:"channel,omitempty"`
+    Property       string               `json:"property,omitempty"`
+    CheckIn        string               `json:"check_in,omitempty"`
+    CheckOut       string               `json:"check_out,omitempty"`
+    Messages       []*transcriptMessage `json:"messages"`
+}
+
+type transcriptMessage struct {
+    HostexMessageID string     `json:"hostex_message_id,omitempty"`
+    MatrixEventID   id.EventID `json:"matrix_event_id"`
+    Timestamp       time.Time  `json:"timestamp"`
+    Sender          string     `json:"sender"`
+    Content         string     `json:"content"`
+}
+
+var transcriptTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Hostex conversation transcript</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; }
+.meta { color: #666; }
+.message { margin: 0.5em 0; }
+.time, .sender { color: #666; font-size: 0.9em; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Hostex conversation transcript</h1>
+<p class="meta">Exported {{.ExportedAt.Format "2006-01-02 15:04 MST"}}{{if .From}}, messages since {{.From.Format "2006-01-02"}}{{end}}{{if .To}}, before {{.To.Format "2006-01-02"}}{{end}}</p>
+{{range .Conversations}}
+<h2>{{if .Guest}}{{.Guest}}{{else}}{{.ConversationID}}{{end}}</h2>
+<p class="meta">Conversation {{.ConversationID}}{{if .Account}} ({{.Account}}){{end}}{{if .Channel}}, {{.Channel}}{{end}}{{if .Property}}, {{.Property}}{{end}}{{if .CheckIn}}, stay {{.CheckIn}} to {{.CheckOut}}{{end}}</p>
+{{range .Messages}}
+<div class="message"><span class="time">{{.Timestamp.Format "2006-01-02 15:04"}}</span> <span class="sender">{{.Sender}}</span><div class="content">{{.Content}}</div></div>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// exportTranscript exports one conversation, or all of them, as a JSON or
+// HTML file in the management room, e.g. as evidence in a dispute with an
+// OTA.
+func (u *User) exportTranscript(ctx context.Context, roomID id.RoomID, args []string) {
+    usage := "Usage: !export <json|html> <conversation ID|room ID|guest name|all> [from YYYY-MM-DD] [to YYYY-MM-DD]"
+    if len(args) < 2 {
+        u.sendNotice(ctx, roomID, usage)
+        return
+    }
+    format := strings.ToLower(args[0])
+    if format != "json" && format != "html" {
+        u.sendNotice(ctx, roomID, usage)
+        return
+    }
+    args = args[1:]
+
+    // Trailing dates are the range, the end date is included
+    var dates []time.Time
+    for len(args) > 1 && len(dates) < 2 {
+        date, err := time.ParseInLocation(dateLayout, args[len(args)-1], u.bridge.location())
+        if err != nil {
+            break
+        }
+        dates = append([]time.Time{date}, dates...)
+        args = args[:len(args)-1]
+    }
+    var from, to time.Time
+    if len(dates) > 0 {
+        from = dates[0]
+    }
+    if len(dates) > 1 {
+        to = dates[1].AddDate(0, 0, 1)
+    }
+
+    var portal *Portal
+    query := strings.Join(args, " ")
+    if !strings.EqualFold(query, "all") {
+        portal = u.bridge.findPortal(query)
+        if portal == nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("No portal found for %q.", query))
+            return
+        }
+    }
+
+    hostexID := ""
+    if portal != nil {
+        hostexID = portal.ID
+    }
+    messages, err := u.bridge.DB.GetMessagesBetween(hostexID, from, to)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get messages: %v", err))
+        return
+    }
+    if len(messages) == 0 {
+        u.sendNotice(ctx, roomID, "There are no messages to export.")
+        return
+    }
+
+    t := u.bridge.buildTranscript(messages, from, to)
+    var data []byte
+    var mimeType string
+    if format == "json" {
+        mimeType = "application/json"
+        data, err = json.MarshalIndent(t, "", "  ")
+    } else {
+        mimeType = "text/html"
+        var buf bytes.Buffer
+        err = transcriptTemplate.Execute(&buf, t)
+        data = buf.Bytes()
+    }
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to build transcript: %v", err))
+        return
+    }
+
+    name := "all"
+    if portal != nil {
+        name = portal.conversationID()
+    }
+    fileName := fmt.Sprintf("hostex-transcript-%s-%s.%s", name, time.Now().In(u.bridge.location()).Format(dateLayout), format)
+    err = u.bridge.sendFile(ctx, u.bridge.managementRoom, fileName, mimeType, data)
+    if err != nil {
+        u.bridge.Logger.Error("Failed to send transcript", zap.Error(err))
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to upload transcript: %v", err))
+        return
+    }
+    if roomID != u.bridge.managementRoom {
+        u.sendNotice(ctx, roomID, "The transcript was uploaded to the management room.")
+    }
+}
+
+func (b *Bridge) buildTranscript(messages []*database.Message, from, to time.Time) *transcript {
+    loc := b.location()
+    t := &transcript{ExportedAt: time.Now().In(loc)}
+    if !from.IsZero() {
+        t.From = &from
+    }
+    if !to.IsZero() {
+        t.To = &to
+    }
+
+    var conv *transcriptConversation
+    for _, msg := range messages {
+        if conv == nil || conv.ConversationID != msg.HostexID {
+            conv = &transcriptConversation{ConversationID: msg.HostexID}
+            if portal, ok := b.getPortalByID(msg.HostexID); ok {
+                conv.ConversationID = portal.conversationID()
+                conv.Account = portal.Account()
+                conv.Guest = portal.Info.Guest.Name
+                conv.Channel = portal.Info.ChannelType
+                conv.Property = portal.Info.PropertyTitle
+                conv.CheckIn = portal.Info.CheckInDate
+                conv.CheckOut = portal.Info.CheckOutDate
+            }
+            t.Conversations = append(t.Conversations, conv)
+        }
+        conv.Messages = append(conv.Messages, &transcriptMessage{
+            HostexMessageID: msg.HostexMessageID,
+            MatrixEventID:   msg.MatrixEventID,
+            Timestamp:       msg.Timestamp.In(loc),
+            Sender:          msg.Sender,
+            Content:         msg.Content,
+        })
+    }
+    return t
+}
+
+// sendFile uploads data and posts it as a file in the room.
+func (b *Bridge) sendFile(ctx context.Context, roomID id.RoomID, fileName, mimeType string, data []byte) error {
+    upload, err := b.MatrixClient.UploadBytesWithName(ctx, data, mimeType, fileName)
+    if err != nil {
+        return err
+    }
+    _, err = b.MatrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, &event.MessageEventContent{
+        MsgType: event.MsgFile,
+        Body:    fileName,
+        URL:     upload.ContentURI.CUString(),
+        Info: &event.FileInfo{
+            MimeType: mimeType,
+            Size:     len(data),
+        },
+    })
+    return err
+}
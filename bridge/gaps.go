@@ -0,0 +1,203 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// vacancyGap is a short run of unbooked nights between two reservations of
+// the same property, which is unlikely to be booked at the regular price.
+type vacancyGap struct {
+    // Account is the Hostex account of the property.
+    Account       string
+    PropertyID    string
+    PropertyTitle string
+    Start         time.Time
+    Nights        int
+}
+
+func (g vacancyGap) lastNight() time.Time {
+    return g.Start.AddDate(0, 0, g.Nights-1)
+}
+
+// nightsBetween returns the number of nights from one date to another. It
+// compares the calendar dates in UTC, so a daylight saving change in between
+// doesn't make a night 23 hours long and drop it.
+func nightsBetween(from, to time.Time) int {
+    from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+    to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+    return int(to.Sub(from) / (24 * time.Hour))
+}
+
+// findVacancyGaps looks for orphan gaps of at most maxNights nights between
+// consecutive reservations of each property.
+func findVacancyGaps(reservations []hostexapi.Reservation, from time.Time, maxNights int) []vacancyGap {
+    byProperty := make(map[string][]hostexapi.Reservation)
+    for _, res := range reservations {
+        if strings.EqualFold(res.Status, "cancelled") {
+            continue
+        }
+        byProperty[res.PropertyID] = append(byProperty[res.PropertyID], res)
+    }
+
+    var gaps []vacancyGap
+    for propertyID, propertyReservations := range byProperty {
+        sort.Slice(propertyReservations, func(i, j int) bool {
+            return propertyReservations[i].CheckInDate < propertyReservations[j].CheckInDate
+        })
+        for i := 1; i < len(propertyReservations); i++ {
+            prev, next := propertyReservations[i-1], propertyReservations[i]
+            checkOut, err := time.ParseInLocation(dateLayout, prev.CheckOutDate, from.Location())
+            if err != nil {
+                continue
+            }
+            checkIn, err := time.ParseInLocation(dateLayout, next.CheckInDate, from.Location())
+            if err != nil {
+                continue
+            }
+            nights := nightsBetween(checkOut, checkIn)
+            if nights < 1 || nights > maxNights || checkOut.Before(from) {
+                continue
+            }
+            gaps = append(gaps, vacancyGap{
+                PropertyID:    propertyID,
+                PropertyTitle: next.PropertyTitle,
+                Start:         checkOut,
+                Nights:        nights,
+            })
+        }
+    }
+
+    sort.Slice(gaps, func(i, j int) bool {
+        return gaps[i].Start.Before(gaps[j].Start)
+    })
+    return gaps
+}
+
+// refreshVacancyGaps fetches upcoming reservations and recomputes the gaps
+// that !gap-discount refers to.
+func (b *Bridge) refreshVacancyGaps(ctx context.Context) ([]vacancyGap, error) {
+    now := time.Now().In(b.location())
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    // Start a month back so reservations that are ongoing today are included
+    start := today.AddDate(0, -1, 0)
+    end := today.AddDate(0, 0, b.Config().VacancyGaps.HorizonDays)
+
+    var gaps []vacancyGap
+    for _, account := range b.accountNames() {
+        reservations, err := b.hostexClient(account).GetReservations(ctx, start.Format(dateLayout), end.Format(dateLayout))
+        if err != nil {
+            return nil, fmt.Errorf("failed to get reservations of the %s account: %w", accountLabel(account), err)
+        }
+        for _, gap := range findVacancyGaps(reservations, today, b.Config().VacancyGaps.MaxNights) {
+            gap.Account = account
+            gaps = append(gaps, gap)
+        }
+    }
+    sort.SliceStable(gaps, func(i, j int) bool {
+        return gaps[i].Start.Before(gaps[j].Start)
+    })
+    b.vacancyGaps = gaps
+    return gaps, nil
+}
+
+func (b *Bridge) formatVacancyGaps(gaps []vacancyGap) string {
+    if len(gaps) == 0 {
+        return "No vacancy gaps found.\n"
+    }
+
+    var sb strings.Builder
+    for i, gap := range gaps {
+        sb.WriteString(fmt.Sprintf("%d. %s: %s to %s (%d nights), suggested action: !gap-discount %d\n",
+            i+1,
+            gap.PropertyTitle,
+            gap.Start.Format(dateLayout),
+            gap.lastNight().Format(dateLayout),
+            gap.Nights,
+            i+1))
+    }
+    return sb.String()
+}
+
+func (b *Bridge) buildVacancyGapDigest(ctx context.Context) string {
+    gaps, err := b.refreshVacancyGaps(ctx)
+    if err != nil {
+        b.Logger.Error("Failed to find vacancy gaps", zap.Error(err))
+        return "Vacancy gaps: unavailable\n"
+    }
+    return "Vacancy gaps:\n" + b.formatVacancyGaps(gaps)
+}
+
+func (u *User) listVacancyGaps(ctx context.Context, roomID id.RoomID) {
+    gaps, err := u.bridge.refreshVacancyGaps(ctx)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to find vacancy gaps: %v", err))
+        return
+    }
+    u.sendNotice(ctx, roomID, "Vacancy gaps:\n"+u.bridge.formatVacancyGaps(gaps))
+}
+
+func (u *User) gapDiscount(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !gap-discount <number> [percent]")
+        return
+    }
+    index, err := strconv.Atoi(args[0])
+    if err != nil || index < 1 || index > len(u.bridge.vacancyGaps) {
+        u.sendNotice(ctx, roomID, "Invalid gap number. Use !gaps to list vacancy gaps.")
+        return
+    }
+    gap := u.bridge.vacancyGaps[index-1]
+
+    percent := u.bridge.Config().VacancyGaps.DiscountPercent
+    if len(args) > 1 {
+        percent, err = strconv.ParseFloat(strings.TrimSuffix(args[1], "%"), 64)
+        if err != nil || percent <= 0 || percent >= 100 {
+            u.sendNotice(ctx, roomID, "Invalid discount percentage.")
+            return
+        }
+    }
+
+    startDate, endDate := gap.Start.Format(dateLayout), gap.lastNight().Format(dateLayout)
+    client := u.bridge.hostexClient(gap.Account)
+    prices, err := client.GetPrices(ctx, gap.PropertyID, startDate, endDate)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get prices: %v", err))
+        return
+    }
+    if len(prices) == 0 {
+        u.sendNotice(ctx, roomID, "Hostex returned no prices for that gap.")
+        return
+    }
+
+    var preview strings.Builder
+    preview.WriteString(fmt.Sprintf("Apply a %.0f%% discount to %s:\n", percent, gap.PropertyTitle))
+    for _, price := range prices {
+        preview.WriteString(fmt.Sprintf("- %s: %.2f -> %.2f\n", price.Date, price.Price, discountedPrice(price.Price, percent)))
+    }
+
+    u.requestConfirmation(ctx, roomID, preview.String(), func(ctx context.Context) {
+        ctx = hostexapi.WithPriority(ctx, hostexapi.PriorityBulk)
+        for _, price := range prices {
+            err := client.UpdatePrice(ctx, gap.PropertyID, price.Date, price.Date, discountedPrice(price.Price, percent))
+            if err != nil {
+                u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to update price for %s: %v", price.Date, err))
+                return
+            }
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Gap discount applied to %s from %s to %s.", gap.PropertyTitle, startDate, endDate))
+    })
+}
+
+func discountedPrice(price, percent float64) float64 {
+    return float64(int(price*(100-percent))) / 100
+}
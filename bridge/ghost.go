@@ -0,0 +1,158 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// ghost is the Matrix user the guest's messages are sent as.
+type ghost struct {
+    client      *mautrix.Client
+    joinedRoom  id.RoomID
+    displayname string
+}
+
+// isGhost reports whether a user is one of the bridge's ghost users.
+func (b *Bridge) isGhost(userID id.UserID) bool {
+    localpart, server, err := userID.Parse()
+    return err == nil && server == b.Config().Homeserver.Domain && strings.HasPrefix(localpart, b.Config().Bridge.UserPrefix)
+}
+
+// ghostUserID returns the user ID of the portal's ghost. It's rendered from
+// the username template once and then stored, so it doesn't change with the
+// guest's details.
+func (p *Portal) ghostUserID() (id.UserID, error) {
+    userID, err := p.bridge.DB.GetPortalGhost(p.ID)
+    if err != nil || userID != "" {
+        return userID, err
+    }
+    username := p.renderName(p.bridge.names.username, p.bridge.Config().Bridge.UserPrefix+p.ID)
+    userID = id.NewUserID(id.EncodeUserLocalpart(username), p.bridge.Config().Homeserver.Domain)
+    if !p.bridge.isGhost(userID) {
+        return "", fmt.Errorf("ghost %s isn't in the appservice namespace %s", userID, p.bridge.Config().Bridge.UserPrefix)
+    }
+    err = p.bridge.DB.SetPortalGhost(p.ID, userID)
+    if err != nil {
+        return "", fmt.Errorf("failed to store ghost: %w", err)
+    }
+    return userID, nil
+}
+
+// ghostClient returns a client acting as the portal's ghost, which is
+// registered, joined to the portal room and has the current display name.
+func (p *Portal) ghostClient(ctx context.Context) (*mautrix.Client, error) {
+    if p.ghost == nil {
+        userID, err := p.ghostUserID()
+        if err != nil {
+            return nil, err
+        }
+        _, _, err = p.bridge.MatrixClient.Register(ctx, &mautrix.ReqRegister{
+            Username:     userID.Localpart(),
+            Type:         mautrix.AuthTypeAppservice,
+            InhibitLogin: true,
+        })
+        if err != nil && !errors.Is(err, mautrix.MUserInUse) {
+            return nil, fmt.Errorf("failed to register ghost: %w", err)
+        }
+        client, err := p.bridge.newGhostClient(userID)
+        if err != nil {
+            return nil, err
+        }
+        p.ghost = &ghost{client: client}
+    }
+
+    if p.ghost.joinedRoom != p.RoomID {
+        // The invite fails if the ghost is already in the room, which the
+        // join then confirms
+        _, err := p.bridge.MatrixClient.InviteUser(ctx, p.RoomID, &mautrix.ReqInviteUser{UserID: p.ghost.client.UserID})
+        if err != nil {
+            p.bridge.Logger.Debug("Failed to invite ghost", zap.String("user_id", p.ghost.client.UserID.String()), zap.Error(err))
+        }
+        _, err = p.ghost.client.JoinRoomByID(ctx, p.RoomID)
+        if err != nil {
+            return nil, fmt.Errorf("failed to join ghost to room: %w", err)
+        }
+        p.ghost.joinedRoom = p.RoomID
+    }
+    p.updateGhostProfile(ctx)
+    return p.ghost.client, nil
+}
+
+// newGhostClient returns a client that acts as the ghost through the
+// appservice.
+func (b *Bridge) newGhostClient(userID id.UserID) (*mautrix.Client, error) {
+    client, err := NewMatrixClient(b.Config().Homeserver.Address, userID.String(), b.Config().Appservice.ASToken)
+    if err != nil {
+        return nil, err
+    }
+    client.SetAppServiceUserID = true
+    return client, nil
+}
+
+// resetGhostProfile clears the global display name and avatar of the
+// portal's ghost, which would otherwise still show the guest's details in
+// the user directory after the room is gone.
+func (p *Portal) resetGhostProfile(ctx context.Context) {
+    userID, err := p.bridge.DB.GetPortalGhost(p.ID)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to get portal ghost", zap.String("hostex_id", p.ID), zap.Error(err))
+        return
+    } else if userID == "" {
+        return
+    }
+    client, err := p.bridge.newGhostClient(userID)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to prepare ghost", zap.String("user_id", userID.String()), zap.Error(err))
+        return
+    }
+    err = client.SetDisplayName(ctx, "")
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to reset ghost display name", zap.String("user_id", userID.String()), zap.Error(err))
+    }
+    err = client.SetAvatarURL(ctx, id.ContentURI{})
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to reset ghost avatar", zap.String("user_id", userID.String()), zap.Error(err))
+    }
+    if p.ghost != nil {
+        p.ghost.displayname = ""
+    }
+}
+
+// updateGhostProfile sets the ghost's display name if the guest's details
+// changed since it was last set.
+func (p *Portal) updateGhostProfile(ctx context.Context) {
+    if p.ghost == nil {
+        return
+    }
+    name := p.renderName(p.bridge.names.displayname, p.Info.Guest.Name)
+    if name == p.ghost.displayname {
+        return
+    }
+    err := p.ghost.client.SetDisplayName(ctx, name)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to set ghost display name", zap.String("user_id", p.ghost.client.UserID.String()), zap.Error(err))
+        return
+    }
+    p.ghost.displayname = name
+}
+
+// messageClient returns the client a Hostex message is sent to the room
+// with: the guest's ghost for guest messages if ghosts are enabled, the
+// bridge bot otherwise. It also returns the user the client acts as.
+func (p *Portal) messageClient(ctx context.Context, sender string) (MatrixClient, id.UserID) {
+    if !p.bridge.Config().Bridge.Ghosts || isHostSender(sender) {
+        return p.bridge.MatrixClient, p.bridge.botUserID()
+    }
+    client, err := p.ghostClient(ctx)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to prepare ghost, sending as the bridge bot", zap.String("hostex_id", p.ID), zap.Error(err))
+        return p.bridge.MatrixClient, p.bridge.botUserID()
+    }
+    return client, client.UserID
+}
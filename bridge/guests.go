@@ -0,0 +1,336 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// guestKeys returns the normalized email address and phone number that
+// identify a guest across conversations and channels.
+func guestKeys(email, phone string) []string {
+    var keys []string
+    if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+        keys = append(keys, "email:"+email)
+    }
+    if phone = normalizePhone(phone); len(phone) >= minPhoneDigits {
+        keys = append(keys, "phone:"+phone)
+    }
+    return keys
+}
+
+// linkGuest links the conversation to the guest with the same email address
+// or phone number, or to a new guest, and adds the conversation's contact
+// details to the guest.
+func (p *Portal) linkGuest() {
+    var err error
+    if p.guestID == 0 {
+        p.guestID, err = p.bridge.DB.GetConversationGuest(p.ID)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to get conversation guest", zap.String("hostex_id", p.ID), zap.Error(err))
+            return
+        }
+    }
+    keys := guestKeys(p.Info.Guest.Email, p.Info.Guest.Phone)
+    if p.guestID == 0 {
+        guestID, err := p.bridge.DB.FindGuestByKeys(keys)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to find guest", zap.String("hostex_id", p.ID), zap.Error(err))
+            return
+        }
+        if guestID == 0 {
+            guestID, err = p.bridge.DB.CreateGuest(p.Info.Guest.Name)
+            if err != nil {
+                p.bridge.Logger.Error("Failed to create guest", zap.String("hostex_id", p.ID), zap.Error(err))
+                return
+            }
+        }
+        err = p.bridge.DB.LinkConversationGuest(p.ID, guestID)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to link conversation to guest", zap.String("hostex_id", p.ID), zap.Error(err))
+            return
+        }
+        p.guestID = guestID
+    }
+    err = p.bridge.DB.AddGuestKeys(p.guestID, keys)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store guest contact details", zap.String("hostex_id", p.ID), zap.Error(err))
+    }
+}
+
+// findGuest returns the ID of the guest of the conversation matching the
+// query, or of the guest with the given email address or phone number.
+// Conversation IDs win over phone numbers, as both may be only digits.
+func (b *Bridge) findGuest(query string) (int64, error) {
+    if portal := b.findPortal(query); portal != nil {
+        if portal.guestID == 0 {
+            portal.linkGuest()
+            if portal.guestID == 0 {
+                return 0, fmt.Errorf("the conversation with %s isn't linked to a guest", portal.Info.Guest.Name)
+            }
+        }
+        return portal.guestID, nil
+    }
+
+    var keys []string
+    if strings.Contains(query, "@") && !strings.HasPrefix(query, "@") {
+        keys = guestKeys(query, "")
+    } else {
+        keys = guestKeys("", query)
+    }
+    guestID, err := b.DB.FindGuestByKeys(keys)
+    if err != nil {
+        return 0, err
+    } else if guestID == 0 {
+        return 0, fmt.Errorf("no conversation or guest found for %q", query)
+    }
+    return guestID, nil
+}
+
+// describeGuest returns the name and conversations of a guest for the
+// confirmation of !merge-guest.
+func (b *Bridge) describeGuest(guestID int64) (string, error) {
+    guest, err := b.DB.GetGuest(guestID)
+    if err != nil {
+        return "", err
+    } else if guest == nil {
+        return "", fmt.Errorf("guest %d doesn't exist", guestID)
+    }
+    conversations, err := b.DB.GetGuestConversations(guestID)
+    if err != nil {
+        return "", err
+    }
+    var sb strings.Builder
+    sb.WriteString(fmt.Sprintf("%s (guest %d)\n", guest.Name, guest.ID))
+    if len(guest.Keys) > 0 {
+        sb.WriteString(fmt.Sprintf("  Contact: %s\n", strings.Join(guest.Keys, ", ")))
+    }
+    for _, hostexID := range conversations {
+        if portal, ok := b.getPortalByID(hostexID); ok {
+            sb.WriteString(fmt.Sprintf("  - %s at %s (%s), %s to %s\n", hostexID, portal.Info.PropertyTitle, portal.Info.ChannelType, portal.Info.CheckInDate, portal.Info.CheckOutDate))
+        } else {
+            sb.WriteString(fmt.Sprintf("  - %s\n", hostexID))
+        }
+    }
+    return sb.String(), nil
+}
+
+// mergeGuest merges the second guest into the first one after confirmation,
+// for a guest who used different contact details in different channels.
+func (u *User) mergeGuest(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) != 2 {
+        u.sendNotice(ctx, roomID, "Usage: !merge-guest <conversation ID|room ID|email|phone> <conversation ID|room ID|email|phone>")
+        return
+    }
+    guestID, err := u.bridge.findGuest(args[0])
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to find the first guest: %v", err))
+        return
+    }
+    mergedID, err := u.bridge.findGuest(args[1])
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to find the second guest: %v", err))
+        return
+    } else if guestID == mergedID {
+        u.sendNotice(ctx, roomID, "Both are already the same guest.")
+        return
+    }
+    guest, err := u.bridge.describeGuest(guestID)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get guest: %v", err))
+        return
+    }
+    merged, err := u.bridge.describeGuest(mergedID)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get guest: %v", err))
+        return
+    }
+
+    u.requestConfirmation(ctx, roomID, fmt.Sprintf("Merge this guest:\n%s\ninto this one?\n%s", merged, guest), func(ctx context.Context) {
+        err := u.bridge.DB.MergeGuests(guestID, mergedID)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to merge guests: %v", err))
+            return
+        }
+        for _, portal := range u.bridge.allPortals() {
+            if portal.guestID == mergedID {
+                portal.guestID = guestID
+            }
+        }
+        u.bridge.Logger.Info("Merged guests", zap.Int64("guest_id", guestID), zap.Int64("merged_guest_id", mergedID))
+        u.sendNotice(ctx, roomID, "Merged the guests.")
+    })
+}
+
+// guestStay is a stay of a guest for !guest history.
+type guestStay struct {
+    hostexID     string
+    code         string
+    status       string
+    checkInDate  string
+    checkOutDate string
+}
+
+// guestStays returns the stays of a conversation from the known reservations,
+// or from the conversation itself if none were recorded.
+func (b *Bridge) guestStays(hostexID string) ([]guestStay, error) {
+    reservations, err := b.DB.GetConversationReservations(hostexID)
+    if err != nil {
+        return nil, err
+    }
+    var stays []guestStay
+    for _, res := range reservations {
+        stays = append(stays, guestStay{hostexID, res.Code, res.Status, res.CheckInDate, res.CheckOutDate})
+    }
+    if portal, ok := b.getPortalByID(hostexID); ok && len(stays) == 0 && portal.Info.CheckInDate != "" {
+        stays = append(stays, guestStay{hostexID, "", portal.Info.ReservationStatus, portal.Info.CheckInDate, portal.Info.CheckOutDate})
+    }
+    return stays, nil
+}
+
+// guestHistory lists the conversations and stays of a guest across channels,
+// newest first, with review ratings, satisfaction replies and notes.
+func (b *Bridge) guestHistory(guestID int64) string {
+    guest, err := b.DB.GetGuest(guestID)
+    if err != nil {
+        b.Logger.Error("Failed to get guest", zap.Error(err))
+        return fmt.Sprintf("Failed to get guest: %v", err)
+    } else if guest == nil {
+        return "The guest doesn't exist anymore."
+    }
+    conversations, err := b.DB.GetGuestConversations(guestID)
+    if err != nil {
+        b.Logger.Error("Failed to get guest conversations", zap.Error(err))
+        return fmt.Sprintf("Failed to get conversations: %v", err)
+    }
+
+    var sb strings.Builder
+    sb.WriteString(fmt.Sprintf("Guest history of %s\n", guest.Name))
+    if len(guest.Keys) > 0 {
+        sb.WriteString(fmt.Sprintf("Contact: %s\n", strings.Join(guest.Keys, ", ")))
+    }
+    if guest.Notes != "" {
+        sb.WriteString(fmt.Sprintf("Notes: %s\n", guest.Notes))
+    }
+
+    var stays []guestStay
+    sb.WriteString(fmt.Sprintf("\nConversations (%d):\n", len(conversations)))
+    for _, hostexID := range conversations {
+        portal, ok := b.getPortalByID(hostexID)
+        if ok {
+            sb.WriteString(fmt.Sprintf("- %s (%s) at %s, last message %s\n", portal.Info.Guest.Name, portal.Info.ChannelType, portal.Info.PropertyTitle, portal.Info.LastMessageAt.In(b.location()).Format(dateLayout)))
+        } else {
+            sb.WriteString(fmt.Sprintf("- %s, no longer listed by Hostex\n", hostexID))
+        }
+        blocked, err := b.DB.IsGuestBlocked(hostexID)
+        if err != nil {
+            b.Logger.Warn("Failed to check blocked guest", zap.Error(err))
+        } else if blocked {
+            sb.WriteString("  Blocked\n")
+        }
+        conversationStays, err := b.guestStays(hostexID)
+        if err != nil {
+            b.Logger.Error("Failed to get guest stays", zap.Error(err))
+            return fmt.Sprintf("Failed to get stays: %v", err)
+        }
+        stays = append(stays, conversationStays...)
+    }
+
+    if len(stays) == 0 {
+        sb.WriteString("\nNo stays recorded.")
+        return sb.String()
+    }
+    sort.Slice(stays, func(i, j int) bool {
+        return stays[i].checkInDate > stays[j].checkInDate
+    })
+    sb.WriteString(fmt.Sprintf("\nStays (%d):\n", len(stays)))
+    for _, stay := range stays {
+        property := stay.hostexID
+        if portal, ok := b.getPortalByID(stay.hostexID); ok {
+            property = fmt.Sprintf("%s (%s)", portal.Info.PropertyTitle, portal.Info.ChannelType)
+        }
+        sb.WriteString(fmt.Sprintf("- %s to %s at %s", stay.checkInDate, stay.checkOutDate, property))
+        if stay.status != "" {
+            sb.WriteString(", " + reservationStage(stay.status))
+        }
+        if stay.code != "" {
+            sb.WriteString(", code " + stay.code)
+            rating, ok, err := b.DB.GetReviewRating(stay.code)
+            if err != nil {
+                b.Logger.Warn("Failed to get review rating", zap.Error(err))
+            } else if ok {
+                sb.WriteString(fmt.Sprintf(", review %.1f/5", rating))
+            }
+        }
+        satisfaction, err := b.DB.GetSatisfaction(stay.hostexID, stay.checkInDate)
+        if err != nil {
+            b.Logger.Warn("Failed to get satisfaction", zap.Error(err))
+        } else if satisfaction != nil && satisfaction.Flag != "" {
+            sb.WriteString(", mid-stay check-in " + satisfaction.Flag)
+        }
+        sb.WriteString("\n")
+    }
+    return sb.String()
+}
+
+// setGuestNotes replaces the notes of a guest and returns the reply to the
+// command.
+func (b *Bridge) setGuestNotes(guestID int64, notes string) string {
+    err := b.DB.SetGuestNotes(guestID, notes)
+    if err != nil {
+        b.Logger.Error("Failed to store guest notes", zap.Error(err))
+        return fmt.Sprintf("Failed to store the notes: %v", err)
+    }
+    if notes == "" {
+        return "Cleared the guest's notes."
+    }
+    return "Stored the guest's notes."
+}
+
+func (u *User) guestCommand(ctx context.Context, roomID id.RoomID, args []string, body string) {
+    const usage = "Usage: !guest history <conversation ID|room ID|guest name|email|phone> or !guest note <conversation ID|room ID|email|phone> [notes]"
+    if len(args) < 2 {
+        u.sendNotice(ctx, roomID, usage)
+        return
+    }
+    switch strings.ToLower(args[0]) {
+    case "history":
+        guestID, err := u.bridge.findGuest(strings.Join(args[1:], " "))
+        if err != nil {
+            u.sendNotice(ctx, roomID, err.Error())
+            return
+        }
+        u.sendNotice(ctx, roomID, u.bridge.guestHistory(guestID))
+    case "note":
+        guestID, err := u.bridge.findGuest(args[1])
+        if err != nil {
+            u.sendNotice(ctx, roomID, err.Error())
+            return
+        }
+        u.sendNotice(ctx, roomID, u.bridge.setGuestNotes(guestID, commandText(body, 3)))
+    default:
+        u.sendNotice(ctx, roomID, usage)
+    }
+}
+
+func (p *Portal) guestCommand(ctx context.Context, args []string, body string) {
+    if p.guestID == 0 {
+        p.linkGuest()
+        if p.guestID == 0 {
+            p.sendNotice(ctx, "This conversation isn't linked to a guest.")
+            return
+        }
+    }
+    switch {
+    case len(args) == 0 || strings.EqualFold(args[0], "history"):
+        p.sendNotice(ctx, p.bridge.guestHistory(p.guestID))
+    case strings.EqualFold(args[0], "note"):
+        p.sendNotice(ctx, p.bridge.setGuestNotes(p.guestID, commandText(body, 2)))
+    default:
+        p.sendNotice(ctx, "Usage: !guest [history] or !guest note [notes]")
+    }
+}
@@ -0,0 +1,80 @@
+package bridge
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+
+    "maunium.net/go/mautrix/event"
+    "go.uber.org/zap"
+)
+
+// StateHostexGuest is the portal room state with the guest's details, so
+// other Matrix automations can read them without parsing the topic.
+var StateHostexGuest = event.Type{Type: "com.hostex.guest", Class: event.StateEventType}
+
+// emptyStateKey is the state key of room-wide state in InitialState.
+var emptyStateKey = ""
+
+type guestStateContent struct {
+    Name     string `json:"name"`
+    Phone    string `json:"phone,omitempty"`
+    Email    string `json:"email,omitempty"`
+    Channel  string `json:"channel,omitempty"`
+    Language string `json:"language,omitempty"`
+}
+
+func (p *Portal) guestState() *guestStateContent {
+    return &guestStateContent{
+        Name:     p.Info.Guest.Name,
+        Phone:    p.Info.Guest.Phone,
+        Email:    p.Info.Guest.Email,
+        Channel:  p.Info.ChannelType,
+        Language: p.guestLanguage(),
+    }
+}
+
+// updateGuestState sends the guest state to the portal room if the guest's
+// details changed since it was last sent.
+func (p *Portal) updateGuestState(ctx context.Context) {
+    content := p.guestState()
+    hash := stateHash(content)
+    if hash == p.guestStateHash {
+        return
+    }
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, StateHostexGuest, "", content)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to update guest state", zap.String("room_id", p.RoomID.String()), zap.Error(err))
+        return
+    }
+    p.setGuestStateHash(hash)
+}
+
+// clearGuestState replaces the guest state of the portal room with empty
+// content, as the redaction of room history leaves state alone.
+func (p *Portal) clearGuestState(ctx context.Context) {
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, StateHostexGuest, "", struct{}{})
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to clear guest state", zap.String("room_id", p.RoomID.String()), zap.Error(err))
+    }
+}
+
+func (p *Portal) setGuestStateHash(hash string) {
+    err := p.bridge.DB.SetPortalGuestStateHash(p.ID, hash)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store guest state hash", zap.Error(err))
+    }
+    p.guestStateHash = hash
+}
+
+// stateHash returns a hash of state content. It's stored instead of the
+// content itself, which may contain personal data.
+func stateHash(content interface{}) string {
+    data, err := json.Marshal(content)
+    if err != nil {
+        return ""
+    }
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,170 @@
+package bridge
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "time"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+const leaderLeaseName = "bridge"
+
+// ErrNotLeader is returned when a standby instance tries to send to Hostex.
+var ErrNotLeader = errors.New("this bridge instance is not the active instance")
+
+// leaseStore is where the leader lease is kept, either the database or Redis.
+type leaseStore interface {
+    GetLease(name string) (*database.Lease, error)
+    AcquireLease(lease *database.Lease, observedSignature string) (bool, error)
+    ReleaseLease(name, instanceID string) error
+}
+
+func (b *Bridge) leases() leaseStore {
+    if b.Redis != nil {
+        return b.Redis
+    }
+    return b.DB
+}
+
+func (b *Bridge) signLease(lease *database.Lease) string {
+    mac := hmac.New(sha256.New, []byte(b.Config().HA.Secret))
+    fmt.Fprintf(mac, "%s|%s|%d", lease.Name, lease.InstanceID, lease.ExpiresAt.UnixMilli())
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (b *Bridge) verifyLease(lease *database.Lease) bool {
+    expected, err := hex.DecodeString(b.signLease(lease))
+    if err != nil {
+        return false
+    }
+    actual, err := hex.DecodeString(lease.Signature)
+    if err != nil {
+        return false
+    }
+    return hmac.Equal(expected, actual)
+}
+
+// IsLeader reports whether this instance currently holds the leader lease and
+// may therefore poll Hostex and send guest messages. Without HA mode, the
+// instance is always the leader.
+func (b *Bridge) IsLeader() bool {
+    if !b.Config().HA.Enable {
+        return true
+    }
+    b.leaderLock.Lock()
+    defer b.leaderLock.Unlock()
+    return time.Now().Before(b.leaseUntil)
+}
+
+// instanceStatus describes this instance and its HA role for !status.
+func (b *Bridge) instanceStatus() string {
+    if !b.Config().HA.Enable {
+        return "single instance"
+    }
+    instanceID := b.Config().HA.InstanceID
+    if b.IsLeader() {
+        return fmt.Sprintf("%s (active)", instanceID)
+    }
+    lease, err := b.leases().GetLease(leaderLeaseName)
+    if err != nil {
+        b.Logger.Error("Failed to read leader lease", zap.Error(err))
+        return fmt.Sprintf("%s (standby)", instanceID)
+    } else if lease == nil || !b.verifyLease(lease) || !time.Now().Before(lease.ExpiresAt) {
+        return fmt.Sprintf("%s (standby, no active instance)", instanceID)
+    }
+    return fmt.Sprintf("%s (standby, active instance: %s)", instanceID, lease.InstanceID)
+}
+
+func (b *Bridge) setLeaseUntil(until time.Time) {
+    b.leaderLock.Lock()
+    wasLeader := time.Now().Before(b.leaseUntil)
+    b.leaseUntil = until
+    isLeader := time.Now().Before(until)
+    b.leaderLock.Unlock()
+
+    if isLeader && !wasLeader {
+        b.Logger.Info("Became the active bridge instance", zap.String("instance_id", b.Config().HA.InstanceID))
+        // The previous leader wrote to the database in the meantime
+        b.DB.ClearCache()
+        b.sendManagementNotice(b.ctx, fmt.Sprintf("Instance %s is now the active bridge instance.", b.Config().HA.InstanceID))
+    } else if !isLeader && wasLeader {
+        b.Logger.Warn("Lost leadership, switching to standby", zap.String("instance_id", b.Config().HA.InstanceID))
+    }
+}
+
+func (b *Bridge) renewLease() {
+    current, err := b.leases().GetLease(leaderLeaseName)
+    if err != nil {
+        b.Logger.Error("Failed to read leader lease", zap.Error(err))
+        return
+    }
+
+    now := time.Now()
+    var observedSignature string
+    if current != nil {
+        valid := b.verifyLease(current)
+        if !valid {
+            b.Logger.Warn("Ignoring leader lease with invalid signature", zap.String("instance_id", current.InstanceID))
+        }
+        if valid && current.InstanceID != b.Config().HA.InstanceID && now.Before(current.ExpiresAt) {
+            b.setLeaseUntil(time.Time{})
+            return
+        }
+        observedSignature = current.Signature
+    }
+
+    lease := &database.Lease{
+        Name:       leaderLeaseName,
+        InstanceID: b.Config().HA.InstanceID,
+        ExpiresAt:  now.Add(b.Config().HA.LeaseDuration),
+    }
+    lease.Signature = b.signLease(lease)
+    acquired, err := b.leases().AcquireLease(lease, observedSignature)
+    if err != nil {
+        b.Logger.Error("Failed to acquire leader lease", zap.Error(err))
+        return
+    }
+    if !acquired {
+        b.setLeaseUntil(time.Time{})
+        return
+    }
+
+    // Stop acting a renew interval before the lease actually expires, so
+    // clock skew between instances can't make both act at the same time.
+    b.setLeaseUntil(lease.ExpiresAt.Add(-b.Config().HA.RenewInterval))
+}
+
+// releaseLease hands the leadership over to another instance right away
+// instead of letting the lease expire.
+func (b *Bridge) releaseLease() {
+    if !b.IsLeader() {
+        return
+    }
+    err := b.leases().ReleaseLease(leaderLeaseName, b.Config().HA.InstanceID)
+    if err != nil {
+        b.Logger.Error("Failed to release leader lease", zap.Error(err))
+    }
+}
+
+func (b *Bridge) startLeaderElection() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(b.Config().HA.RenewInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.stop:
+            // The lease is released by Stop once the in-flight work is done
+            return
+        case <-ticker.C:
+            b.renewLease()
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package bridge
+
+import (
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// applyNotificationHint makes a bridged message silent if it's a routine
+// platform message, or mentions the admin if it needs attention: inquiries,
+// same-day bookings and messages about high-value properties.
+func (p *Portal) applyNotificationHint(content *event.MessageEventContent, msg hostexapi.Message) {
+    hints := p.bridge.Config().NotificationHints
+    for _, sender := range hints.PlatformSenders {
+        if strings.EqualFold(msg.Sender, sender) {
+            content.MsgType = event.MsgNotice
+            return
+        }
+    }
+    if isHostSender(msg.Sender) || !p.needsAttention() {
+        return
+    }
+    content.Mentions = &event.Mentions{UserIDs: []id.UserID{p.bridge.alertRecipient()}}
+}
+
+func (p *Portal) needsAttention() bool {
+    if isPendingStage(reservationStage(p.Info.ReservationStatus)) {
+        return true
+    }
+    if p.Info.CheckInDate == time.Now().In(p.bridge.location()).Format(dateLayout) {
+        return true
+    }
+    for _, property := range p.bridge.Config().NotificationHints.HighValueProperties {
+        if strings.EqualFold(property, p.Info.PropertyTitle) {
+            return true
+        }
+    }
+    return false
+}
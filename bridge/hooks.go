@@ -0,0 +1,134 @@
+package bridge
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "sort"
+    "strings"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// maxHookResponseSize limits how much of a webhook response is posted.
+const maxHookResponseSize = 64 * 1024
+
+// CommandHookRequest is the JSON body sent to command webhooks.
+type CommandHookRequest struct {
+    Command string    `json:"command"`
+    Args    []string  `json:"args"`
+    Sender  id.UserID `json:"sender"`
+    RoomID  id.RoomID `json:"room_id"`
+
+    // Only set for commands sent in portal rooms
+    Conversation *hostexapi.Conversation `json:"conversation,omitempty"`
+}
+
+// CommandHookResponse is the expected JSON response of command webhooks.
+// Responses that aren't JSON are posted as plain text.
+type CommandHookResponse struct {
+    Text string `json:"text"`
+}
+
+// commandHookURL returns the webhook URL of a custom command, or an empty
+// string if there is none.
+func (b *Bridge) commandHookURL(command string) string {
+    return b.Config().CommandHooks.Commands[strings.TrimPrefix(command, "!")]
+}
+
+// runCommandHook forwards a custom command to its webhook in the background
+// and posts the response to the room. It returns false if the command has no
+// webhook.
+func (b *Bridge) runCommandHook(roomID id.RoomID, sender id.UserID, command string, args []string, conv *hostexapi.Conversation) bool {
+    url := b.commandHookURL(command)
+    if url == "" {
+        return false
+    }
+
+    req := &CommandHookRequest{
+        Command: strings.TrimPrefix(command, "!"),
+        Args:    args,
+        Sender:  sender,
+        RoomID:  roomID,
+
+        Conversation: conv,
+    }
+    started := b.goTask(func() {
+        ctx, cancel := context.WithTimeout(b.ctx, b.Config().CommandHooks.Timeout)
+        defer cancel()
+
+        text, err := b.callCommandHook(ctx, url, req)
+        if err != nil {
+            b.Logger.Error("Command webhook failed", zap.String("command", req.Command), zap.Error(err))
+            b.sendNotice(b.ctx, roomID, fmt.Sprintf("!%s failed: %v", req.Command, err))
+            return
+        } else if text == "" {
+            return
+        }
+        b.sendNotice(b.ctx, roomID, text)
+    })
+    if !started {
+        b.sendNotice(b.ctx, roomID, fmt.Sprintf("The bridge is shutting down, !%s wasn't run.", req.Command))
+    }
+    return true
+}
+
+func (b *Bridge) callCommandHook(ctx context.Context, url string, hookReq *CommandHookRequest) (string, error) {
+    body, err := json.Marshal(hookReq)
+    if err != nil {
+        return "", err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if b.Config().CommandHooks.Secret != "" {
+        req.Header.Set("Authorization", "Bearer "+b.Config().CommandHooks.Secret)
+    }
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
+    if err != nil {
+        return "", fmt.Errorf("failed to read response: %w", err)
+    }
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return "", fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+    }
+
+    if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+        var hookResp CommandHookResponse
+        err = json.Unmarshal(data, &hookResp)
+        if err != nil {
+            return "", fmt.Errorf("invalid response: %w", err)
+        }
+        return hookResp.Text, nil
+    }
+    return strings.TrimSpace(string(data)), nil
+}
+
+// customCommandsHelp lists the commands handled by webhooks for the help
+// message.
+func (b *Bridge) customCommandsHelp() string {
+    if len(b.Config().CommandHooks.Commands) == 0 {
+        return ""
+    }
+    commands := make([]string, 0, len(b.Config().CommandHooks.Commands))
+    for command := range b.Config().CommandHooks.Commands {
+        commands = append(commands, "!"+command)
+    }
+    sort.Strings(commands)
+    return "\n\nCustom commands: " + strings.Join(commands, ", ")
+}
@@ -0,0 +1,208 @@
+package bridge
+
+import (
+    "bytes"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// importedMessage is a message of a conversation export. JSON exports are an
+// array of these objects, CSV exports need a header row with the same
+// column names.
+type importedMessage struct {
+    Timestamp string `json:"timestamp"`
+    Sender    string `json:"sender"`
+    Content   string `json:"content"`
+}
+
+var importTimestampLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05"}
+
+// pendingImport is a history import waiting for the export file upload.
+type pendingImport struct {
+    portal *Portal
+}
+
+func (u *User) startHistoryImport(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !import-history <conversation ID|room ID|guest name>, then upload the CSV or JSON export")
+        return
+    }
+    portal := u.bridge.findPortal(strings.Join(args, " "))
+    if portal == nil || portal.RoomID == "" {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No bridged conversation found for %q.", strings.Join(args, " ")))
+        return
+    }
+    u.pendingImport = &pendingImport{portal: portal}
+    u.sendNotice(ctx, roomID, fmt.Sprintf(`Upload the export of the conversation with %s as a CSV or JSON file.
+It needs timestamp, sender and content fields, e.g. [{"timestamp": "2023-05-01T14:30:00Z", "sender": "Guest", "content": "Hi!"}].
+Messages with the sender "Guest" or %s are posted as the guest, the others as the host. Type !cancel to stop.`,
+        portal.Info.Guest.Name, portal.Info.Guest.Name))
+}
+
+// handleImportFile imports the uploaded export file into the portal the
+// import was started for.
+func (u *User) handleImportFile(ctx context.Context, roomID id.RoomID, content *event.MessageEventContent) {
+    portal := u.pendingImport.portal
+    u.pendingImport = nil
+
+    mxc, err := content.URL.Parse()
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Invalid file: %v", err))
+        return
+    }
+    data, err := u.bridge.MatrixClient.DownloadBytes(ctx, mxc)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to download the file: %v", err))
+        return
+    }
+    messages, err := parseImport(data, content.Body)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to parse the export: %v", err))
+        return
+    }
+
+    started := u.bridge.goTask(func() {
+        imported, skipped, err := portal.importMessages(ctx, messages)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Import stopped after %d messages: %v", imported, err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Imported %d messages into the conversation with %s (%d already present).", imported, portal.Info.Guest.Name, skipped))
+    })
+    if !started {
+        u.sendNotice(ctx, roomID, "The bridge is shutting down, try again after the restart.")
+        return
+    }
+    u.sendNotice(ctx, roomID, fmt.Sprintf("Importing %d messages into the conversation with %s...", len(messages), portal.Info.Guest.Name))
+}
+
+func parseImport(data []byte, fileName string) ([]importedMessage, error) {
+    var messages []importedMessage
+    trimmed := bytes.TrimSpace(data)
+    if strings.HasSuffix(strings.ToLower(fileName), ".json") || bytes.HasPrefix(trimmed, []byte("[")) {
+        err := json.Unmarshal(trimmed, &messages)
+        return messages, err
+    }
+
+    records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+    if err != nil {
+        return nil, err
+    } else if len(records) == 0 {
+        return nil, fmt.Errorf("the file is empty")
+    }
+    columns := make(map[string]int)
+    for i, name := range records[0] {
+        columns[strings.ToLower(strings.TrimSpace(name))] = i
+    }
+    for _, name := range []string{"timestamp", "sender", "content"} {
+        if _, ok := columns[name]; !ok {
+            return nil, fmt.Errorf("missing %s column", name)
+        }
+    }
+    for _, record := range records[1:] {
+        messages = append(messages, importedMessage{
+            Timestamp: record[columns["timestamp"]],
+            Sender:    record[columns["sender"]],
+            Content:   record[columns["content"]],
+        })
+    }
+    return messages, nil
+}
+
+func parseImportTimestamp(value string, loc *time.Location) (time.Time, error) {
+    for _, layout := range importTimestampLayouts {
+        timestamp, err := time.ParseInLocation(layout, strings.TrimSpace(value), loc)
+        if err == nil {
+            return timestamp, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// importSender maps the sender of an exported message to a Hostex sender, so
+// the message is sent by the guest's ghost or as the host like bridged
+// messages. Anyone but the guest is taken to be the host or a co-host.
+func (p *Portal) importSender(sender string) string {
+    sender = strings.TrimSpace(sender)
+    if strings.EqualFold(sender, "guest") || strings.EqualFold(sender, p.Info.Guest.Name) {
+        return "guest"
+    }
+    return "host"
+}
+
+// importMessages posts the messages in the portal room with their original
+// timestamps, oldest first, skipping the ones that are already there.
+func (p *Portal) importMessages(ctx context.Context, messages []importedMessage) (imported, skipped int, err error) {
+    type parsedMessage struct {
+        importedMessage
+        timestamp time.Time
+    }
+    parsed := make([]parsedMessage, 0, len(messages))
+    for _, msg := range messages {
+        timestamp, err := parseImportTimestamp(msg.Timestamp, p.bridge.location())
+        if err != nil {
+            return 0, 0, err
+        }
+        parsed = append(parsed, parsedMessage{msg, timestamp})
+    }
+    sort.SliceStable(parsed, func(i, j int) bool {
+        return parsed[i].timestamp.Before(parsed[j].timestamp)
+    })
+
+    batch := p.bridge.DB.NewMessageBatch(messageBatchSize)
+    defer func() {
+        flushErr := batch.Flush()
+        if flushErr != nil {
+            p.bridge.Logger.Error("Failed to store imported messages", zap.Error(flushErr))
+        }
+    }()
+
+    for _, msg := range parsed {
+        if msg.Content == "" {
+            continue
+        }
+        messageID := p.bridge.DB.ImportedMessageID(p.ID, msg.timestamp, msg.Sender, msg.Content)
+        exists, err := batch.HasMessage(messageID)
+        if err != nil {
+            return imported, skipped, err
+        } else if exists {
+            skipped++
+            continue
+        }
+
+        eventID, err := p.SendMessage(ctx, hostexapi.Message{
+            ID:        messageID,
+            Content:   msg.Content,
+            Timestamp: msg.timestamp,
+            Sender:    p.importSender(msg.Sender),
+        })
+        if err != nil {
+            return imported, skipped, err
+        }
+        err = batch.Add(&database.Message{
+            HostexID:        p.ID,
+            MatrixEventID:   eventID,
+            HostexMessageID: messageID,
+            Timestamp:       msg.timestamp,
+            Sender:          msg.Sender,
+            Content:         msg.Content,
+        })
+        if err != nil {
+            p.bridge.Logger.Error("Failed to store imported messages", zap.Error(err))
+        }
+        imported++
+    }
+    return imported, skipped, nil
+}
@@ -0,0 +1,132 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "text/template"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/config"
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+// checkInTemplateData is what the check-in instructions template is rendered
+// with.
+type checkInTemplateData struct {
+    replyTemplateData
+    DoorCode     string
+    Instructions string
+}
+
+// propertyInstructions returns the configured check-in details of the
+// property with the given ID or title.
+func (b *Bridge) propertyInstructions(propertyID, title string) config.PropertyInstructions {
+    properties := b.Config().CheckInInstructions.Properties
+    if instructions, ok := properties[propertyID]; ok && propertyID != "" {
+        return instructions
+    }
+    for name, instructions := range properties {
+        if strings.EqualFold(name, title) {
+            return instructions
+        }
+    }
+    return config.PropertyInstructions{}
+}
+
+// checkInInstructions renders the check-in instructions of the portal's
+// reservation and returns them with the reservation code.
+func (p *Portal) checkInInstructions(ctx context.Context) (string, string, error) {
+    res, err := p.conversationReservation(ctx)
+    if err != nil {
+        return "", "", fmt.Errorf("failed to get reservation: %w", err)
+    }
+
+    cfg := p.bridge.Config().CheckInInstructions
+    data := checkInTemplateData{replyTemplateData: p.replyTemplateData()}
+    var code, propertyID string
+    if res != nil {
+        code = res.ReservationCode
+        propertyID = res.PropertyID
+        data.DoorCode = strings.TrimSpace(res.CustomFields[cfg.CodeField])
+        data.Instructions = strings.TrimSpace(res.CustomFields[cfg.InstructionsField])
+    }
+    fallback := p.bridge.propertyInstructions(propertyID, p.Info.PropertyTitle)
+    if data.DoorCode == "" {
+        data.DoorCode = fallback.DoorCode
+    }
+    if data.Instructions == "" {
+        data.Instructions = fallback.Instructions
+    }
+    if data.DoorCode == "" && data.Instructions == "" {
+        return "", "", fmt.Errorf("no door code or check-in instructions for %s, set the %s or %s custom field in Hostex or add the property to check_in_instructions in the config",
+            p.Info.PropertyTitle, cfg.CodeField, cfg.InstructionsField)
+    }
+
+    tmpl, err := template.New("check_in_instructions").Parse(cfg.Template)
+    if err != nil {
+        return "", "", fmt.Errorf("invalid check-in instructions template: %w", err)
+    }
+    var text strings.Builder
+    err = tmpl.Execute(&text, data)
+    if err != nil {
+        return "", "", fmt.Errorf("failed to render check-in instructions: %w", err)
+    }
+    return strings.TrimSpace(text.String()), code, nil
+}
+
+// sendCheckInInstructions sends the check-in instructions to the guest on
+// behalf of sender and records that they were sent.
+func (p *Portal) sendCheckInInstructions(ctx context.Context, sender id.UserID) string {
+    previous, err := p.bridge.DB.GetLastInstructionsSent(p.ID)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to get previously sent check-in instructions", zap.Error(err))
+    }
+
+    text, code, err := p.checkInInstructions(ctx)
+    if err != nil {
+        return fmt.Sprintf("Failed to send check-in instructions: %v", err)
+    }
+    err = p.sendBridgeMessage(ctx, sender, text)
+    if err != nil {
+        return fmt.Sprintf("Failed to send check-in instructions: %v", err)
+    }
+
+    p.bridge.Logger.Info("Sent check-in instructions",
+        zap.String("hostex_id", p.ID),
+        zap.String("reservation_code", code),
+        zap.String("sender", sender.String()))
+    err = p.bridge.DB.LogInstructionsSent(&database.InstructionsSent{
+        HostexID:        p.ID,
+        ReservationCode: code,
+        Sender:          sender,
+        SentAt:          time.Now(),
+    })
+    if err != nil {
+        p.bridge.Logger.Error("Failed to log sent check-in instructions", zap.Error(err))
+    }
+
+    reply := fmt.Sprintf("Sent the check-in instructions to %s.", p.Info.Guest.Name)
+    if previous != nil {
+        reply += fmt.Sprintf(" They were already sent by %s on %s.",
+            previous.Sender, previous.SentAt.In(p.bridge.location()).Format("2006-01-02 15:04"))
+    }
+    return reply
+}
+
+func (u *User) sendCheckInInstructions(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !sendcode <conversation ID|room ID|guest name>")
+        return
+    }
+    query := strings.Join(args, " ")
+    portal := u.bridge.findPortal(query)
+    if portal == nil || portal.RoomID == "" {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No bridged conversation found for %q.", query))
+        return
+    }
+    u.sendNotice(ctx, roomID, portal.sendCheckInInstructions(ctx, u.MXID))
+}
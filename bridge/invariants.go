@@ -0,0 +1,104 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// invariantChecker collects the Hostex messages seen during a poll, so that
+// checkInvariants can verify none of them got lost on the way to Matrix.
+type invariantChecker struct {
+    lock     sync.Mutex
+    pending  []seenMessage
+    reported map[string]bool
+}
+
+type seenMessage struct {
+    portal *Portal
+    msg    hostexapi.Message
+}
+
+func newInvariantChecker() *invariantChecker {
+    return &invariantChecker{
+        reported: make(map[string]bool),
+    }
+}
+
+func (ic *invariantChecker) seen(portal *Portal, msg hostexapi.Message) {
+    if msg.ID == "" {
+        return
+    }
+    ic.lock.Lock()
+    ic.pending = append(ic.pending, seenMessage{portal: portal, msg: msg})
+    ic.lock.Unlock()
+}
+
+func (ic *invariantChecker) take() []seenMessage {
+    ic.lock.Lock()
+    defer ic.lock.Unlock()
+    pending := ic.pending
+    ic.pending = nil
+    return pending
+}
+
+// checkInvariants verifies that every Hostex message seen since the last
+// check has a database row and a Matrix event, and reports the ones that
+// don't to the management room. Each message is only reported once.
+func (b *Bridge) checkInvariants(ctx context.Context) {
+    var problems []string
+    for _, seen := range b.invariants.take() {
+        problem := b.checkMessageInvariant(ctx, seen.portal, seen.msg)
+        if problem == "" || b.invariants.reported[seen.msg.ID] {
+            continue
+        }
+        b.invariants.reported[seen.msg.ID] = true
+
+        b.Logger.Error("Message invariant violated",
+            zap.String("hostex_id", seen.portal.ID),
+            zap.String("message_id", seen.msg.ID),
+            zap.String("problem", problem))
+        problems = append(problems, fmt.Sprintf("- %s\n  Conversation: %s (%s), room %s\n  Message %s from %s at %s: %q",
+            problem,
+            seen.portal.Info.Guest.Name, seen.portal.ID, seen.portal.RoomID,
+            seen.msg.ID, seen.msg.Sender, seen.msg.Timestamp.In(b.location()).Format("2006-01-02 15:04:05"),
+            truncate(seen.msg.Content, 100)))
+    }
+    if len(problems) == 0 {
+        return
+    }
+    b.sendManagementNotice(ctx, fmt.Sprintf("Found %d Hostex messages that weren't bridged correctly:\n%s", len(problems), strings.Join(problems, "\n")))
+}
+
+// checkMessageInvariant returns what's wrong with a seen message, or an empty
+// string if it was bridged correctly.
+func (b *Bridge) checkMessageInvariant(ctx context.Context, portal *Portal, msg hostexapi.Message) string {
+    eventID, err := b.DB.GetMessageEventID(msg.ID)
+    if err != nil {
+        b.Logger.Warn("Failed to check message invariant", zap.String("message_id", msg.ID), zap.Error(err))
+        return ""
+    } else if eventID == "" {
+        return "no database row"
+    } else if portal.RoomID == "" {
+        return "the portal has no room"
+    }
+
+    _, err = b.MatrixClient.GetEvent(ctx, portal.RoomID, eventID)
+    if err != nil {
+        return fmt.Sprintf("Matrix event %s can't be fetched (%v)", eventID, err)
+    }
+    return ""
+}
+
+func truncate(text string, length int) string {
+    runes := []rune(text)
+    if len(runes) <= length {
+        return text
+    }
+    return string(runes[:length]) + "…"
+}
@@ -0,0 +1,119 @@
+package bridge
+
+import (
+    "context"
+    "strings"
+    "unicode"
+
+    "go.uber.org/zap"
+)
+
+// languageWords are common words of languages written in the Latin script,
+// which tell them apart in short messages.
+var languageWords = map[string][]string{
+    "en": {"the", "and", "is", "you", "to", "we", "are", "have", "what", "this", "thanks", "thank", "hello", "please", "can", "will", "our", "with", "there", "arrive", "check"},
+    "es": {"el", "los", "las", "que", "y", "es", "por", "para", "gracias", "hola", "una", "con", "está", "estamos", "buenas", "llegamos", "cuando", "donde", "muchas", "hay", "pero"},
+    "fr": {"le", "les", "et", "est", "je", "nous", "vous", "pour", "merci", "bonjour", "une", "des", "avec", "dans", "pas", "sommes", "arrivée", "bonsoir", "oui"},
+    "de": {"der", "die", "das", "und", "ist", "ich", "wir", "nicht", "danke", "hallo", "mit", "für", "ein", "eine", "auf", "zu", "bitte", "können", "wann", "gibt"},
+    "it": {"il", "lo", "che", "di", "grazie", "ciao", "sono", "siamo", "per", "non", "buongiorno", "arriviamo", "della", "anche", "quando", "dove"},
+    "pt": {"os", "que", "obrigado", "obrigada", "olá", "oi", "não", "você", "para", "uma", "estamos", "chegamos", "muito", "bom", "dia", "vocês"},
+    "nl": {"het", "en", "ik", "wij", "niet", "dank", "bedankt", "hallo", "met", "voor", "een", "van", "zijn", "wanneer", "graag", "jullie"},
+}
+
+// scriptLanguages are languages recognized by their script alone.
+var scriptLanguages = []struct {
+    language string
+    script   *unicode.RangeTable
+}{
+    {"ja", unicode.Hiragana},
+    {"ja", unicode.Katakana},
+    {"ko", unicode.Hangul},
+    {"zh", unicode.Han},
+    {"ru", unicode.Cyrillic},
+    {"ar", unicode.Arabic},
+    {"he", unicode.Hebrew},
+    {"el", unicode.Greek},
+    {"th", unicode.Thai},
+}
+
+// detectLanguage guesses the language of a message, as a language code like
+// "es", or returns an empty string if the message is too short or ambiguous
+// to tell.
+func detectLanguage(text string) string {
+    var letters int
+    scripts := make(map[string]int)
+    for _, r := range text {
+        if !unicode.IsLetter(r) {
+            continue
+        }
+        letters++
+        for _, sl := range scriptLanguages {
+            if unicode.Is(sl.script, r) {
+                scripts[sl.language]++
+                break
+            }
+        }
+    }
+    if letters == 0 {
+        return ""
+    }
+    // Japanese mixes kana with Han characters, so any kana makes it
+    // Japanese
+    if scripts["ja"] > 0 {
+        return "ja"
+    }
+    for _, sl := range scriptLanguages {
+        if scripts[sl.language]*10 >= letters*3 {
+            return sl.language
+        }
+    }
+
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r)
+    })
+    present := make(map[string]bool, len(words))
+    for _, word := range words {
+        present[word] = true
+    }
+    best, bestScore, secondScore := "", 0, 0
+    for language, common := range languageWords {
+        var score int
+        for _, word := range common {
+            if present[word] {
+                score++
+            }
+        }
+        if score > bestScore {
+            best, bestScore, secondScore = language, score, bestScore
+        } else if score > secondScore {
+            secondScore = score
+        }
+    }
+    if bestScore < 2 || bestScore == secondScore {
+        return ""
+    }
+    return best
+}
+
+// guestLanguage returns the guest's language: the one from Hostex, or the
+// one detected in their messages.
+func (p *Portal) guestLanguage() string {
+    if p.Info.Guest.Language != "" {
+        return p.Info.Guest.Language
+    }
+    return p.language
+}
+
+// setLanguage stores the language the guest's messages were detected to be
+// in.
+func (p *Portal) setLanguage(ctx context.Context, language string) {
+    if language == "" || language == p.language {
+        return
+    }
+    p.language = language
+    err := p.bridge.DB.SetPortalLanguage(p.ID, language)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store portal language", zap.String("hostex_id", p.ID), zap.Error(err))
+    }
+    p.updateGuestState(ctx)
+}
@@ -0,0 +1,156 @@
+package bridge
+
+import (
+    "fmt"
+    "io"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/config"
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/email"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+    "github.com/keithah/hostex-bridge-go/logging"
+    "github.com/keithah/hostex-bridge-go/redisstore"
+    "github.com/keithah/hostex-bridge-go/translate"
+)
+
+// Options are the dependencies of a bridge created with New. Anything left
+// nil is created from the config, so an application embedding the bridge
+// only has to pass the parts it wants to share or replace.
+type Options struct {
+    Logger *zap.Logger
+    // Store is a shared SQLite connection, opened with the options of
+    // database.DSN. It isn't closed by Stop.
+    Store  database.Store
+    Hostex HostexAPI
+    // Matrix is the client of the bridge bot, config.user.user_id.
+    Matrix MatrixClient
+    // Admin is the client of the admin's account. When nil, it's created if
+    // admin.access_token is set.
+    Admin MatrixClient
+    // Accounts are the clients of the additional Hostex accounts, by name.
+    // When nil, they're created from hostex.accounts in the config.
+    Accounts  map[string]HostexAPI
+    Redis     *redisstore.Store
+    Email      *email.Client
+    Translator translate.Translator
+    AccessLog  *logging.AccessLog
+}
+
+// New creates a bridge that can be driven with Start and Stop. Resources
+// created here, like the database and Redis connections, are closed by Stop.
+func New(cfg *config.Config, opts Options) (*Bridge, error) {
+    logger := opts.Logger
+    if logger == nil {
+        logger = zap.NewNop()
+    }
+    var closers []io.Closer
+    fail := func(err error) (*Bridge, error) {
+        for _, closer := range closers {
+            closer.Close()
+        }
+        return nil, err
+    }
+
+    var db *database.Database
+    var err error
+    if opts.Store != nil {
+        db, err = database.NewWithDB(opts.Store, logger, cfg.Database.EncryptionKeyBytes)
+    } else {
+        db, err = database.New(cfg.Database.Path, logger, cfg.Database.EncryptionKeyBytes)
+        if err == nil {
+            closers = append(closers, db)
+        }
+    }
+    if err != nil {
+        return fail(fmt.Errorf("failed to initialize database: %w", err))
+    }
+
+    redisStore := opts.Redis
+    if redisStore == nil && cfg.Redis.URL != "" {
+        redisStore, err = redisstore.New(cfg.Redis.URL, cfg.Redis.KeyPrefix, cfg.Redis.Timeout)
+        if err != nil {
+            return fail(fmt.Errorf("failed to initialize Redis: %w", err))
+        }
+        closers = append(closers, redisStore)
+    }
+
+    hostexClient := opts.Hostex
+    if hostexClient == nil {
+        client := newHostexClient(cfg, cfg.Hostex.Token, logger)
+        if redisStore != nil {
+            client.SetRateLimitStore(redisStore)
+        }
+        hostexClient = client
+    }
+
+    matrixClient := opts.Matrix
+    if matrixClient == nil {
+        client, err := NewMatrixClient(cfg.Homeserver.Address, cfg.User.UserID, cfg.Appservice.ASToken)
+        if err != nil {
+            return fail(fmt.Errorf("failed to create Matrix client: %w", err))
+        }
+        matrixClient = client
+    }
+
+    adminClient := opts.Admin
+    if adminClient == nil && cfg.Admin.AccessToken != "" {
+        client, err := NewMatrixClient(cfg.Homeserver.Address, cfg.Admin.UserID.String(), cfg.Admin.AccessToken)
+        if err != nil {
+            return fail(fmt.Errorf("failed to create admin Matrix client: %w", err))
+        }
+        adminClient = client
+    }
+
+    accessLog := opts.AccessLog
+    if accessLog == nil && cfg.AccessLog.Path != "" {
+        accessLogFile, err := logging.NewRotatingFile(cfg.AccessLog.Path, int64(cfg.AccessLog.MaxSize)*1024*1024, cfg.AccessLog.MaxBackups)
+        if err != nil {
+            return fail(fmt.Errorf("failed to open access log: %w", err))
+        }
+        closers = append(closers, accessLogFile)
+        accessLog = logging.NewAccessLog(accessLogFile)
+        err = accessLog.SetTrustedProxies(cfg.AccessLog.TrustedProxies)
+        if err != nil {
+            return fail(err)
+        }
+    }
+
+    b := NewBridge(cfg, db, hostexClient, matrixClient, logger)
+    b.AdminClient = adminClient
+    b.AccessLog = accessLog
+    b.Redis = redisStore
+    b.Email = opts.Email
+    if b.Email == nil && cfg.Email.Enable {
+        b.Email = email.NewClient(cfg.Email.IMAPAddress, cfg.Email.SMTPAddress, cfg.Email.Username, cfg.Email.Password, cfg.Email.From, cfg.Email.Mailbox, cfg.Email.Timeout)
+    }
+    b.Translator = opts.Translator
+    if b.Translator == nil && cfg.Translation.Enable {
+        translator, err := translate.New(cfg.Translation.Provider, cfg.Translation.URL, cfg.Translation.APIKey, cfg.Translation.Timeout)
+        if err != nil {
+            return fail(err)
+        }
+        b.Translator = translator
+    }
+    for _, account := range cfg.Hostex.Accounts {
+        client, ok := opts.Accounts[account.Name]
+        if !ok {
+            accountClient := newHostexClient(cfg, account.Token, logger)
+            if redisStore != nil {
+                accountClient.SetRateLimitStore(redisStore.AccountRateLimit(account.Name))
+            }
+            client = accountClient
+        }
+        b.AddAccount(account.Name, client)
+    }
+    b.closers = closers
+    return b, nil
+}
+
+func newHostexClient(cfg *config.Config, token string, logger *zap.Logger) *hostexapi.Client {
+    client := hostexapi.NewClient(cfg.Hostex.APIURL, token, cfg.Hostex.Timeout, logger)
+    client.SetBudget(cfg.Hostex.RequestsPerMinute, cfg.Hostex.BackfillShare, cfg.Hostex.BulkShare)
+    client.SetFallbackURLs(cfg.Hostex.FallbackURLs)
+    return client
+}
@@ -0,0 +1,51 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// findContinuedPortal returns the portal of a known conversation that a new
+// conversation clearly continues, e.g. when Hostex moves an inquiry to a new
+// thread once it's booked. Both need the same guest, channel, property and
+// check-in date. Only portals loaded since startup are considered.
+func (b *Bridge) findContinuedPortal(conv hostexapi.Conversation) *Portal {
+    if conv.Guest.Name == "" || conv.CheckInDate == "" {
+        return nil
+    }
+    for _, portal := range b.allPortals() {
+        if portal.ID != conv.ID && portal.RoomID != "" &&
+            strings.EqualFold(portal.Info.Guest.Name, conv.Guest.Name) &&
+            portal.Info.ChannelType == conv.ChannelType &&
+            portal.Info.PropertyTitle == conv.PropertyTitle &&
+            portal.Info.CheckInDate == conv.CheckInDate {
+            return portal
+        }
+    }
+    return nil
+}
+
+// mergePortal moves an existing portal to the new conversation ID, so that
+// the conversation continues in the same room.
+func (b *Bridge) mergePortal(ctx context.Context, portal *Portal, conv hostexapi.Conversation) error {
+    oldID := portal.ID
+    err := b.DB.MergePortal(oldID, conv.ID)
+    if err != nil {
+        return err
+    }
+
+    b.renamePortal(portal, conv.ID)
+    portal.cacheState()
+
+    b.Logger.Info("Merged conversation into existing portal",
+        zap.String("old_hostex_id", oldID),
+        zap.String("hostex_id", conv.ID),
+        zap.String("room_id", portal.RoomID.String()))
+    portal.sendNotice(ctx, fmt.Sprintf("Hostex moved this conversation to a new thread (%s → %s). It continues in this room.", oldID, conv.ID))
+    return nil
+}
@@ -0,0 +1,70 @@
+package bridge
+
+import (
+    "fmt"
+    "strings"
+    "text/template"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/config"
+)
+
+// nameTemplateData is what the username, display name and room name
+// templates get. As {{.}} it's the conversation ID.
+type nameTemplateData struct {
+    ID       string
+    Name     string
+    Channel  string
+    Property string
+    // Status is the reservation stage: inquiry, request, confirmed or
+    // cancelled.
+    Status string
+}
+
+func (d nameTemplateData) String() string {
+    return d.ID
+}
+
+type nameTemplates struct {
+    username    *template.Template
+    displayname *template.Template
+    roomName    *template.Template
+}
+
+func parseNameTemplates(cfg *config.Config) (names nameTemplates, err error) {
+    names.username, err = template.New("username_template").Parse(cfg.Bridge.UsernameTemplate)
+    if err != nil {
+        return names, fmt.Errorf("invalid bridge.username_template: %w", err)
+    }
+    names.displayname, err = template.New("displayname_format").Parse(cfg.Bridge.DisplaynameFormat)
+    if err != nil {
+        return names, fmt.Errorf("invalid bridge.displayname_format: %w", err)
+    }
+    names.roomName, err = template.New("room_name_format").Parse(cfg.Bridge.RoomNameFormat)
+    if err != nil {
+        return names, fmt.Errorf("invalid bridge.room_name_format: %w", err)
+    }
+    return names, nil
+}
+
+// renderName renders a name template for the portal's guest. The fallback is
+// used if the template isn't loaded yet or fails.
+func (p *Portal) renderName(tpl *template.Template, fallback string) string {
+    if tpl == nil {
+        return fallback
+    }
+    var buf strings.Builder
+    err := tpl.Execute(&buf, nameTemplateData{
+        ID:       p.ID,
+        Name:     p.Info.Guest.Name,
+        Channel:  p.Info.ChannelType,
+        Property: p.Info.PropertyTitle,
+        Status:   reservationStage(p.Info.ReservationStatus),
+    })
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to render name template", zap.String("template", tpl.Name()), zap.Error(err))
+        return fallback
+    }
+    return buf.String()
+}
@@ -0,0 +1,229 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+const (
+    outboxBatchSize    = 20
+    outboxPollInterval = 5 * time.Second
+    maxOutboxBackoff   = 10 * time.Minute
+    // deliveryClockSkew is how much earlier than the first attempt a
+    // matching Hostex message may be timestamped to count as delivered by it.
+    deliveryClockSkew = time.Minute
+)
+
+// AutomatedContentKey marks the messages in portal rooms that were sent to
+// the guest by an automation instead of a person.
+const AutomatedContentKey = "com.hostex.automated"
+
+// queueMessage stores a reply in the outbox and wakes up the outbox worker,
+// which delivers it to Hostex with retries.
+func (p *Portal) queueMessage(eventID id.EventID, sender id.UserID, body string) error {
+    return p.enqueueMessage(&database.OutboxMessage{
+        MatrixEventID: eventID,
+        Sender:        sender,
+        Content:       body,
+    })
+}
+
+// enqueueMessage stores a message in the outbox. Replies are translated here
+// rather than on delivery, so every attempt sends the same text and the room
+// shows what the guest gets.
+func (p *Portal) enqueueMessage(msg *database.OutboxMessage) error {
+    var translated bool
+    if p.bridge.Translator != nil && p.bridge.Config().Translation.Outbound {
+        body, err := p.translateOutbound(p.bridge.ctx, msg.Content)
+        if err != nil {
+            return fmt.Errorf("failed to translate reply: %w", err)
+        }
+        translated = body != msg.Content
+        msg.Content = body
+    }
+
+    now := time.Now()
+    msg.HostexID = p.ID
+    msg.NextAttemptAt = now
+    msg.CreatedAt = now
+    _, err := p.bridge.DB.EnqueueOutbox(msg)
+    if err != nil {
+        return err
+    }
+    p.clearAISuggestion()
+    p.setMessageStatus(p.bridge.ctx, msg.MatrixEventID, StatusPending)
+    if translated {
+        p.sendTranslationNotice(p.bridge.ctx, msg.MatrixEventID, msg.Content)
+    }
+    p.bridge.wakeOutbox()
+    return nil
+}
+
+// sendBridgeMessage sends a message to the guest on behalf of sender that
+// wasn't typed in the room, e.g. a suggested reply. It's posted in the room
+// first, so it's part of the conversation history and gets a delivery status
+// like any other reply.
+func (p *Portal) sendBridgeMessage(ctx context.Context, sender id.UserID, body string) error {
+    return p.postAndQueue(ctx, &event.Content{
+        Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: body},
+    }, &database.OutboxMessage{Sender: sender, Content: body})
+}
+
+func (p *Portal) postAndQueue(ctx context.Context, content *event.Content, msg *database.OutboxMessage) error {
+    resp, err := p.bridge.MatrixClient.SendMessageEvent(ctx, p.RoomID, event.EventMessage, content)
+    if err != nil {
+        return fmt.Errorf("failed to post message in room: %w", err)
+    }
+    msg.MatrixEventID = resp.EventID
+    err = p.enqueueMessage(msg)
+    if err != nil {
+        p.setMessageStatus(ctx, resp.EventID, StatusFailed)
+        return fmt.Errorf("failed to queue message for delivery to Hostex: %w", err)
+    }
+    return nil
+}
+
+// sendAutomatedMessage sends a message generated by an automation to the
+// guest, with the disclosure footer if it's enabled.
+func (p *Portal) sendAutomatedMessage(ctx context.Context, body string) error {
+    return p.sendAutomated(ctx, body, false)
+}
+
+// sendAutomated posts an automated message in the room, marked with
+// AutomatedContentKey, and queues it. Automated messages are held during
+// quiet hours unless they're immediate.
+func (p *Portal) sendAutomated(ctx context.Context, body string, immediate bool) error {
+    if disclosure := p.bridge.Config().AutomationDisclosure; disclosure.Enable {
+        body = strings.TrimRight(body, "\n") + "\n\n" + disclosure.Footer
+    }
+    return p.postAndQueue(ctx, &event.Content{
+        Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: body},
+        Raw:    map[string]interface{}{AutomatedContentKey: true},
+    }, &database.OutboxMessage{Sender: p.bridge.botUserID(), Content: body, Immediate: immediate})
+}
+
+func (b *Bridge) wakeOutbox() {
+    select {
+    case b.outboxWake <- struct{}{}:
+    default:
+    }
+}
+
+func (b *Bridge) startOutbox() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(outboxPollInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.stop:
+            // Deliver what is already due before shutting down
+            b.processOutbox(b.ctx)
+            return
+        case <-ticker.C:
+        case <-b.outboxWake:
+        }
+        b.processOutbox(b.ctx)
+    }
+}
+
+func (b *Bridge) processOutbox(ctx context.Context) {
+    if !b.IsLeader() {
+        return
+    }
+
+    messages, err := b.DB.GetDueOutbox(time.Now(), outboxBatchSize)
+    if err != nil {
+        b.Logger.Error("Failed to get queued messages", zap.Error(err))
+        return
+    }
+
+    quietUntil, quiet := b.inQuietHours()
+    for _, msg := range messages {
+        // Hold automated messages until the quiet hours are over
+        if quiet && msg.Sender == b.botUserID() && !msg.Immediate {
+            err = b.DB.RescheduleOutbox(msg.ID, msg.Attempts, quietUntil, "held during quiet hours")
+            if err != nil {
+                b.Logger.Error("Failed to hold queued message during quiet hours", zap.Error(err))
+            }
+            continue
+        }
+
+        portal, ok := b.getPortalByID(msg.HostexID)
+        if account, _ := splitPortalKey(msg.HostexID); !ok && !b.hasPolled(account) {
+            // The portals are only loaded by the first poll, so wait for it
+            // instead of counting an attempt
+            err = b.DB.RescheduleOutbox(msg.ID, msg.Attempts, time.Now().Add(outboxPollInterval), "waiting for the first poll")
+            if err != nil {
+                b.Logger.Error("Failed to reschedule queued message", zap.Error(err))
+            }
+            continue
+        }
+        if ok {
+            var retrySince time.Time
+            if msg.Attempts > 0 {
+                retrySince = msg.CreatedAt
+            }
+            err = portal.sendToHostex(ctx, msg.MatrixEventID, msg.Sender, msg.Content, retrySince)
+        } else {
+            err = fmt.Errorf("conversation %s isn't bridged", msg.HostexID)
+        }
+        if err == nil {
+            err = b.DB.DeleteOutbox(msg.ID)
+            if err != nil {
+                b.Logger.Error("Failed to remove delivered message from outbox", zap.Error(err))
+            }
+            continue
+        } else if ctx.Err() != nil {
+            // Count the interrupted attempt, so the retry after the restart
+            // checks whether it was delivered anyway
+            err = b.DB.RescheduleOutbox(msg.ID, msg.Attempts+1, time.Now(), "interrupted by shutdown")
+            if err != nil {
+                b.Logger.Error("Failed to persist interrupted message", zap.Error(err))
+            }
+            return
+        } else if errors.Is(err, ErrNotLeader) {
+            // The lease was lost during the batch, so the message wasn't
+            // tried and is left for the new active instance
+            return
+        }
+
+        attempts := msg.Attempts + 1
+        if attempts >= b.Config().Outbox.MaxAttempts {
+            b.Logger.Error("Giving up on queued message", zap.Int64("outbox_id", msg.ID), zap.Int("attempts", attempts), zap.Error(err))
+            deleteErr := b.DB.DeleteOutbox(msg.ID)
+            if deleteErr != nil {
+                b.Logger.Error("Failed to remove failed message from outbox", zap.Error(deleteErr))
+            }
+            if ok {
+                portal.setMessageStatus(ctx, msg.MatrixEventID, StatusFailed)
+                portal.saveDraft(ctx, msg.MatrixEventID, msg.Sender, msg.Content, err)
+            }
+            continue
+        }
+
+        delay := b.Config().Outbox.RetryInterval << (attempts - 1)
+        if delay > maxOutboxBackoff {
+            delay = maxOutboxBackoff
+        }
+        b.Logger.Warn("Failed to deliver queued message, retrying later",
+            zap.Int64("outbox_id", msg.ID),
+            zap.Int("attempts", attempts),
+            zap.Duration("retry_in", delay),
+            zap.Error(err))
+        err = b.DB.RescheduleOutbox(msg.ID, attempts, time.Now().Add(delay), err.Error())
+        if err != nil {
+            b.Logger.Error("Failed to reschedule queued message", zap.Error(err))
+        }
+    }
+}
@@ -0,0 +1,297 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+const (
+    paymentStatusFailed   = "failed"
+    depositStatusHeld     = "held"
+    depositStatusReleased = "released"
+    depositStatusClaimed  = "claimed"
+    payoutStatusPaid      = "paid"
+    // payoutLookback is how far back the watcher looks for payouts, which
+    // covers payouts that are reported a few days late.
+    payoutLookback = 14 * 24 * time.Hour
+)
+
+// paymentNotices returns the notices for the changes of a reservation's
+// payment and security deposit status since the previously seen state.
+func paymentNotices(previous *database.PaymentState, res hostexapi.Reservation) []string {
+    var notices []string
+    stay := fmt.Sprintf("%s at %s (%s), %s to %s, confirmation code %s",
+        res.GuestName, res.PropertyTitle, res.ChannelType, res.CheckInDate, res.CheckOutDate, res.ReservationCode)
+
+    paymentStatus := strings.ToLower(res.PaymentStatus)
+    if paymentStatus == paymentStatusFailed && (previous == nil || previous.PaymentStatus != paymentStatusFailed) {
+        notices = append(notices, fmt.Sprintf("Payment failed: %s", stay))
+    }
+
+    depositStatus := strings.ToLower(res.DepositStatus)
+    if previous != nil && previous.DepositStatus == depositStatus {
+        return notices
+    }
+    switch depositStatus {
+    case depositStatusHeld:
+        notices = append(notices, fmt.Sprintf("Security deposit of %.2f %s held: %s", res.DepositAmount, res.Currency, stay))
+    case depositStatusReleased:
+        notices = append(notices, fmt.Sprintf("Security deposit of %.2f %s released: %s", res.DepositAmount, res.Currency, stay))
+    case depositStatusClaimed:
+        notices = append(notices, fmt.Sprintf("Security deposit of %.2f %s claimed: %s", res.DepositAmount, res.Currency, stay))
+    }
+    return notices
+}
+
+func formatPayout(payout hostexapi.Payout) string {
+    text := fmt.Sprintf("%s: %.2f %s", payout.PayoutDate, payout.Amount, payout.Currency)
+    if payout.GuestName != "" {
+        text += fmt.Sprintf(" for %s at %s", payout.GuestName, payout.PropertyTitle)
+    }
+    if payout.ReservationCode != "" {
+        text += fmt.Sprintf(" (%s)", payout.ReservationCode)
+    }
+    if payout.Status != "" && !strings.EqualFold(payout.Status, payoutStatusPaid) {
+        text += fmt.Sprintf(" [%s]", payout.Status)
+    }
+    return text
+}
+
+// notifyPayment posts a payment notice to the management room and, if the
+// guest's conversation is bridged, to its portal.
+func (b *Bridge) notifyPayment(ctx context.Context, conversationID, notice string) {
+    b.sendManagementNotice(ctx, notice)
+    if portal, ok := b.getPortalByID(conversationID); ok && portal.RoomID != "" {
+        portal.sendNotice(ctx, notice)
+    }
+}
+
+func (b *Bridge) startPaymentWatcher() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(b.Config().PaymentNotices.Interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            b.checkPayments(b.ctx)
+            b.checkPayouts(b.ctx)
+        }
+    }
+}
+
+// checkPayments posts a notice when a reservation's payment fails or its
+// security deposit is held or released. The first check only records the
+// existing states.
+func (b *Bridge) checkPayments(ctx context.Context) {
+    if !b.IsLeader() {
+        return
+    }
+
+    known, err := b.DB.GetPaymentStates()
+    if err != nil {
+        b.Logger.Error("Failed to get known payment states", zap.Error(err))
+        return
+    }
+    for _, account := range b.accountNames() {
+        b.checkAccountPayments(ctx, account, known)
+    }
+}
+
+func (b *Bridge) checkAccountPayments(ctx context.Context, account string, known map[string]*database.PaymentState) {
+    // Deposits are released up to a few weeks after check-out
+    now := time.Now().In(b.location())
+    start := now.AddDate(0, -1, 0).Format(dateLayout)
+    end := now.AddDate(1, 0, 0).Format(dateLayout)
+    reservations, err := b.getAccountReservations(ctx, account, start, end)
+    if errors.Is(err, hostexapi.ErrRateLimited) {
+        b.Logger.Warn("Skipping payment check while rate limited by Hostex", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    } else if err != nil {
+        b.Logger.Error("Failed to get reservations", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    }
+
+    firstCheck, err := b.isFirstAccountCheck("payments", account, len(known))
+    if err != nil {
+        b.Logger.Error("Failed to check if payment states were recorded before", zap.Error(err))
+        return
+    }
+    for _, res := range reservations {
+        previous := known[res.ReservationCode]
+        state := &database.PaymentState{
+            Code:          res.ReservationCode,
+            PaymentStatus: strings.ToLower(res.PaymentStatus),
+            DepositStatus: strings.ToLower(res.DepositStatus),
+        }
+        if previous != nil && previous.PaymentStatus == state.PaymentStatus && previous.DepositStatus == state.DepositStatus {
+            continue
+        }
+        if !firstCheck {
+            for _, notice := range paymentNotices(previous, res) {
+                b.notifyPayment(ctx, res.ConversationID, notice)
+            }
+        }
+        err = b.DB.StorePaymentState(state)
+        if err != nil {
+            b.Logger.Error("Failed to store payment state", zap.String("reservation_code", res.ReservationCode), zap.Error(err))
+        }
+    }
+    if firstCheck {
+        err = b.markAccountChecked("payments", account)
+        if err != nil {
+            b.Logger.Error("Failed to store payment check", zap.Error(err))
+        }
+    }
+}
+
+// checkPayouts posts a notice for every new payout. The first check only
+// records the existing payouts.
+func (b *Bridge) checkPayouts(ctx context.Context) {
+    if !b.IsLeader() {
+        return
+    }
+
+    count, err := b.DB.CountNotifiedPayouts()
+    if err != nil {
+        b.Logger.Error("Failed to count notified payouts", zap.Error(err))
+        return
+    }
+    for _, account := range b.accountNames() {
+        b.checkAccountPayouts(ctx, account, count)
+    }
+}
+
+func (b *Bridge) checkAccountPayouts(ctx context.Context, account string, count int) {
+    firstCheck, err := b.isFirstAccountCheck("payouts", account, count)
+    if err != nil {
+        b.Logger.Error("Failed to check if payouts were recorded before", zap.Error(err))
+        return
+    }
+
+    now := time.Now().In(b.location())
+    payouts, err := b.hostexClient(account).GetPayouts(ctx, now.Add(-payoutLookback).Format(dateLayout), now.Format(dateLayout))
+    if errors.Is(err, hostexapi.ErrRateLimited) {
+        b.Logger.Warn("Skipping payout check while rate limited by Hostex", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    } else if err != nil {
+        b.Logger.Error("Failed to get payouts", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    }
+
+    for _, payout := range payouts {
+        if payout.ID == "" || (payout.Status != "" && !strings.EqualFold(payout.Status, payoutStatusPaid)) {
+            continue
+        }
+        // Payout IDs are only unique within an account
+        payoutID := portalKey(account, payout.ID)
+        notified, err := b.DB.IsPayoutNotified(payoutID)
+        if err != nil {
+            b.Logger.Error("Failed to check payout", zap.String("payout_id", payoutID), zap.Error(err))
+            continue
+        } else if notified {
+            continue
+        }
+        if !firstCheck {
+            var conversationID string
+            if payout.ReservationCode != "" {
+                conversationID, err = b.DB.GetReservationConversation(payout.ReservationCode)
+                if err != nil {
+                    b.Logger.Warn("Failed to get reservation conversation", zap.Error(err))
+                }
+            }
+            b.notifyPayment(ctx, conversationID, "Payout sent: "+formatPayout(payout))
+        }
+        err = b.DB.SetPayoutNotified(payoutID)
+        if err != nil {
+            b.Logger.Error("Failed to store notified payout", zap.String("payout_id", payoutID), zap.Error(err))
+        }
+    }
+    if firstCheck {
+        err = b.markAccountChecked("payouts", account)
+        if err != nil {
+            b.Logger.Error("Failed to store payout check", zap.Error(err))
+        }
+    }
+}
+
+// parsePayoutMonth parses the month of !payouts. Unlike the calendar, a bare
+// month name means the most recent one, as payouts are in the past.
+func parsePayoutMonth(value string, now time.Time) (time.Time, error) {
+    month, err := parseMonth(value, now)
+    if err != nil {
+        return month, err
+    }
+    if _, err := time.Parse("2006-01", value); err != nil && month.After(now) {
+        month = month.AddDate(-1, 0, 0)
+    }
+    return month, nil
+}
+
+func (u *User) listPayouts(ctx context.Context, roomID id.RoomID, args []string) {
+    now := time.Now().In(u.bridge.location())
+    var value string
+    if len(args) > 0 {
+        value = args[0]
+    }
+    month, err := parsePayoutMonth(value, now)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Usage: !payouts [month]\n%v", err))
+        return
+    }
+
+    var payouts []hostexapi.Payout
+    for _, account := range u.bridge.accountNames() {
+        accountPayouts, err := u.bridge.hostexClient(account).GetPayouts(ctx, month.Format(dateLayout), month.AddDate(0, 1, -1).Format(dateLayout))
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get payouts of the %s account: %v", accountLabel(account), err))
+            return
+        }
+        payouts = append(payouts, accountPayouts...)
+    }
+    title := month.Format("January 2006")
+    if len(payouts) == 0 {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No payouts in %s.", title))
+        return
+    }
+
+    sort.Slice(payouts, func(i, j int) bool {
+        return payouts[i].PayoutDate < payouts[j].PayoutDate
+    })
+    totals := make(map[string]float64)
+    var lines []string
+    for _, payout := range payouts {
+        lines = append(lines, formatPayout(payout))
+        if payout.Status == "" || strings.EqualFold(payout.Status, payoutStatusPaid) {
+            totals[payout.Currency] += payout.Amount
+        }
+    }
+    currencies := make([]string, 0, len(totals))
+    for currency := range totals {
+        currencies = append(currencies, currency)
+    }
+    sort.Strings(currencies)
+    var totalParts []string
+    for _, currency := range currencies {
+        totalParts = append(totalParts, fmt.Sprintf("%.2f %s", totals[currency], currency))
+    }
+    if len(totalParts) == 0 {
+        totalParts = append(totalParts, "nothing paid yet")
+    }
+
+    u.sendNotice(ctx, roomID, fmt.Sprintf("Payouts in %s (%d):\n%s\n\nTotal paid: %s",
+        title, len(payouts), strings.Join(lines, "\n"), strings.Join(totalParts, ", ")))
+}
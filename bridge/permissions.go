@@ -0,0 +1,88 @@
+package bridge
+
+import (
+    "context"
+    "strings"
+
+    "maunium.net/go/mautrix/id"
+)
+
+// PermissionLevel is what a Matrix user may do with the bridge.
+type PermissionLevel int
+
+const (
+    PermissionNone PermissionLevel = iota
+    // PermissionUser may send messages to guests from portal rooms.
+    PermissionUser
+    // PermissionAdmin may also run commands and is invited to the management
+    // rooms.
+    PermissionAdmin
+    // PermissionOwner may also change the bridge setup and delete portals.
+    PermissionOwner
+)
+
+var permissionLevels = map[string]PermissionLevel{
+    "user":  PermissionUser,
+    "admin": PermissionAdmin,
+    "owner": PermissionOwner,
+}
+
+// ownerCommands are the management and portal commands that need
+// PermissionOwner.
+var ownerCommands = map[string]bool{
+    "!setup":         true,
+    "!config":        true,
+    "!delete-portal": true,
+    "!purge-guest":   true,
+    "!block":         true,
+    "!unblock":       true,
+}
+
+// permissionLevel returns the highest level granted to the user, directly,
+// through their homeserver or "*", or to everyone in the room. The configured
+// admin is always an owner. Without any permissions configured, everyone may
+// send messages in portal rooms, like before permissions existed.
+func (b *Bridge) permissionLevel(userID id.UserID, roomID id.RoomID) PermissionLevel {
+    if userID == b.Config().Admin.UserID {
+        return PermissionOwner
+    }
+    permissions := b.Config().Permissions
+    if len(permissions) == 0 {
+        return PermissionUser
+    }
+
+    level := PermissionNone
+    for _, key := range []string{userID.String(), roomID.String(), userID.Homeserver(), "*"} {
+        if granted := permissionLevels[permissions[key]]; key != "" && granted > level {
+            level = granted
+        }
+    }
+    return level
+}
+
+// checkOwnerCommand returns whether a user with the given level may run the
+// command, telling them in the room if not. It's used for both management
+// and portal commands.
+func (b *Bridge) checkOwnerCommand(ctx context.Context, roomID id.RoomID, command string, level PermissionLevel) bool {
+    command = strings.ToLower(command)
+    if ownerCommands[command] && level < PermissionOwner {
+        b.sendNotice(ctx, roomID, "Only bridge owners can run "+command)
+        return false
+    }
+    return true
+}
+
+// managementInvites returns the users invited to the management rooms: the
+// admin and every user with admin or owner permissions.
+func (b *Bridge) managementInvites() []id.UserID {
+    invites := []id.UserID{b.Config().Admin.UserID}
+    for key, level := range b.Config().Permissions {
+        userID := id.UserID(key)
+        if permissionLevels[level] >= PermissionAdmin && userID != b.Config().Admin.UserID {
+            if _, _, err := userID.Parse(); err == nil {
+                invites = append(invites, userID)
+            }
+        }
+    }
+    return invites
+}
@@ -0,0 +1,91 @@
+package bridge
+
+import (
+    "fmt"
+    "time"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+// pollHistoryRetention is how long poll results are kept for !status.
+const pollHistoryRetention = 7 * 24 * time.Hour
+
+func (b *Bridge) recordPoll(pollErr error) {
+    record := &database.PollRecord{
+        Timestamp: time.Now(),
+        Success:   pollErr == nil,
+    }
+    if pollErr != nil {
+        record.Error = pollErr.Error()
+    }
+    err := b.DB.RecordPoll(record)
+    if err != nil {
+        b.Logger.Error("Failed to record poll result", zap.Error(err))
+        return
+    }
+    err = b.DB.PrunePollHistory(record.Timestamp.Add(-pollHistoryRetention))
+    if err != nil {
+        b.Logger.Error("Failed to prune poll history", zap.Error(err))
+    }
+}
+
+func successRate(records []*database.PollRecord, since time.Time) string {
+    var total, successful int
+    for _, record := range records {
+        if record.Timestamp.Before(since) {
+            continue
+        }
+        total++
+        if record.Success {
+            successful++
+        }
+    }
+    if total == 0 {
+        return "no polls"
+    }
+    return fmt.Sprintf("%.1f%% of %d polls", float64(successful)*100/float64(total), total)
+}
+
+// pollReliability summarizes the poll history for !status: success rates
+// over the last day and week, the longest time without a successful poll and
+// the last error.
+func (b *Bridge) pollReliability() string {
+    now := time.Now()
+    records, err := b.DB.GetPollHistory(now.Add(-pollHistoryRetention))
+    if err != nil {
+        b.Logger.Error("Failed to get poll history", zap.Error(err))
+        return fmt.Sprintf("Poll history: unavailable (%v)", err)
+    }
+
+    var longestGap time.Duration
+    var lastSuccess time.Time
+    var lastError *database.PollRecord
+    for _, record := range records {
+        if !record.Success {
+            lastError = record
+            continue
+        }
+        if !lastSuccess.IsZero() && record.Timestamp.Sub(lastSuccess) > longestGap {
+            longestGap = record.Timestamp.Sub(lastSuccess)
+        }
+        lastSuccess = record.Timestamp
+    }
+    if !lastSuccess.IsZero() && now.Sub(lastSuccess) > longestGap {
+        longestGap = now.Sub(lastSuccess)
+    }
+
+    lastErrorText := "none"
+    if lastError != nil {
+        lastErrorText = fmt.Sprintf("%s (%s)", lastError.Error, lastError.Timestamp.In(b.location()).Format(time.RFC3339))
+    }
+    return fmt.Sprintf(`Poll success (24h): %s
+Poll success (7d): %s
+Longest gap between successful polls (7d): %s
+Last poll error: %s`,
+        successRate(records, now.Add(-24*time.Hour)),
+        successRate(records, now.Add(-pollHistoryRetention)),
+        longestGap.Round(time.Second),
+        lastErrorText)
+}
@@ -0,0 +1,82 @@
+package bridge
+
+import (
+    "time"
+)
+
+// pollInterval returns how long to wait before the next Hostex poll. With
+// adaptive polling enabled, the interval tightens while conversations are
+// active and relaxes overnight or after a long idle period.
+func (b *Bridge) pollInterval() time.Duration {
+    adaptive := b.Config().AdaptivePolling
+    if !adaptive.Enable {
+        return b.Config().PollInterval
+    }
+
+    now := time.Now()
+    sinceActivity := now.Sub(time.Unix(0, b.lastActivity.Load()))
+    switch {
+    case sinceActivity < adaptive.ActiveWindow:
+        return adaptive.MinInterval
+    case isNight(now.In(b.location()), adaptive.NightStart, adaptive.NightEnd), sinceActivity > adaptive.IdleAfter:
+        return adaptive.MaxInterval
+    default:
+        return b.Config().PollInterval
+    }
+}
+
+// isNight reports whether the time of day falls between start and end, which
+// are HH:MM strings. The window may wrap around midnight.
+func isNight(now time.Time, start, end string) bool {
+    clock := now.Format("15:04")
+    if start <= end {
+        return clock >= start && clock < end
+    }
+    return clock >= start || clock < end
+}
+
+// SettingCheckedPrefix is the prefix of the settings that record when a
+// watcher first recorded the existing Hostex data, e.g.
+// "watcher.checked.reservations".
+const SettingCheckedPrefix = "watcher.checked."
+
+// isFirstCheck reports whether a watcher hasn't recorded the existing Hostex
+// data yet, so its first check shouldn't post notices. That's remembered in
+// a setting rather than inferred from empty tables, so the first booking or
+// review of a fresh install isn't taken for existing data. Databases from
+// before the setting that already have known rows count as checked.
+func (b *Bridge) isFirstCheck(watcher string, known int) (bool, error) {
+    checkedAt, err := b.DB.GetSetting(SettingCheckedPrefix + watcher)
+    if err != nil {
+        return false, err
+    } else if checkedAt != "" {
+        return false, nil
+    } else if known > 0 {
+        return false, b.markChecked(watcher)
+    }
+    return true, nil
+}
+
+// isFirstAccountCheck is isFirstCheck for the watcher's check of an account.
+// Additional accounts have their own setting, so the existing data of an
+// account that's added later isn't posted either.
+func (b *Bridge) isFirstAccountCheck(watcher, account string, known int) (bool, error) {
+    if account != "" {
+        return b.isFirstCheck(watcher+"."+account, 0)
+    }
+    return b.isFirstCheck(watcher, known)
+}
+
+// markAccountChecked records that the watcher's first check of an account
+// is done.
+func (b *Bridge) markAccountChecked(watcher, account string) error {
+    if account != "" {
+        watcher += "." + account
+    }
+    return b.markChecked(watcher)
+}
+
+// markChecked records that the watcher's first check is done.
+func (b *Bridge) markChecked(watcher string) error {
+    return b.DB.SetSetting(SettingCheckedPrefix+watcher, time.Now().UTC().Format(time.RFC3339))
+}
@@ -3,13 +3,19 @@ package bridge
 import (
     "context"
     "fmt"
+    "slices"
+    "strings"
+    "sync"
     "time"
 
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
     "maunium.net/go/mautrix"
     "maunium.net/go/mautrix/event"
     "maunium.net/go/mautrix/id"
     "go.uber.org/zap"
 
+    "github.com/keithah/hostex-bridge-go/database"
     "github.com/keithah/hostex-bridge-go/hostexapi"
 )
 
@@ -18,69 +24,318 @@ type Portal struct {
     ID     string
     RoomID id.RoomID
 
-    Info hostexapi.Conversation
+    Info      hostexapi.Conversation
+    Checklist map[string]time.Time
+
+    echoes        *echoTracker
+    lastMessageAt time.Time
+    name          string
+    topic         string
+    avatar        string
+    avatarRetry   avatarRetry
+    archived      bool
+    blocked       bool
+    // guestID is the guest the conversation is linked to, 0 until it's
+    // loaded or linked.
+    guestID int64
+    ghost         *ghost
+    // guestStateHash and reservationStateHash are the hashes of the custom
+    // state last sent to the room.
+    guestStateHash       string
+    reservationStateHash string
+
+    statusLock      sync.Mutex
+    statusReactions map[id.EventID]id.EventID
+
+    suggestions []string
+    // aiSuggestion is the AI suggested reply waiting for approval.
+    aiSuggestion     *aiSuggestion
+    aiSuggestionLock sync.Mutex
+    // language is the language the guest's messages were last detected
+    // to be in.
+    language string
+    // assignee is the user on duty when the guest last wrote without a
+    // reply yet
+    assignee     id.UserID
+    assigneeLock sync.Mutex
 }
 
-func NewPortal(bridge *Bridge, id string) *Portal {
+func NewPortal(bridge *Bridge, hostexID string) *Portal {
     return &Portal{
         bridge: bridge,
-        ID:     id,
+        ID:     hostexID,
+        echoes: newEchoTracker(),
+
+        statusReactions: make(map[id.EventID]id.EventID),
     }
 }
 
-func (p *Portal) UpdateInfo(info hostexapi.Conversation) {
+// roomName returns the name of the portal room, rendered from the room name
+// template.
+func (p *Portal) roomName() string {
+    return p.renderName(p.bridge.names.roomName, fmt.Sprintf("%s - %s", p.Info.ChannelType, p.Info.Guest.Name))
+}
+
+func (p *Portal) UpdateInfo(ctx context.Context, info hostexapi.Conversation) {
+    previous := p.Info
     p.Info = info
+    if previous.ID != "" {
+        p.checkConversion(ctx, previous)
+    }
+    if previous.ID == "" || previous.Guest.Email != info.Guest.Email || previous.Guest.Phone != info.Guest.Phone {
+        p.linkGuest()
+    }
+    if p.RoomID == "" {
+        return
+    }
+    p.updateRoomName(ctx)
+    p.updateTopic(ctx)
+    p.updateAvatar(ctx)
+    p.updateGuestState(ctx)
+    p.updateGhostProfile(ctx)
+    if previous.ID == "" || !slices.Equal(previous.Tags, info.Tags) {
+        p.syncRoomTags(ctx)
+    }
+    stayChanged := previous.ID != "" && (previous.CheckInDate != info.CheckInDate || previous.CheckOutDate != info.CheckOutDate || previous.ReservationStatus != info.ReservationStatus)
+    if stayChanged || (previous.ID == "" && p.reservationStateHash == "") {
+        p.refreshReservationState(ctx)
+    }
+    if stayChanged {
+        p.postSummary(ctx)
+    }
 }
 
-func (p *Portal) CreateMatrixRoom() error {
+// roomTopic returns the topic of the portal room, which shows the property,
+// the stay dates and the reservation status.
+func (p *Portal) roomTopic() string {
+    parts := []string{p.Info.PropertyTitle}
+    if p.Info.CheckInDate != "" || p.Info.CheckOutDate != "" {
+        parts = append(parts, fmt.Sprintf("%s to %s", p.Info.CheckInDate, p.Info.CheckOutDate))
+    }
+    if p.Info.ReservationStatus != "" {
+        parts = append(parts, reservationStage(p.Info.ReservationStatus))
+    }
+    return strings.Join(parts, " · ")
+}
+
+// updateRoomName renames the room if the guest's details changed since the
+// name was last set, keeping the archive prefix of archived rooms.
+func (p *Portal) updateRoomName(ctx context.Context) {
+    name := p.roomName()
+    if name == p.name {
+        return
+    }
+    displayName := name
+    if p.archived {
+        displayName = p.bridge.Config().PortalArchive.Prefix + name
+    }
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, event.StateRoomName, "", &event.RoomNameEventContent{Name: displayName})
+    if err != nil {
+        p.bridge.Logger.Error("Failed to update portal name", zap.String("room_id", p.RoomID.String()), zap.Error(err))
+        return
+    }
+    err = p.bridge.DB.SetPortalName(p.ID, name)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store portal name", zap.Error(err))
+    }
+    p.name = name
+}
+
+// updateTopic sets the room topic if the stay details changed since it was
+// last set.
+func (p *Portal) updateTopic(ctx context.Context) {
+    topic := p.roomTopic()
+    if topic == p.topic {
+        return
+    }
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, event.StateTopic, "", &event.TopicEventContent{Topic: topic})
+    if err != nil {
+        p.bridge.Logger.Error("Failed to update portal topic", zap.String("room_id", p.RoomID.String()), zap.Error(err))
+        return
+    }
+    err = p.bridge.DB.SetPortalTopic(p.ID, topic)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store portal topic", zap.Error(err))
+    }
+    p.topic = topic
+}
+
+func (p *Portal) loadLastMessageAt() error {
+    if p.bridge.Redis != nil {
+        state, err := p.bridge.Redis.GetPortalState(p.ID)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to get cached portal state", zap.Error(err))
+        } else if state != nil && !state.LastMessageAt.IsZero() {
+            p.lastMessageAt = state.LastMessageAt
+            return nil
+        }
+    }
+
+    lastMessageAt, err := p.bridge.DB.GetPortalLastMessageAt(p.ID)
+    if err != nil {
+        return fmt.Errorf("failed to get last message time: %w", err)
+    }
+    p.lastMessageAt = lastMessageAt
+    return nil
+}
+
+func (p *Portal) setLastMessageAt(lastMessageAt time.Time) error {
+    if lastMessageAt.IsZero() {
+        return nil
+    }
+    err := p.bridge.DB.SetPortalLastMessageAt(p.ID, lastMessageAt)
+    if err != nil {
+        return err
+    }
+    p.lastMessageAt = lastMessageAt
+    p.cacheState()
+    return nil
+}
+
+func (p *Portal) CreateMatrixRoom(ctx context.Context) error {
     if p.RoomID != "" {
         return nil
     }
 
-    existingRoomID, err := p.bridge.DB.GetPortal(p.ID)
+    if p.Checklist == nil {
+        err := p.loadChecklist()
+        if err != nil {
+            return err
+        }
+    }
+
+    existingRoomID, err := p.getCachedRoomID()
     if err != nil {
         return fmt.Errorf("failed to check existing portal: %w", err)
     }
 
     if existingRoomID != "" {
         p.RoomID = existingRoomID
+        p.name, err = p.bridge.DB.GetPortalName(p.ID)
+        if err != nil {
+            return fmt.Errorf("failed to get portal name: %w", err)
+        }
+        p.topic, err = p.bridge.DB.GetPortalTopic(p.ID)
+        if err != nil {
+            return fmt.Errorf("failed to get portal topic: %w", err)
+        }
+        p.avatar, err = p.bridge.DB.GetPortalAvatar(p.ID)
+        if err != nil {
+            return fmt.Errorf("failed to get portal avatar: %w", err)
+        }
+        p.guestStateHash, err = p.bridge.DB.GetPortalGuestStateHash(p.ID)
+        if err != nil {
+            return fmt.Errorf("failed to get portal guest state: %w", err)
+        }
+        p.reservationStateHash, err = p.bridge.DB.GetPortalReservationStateHash(p.ID)
+        if err != nil {
+            return fmt.Errorf("failed to get portal reservation state: %w", err)
+        }
+        p.language, err = p.bridge.DB.GetPortalLanguage(p.ID)
+        if err != nil {
+            return fmt.Errorf("failed to get portal language: %w", err)
+        }
+        p.updateRoomName(ctx)
+        p.updateTopic(ctx)
+        p.updateAvatar(ctx)
+        p.updateGuestState(ctx)
+        p.updateBridgeInfo(ctx, false)
         return nil
     }
 
+    bridgeInfo := p.bridgeInfo()
+    guestState := p.guestState()
     createRoom := &mautrix.ReqCreateRoom{
         Visibility: "private",
-        Name:       fmt.Sprintf("%s - %s", p.Info.ChannelType, p.Info.Guest.Name),
-        Topic:      fmt.Sprintf("Hostex conversation for %s", p.Info.PropertyTitle),
+        Name:       p.roomName(),
+        Topic:      p.roomTopic(),
+        InitialState: []*event.Event{
+            {Type: event.StateBridge, StateKey: &bridgeInfo.stateKey, Content: event.Content{Parsed: bridgeInfo.content}},
+            {Type: event.StateHalfShotBridge, StateKey: &bridgeInfo.stateKey, Content: event.Content{Parsed: bridgeInfo.content}},
+            {Type: StateHostexGuest, StateKey: &emptyStateKey, Content: event.Content{Parsed: guestState}},
+        },
+    }
+    if p.bridge.batchSend {
+        createRoom.RoomVersion = batchSendRoomVersion
     }
 
-    ctx := context.Background()
     resp, err := p.bridge.MatrixClient.CreateRoom(ctx, createRoom)
     if err != nil {
         return fmt.Errorf("failed to create Matrix room: %w", err)
     }
 
     p.RoomID = resp.RoomID
+    p.name = createRoom.Name
+    p.topic = createRoom.Topic
+    if p.archived {
+        // The previous room was left when the conversation was archived
+        p.archived = false
+        err = p.bridge.DB.SetPortalArchived(p.ID, false)
+        if err != nil {
+            p.bridge.Logger.Error("Failed to unarchive portal", zap.Error(err))
+        }
+    }
     p.bridge.Logger.Info("Created Matrix room", zap.String("room_id", p.RoomID.String()))
 
     err = p.bridge.DB.StorePortal(p.ID, p.RoomID, createRoom.Name, createRoom.Topic, "", false)
     if err != nil {
         return fmt.Errorf("failed to store portal in database: %w", err)
     }
+    p.cacheState()
+    p.updateAvatar(ctx)
+    err = p.bridge.DB.SetPortalBridgeInfoVersion(p.ID, bridgeInfoVersion)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store bridge info version", zap.Error(err))
+    }
+    p.setGuestStateHash(stateHash(guestState))
+    p.refreshReservationState(ctx)
+    p.syncRoomTags(ctx)
 
-    if p.bridge.Config.PersonalSpaceEnable {
-        err = p.addToPersonalSpace()
+    if p.bridge.Config().PersonalSpaceEnable {
+        err = p.addToPersonalSpace(ctx)
         if err != nil {
             p.bridge.Logger.Error("Failed to add room to personal space", zap.Error(err))
         }
     }
 
+    p.sendWelcomeCard(ctx)
+    p.postSummary(ctx)
+
     return nil
 }
 
-func (p *Portal) addToPersonalSpace() error {
-    ctx := context.Background()
-    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.bridge.spaceRoom, event.StateSpaceChild, p.RoomID.String(), &event.SpaceChildEventContent{
-        Via: []string{p.bridge.Config.Homeserver.Domain},
+func (p *Portal) sendWelcomeCard(ctx context.Context) {
+    p.sendNotice(ctx, fmt.Sprintf(`Guest: %s (%s)
+Property: %s
+Stay: %s to %s
+
+Checklist:
+%s
+Use !check <item> and !uncheck <item> to update the checklist.`,
+        p.Info.Guest.Name,
+        p.Info.ChannelType,
+        p.Info.PropertyTitle,
+        p.Info.CheckInDate,
+        p.Info.CheckOutDate,
+        p.formatChecklist()))
+}
+
+// personalSpace returns the space the portal room is filed under, which is
+// its property's space if property spaces are enabled.
+func (p *Portal) personalSpace(ctx context.Context) (id.RoomID, error) {
+    if p.bridge.Config().PropertySpaces && p.Info.PropertyTitle != "" {
+        return p.bridge.getPropertySpace(ctx, p.Info.PropertyTitle)
+    }
+    return p.bridge.spaceRoom, nil
+}
+
+func (p *Portal) addToPersonalSpace(ctx context.Context) error {
+    spaceRoom, err := p.personalSpace(ctx)
+    if err != nil {
+        return err
+    }
+    _, err = p.bridge.MatrixClient.SendStateEvent(ctx, spaceRoom, event.StateSpaceChild, p.RoomID.String(), &event.SpaceChildEventContent{
+        Via: []string{p.bridge.Config().Homeserver.Domain},
     })
     if err != nil {
         return fmt.Errorf("failed to add room to personal space: %w", err)
@@ -99,60 +354,684 @@ func (p *Portal) HandleMatrixMessage(evt *event.Event) {
         return
     }
 
-    // Send message to Hostex
-    err := p.bridge.HostexClient.SendMessage(p.ID, content.Body)
-    if err != nil {
-        p.bridge.Logger.Error("Failed to send message to Hostex", zap.Error(err))
+    if p.bridge.isPortalCommand(content.Body) {
+        p.HandleCommand(evt.Sender, content.Body)
         return
     }
+    if p.bridge.permissionLevel(evt.Sender, evt.RoomID) < PermissionUser {
+        p.bridge.Logger.Warn("Ignoring message from user without permission to relay", zap.String("sender", evt.Sender.String()))
+        return
+    }
+
+    err := p.queueMessage(evt.ID, evt.Sender, content.Body)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to queue message for Hostex", zap.Error(err))
+        p.setMessageStatus(p.bridge.ctx, evt.ID, StatusFailed)
+        p.sendNotice(p.bridge.ctx, fmt.Sprintf("Failed to queue your message for delivery to Hostex: %v", err))
+    }
+}
+
+// sendToHostex delivers a message to the guest. Retries of a message that
+// was already attempted pass the time of the first attempt, so a delivery
+// whose response was lost isn't sent twice.
+func (p *Portal) sendToHostex(ctx context.Context, eventID id.EventID, sender id.UserID, body string, retrySince time.Time) (err error) {
+    if !p.bridge.IsLeader() {
+        return ErrNotLeader
+    }
+    ctx, span := tracer.Start(ctx, "sendToHostex", trace.WithAttributes(
+        attribute.String("hostex.portal_id", p.ID),
+        attribute.String("matrix.event_id", eventID.String()),
+    ))
+    defer func() { endSpan(span, err) }()
+
+    body = p.bridge.attribution(sender) + body
+    var messageID string
+    if !retrySince.IsZero() {
+        messageID = p.findDeliveredMessage(ctx, body, retrySince)
+    }
+
+    // Send message to Hostex, keyed by the Matrix event so the API can
+    // dedupe retries itself
+    if messageID == "" {
+        messageID, err = p.client().SendMessage(hostexapi.WithIdempotencyKey(ctx, eventID.String()), p.conversationID(), body)
+        if err != nil {
+            return err
+        }
+    } else {
+        p.bridge.Logger.Info("Message was already delivered by an earlier attempt",
+            zap.String("event_id", eventID.String()),
+            zap.String("message_id", messageID))
+    }
+    p.echoes.Add(messageID, body)
+    p.setMessageStatus(ctx, eventID, StatusSent)
 
     // Store message in database
-    err = p.bridge.DB.StoreMessage(p.ID, evt.ID, time.Now(), evt.Sender.String(), content.Body)
+    err = p.bridge.DB.StoreMessage(p.ID, eventID, messageID, time.Now(), sender.String(), body)
     if err != nil {
         p.bridge.Logger.Error("Failed to store message in database", zap.Error(err))
     }
+    return nil
+}
+
+// findDeliveredMessage returns the ID of a host message with the given body
+// sent since the given time, or an empty string if there is none.
+func (p *Portal) findDeliveredMessage(ctx context.Context, body string, since time.Time) string {
+    since = since.Add(-deliveryClockSkew)
+    messages, err := p.client().GetMessages(ctx, p.conversationID(), since, 50)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to check for an earlier delivery", zap.String("portal_id", p.ID), zap.Error(err))
+        return ""
+    }
+    for _, msg := range messages {
+        if strings.EqualFold(msg.Sender, "host") && msg.Content == body && !msg.Timestamp.Before(since) {
+            return msg.ID
+        }
+    }
+    return ""
+}
+
+// portalCommands are the commands handled in portal rooms. Other messages
+// starting with ! are relayed to the guest like any other message.
+var portalCommands = map[string]bool{
+    "!check":           true,
+    "!uncheck":         true,
+    "!drafts":          true,
+    "!send-draft":      true,
+    "!email":           true,
+    "!info":            true,
+    "!contact":         true,
+    "!reply":           true,
+    "!template":        true,
+    "!schedule":        true,
+    "!sendcode":        true,
+    "!upsells":         true,
+    "!tag":             true,
+    "!done":            true,
+    "!block":           true,
+    "!unblock":         true,
+    "!guest":           true,
+    "!send-suggestion": true,
+    "!preapprove":      true,
+    "!specialoffer":    true,
+}
+
+// isPortalCommand reports whether a portal room message is a built-in
+// command or one with a command webhook.
+func (b *Bridge) isPortalCommand(body string) bool {
+    fields := strings.Fields(body)
+    if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+        return false
+    }
+    command := strings.ToLower(fields[0])
+    return portalCommands[command] || b.commandHookURL(command) != ""
+}
+
+func (p *Portal) HandleCommand(sender id.UserID, body string) {
+    level := p.bridge.permissionLevel(sender, p.RoomID)
+    if level < PermissionAdmin {
+        p.bridge.Logger.Warn("Unauthorized portal command", zap.String("sender", sender.String()))
+        return
+    }
+
+    parts := strings.Fields(body)
+    command := strings.ToLower(parts[0])
+    args := parts[1:]
+
+    ctx := p.bridge.ctx
+    if !p.bridge.checkOwnerCommand(ctx, p.RoomID, command, level) {
+        return
+    }
+
+    switch command {
+    case "!check":
+        p.handleCheckCommand(ctx, args, true)
+    case "!uncheck":
+        p.handleCheckCommand(ctx, args, false)
+    case "!drafts":
+        p.sendNotice(ctx, p.bridge.listDrafts(p.ID))
+    case "!send-draft":
+        p.sendNotice(ctx, p.bridge.sendDraftCommand(ctx, p.ID, args))
+    case "!email":
+        p.emailGuest(ctx, args)
+    case "!info":
+        p.showReservation(ctx)
+    case "!contact":
+        p.sendContactLinks(ctx)
+    case "!reply":
+        p.sendSuggestedReply(ctx, sender, args)
+    case "!template":
+        p.handleTemplateCommand(ctx, sender, args)
+    case "!schedule":
+        p.scheduleCommand(ctx, sender, args, body)
+    case "!sendcode":
+        p.sendNotice(ctx, p.sendCheckInInstructions(ctx, sender))
+    case "!upsells":
+        p.sendNotice(ctx, p.bridge.listUpsells(p.ID))
+    case "!tag":
+        p.tagCommand(ctx, args)
+    case "!done":
+        p.markDone(ctx, sender)
+    case "!block":
+        p.sendNotice(ctx, p.block(ctx, sender, commandText(body, 1)))
+    case "!unblock":
+        p.sendNotice(ctx, p.unblock(ctx, sender))
+    case "!guest":
+        p.guestCommand(ctx, args, body)
+    case "!send-suggestion":
+        p.sendAISuggestion(ctx, sender, "")
+    case "!preapprove":
+        p.preapprove(ctx)
+    case "!specialoffer":
+        p.sendSpecialOffer(ctx, args)
+    default:
+        info := p.Info
+        if p.bridge.runCommandHook(p.RoomID, sender, command, args, &info) {
+            return
+        }
+        p.sendNotice(ctx, "Unknown command. Available commands in portal rooms: !info, !contact, !reply, !template, !sendcode, !upsells, !tag, !done, !block, !unblock, !guest, !schedule, !send-suggestion, !preapprove, !specialoffer, !check, !uncheck, !drafts, !send-draft, !email")
+    }
 }
 
-func (p *Portal) BackfillMessages() error {
+// BackfillMessages bridges the messages that haven't been bridged yet. A new
+// portal first gets the configured initial history, which resumes after the
+// last bridged page if it was interrupted.
+func (p *Portal) BackfillMessages(ctx context.Context) error {
+    state, err := p.bridge.DB.GetBackfillState(p.ID)
+    if err != nil {
+        return fmt.Errorf("failed to get backfill state: %w", err)
+    }
     lastTimestamp, err := p.bridge.DB.GetLastMessageTimestamp(p.ID)
     if err != nil {
         return fmt.Errorf("failed to get last message timestamp: %w", err)
     }
+    if state == nil && !lastTimestamp.IsZero() {
+        // The portal was backfilled before the state was tracked
+        state = &database.BackfillState{HostexID: p.ID, Complete: true}
+        err = p.bridge.DB.SetBackfillState(state)
+        if err != nil {
+            return fmt.Errorf("failed to store backfill state: %w", err)
+        }
+    }
+    if state != nil && state.Complete {
+        return p.backfillSince(ctx, lastTimestamp)
+    }
+    return p.initialBackfill(ctx, state)
+}
+
+// initialBackfill bridges the history of a new portal, limited by the
+// backfill config, or continues an interrupted initial backfill.
+func (p *Portal) initialBackfill(ctx context.Context, state *database.BackfillState) error {
+    var since time.Time
+    var limit int
+    if state != nil && !state.Cursor.IsZero() {
+        since = state.Cursor
+    } else {
+        state = &database.BackfillState{HostexID: p.ID}
+        if age := p.bridge.Config().Backfill.InitialAge; age > 0 {
+            since = time.Now().Add(-age)
+        }
+        if n := p.bridge.Config().Backfill.InitialMessages; n > 0 {
+            limit = n
+        }
+    }
 
-    messages, err := p.bridge.HostexClient.GetMessages(p.ID, lastTimestamp, 10)
+    err := p.backfill(ctx, backfillParams{
+        since:      since,
+        limit:      limit,
+        historical: true,
+        progress: func(cursor time.Time) error {
+            state.Cursor = cursor
+            return p.bridge.DB.SetBackfillState(state)
+        },
+    })
     if err != nil {
-        return fmt.Errorf("failed to get messages from Hostex: %w", err)
+        return err
     }
+    state.Complete = true
+    err = p.bridge.DB.SetBackfillState(state)
+    if err != nil {
+        return fmt.Errorf("failed to store backfill state: %w", err)
+    }
+    return nil
+}
 
-    for _, msg := range messages {
-        err = p.SendMessage(msg)
+// messageBatchSize is how many bridged messages are stored per transaction
+// when backfilling or importing.
+const messageBatchSize = 100
+
+// backfillSince bridges the messages since the given time that haven't been
+// bridged yet.
+func (p *Portal) backfillSince(ctx context.Context, since time.Time) error {
+    return p.backfill(ctx, backfillParams{since: since})
+}
+
+// backfillParams selects the messages a backfill bridges and how.
+type backfillParams struct {
+    // since and until limit the messages by time, a zero until for none.
+    since time.Time
+    until time.Time
+    // limit keeps only the newest messages if it isn't 0.
+    limit int
+    // historical messages are batch sent if that's enabled.
+    historical bool
+    // progress is called with the time of the newest message of every
+    // page once the page is stored.
+    progress func(cursor time.Time) error
+}
+
+// backfill bridges the messages selected by params that haven't been bridged
+// yet.
+func (p *Portal) backfill(ctx context.Context, params backfillParams) (err error) {
+    ctx, span := tracer.Start(ctx, "BackfillMessages", trace.WithAttributes(attribute.String("hostex.portal_id", p.ID)))
+    defer func() { endSpan(span, err) }()
+
+    batch := p.bridge.DB.NewMessageBatch(messageBatchSize)
+    defer func() {
+        flushErr := batch.Flush()
+        if flushErr != nil {
+            p.bridge.Logger.Error("Failed to store backfilled messages", zap.Error(flushErr))
+        }
+    }()
+
+    var lastGuestMessage string
+    var lastGuestTime, newest time.Time
+    var lastGuestEventID id.EventID
+    var total int
+    // unbridged returns the messages that aren't suppressed, echoes or
+    // already bridged
+    unbridged := func(messages []hostexapi.Message) ([]hostexapi.Message, error) {
+        var pending []hostexapi.Message
+        for _, msg := range messages {
+            if category := p.bridge.suppressionCategory(msg); category != "" {
+                p.suppress(msg, category)
+                continue
+            } else if p.blocked && !isHostSender(msg.Sender) {
+                p.suppress(msg, suppressionBlocked)
+                continue
+            }
+
+            if p.bridge.Config().Invariants.Enable {
+                p.bridge.invariants.seen(p, msg)
+            }
+
+            if p.echoes.IsEcho(msg) {
+                p.bridge.Logger.Debug("Skipping echo of message sent from Matrix", zap.String("message_id", msg.ID))
+                continue
+            }
+
+            if msg.ID != "" {
+                exists, err := batch.HasMessage(msg.ID)
+                if err != nil {
+                    return nil, fmt.Errorf("failed to check for existing message: %w", err)
+                }
+                if exists {
+                    continue
+                }
+            }
+            pending = append(pending, msg)
+        }
+        return pending, nil
+    }
+    // store adds the messages that were bridged as the given events to the
+    // batch
+    store := func(messages []hostexapi.Message, eventIDs []id.EventID) {
+        for i, msg := range messages {
+            err := batch.Add(&database.Message{
+                HostexID:        p.ID,
+                MatrixEventID:   eventIDs[i],
+                HostexMessageID: msg.ID,
+                Timestamp:       msg.Timestamp,
+                Sender:          msg.Sender,
+                Content:         msg.Content,
+            })
+            if err != nil {
+                p.bridge.Logger.Error("Failed to store backfilled messages", zap.Error(err))
+            }
+            if p.bridge.recovery != nil {
+                p.bridge.recovery.messages++
+            }
+            // Historical batches are stored newest first
+            if msg.Timestamp.Before(newest) {
+                continue
+            }
+            newest = msg.Timestamp
+            if isHostSender(msg.Sender) {
+                lastGuestMessage = ""
+                p.setAssignee("")
+                p.clearAISuggestion()
+            } else {
+                lastGuestMessage = msg.Content
+                lastGuestTime = msg.Timestamp
+                lastGuestEventID = eventIDs[i]
+                p.setAssignee(p.bridge.alertRecipient())
+            }
+        }
+    }
+    // send sends the messages in order and stores the ones that were sent.
+    // It stops at the first message that can't be sent, which is returned
+    // with the error, so that nothing after it is marked as bridged and it's
+    // retried on the next backfill.
+    send := func(messages []hostexapi.Message) (*hostexapi.Message, error) {
+        eventIDs := make([]id.EventID, 0, len(messages))
+        for i, msg := range messages {
+            eventID, err := p.SendMessage(ctx, msg)
+            if err != nil {
+                store(messages[:i], eventIDs)
+                return &messages[i], fmt.Errorf("failed to send backfilled message: %w", err)
+            }
+            eventIDs = append(eventIDs, eventID)
+        }
+        store(messages, eventIDs)
+        return nil, nil
+    }
+
+    bridgePage := func(messages []hostexapi.Message) error {
+        total += len(messages)
+        pending, err := unbridged(messages)
+        if err != nil {
+            return err
+        }
+        failed, sendErr := send(pending)
+        if params.progress == nil || len(messages) == 0 {
+            return sendErr
+        }
+        err = batch.Flush()
+        if err != nil {
+            return fmt.Errorf("failed to store backfilled messages: %w", err)
+        }
+        // The cursor is inclusive, so a failed message is fetched again
+        cursor := messages[len(messages)-1].Timestamp
+        if failed != nil {
+            cursor = failed.Timestamp
+        }
+        err = params.progress(cursor)
+        if err != nil {
+            return err
+        }
+        return sendErr
+    }
+    bridgeHistory := func(messages []hostexapi.Message) error {
+        total += len(messages)
+        pending, err := unbridged(messages)
+        if err != nil || len(pending) == 0 {
+            return err
+        }
+        prevEventID, err := p.historyInsertionPoint(ctx, pending[len(pending)-1].Timestamp)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to find where to insert history, sending it as regular messages", zap.Error(err))
+            _, err = send(pending)
+            return err
+        }
+        var batchID id.BatchID
+        pageSize := p.bridge.Config().Backfill.PageSize
+        for end := len(pending); end > 0; end -= pageSize {
+            chunk := pending[max(end-pageSize, 0):end]
+            var eventIDs []id.EventID
+            eventIDs, batchID, err = p.sendHistoricalBatch(ctx, prevEventID, batchID, chunk)
+            if err != nil {
+                p.bridge.Logger.Warn("Failed to batch send history, sending the rest as regular messages", zap.Error(err))
+                _, err = send(pending[:end])
+                return err
+            }
+            store(chunk, eventIDs)
+            err = batch.Flush()
+            if err != nil {
+                return fmt.Errorf("failed to store backfilled messages: %w", err)
+            }
+        }
+        return nil
+    }
+
+    historical := params.historical && p.bridge.batchSend
+    if params.limit > 0 || historical {
+        // The newest messages are picked and history is batch sent newest
+        // first, but pages start at the oldest
+        var messages []hostexapi.Message
+        if params.limit > 0 {
+            messages, err = p.newestMessages(ctx, params.since, params.until, params.limit)
+        } else {
+            messages, err = p.collectMessages(ctx, params.since, params.until)
+        }
+        if err == nil && params.progress != nil && len(messages) > 0 {
+            // The older messages are left out on purpose, so an interrupted
+            // backfill resumes at the oldest picked one, and the ones that
+            // were already bridged are skipped then
+            err = params.progress(messages[0].Timestamp)
+        }
+        if err == nil && historical {
+            err = bridgeHistory(messages)
+        } else if err == nil {
+            pageSize := p.bridge.Config().Backfill.PageSize
+            for start := 0; start < len(messages) && err == nil; start += pageSize {
+                err = bridgePage(messages[start:min(start+pageSize, len(messages))])
+            }
+        }
+    } else {
+        err = p.forEachMessagePage(ctx, params.since, params.until, bridgePage)
+    }
+    span.SetAttributes(attribute.Int("hostex.messages", total))
+    if err != nil {
+        return err
+    }
+
+    if p.archived && total > 0 {
+        p.unarchive(ctx)
+    }
+    if lastGuestMessage != "" {
+        p.setLanguage(ctx, detectLanguage(lastGuestMessage))
+    }
+    if lastGuestMessage != "" && p.bridge.Config().SatisfactionPulse.Enable {
+        p.handleSatisfactionReply(ctx, lastGuestMessage)
+    }
+    if lastGuestMessage != "" && p.bridge.Config().ReplySuggestions.Enable {
+        p.sendReplySuggestions(ctx, lastGuestMessage)
+    }
+    if lastGuestMessage != "" && (p.bridge.Config().AutoResponder.Enable || p.bridge.Config().AISuggestions.Enable) {
+        // The first_message trigger and AI suggestions look at the stored
+        // messages
+        err = batch.Flush()
         if err != nil {
-            p.bridge.Logger.Error("Failed to send backfilled message", zap.Error(err))
+            return fmt.Errorf("failed to store backfilled messages: %w", err)
         }
     }
+    if lastGuestMessage != "" && p.bridge.Config().AutoResponder.Enable {
+        p.autoRespond(ctx, lastGuestMessage, lastGuestTime)
+    }
+    if lastGuestMessage != "" && p.bridge.Config().AISuggestions.Enable {
+        p.suggestAIReply(lastGuestEventID, lastGuestTime)
+    }
 
     return nil
 }
 
-func (p *Portal) SendMessage(msg hostexapi.Message) error {
+// forEachMessagePage fetches the messages since the given time a page at a
+// time, oldest first, and calls fn with every page. A non-zero until stops at
+// the messages from that time on.
+func (p *Portal) forEachMessagePage(ctx context.Context, since, until time.Time, fn func(page []hostexapi.Message) error) error {
+    pageSize := p.bridge.Config().Backfill.PageSize
+    for {
+        page, err := p.client().GetMessages(ctx, p.conversationID(), since, pageSize)
+        if err != nil {
+            return fmt.Errorf("failed to get messages from Hostex: %w", err)
+        }
+        full := len(page) >= pageSize
+        if !until.IsZero() {
+            for i, msg := range page {
+                if !msg.Timestamp.Before(until) {
+                    page, full = page[:i], false
+                    break
+                }
+            }
+        }
+        err = fn(page)
+        if err != nil {
+            return err
+        }
+        if !full {
+            return nil
+        }
+        // The next page starts at the newest message of this one, which is
+        // skipped as already bridged
+        next := page[len(page)-1].Timestamp
+        if !next.After(since) {
+            p.bridge.Logger.Warn("Stopping backfill at a page of messages with the same timestamp",
+                zap.String("hostex_id", p.ID), zap.Time("timestamp", next))
+            return nil
+        }
+        since = next
+    }
+}
+
+// collectMessages fetches the messages between since and until like
+// forEachMessagePage. Pages overlap at the message they start at, so the
+// copies are left out.
+func (p *Portal) collectMessages(ctx context.Context, since, until time.Time) ([]hostexapi.Message, error) {
+    var messages []hostexapi.Message
+    seen := make(map[string]bool)
+    err := p.forEachMessagePage(ctx, since, until, func(page []hostexapi.Message) error {
+        for _, msg := range page {
+            if msg.ID != "" {
+                if seen[msg.ID] {
+                    continue
+                }
+                seen[msg.ID] = true
+            }
+            messages = append(messages, msg)
+        }
+        return nil
+    })
+    return messages, err
+}
+
+const (
+    newestMessagesWindow    = 24 * time.Hour
+    newestMessagesMaxWindow = 365 * 24 * time.Hour
+)
+
+// newestMessages fetches the newest limit messages since the given time. The
+// API only pages forward from a time, so instead of fetching the whole
+// history, it looks back in growing windows until it has enough messages.
+func (p *Portal) newestMessages(ctx context.Context, since, until time.Time, limit int) ([]hostexapi.Message, error) {
+    reference := until
+    if reference.IsZero() {
+        reference = time.Now()
+    }
+    var messages []hostexapi.Message
+    end := until
+    for window := newestMessagesWindow; ; window *= 4 {
+        start := reference.Add(-window)
+        if window > newestMessagesMaxWindow || !start.After(since) {
+            start = since
+        }
+        older, err := p.collectMessages(ctx, start, end)
+        if err != nil {
+            return nil, err
+        }
+        messages = append(older, messages...)
+        if len(messages) >= limit || start.Equal(since) {
+            break
+        }
+        // The windows don't overlap, since until excludes the messages
+        // from that time on
+        end = start
+    }
+    if len(messages) > limit {
+        messages = messages[len(messages)-limit:]
+    }
+    return messages, nil
+}
+
+func (p *Portal) SendMessage(ctx context.Context, msg hostexapi.Message) (eventID id.EventID, err error) {
+    ctx, span := tracer.Start(ctx, "SendMessage", trace.WithAttributes(
+        attribute.String("hostex.portal_id", p.ID),
+        attribute.String("hostex.message_id", msg.ID),
+    ))
+    defer func() { endSpan(span, err) }()
+
+    content := p.messageContent(ctx, msg)
+
+    // Convert timestamp to configured timezone
+    timestamp := msg.Timestamp.In(p.bridge.location())
+
+    client, _ := p.messageClient(ctx, msg.Sender)
+    resp, err := client.SendMessageEvent(ctx, p.RoomID, event.EventMessage, content, mautrix.ReqSendEvent{Timestamp: timestamp.UnixNano() / 1e6})
+    if err != nil {
+        return "", fmt.Errorf("failed to send Matrix message: %w", err)
+    }
+
+    return resp.EventID, nil
+}
+
+// messageContent returns the Matrix content of a Hostex message, with the
+// translation of guest messages and sent as a notice while notifications are
+// quiet. It's used for both regular and batch sent messages.
+func (p *Portal) messageContent(ctx context.Context, msg hostexapi.Message) *event.MessageEventContent {
     content := &event.MessageEventContent{
         MsgType: event.MsgText,
         Body:    msg.Content,
     }
+    if p.bridge.Translator != nil && !isHostSender(msg.Sender) {
+        content.Body += p.translateInbound(ctx, msg.Content)
+    }
+    if p.bridge.notificationLevel() == NotificationLevelQuiet {
+        content.MsgType = event.MsgNotice
+    } else if _, quiet := p.bridge.inQuietHours(); quiet && !p.bridge.isUrgent(msg.Content) {
+        content.MsgType = event.MsgNotice
+    } else if p.bridge.Config().NotificationHints.Enable {
+        p.applyNotificationHint(content, msg)
+    }
+    return content
+}
 
-    // Convert timestamp to configured timezone
-    loc, err := time.LoadLocation(p.bridge.Config.Timezone)
+func (p *Portal) sendNotice(ctx context.Context, message string) {
+    content := &event.MessageEventContent{
+        MsgType: event.MsgNotice,
+        Body:    message,
+    }
+    _, err := p.bridge.MatrixClient.SendMessageEvent(ctx, p.RoomID, event.EventMessage, content)
     if err != nil {
-        p.bridge.Logger.Error("Failed to load timezone", zap.Error(err))
-        loc = time.UTC
+        p.bridge.Logger.Error("Failed to send notice", zap.Error(err))
     }
-    timestamp := msg.Timestamp.In(loc)
+}
 
-    ctx := context.Background()
-    _, err = p.bridge.MatrixClient.SendMessageEvent(ctx, p.RoomID, event.EventMessage, content, mautrix.ReqSendEvent{Timestamp: timestamp.UnixNano() / 1e6})
-    if err != nil {
-        return fmt.Errorf("failed to send Matrix message: %w", err)
+// getCachedRoomID returns the room ID of the portal from Redis if it's
+// configured, falling back to the database and filling the cache from it.
+func (p *Portal) getCachedRoomID() (id.RoomID, error) {
+    if p.bridge.Redis != nil {
+        state, err := p.bridge.Redis.GetPortalState(p.ID)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to get cached portal state", zap.Error(err))
+        } else if state != nil && state.RoomID != "" {
+            return state.RoomID, nil
+        }
     }
 
-    return nil
+    roomID, err := p.bridge.DB.GetPortal(p.ID)
+    if err != nil || roomID == "" {
+        return roomID, err
+    }
+    p.RoomID = roomID
+    p.cacheState()
+    return roomID, nil
+}
+
+// cacheState writes the room ID and last message time of the portal to Redis,
+// if it's configured. The database remains the source of truth, so failures
+// are only logged.
+func (p *Portal) cacheState() {
+    if p.bridge.Redis == nil {
+        return
+    }
+    if p.RoomID != "" {
+        err := p.bridge.Redis.SetPortalRoomID(p.ID, p.RoomID)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to cache portal room ID", zap.Error(err))
+        }
+    }
+    if !p.lastMessageAt.IsZero() {
+        err := p.bridge.Redis.SetPortalLastMessageAt(p.ID, p.lastMessageAt)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to cache portal last message time", zap.Error(err))
+        }
+    }
 }
@@ -0,0 +1,208 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// SettingArchiveSpace is the setting that stores the room ID of the space of
+// archived portals.
+const SettingArchiveSpace = "portal_archive.space"
+
+// archivePortals archives the portals whose guest checked out the configured
+// number of days ago and hasn't written since.
+func (b *Bridge) archivePortals(ctx context.Context) {
+    after := time.Duration(b.Config().PortalArchive.AfterDays) * 24 * time.Hour
+    now := time.Now()
+    for _, portal := range b.allPortals() {
+        if portal.RoomID == "" || portal.archived {
+            continue
+        }
+        checkOut, err := time.ParseInLocation(dateLayout, portal.Info.CheckOutDate, b.location())
+        if err != nil {
+            continue
+        }
+        lastActivity := checkOut
+        if portal.lastMessageAt.After(lastActivity) {
+            lastActivity = portal.lastMessageAt
+        }
+        if now.Sub(lastActivity) < after {
+            continue
+        }
+
+        err = portal.archive(ctx)
+        if err != nil {
+            b.Logger.Error("Failed to archive portal", zap.String("hostex_id", portal.ID), zap.Error(err))
+        }
+    }
+}
+
+func (p *Portal) archive(ctx context.Context) error {
+    cfg := p.bridge.Config().PortalArchive
+    roomID := p.RoomID
+
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, roomID, event.StateRoomName, "", &event.RoomNameEventContent{
+        Name: cfg.Prefix + p.roomName(),
+    })
+    if err != nil {
+        return fmt.Errorf("failed to rename room: %w", err)
+    }
+    if p.bridge.AdminClient != nil {
+        err = p.bridge.AdminClient.AddTag(ctx, roomID, event.RoomTagLowPriority, 0.5)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to tag archived room as low priority", zap.Error(err))
+        }
+    }
+    if p.bridge.Config().PersonalSpaceEnable {
+        err = p.moveToArchiveSpace(ctx)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to move archived room out of the conversation space", zap.Error(err))
+        }
+    }
+
+    err = p.bridge.DB.SetPortalArchived(p.ID, true)
+    if err != nil {
+        return err
+    }
+    p.archived = true
+
+    if cfg.Leave {
+        _, err = p.bridge.MatrixClient.LeaveRoom(ctx, roomID)
+        if err != nil {
+            return fmt.Errorf("failed to leave room: %w", err)
+        }
+        err = p.bridge.DB.ClearPortalRoom(p.ID)
+        if err != nil {
+            return err
+        }
+        p.bridge.removePortalRoom(roomID)
+        p.RoomID = ""
+        if p.bridge.Redis != nil {
+            err = p.bridge.Redis.SetPortalRoomID(p.ID, "")
+            if err != nil {
+                p.bridge.Logger.Warn("Failed to clear cached portal room ID", zap.Error(err))
+            }
+        }
+    }
+    p.bridge.Logger.Info("Archived portal", zap.String("hostex_id", p.ID), zap.String("room_id", roomID.String()))
+    return nil
+}
+
+// unarchive restores an archived portal room after the guest wrote again.
+func (p *Portal) unarchive(ctx context.Context) {
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, event.StateRoomName, "", &event.RoomNameEventContent{
+        Name: p.roomName(),
+    })
+    if err != nil {
+        p.bridge.Logger.Error("Failed to rename unarchived room", zap.Error(err))
+    }
+    if p.bridge.AdminClient != nil {
+        err = p.bridge.AdminClient.RemoveTag(ctx, p.RoomID, event.RoomTagLowPriority)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to remove low priority tag", zap.Error(err))
+        }
+    }
+    if p.bridge.Config().PersonalSpaceEnable {
+        if p.bridge.Config().PortalArchive.Space {
+            err = p.removeFromArchiveSpace(ctx)
+            if err != nil {
+                p.bridge.Logger.Warn("Failed to remove room from the archive space", zap.Error(err))
+            }
+        }
+        err = p.addToPersonalSpace(ctx)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to add unarchived room to personal space", zap.Error(err))
+        }
+    }
+
+    err = p.bridge.DB.SetPortalArchived(p.ID, false)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to unarchive portal", zap.Error(err))
+        return
+    }
+    p.archived = false
+}
+
+func (p *Portal) moveToArchiveSpace(ctx context.Context) error {
+    spaceRoom, err := p.personalSpace(ctx)
+    if err != nil {
+        return err
+    }
+    err = p.removeFromSpace(ctx, spaceRoom)
+    if err != nil || !p.bridge.Config().PortalArchive.Space {
+        return err
+    }
+
+    archiveSpace, err := p.bridge.getArchiveSpace(ctx)
+    if err != nil {
+        return err
+    }
+    _, err = p.bridge.MatrixClient.SendStateEvent(ctx, archiveSpace, event.StateSpaceChild, p.RoomID.String(), &event.SpaceChildEventContent{
+        Via: []string{p.bridge.Config().Homeserver.Domain},
+    })
+    return err
+}
+
+func (p *Portal) removeFromArchiveSpace(ctx context.Context) error {
+    archiveSpace, err := p.bridge.getArchiveSpace(ctx)
+    if err != nil {
+        return err
+    }
+    return p.removeFromSpace(ctx, archiveSpace)
+}
+
+// removeFromSpace removes the portal room from a space by clearing its
+// space child event.
+func (p *Portal) removeFromSpace(ctx context.Context, spaceRoom id.RoomID) error {
+    if spaceRoom == "" {
+        return nil
+    }
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, spaceRoom, event.StateSpaceChild, p.RoomID.String(), &event.SpaceChildEventContent{})
+    return err
+}
+
+// getArchiveSpace returns the space of archived portals, creating it as a
+// child of the personal space if it doesn't exist yet.
+func (b *Bridge) getArchiveSpace(ctx context.Context) (id.RoomID, error) {
+    if b.archiveSpace != "" {
+        return b.archiveSpace, nil
+    }
+    roomID, err := b.DB.GetSetting(SettingArchiveSpace)
+    if err != nil {
+        return "", err
+    } else if roomID != "" {
+        b.archiveSpace = id.RoomID(roomID)
+        return b.archiveSpace, nil
+    }
+
+    resp, err := b.MatrixClient.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+        Visibility: "private",
+        Name:       "Archive",
+        Topic:      "Archived Hostex conversations",
+        CreationContent: map[string]interface{}{
+            "type": "m.space",
+        },
+    })
+    if err != nil {
+        return "", fmt.Errorf("failed to create archive space: %w", err)
+    }
+    err = b.DB.SetSetting(SettingArchiveSpace, resp.RoomID.String())
+    if err != nil {
+        return "", err
+    }
+    b.archiveSpace = resp.RoomID
+
+    _, err = b.MatrixClient.SendStateEvent(ctx, b.spaceRoom, event.StateSpaceChild, resp.RoomID.String(), &event.SpaceChildEventContent{
+        Via: []string{b.Config().Homeserver.Domain},
+    })
+    if err != nil {
+        b.Logger.Error("Failed to add archive space to personal space", zap.Error(err))
+    }
+    return b.archiveSpace, nil
+}
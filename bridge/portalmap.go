@@ -0,0 +1,106 @@
+package bridge
+
+import (
+    "maunium.net/go/mautrix/id"
+)
+
+// The portal maps are read by the Matrix event handlers, task workers and
+// the backfill queue while the poller adds and removes portals, so all access
+// goes through these methods, which hold portalsLock.
+
+func (b *Bridge) getPortalByID(hostexID string) (*Portal, bool) {
+    b.portalsLock.RLock()
+    defer b.portalsLock.RUnlock()
+    portal, ok := b.portalsByID[hostexID]
+    return portal, ok
+}
+
+func (b *Bridge) getPortalByMXID(roomID id.RoomID) (*Portal, bool) {
+    b.portalsLock.RLock()
+    defer b.portalsLock.RUnlock()
+    portal, ok := b.portalsByMXID[roomID]
+    return portal, ok
+}
+
+// allPortals returns a snapshot of the loaded portals, which can be iterated
+// without holding the lock.
+func (b *Bridge) allPortals() []*Portal {
+    b.portalsLock.RLock()
+    defer b.portalsLock.RUnlock()
+    portals := make([]*Portal, 0, len(b.portalsByID))
+    for _, portal := range b.portalsByID {
+        portals = append(portals, portal)
+    }
+    return portals
+}
+
+// addPortal stores a newly loaded portal. If another goroutine loaded the
+// same conversation first, that portal is returned instead.
+func (b *Bridge) addPortal(portal *Portal) *Portal {
+    b.portalsLock.Lock()
+    defer b.portalsLock.Unlock()
+    if existing, ok := b.portalsByID[portal.ID]; ok {
+        return existing
+    }
+    b.portalsByID[portal.ID] = portal
+    return portal
+}
+
+// setPortalRoom maps the portal's current room to it, dropping any room it
+// was mapped to before.
+func (b *Bridge) setPortalRoom(portal *Portal) {
+    b.portalsLock.Lock()
+    defer b.portalsLock.Unlock()
+    for roomID, existing := range b.portalsByMXID {
+        if existing == portal && roomID != portal.RoomID {
+            delete(b.portalsByMXID, roomID)
+        }
+    }
+    b.portalsByID[portal.ID] = portal
+    if portal.RoomID != "" {
+        b.portalsByMXID[portal.RoomID] = portal
+    }
+}
+
+// removePortalRoom unmaps a room that the portal left.
+func (b *Bridge) removePortalRoom(roomID id.RoomID) {
+    b.portalsLock.Lock()
+    defer b.portalsLock.Unlock()
+    delete(b.portalsByMXID, roomID)
+}
+
+// removePortal forgets a deleted portal and its room.
+func (b *Bridge) removePortal(portal *Portal) {
+    b.portalsLock.Lock()
+    defer b.portalsLock.Unlock()
+    delete(b.portalsByID, portal.ID)
+    delete(b.portalsByMXID, portal.RoomID)
+    delete(b.portalRetries, portal.ID)
+}
+
+// renamePortal moves a portal to a new conversation ID.
+func (b *Bridge) renamePortal(portal *Portal, newID string) {
+    b.portalsLock.Lock()
+    defer b.portalsLock.Unlock()
+    delete(b.portalsByID, portal.ID)
+    delete(b.portalRetries, portal.ID)
+    portal.ID = newID
+    b.portalsByID[newID] = portal
+}
+
+// getPortalRetry returns a copy of the backoff state of the conversation.
+func (b *Bridge) getPortalRetry(hostexID string) (portalRetry, bool) {
+    b.portalsLock.RLock()
+    defer b.portalsLock.RUnlock()
+    retry, ok := b.portalRetries[hostexID]
+    if !ok {
+        return portalRetry{}, false
+    }
+    return *retry, true
+}
+
+func (b *Bridge) clearPortalRetry(hostexID string) {
+    b.portalsLock.Lock()
+    defer b.portalsLock.Unlock()
+    delete(b.portalRetries, hostexID)
+}
@@ -0,0 +1,107 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+const (
+    portalRetryMinDelay = 30 * time.Second
+    portalRetryMaxDelay = 30 * time.Minute
+    // portalRetryNotifyAfter is the number of failed attempts after which
+    // the management room is told about the failing portal.
+    portalRetryNotifyAfter = 5
+)
+
+// portalRetry is the backoff state of a portal whose room couldn't be
+// created.
+type portalRetry struct {
+    attempts    int
+    nextAttempt time.Time
+    lastError   error
+}
+
+// portalRetryDue reports whether creating the room of the conversation
+// should be attempted, i.e. it hasn't failed before or its backoff is over.
+func (b *Bridge) portalRetryDue(hostexID string) bool {
+    retry, ok := b.getPortalRetry(hostexID)
+    return !ok || !time.Now().Before(retry.nextAttempt)
+}
+
+// portalCreationFailed records a failed room creation and schedules the next
+// attempt with exponential backoff.
+func (b *Bridge) portalCreationFailed(ctx context.Context, hostexID string, err error) {
+    b.portalsLock.Lock()
+    retry, ok := b.portalRetries[hostexID]
+    if !ok {
+        retry = &portalRetry{}
+        b.portalRetries[hostexID] = retry
+    }
+    retry.attempts++
+    retry.lastError = err
+
+    delay := portalRetryMinDelay << (retry.attempts - 1)
+    if delay > portalRetryMaxDelay || delay <= 0 {
+        delay = portalRetryMaxDelay
+    }
+    retry.nextAttempt = time.Now().Add(delay)
+    attempts := retry.attempts
+    b.portalsLock.Unlock()
+
+    b.Logger.Error("Failed to create Matrix room",
+        zap.String("hostex_id", hostexID),
+        zap.Int("attempts", attempts),
+        zap.Duration("retry_in", delay),
+        zap.Error(err))
+    if attempts == portalRetryNotifyAfter {
+        b.sendManagementNotice(ctx, fmt.Sprintf("Creating the room for conversation %s failed %d times (%v). Retrying every %s, or use !create-portal %s to try now.",
+            hostexID, attempts, err, portalRetryMaxDelay, hostexID))
+    }
+}
+
+func (u *User) createPortal(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !create-portal <conversation ID|guest name>")
+        return
+    }
+    query := strings.Join(args, " ")
+
+    portal := u.bridge.findPortal(query)
+    if portal == nil {
+        conversations, err := u.bridge.getAllConversations(ctx)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get conversations: %v", err))
+            return
+        }
+        for _, conv := range conversations {
+            if conv.ID == query || strings.EqualFold(conv.Guest.Name, query) {
+                err = u.bridge.DB.UndeletePortal(conv.ID)
+                if err != nil {
+                    u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to restore deleted portal: %v", err))
+                    return
+                }
+                u.bridge.handleHostexConversation(ctx, conv)
+                portal, _ = u.bridge.getPortalByID(conv.ID)
+                break
+            }
+        }
+        if portal == nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("No conversation found for %q.", query))
+            return
+        }
+    } else if portal.RoomID == "" {
+        u.bridge.clearPortalRetry(portal.ID)
+        u.bridge.handleHostexConversation(ctx, portal.Info)
+    }
+
+    if retry, ok := u.bridge.getPortalRetry(portal.ID); ok && portal.RoomID == "" {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to create the room: %v", retry.lastError))
+        return
+    }
+    u.sendNotice(ctx, roomID, fmt.Sprintf("The room for %s is %s", portal.Info.Guest.Name, portal.RoomID))
+}
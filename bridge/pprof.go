@@ -0,0 +1,40 @@
+package bridge
+
+import (
+    "context"
+    "net/http"
+    "net/http/pprof"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// startPProf serves the pprof profiles, e.g. for
+// go tool pprof http://127.0.0.1:6060/debug/pprof/heap
+func (b *Bridge) startPProf() {
+    defer b.wg.Done()
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+    server := &http.Server{
+        Addr:    b.Config().PProf.Listen,
+        Handler: b.AccessLog.Wrap("pprof", mux),
+    }
+    go func() {
+        <-b.stop
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        server.Shutdown(ctx)
+    }()
+
+    b.Logger.Info("Serving pprof", zap.String("address", server.Addr))
+    err := server.ListenAndServe()
+    if err != nil && err != http.ErrServerClosed {
+        b.Logger.Error("pprof listener failed", zap.Error(err))
+    }
+}
@@ -0,0 +1,157 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+// PreferencesAccountDataType is the account data event of the bridge bot that
+// holds a backup of every user's preferences, keyed by Matrix user ID.
+const PreferencesAccountDataType = "com.hostex.preferences"
+
+const (
+    NotificationLevelAll   = "all"
+    NotificationLevelQuiet = "quiet"
+)
+
+type preferencesAccountData struct {
+    Users map[id.UserID]*database.Preferences `json:"users"`
+}
+
+func (u *User) loadPreferences(ctx context.Context) {
+    prefs, err := u.bridge.DB.GetPreferences(u.MXID)
+    if err != nil {
+        u.bridge.Logger.Error("Failed to load preferences", zap.Error(err))
+    }
+    if prefs != nil {
+        u.Preferences = prefs
+        return
+    }
+
+    // Restore from account data if the database doesn't know the user,
+    // e.g. after the database was reset or the bridge was reinstalled.
+    var backup preferencesAccountData
+    err = u.bridge.MatrixClient.GetAccountData(ctx, PreferencesAccountDataType, &backup)
+    if err != nil {
+        if !errors.Is(err, mautrix.MNotFound) {
+            u.bridge.Logger.Error("Failed to load preferences from account data", zap.Error(err))
+        }
+        u.Preferences = &database.Preferences{}
+        return
+    }
+    prefs, ok := backup.Users[u.MXID]
+    if !ok || prefs == nil {
+        u.Preferences = &database.Preferences{}
+        return
+    }
+
+    u.Preferences = prefs
+    err = u.bridge.DB.StorePreferences(u.MXID, prefs)
+    if err != nil {
+        u.bridge.Logger.Error("Failed to store restored preferences", zap.Error(err))
+    } else {
+        u.bridge.Logger.Info("Restored preferences from account data", zap.String("mxid", u.MXID.String()))
+    }
+}
+
+func (u *User) savePreferences(ctx context.Context) error {
+    err := u.bridge.DB.StorePreferences(u.MXID, u.Preferences)
+    if err != nil {
+        return fmt.Errorf("failed to store preferences: %w", err)
+    }
+
+    var backup preferencesAccountData
+    err = u.bridge.MatrixClient.GetAccountData(ctx, PreferencesAccountDataType, &backup)
+    if err != nil && !errors.Is(err, mautrix.MNotFound) {
+        return fmt.Errorf("failed to get preferences account data: %w", err)
+    }
+    if backup.Users == nil {
+        backup.Users = make(map[id.UserID]*database.Preferences)
+    }
+    backup.Users[u.MXID] = u.Preferences
+    err = u.bridge.MatrixClient.SetAccountData(ctx, PreferencesAccountDataType, &backup)
+    if err != nil {
+        return fmt.Errorf("failed to back up preferences to account data: %w", err)
+    }
+    return nil
+}
+
+func (u *User) setPreference(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) < 2 {
+        u.sendNotice(ctx, roomID, "Usage: !set <timezone|digest-time|notifications> <value>")
+        return
+    }
+
+    key, value := strings.ToLower(args[0]), args[1]
+    switch key {
+    case "timezone":
+        _, err := time.LoadLocation(value)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Invalid timezone: %v", err))
+            return
+        }
+        u.Preferences.Timezone = value
+    case "digest-time":
+        _, err := time.Parse("15:04", value)
+        if err != nil {
+            u.sendNotice(ctx, roomID, "Invalid digest time, expected HH:MM")
+            return
+        }
+        u.Preferences.DigestTime = value
+    case "notifications":
+        value = strings.ToLower(value)
+        if value != NotificationLevelAll && value != NotificationLevelQuiet {
+            u.sendNotice(ctx, roomID, "Invalid notification level, expected all or quiet")
+            return
+        }
+        u.Preferences.NotificationLevel = value
+    default:
+        u.sendNotice(ctx, roomID, "Unknown setting. Available settings: timezone, digest-time, notifications")
+        return
+    }
+
+    err := u.savePreferences(ctx)
+    if err != nil {
+        u.bridge.Logger.Error("Failed to save preferences", zap.Error(err))
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to save setting: %v", err))
+        return
+    }
+    u.sendNotice(ctx, roomID, fmt.Sprintf("Set %s to %s", key, value))
+}
+
+func (u *User) sendSettings(ctx context.Context, roomID id.RoomID) {
+    u.sendNotice(ctx, roomID, fmt.Sprintf(`Settings:
+Timezone: %s
+Digest time: %s
+Notifications: %s`,
+        u.bridge.location().String(),
+        u.bridge.digestTime(),
+        u.bridge.notificationLevel()))
+}
+
+func (b *Bridge) adminPreferences() *database.Preferences {
+    return b.getUser(b.Config().Admin.UserID).Preferences
+}
+
+func (b *Bridge) digestTime() string {
+    if prefs := b.adminPreferences(); prefs.DigestTime != "" {
+        return prefs.DigestTime
+    }
+    return b.Config().Digest.Time
+}
+
+func (b *Bridge) notificationLevel() string {
+    if prefs := b.adminPreferences(); prefs.NotificationLevel != "" {
+        return prefs.NotificationLevel
+    }
+    return NotificationLevelAll
+}
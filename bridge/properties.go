@@ -0,0 +1,80 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// getProperties returns the listings of the Hostex account. They're fetched
+// once and cached, as they rarely change; refresh forces a new fetch.
+func (b *Bridge) getProperties(ctx context.Context, refresh bool) ([]hostexapi.Property, error) {
+    b.propertiesLock.Lock()
+    defer b.propertiesLock.Unlock()
+
+    if b.properties != nil && !refresh {
+        return b.properties, nil
+    }
+    properties, err := b.HostexClient.GetProperties(ctx)
+    if err != nil {
+        return nil, err
+    }
+    b.properties = properties
+    return properties, nil
+}
+
+// findProperty returns the property with the given ID, or the only property
+// whose title contains the query.
+func (b *Bridge) findProperty(ctx context.Context, query string) (*hostexapi.Property, error) {
+    properties, err := b.getProperties(ctx, false)
+    if err != nil {
+        return nil, err
+    }
+
+    var matches []hostexapi.Property
+    lowerQuery := strings.ToLower(query)
+    for _, property := range properties {
+        if property.ID == query {
+            return &property, nil
+        } else if strings.Contains(strings.ToLower(property.Title), lowerQuery) {
+            matches = append(matches, property)
+        }
+    }
+    switch len(matches) {
+    case 0:
+        return nil, fmt.Errorf("no property matches %q", query)
+    case 1:
+        return &matches[0], nil
+    default:
+        return nil, fmt.Errorf("%d properties match %q, use the property ID instead", len(matches), query)
+    }
+}
+
+func (u *User) listProperties(ctx context.Context, roomID id.RoomID) {
+    properties, err := u.bridge.getProperties(ctx, true)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get properties: %v", err))
+        return
+    } else if len(properties) == 0 {
+        u.sendNotice(ctx, roomID, "No properties found.")
+        return
+    }
+
+    var list strings.Builder
+    list.WriteString("Properties:\n")
+    for _, property := range properties {
+        channels := make([]string, len(property.Channels))
+        for i, channel := range property.Channels {
+            channels[i] = channel.ChannelType
+        }
+        list.WriteString(fmt.Sprintf("- %s (ID: %s)\n", property.Title, property.ID))
+        if len(channels) > 0 {
+            list.WriteString(fmt.Sprintf("  Channels: %s\n", strings.Join(channels, ", ")))
+        }
+    }
+    u.sendNotice(ctx, roomID, list.String())
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// getPropertySpace returns the space of a property, creating it as a child of
+// the personal space if it doesn't exist yet.
+func (b *Bridge) getPropertySpace(ctx context.Context, propertyTitle string) (id.RoomID, error) {
+    b.propertySpacesLock.Lock()
+    defer b.propertySpacesLock.Unlock()
+
+    if roomID, ok := b.propertySpaces[propertyTitle]; ok {
+        return roomID, nil
+    }
+    roomID, err := b.DB.GetPropertySpace(propertyTitle)
+    if err != nil {
+        return "", fmt.Errorf("failed to get property space: %w", err)
+    } else if roomID != "" {
+        b.propertySpaces[propertyTitle] = roomID
+        return roomID, nil
+    }
+
+    resp, err := b.MatrixClient.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+        Visibility: "private",
+        Name:       propertyTitle,
+        Topic:      fmt.Sprintf("Hostex conversations about %s", propertyTitle),
+        CreationContent: map[string]interface{}{
+            "type": "m.space",
+        },
+    })
+    if err != nil {
+        return "", fmt.Errorf("failed to create property space: %w", err)
+    }
+    roomID = resp.RoomID
+    b.Logger.Info("Created property space", zap.String("property", propertyTitle), zap.String("room_id", roomID.String()))
+
+    err = b.DB.StorePropertySpace(propertyTitle, roomID)
+    if err != nil {
+        return "", fmt.Errorf("failed to store property space: %w", err)
+    }
+    b.propertySpaces[propertyTitle] = roomID
+
+    _, err = b.MatrixClient.SendStateEvent(ctx, b.spaceRoom, event.StateSpaceChild, roomID.String(), &event.SpaceChildEventContent{
+        Via: []string{b.Config().Homeserver.Domain},
+    })
+    if err != nil {
+        b.Logger.Error("Failed to add property space to personal space", zap.String("property", propertyTitle), zap.Error(err))
+    }
+    return roomID, nil
+}
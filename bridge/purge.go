@@ -0,0 +1,299 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// PurgeResult lists what PurgeGuest deleted.
+type PurgeResult struct {
+    Conversations []string
+    EmailThreads  []string
+}
+
+// ErrGuestNotFound is returned by PurgeGuest when nothing is stored about
+// the guest.
+var ErrGuestNotFound = errors.New("no conversation or email thread found for the guest")
+
+// findGuestData returns the portals and email thread addresses of a guest,
+// who is given by email address, phone number or like any portal. The
+// conversations are found through the guest they're linked to and through
+// blocked guests with the same contact details, so conversations that Hostex
+// doesn't list anymore are included.
+func (b *Bridge) findGuestData(query string) ([]*Portal, []string, error) {
+    var hostexIDs []string
+    seen := make(map[string]bool)
+    add := func(hostexID string) {
+        if !seen[hostexID] {
+            seen[hostexID] = true
+            hostexIDs = append(hostexIDs, hostexID)
+        }
+    }
+
+    var keys []string
+    var guestID int64
+    if strings.Contains(query, "@") && !strings.HasPrefix(query, "@") && !strings.HasPrefix(query, "!") {
+        keys = guestKeys(query, "")
+        for _, portal := range b.allPortals() {
+            if strings.EqualFold(portal.Info.Guest.Email, strings.TrimSpace(query)) {
+                add(portal.ID)
+            }
+        }
+    } else if portal := b.findPortal(query); portal != nil {
+        add(portal.ID)
+        keys = guestKeys(portal.Info.Guest.Email, portal.Info.Guest.Phone)
+        guestID = portal.guestID
+        if guestID == 0 {
+            var err error
+            guestID, err = b.DB.GetConversationGuest(portal.ID)
+            if err != nil {
+                return nil, nil, fmt.Errorf("failed to get conversation guest: %w", err)
+            }
+        }
+    } else {
+        keys = guestKeys("", query)
+    }
+
+    if guestID == 0 && len(keys) > 0 {
+        var err error
+        guestID, err = b.DB.FindGuestByKeys(keys)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to find guest: %w", err)
+        }
+    }
+    if guestID != 0 {
+        guest, err := b.DB.GetGuest(guestID)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to get guest: %w", err)
+        } else if guest != nil {
+            keys = append(keys, guest.Keys...)
+        }
+        conversations, err := b.DB.GetGuestConversations(guestID)
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to get guest conversations: %w", err)
+        }
+        for _, hostexID := range conversations {
+            add(hostexID)
+        }
+    }
+
+    keySet := make(map[string]bool, len(keys))
+    for _, key := range keys {
+        keySet[key] = true
+    }
+    blocked, err := b.DB.GetBlockedGuests()
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to get blocked guests: %w", err)
+    }
+    for _, guest := range blocked {
+        for _, key := range guestKeys(guest.Email, guest.Phone) {
+            if keySet[key] {
+                add(guest.HostexID)
+                break
+            }
+        }
+    }
+
+    var portals []*Portal
+    for _, hostexID := range hostexIDs {
+        portal, err := b.loadPortalForPurge(hostexID)
+        if err != nil {
+            return nil, nil, err
+        }
+        portals = append(portals, portal)
+        if portal.Info.Guest.Email != "" {
+            keySet["email:"+strings.ToLower(strings.TrimSpace(portal.Info.Guest.Email))] = true
+        }
+    }
+
+    var addresses []string
+    b.emailLock.Lock()
+    for key := range keySet {
+        address := strings.TrimPrefix(key, "email:")
+        if _, ok := b.emailThreads[address]; ok && address != key {
+            addresses = append(addresses, address)
+        }
+    }
+    b.emailLock.Unlock()
+    sort.Strings(addresses)
+    return portals, addresses, nil
+}
+
+// loadPortalForPurge returns the loaded portal of a conversation, or one
+// loaded from the database for a conversation that Hostex doesn't list
+// anymore, so its room and data can be deleted too.
+func (b *Bridge) loadPortalForPurge(hostexID string) (*Portal, error) {
+    if portal, ok := b.getPortalByID(hostexID); ok {
+        return portal, nil
+    }
+    portal := NewPortal(b, hostexID)
+    var err error
+    portal.RoomID, err = b.DB.GetPortal(hostexID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get portal %s: %w", hostexID, err)
+    }
+    portal.archived, err = b.DB.IsPortalArchived(hostexID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get portal %s: %w", hostexID, err)
+    }
+    return portal, nil
+}
+
+// PurgeGuest deletes everything stored about a guest for a data deletion
+// request: the portals of their conversations with the bridged messages,
+// drafts, checklists and other per-conversation data, and their email
+// threads. The conversations aren't bridged again. With redact, the history
+// of the rooms is redacted before they're left.
+func (b *Bridge) PurgeGuest(ctx context.Context, query string, redact bool) (*PurgeResult, error) {
+    portals, addresses, err := b.findGuestData(query)
+    if err != nil {
+        return nil, err
+    } else if len(portals) == 0 && len(addresses) == 0 {
+        return nil, ErrGuestNotFound
+    }
+
+    result := &PurgeResult{}
+    for _, address := range addresses {
+        b.emailLock.Lock()
+        thread, ok := b.emailThreads[address]
+        if !ok {
+            // Deleted concurrently since it was found
+            b.emailLock.Unlock()
+            continue
+        }
+        delete(b.emailThreads, address)
+        delete(b.emailThreadsByMXID, thread.RoomID)
+        b.emailLock.Unlock()
+
+        err := b.DB.DeleteEmailThread(address)
+        if err != nil {
+            return result, fmt.Errorf("failed to delete email thread: %w", err)
+        }
+        // Portal rooms are cleaned up with their portal below
+        if _, isPortal := b.getPortalByMXID(thread.RoomID); !isPortal && thread.RoomID != "" {
+            if redact {
+                b.redactRoomHistory(ctx, thread.RoomID)
+            }
+            b.leaveRoom(ctx, thread.RoomID)
+        }
+        result.EmailThreads = append(result.EmailThreads, address)
+    }
+
+    for _, portal := range portals {
+        if redact && portal.RoomID != "" {
+            b.redactRoomHistory(ctx, portal.RoomID)
+            portal.clearGuestState(ctx)
+        }
+        portal.resetGhostProfile(ctx)
+        err := portal.delete(ctx)
+        if err != nil {
+            return result, fmt.Errorf("failed to delete conversation %s: %w", portal.ID, err)
+        }
+        result.Conversations = append(result.Conversations, portal.ID)
+    }
+    b.Logger.Info("Purged guest data",
+        zap.Strings("conversations", result.Conversations),
+        zap.Int("email_threads", len(result.EmailThreads)),
+        zap.Bool("redacted", redact))
+    return result, nil
+}
+
+// redactRoomHistory redacts every message in the room. Failures are only
+// logged, so one undeletable event doesn't stop the purge.
+func (b *Bridge) redactRoomHistory(ctx context.Context, roomID id.RoomID) {
+    var from string
+    var redacted int
+    for {
+        resp, err := b.MatrixClient.Messages(ctx, roomID, from, "", mautrix.DirectionBackward, nil, 100)
+        if err != nil {
+            b.Logger.Warn("Failed to get room history to redact", zap.String("room_id", roomID.String()), zap.Error(err))
+            return
+        }
+        for _, evt := range resp.Chunk {
+            if evt.StateKey != nil || evt.Type == event.EventRedaction || evt.Unsigned.RedactedBecause != nil {
+                continue
+            }
+            _, err = b.MatrixClient.RedactEvent(ctx, roomID, evt.ID, mautrix.ReqRedact{Reason: "Guest data deleted"})
+            if err != nil {
+                b.Logger.Warn("Failed to redact event", zap.String("event_id", evt.ID.String()), zap.Error(err))
+                continue
+            }
+            redacted++
+        }
+        if resp.End == "" || len(resp.Chunk) == 0 {
+            break
+        }
+        from = resp.End
+    }
+    b.Logger.Debug("Redacted room history", zap.String("room_id", roomID.String()), zap.Int("events", redacted))
+}
+
+func (b *Bridge) leaveRoom(ctx context.Context, roomID id.RoomID) {
+    _, err := b.MatrixClient.LeaveRoom(ctx, roomID)
+    if err != nil {
+        b.Logger.Warn("Failed to leave room", zap.String("room_id", roomID.String()), zap.Error(err))
+    }
+    _, err = b.MatrixClient.ForgetRoom(ctx, roomID)
+    if err != nil {
+        b.Logger.Warn("Failed to forget room", zap.String("room_id", roomID.String()), zap.Error(err))
+    }
+}
+
+func (u *User) purgeGuest(ctx context.Context, roomID id.RoomID, args []string) {
+    redact := false
+    var rest []string
+    for _, arg := range args {
+        if arg == "--redact" {
+            redact = true
+        } else {
+            rest = append(rest, arg)
+        }
+    }
+    if len(rest) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !purge-guest <conversation ID|room ID|guest name|email|phone> [--redact]")
+        return
+    }
+    query := strings.Join(rest, " ")
+    portals, addresses, err := u.bridge.findGuestData(query)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to find guest data: %v", err))
+        return
+    } else if len(portals) == 0 && len(addresses) == 0 {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Nothing is stored about %q.", query))
+        return
+    }
+
+    var description strings.Builder
+    description.WriteString("This will permanently delete everything stored about the guest:\n")
+    for _, portal := range portals {
+        if portal.Info.Guest.Name == "" {
+            description.WriteString(fmt.Sprintf("- Conversation %s, no longer listed by Hostex, its room and bridged messages\n", portal.ID))
+        } else {
+            description.WriteString(fmt.Sprintf("- Conversation with %s (%s), its room and bridged messages\n", portal.Info.Guest.Name, portal.ID))
+        }
+    }
+    for _, address := range addresses {
+        description.WriteString(fmt.Sprintf("- Email thread with %s\n", address))
+    }
+    if redact {
+        description.WriteString("The room history will be redacted first.\n")
+    }
+    description.WriteString("The conversations won't be bridged again.")
+
+    u.requestConfirmation(ctx, roomID, description.String(), func(ctx context.Context) {
+        result, err := u.bridge.PurgeGuest(ctx, query, redact)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to purge guest data: %v", err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Purged %d conversations and %d email threads.", len(result.Conversations), len(result.EmailThreads)))
+    })
+}
@@ -0,0 +1,40 @@
+package bridge
+
+import (
+    "strings"
+    "time"
+)
+
+// inQuietHours reports whether it's currently within the configured quiet
+// hours, and if so, when they end.
+func (b *Bridge) inQuietHours() (time.Time, bool) {
+    quiet := b.Config().QuietHours
+    if !quiet.Enable {
+        return time.Time{}, false
+    }
+    now := time.Now().In(b.location())
+    if !isNight(now, quiet.Start, quiet.End) {
+        return time.Time{}, false
+    }
+
+    end, err := time.ParseInLocation(dateLayout+" 15:04", now.Format(dateLayout)+" "+quiet.End, now.Location())
+    if err != nil {
+        return time.Time{}, false
+    }
+    if !end.After(now) {
+        end = end.AddDate(0, 0, 1)
+    }
+    return end, true
+}
+
+// isUrgent reports whether a guest message contains one of the configured
+// urgent keywords, which are bridged normally even during quiet hours.
+func (b *Bridge) isUrgent(message string) bool {
+    lower := strings.ToLower(message)
+    for _, keyword := range b.Config().QuietHours.UrgentKeywords {
+        if strings.Contains(lower, strings.ToLower(keyword)) {
+            return true
+        }
+    }
+    return false
+}
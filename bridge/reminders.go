@@ -0,0 +1,144 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "text/template"
+    "time"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/config"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// reminderRefreshInterval is how often the reservations are fetched again
+// for the reminder scheduler. Reminders themselves are checked every minute.
+const reminderRefreshInterval = 15 * time.Minute
+
+type reminder struct {
+    rule     config.ReminderRule
+    template *template.Template
+}
+
+// reminderData is what reminder templates are rendered with.
+type reminderData struct {
+    hostexapi.Reservation
+    Date string
+    Time string
+}
+
+func parseReminders(rules []config.ReminderRule) ([]reminder, error) {
+    reminders := make([]reminder, len(rules))
+    for i, rule := range rules {
+        tmpl, err := template.New(fmt.Sprintf("reminder%d", i)).Parse(rule.Template)
+        if err != nil {
+            return nil, fmt.Errorf("invalid template of %s reminder %d: %w", rule.Event, i+1, err)
+        }
+        reminders[i] = reminder{rule: rule, template: tmpl}
+    }
+    return reminders, nil
+}
+
+func (b *Bridge) startReminders(reminders []reminder) {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+
+    var reservations []hostexapi.Reservation
+    var fetchedAt time.Time
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            if !b.IsLeader() {
+                continue
+            }
+            if time.Since(fetchedAt) > reminderRefreshInterval {
+                fetched, err := b.upcomingReservations(b.ctx)
+                if errors.Is(err, hostexapi.ErrRateLimited) {
+                    b.Logger.Warn("Skipping reminder refresh while rate limited by Hostex", zap.Error(err))
+                } else if err != nil {
+                    b.Logger.Error("Failed to get reservations for reminders", zap.Error(err))
+                } else {
+                    reservations = fetched
+                    fetchedAt = time.Now()
+                }
+            }
+            b.sendDueReminders(b.ctx, reminders, reservations)
+        }
+    }
+}
+
+// upcomingReservations returns the reservations that check in or out within
+// the longest reminder offset, plus a day of margin.
+func (b *Bridge) upcomingReservations(ctx context.Context) ([]hostexapi.Reservation, error) {
+    var horizon time.Duration
+    for _, rule := range b.Config().Reminders.Rules {
+        if rule.Offset > horizon {
+            horizon = rule.Offset
+        }
+    }
+    now := time.Now().In(b.location())
+    // Start a month back so check-outs of ongoing stays are included
+    start := now.AddDate(0, -1, 0).Format(dateLayout)
+    end := now.Add(horizon).AddDate(0, 0, 1).Format(dateLayout)
+    return b.getAllReservations(ctx, start, end)
+}
+
+// reminderTime returns when the check-in or check-out of the reservation
+// happens, in the bridge timezone.
+func (b *Bridge) reminderTime(event string, res hostexapi.Reservation) (time.Time, error) {
+    date, timeOfDay := res.CheckInDate, b.Config().Reminders.CheckInTime
+    if event == "check_out" {
+        date, timeOfDay = res.CheckOutDate, b.Config().Reminders.CheckOutTime
+    }
+    return time.ParseInLocation(dateLayout+" 15:04", date+" "+timeOfDay, b.location())
+}
+
+func (b *Bridge) sendDueReminders(ctx context.Context, reminders []reminder, reservations []hostexapi.Reservation) {
+    now := time.Now()
+    for _, res := range reservations {
+        if stage := reservationStage(res.Status); stage == reservationStatusCancelled || isPendingStage(stage) {
+            continue
+        }
+        for _, rem := range reminders {
+            at, err := b.reminderTime(rem.rule.Event, res)
+            if err != nil || now.Before(at.Add(-rem.rule.Offset)) || !now.Before(at) {
+                continue
+            }
+
+            sent, err := b.DB.IsReminderSent(res.ReservationCode, rem.rule.Event, rem.rule.Offset)
+            if err != nil {
+                b.Logger.Error("Failed to check reminder", zap.Error(err))
+                continue
+            } else if sent {
+                continue
+            }
+
+            var text strings.Builder
+            err = rem.template.Execute(&text, reminderData{
+                Reservation: res,
+                Date:        at.Format("Monday, January 2"),
+                Time:        at.Format("15:04"),
+            })
+            if err != nil {
+                b.Logger.Error("Failed to render reminder", zap.Error(err))
+                continue
+            }
+
+            b.sendManagementNotice(ctx, text.String())
+            if portal, ok := b.getPortalByID(res.ConversationID); ok && portal.RoomID != "" {
+                portal.sendNotice(ctx, text.String())
+            }
+            err = b.DB.SetReminderSent(res.ReservationCode, rem.rule.Event, rem.rule.Offset)
+            if err != nil {
+                b.Logger.Error("Failed to store sent reminder", zap.Error(err))
+            }
+        }
+    }
+}
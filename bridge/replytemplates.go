@@ -0,0 +1,214 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "text/template"
+    "time"
+    "unicode"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+// replyTemplateData is what reply templates are rendered with.
+type replyTemplateData struct {
+    GuestName string
+    Property  string
+    Channel   string
+    CheckIn   string
+    CheckOut  string
+    Language  string
+}
+
+// friendlyDate formats a YYYY-MM-DD date like "Monday, January 2", or returns
+// it as is if it can't be parsed.
+func friendlyDate(date string) string {
+    parsed, err := time.Parse(dateLayout, date)
+    if err != nil {
+        return date
+    }
+    return parsed.Format("Monday, January 2")
+}
+
+func renderReplyTemplate(content string, data replyTemplateData) (string, error) {
+    tmpl, err := template.New("reply").Parse(content)
+    if err != nil {
+        return "", err
+    }
+    var text strings.Builder
+    err = tmpl.Execute(&text, data)
+    if err != nil {
+        return "", err
+    }
+    return text.String(), nil
+}
+
+func (p *Portal) replyTemplateData() replyTemplateData {
+    return replyTemplateData{
+        GuestName: p.Info.Guest.Name,
+        Property:  p.Info.PropertyTitle,
+        Channel:   p.Info.ChannelType,
+        CheckIn:   friendlyDate(p.Info.CheckInDate),
+        CheckOut:  friendlyDate(p.Info.CheckOutDate),
+        Language:  p.guestLanguage(),
+    }
+}
+
+// commandText returns the text of a command after its first skip words,
+// keeping its line breaks.
+func commandText(body string, skip int) string {
+    rest := strings.TrimSpace(body)
+    for i := 0; i < skip; i++ {
+        end := strings.IndexFunc(rest, unicode.IsSpace)
+        if end < 0 {
+            return ""
+        }
+        rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+    }
+    return rest
+}
+
+func (b *Bridge) listReplyTemplates() string {
+    templates, err := b.DB.GetReplyTemplates()
+    if err != nil {
+        b.Logger.Error("Failed to get reply templates", zap.Error(err))
+        return fmt.Sprintf("Failed to get templates: %v", err)
+    }
+    if len(templates) == 0 {
+        return "No templates yet. Add one with !template add <name> <text>."
+    }
+    var list strings.Builder
+    list.WriteString("Templates:\n")
+    for _, tpl := range templates {
+        list.WriteString(fmt.Sprintf("- %s: %s\n", tpl.Name, truncate(strings.ReplaceAll(tpl.Content, "\n", " "), 80)))
+    }
+    list.WriteString("Send one with !template <name> in a portal room.")
+    return list.String()
+}
+
+// getReplyTemplate returns the template with the name, preferring the
+// variant in the guest's language, named like "checkin.es", if there is one.
+func (p *Portal) getReplyTemplate(name string) (*database.ReplyTemplate, error) {
+    name = strings.ToLower(name)
+    if language := baseLanguage(p.guestLanguage()); language != "" {
+        tpl, err := p.bridge.DB.GetReplyTemplate(name + "." + language)
+        if err != nil || tpl != nil {
+            return tpl, err
+        }
+    }
+    return p.bridge.DB.GetReplyTemplate(name)
+}
+
+// sendReplyTemplate renders a reply template for the portal's guest and sends
+// it on behalf of sender.
+func (p *Portal) sendReplyTemplate(ctx context.Context, sender id.UserID, name string) error {
+    tpl, err := p.getReplyTemplate(name)
+    if err != nil {
+        return fmt.Errorf("failed to get template: %w", err)
+    } else if tpl == nil {
+        return fmt.Errorf("no template named %q, see !template list", name)
+    }
+    text, err := renderReplyTemplate(tpl.Content, p.replyTemplateData())
+    if err != nil {
+        return fmt.Errorf("failed to render template: %w", err)
+    }
+    return p.sendBridgeMessage(ctx, sender, text)
+}
+
+func (u *User) handleTemplateCommand(ctx context.Context, roomID id.RoomID, args []string, body string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !template <add|list|remove|send> ...")
+        return
+    }
+
+    switch strings.ToLower(args[0]) {
+    case "list":
+        u.sendNotice(ctx, roomID, u.bridge.listReplyTemplates())
+    case "add":
+        content := commandText(body, 3)
+        if len(args) < 3 || content == "" {
+            u.sendNotice(ctx, roomID, "Usage: !template add <name> <text>. The text can use {{.GuestName}}, {{.Property}}, {{.Channel}}, {{.CheckIn}}, {{.CheckOut}} and {{.Language}}. Name a template like <name>.es to send it instead of <name> to guests writing in Spanish.")
+            return
+        }
+        name := strings.ToLower(args[1])
+        // Catch typos in variable names before the template is used
+        _, err := renderReplyTemplate(content, replyTemplateData{})
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Invalid template: %v", err))
+            return
+        }
+        err = u.bridge.DB.StoreReplyTemplate(&database.ReplyTemplate{
+            Name:      name,
+            Content:   content,
+            CreatedBy: u.MXID,
+            UpdatedAt: time.Now(),
+        })
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to save template: %v", err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Saved template %q. Send it with !template %s in a portal room.", name, name))
+    case "remove":
+        if len(args) < 2 {
+            u.sendNotice(ctx, roomID, "Usage: !template remove <name>")
+            return
+        }
+        name := strings.ToLower(args[1])
+        deleted, err := u.bridge.DB.DeleteReplyTemplate(name)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to remove template: %v", err))
+        } else if !deleted {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("No template named %q.", name))
+        } else {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Removed template %q.", name))
+        }
+    case "send":
+        if len(args) < 3 {
+            u.sendNotice(ctx, roomID, "Usage: !template send <name> <conversation ID|room ID|guest name>")
+            return
+        }
+        query := strings.Join(args[2:], " ")
+        portal := u.bridge.findPortal(query)
+        if portal == nil || portal.RoomID == "" {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("No bridged conversation found for %q.", query))
+            return
+        }
+        err := portal.sendReplyTemplate(ctx, u.MXID, args[1])
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to send template: %v", err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Sent template %q to %s.", strings.ToLower(args[1]), portal.Info.Guest.Name))
+    default:
+        u.sendNotice(ctx, roomID, "Usage: !template <add|list|remove|send> ...")
+    }
+}
+
+// handleTemplateCommand sends a reply template to the guest, with !template
+// <name> as the short form of !template send <name>.
+func (p *Portal) handleTemplateCommand(ctx context.Context, sender id.UserID, args []string) {
+    if len(args) == 0 {
+        p.sendNotice(ctx, "Usage: !template <name>, or !template list")
+        return
+    }
+    name := args[0]
+    switch strings.ToLower(name) {
+    case "list":
+        p.sendNotice(ctx, p.bridge.listReplyTemplates())
+        return
+    case "send":
+        if len(args) < 2 {
+            p.sendNotice(ctx, "Usage: !template send <name>")
+            return
+        }
+        name = args[1]
+    }
+    err := p.sendReplyTemplate(ctx, sender, name)
+    if err != nil {
+        p.sendNotice(ctx, fmt.Sprintf("Failed to send template: %v", err))
+    }
+}
@@ -0,0 +1,397 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// ReservationEventMsgType is the msgtype of reservation lifecycle notices.
+// The structured event is included under the same key in the content, next
+// to the plaintext body that other clients fall back to.
+const ReservationEventMsgType event.MessageType = "com.hostex.reservation_event"
+
+const (
+    ReservationEventBooking      = "booking"
+    ReservationEventModification = "modification"
+    ReservationEventCancellation = "cancellation"
+    ReservationEventInquiry      = "inquiry"
+)
+
+const (
+    reservationStatusCancelled = "cancelled"
+    reservationStatusInquiry   = "inquiry"
+    reservationStatusRequest   = "request"
+    reservationStatusConfirmed = "confirmed"
+)
+
+// reservationStages maps the reservation statuses of the channels to the
+// stage they stand for.
+var reservationStages = map[string]string{
+    "inquiry":           reservationStatusInquiry,
+    "request":           reservationStatusRequest,
+    "request_to_book":   reservationStatusRequest,
+    "pending":           reservationStatusRequest,
+    "awaiting_approval": reservationStatusRequest,
+    "accepted":          reservationStatusConfirmed,
+    "confirmed":         reservationStatusConfirmed,
+    "booked":            reservationStatusConfirmed,
+    "checked_in":        reservationStatusConfirmed,
+    "checked_out":       reservationStatusConfirmed,
+    "completed":         reservationStatusConfirmed,
+    "cancelled":         reservationStatusCancelled,
+    "canceled":          reservationStatusCancelled,
+    "declined":          reservationStatusCancelled,
+    "expired":           reservationStatusCancelled,
+}
+
+// reservationStage returns whether a reservation status is an inquiry, a
+// booking request waiting for approval, confirmed or cancelled. Unknown
+// statuses are returned in lowercase.
+func reservationStage(status string) string {
+    status = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(status), " ", "_"))
+    if stage, ok := reservationStages[status]; ok {
+        return stage
+    }
+    return status
+}
+
+// conversionAction describes a booking that was an inquiry or request with
+// the previous status before.
+func conversionAction(previousStatus string) string {
+    if reservationStage(previousStatus) == reservationStatusRequest {
+        return "Booking request accepted"
+    }
+    return "Inquiry converted to a booking"
+}
+
+// isPendingStage reports whether a reservation stage is an inquiry or a
+// request that may still turn into a booking.
+func isPendingStage(stage string) bool {
+    return stage == reservationStatusInquiry || stage == reservationStatusRequest
+}
+
+// ReservationEvent is the content schema of reservation notices.
+type ReservationEvent struct {
+    Type            string `json:"type"`
+    ReservationCode string `json:"reservation_code"`
+    ConversationID  string `json:"conversation_id,omitempty"`
+    PropertyID      string `json:"property_id"`
+    PropertyTitle   string `json:"property_title"`
+    ChannelType     string `json:"channel_type"`
+    Status          string `json:"status"`
+    GuestName       string `json:"guest_name"`
+    NumberOfGuests  int    `json:"number_of_guests"`
+    CheckInDate     string `json:"check_in_date"`
+    CheckOutDate    string `json:"check_out_date"`
+
+    // Only set for modifications
+    PreviousCheckInDate  string `json:"previous_check_in_date,omitempty"`
+    PreviousCheckOutDate string `json:"previous_check_out_date,omitempty"`
+    // Only set for bookings that were an inquiry or request before
+    PreviousStatus string `json:"previous_status,omitempty"`
+}
+
+func newReservationEvent(eventType string, res hostexapi.Reservation) ReservationEvent {
+    return ReservationEvent{
+        Type:            eventType,
+        ReservationCode: res.ReservationCode,
+        ConversationID:  res.ConversationID,
+        PropertyID:      res.PropertyID,
+        PropertyTitle:   res.PropertyTitle,
+        ChannelType:     res.ChannelType,
+        Status:          res.Status,
+        GuestName:       res.GuestName,
+        NumberOfGuests:  res.NumberOfGuests,
+        CheckInDate:     res.CheckInDate,
+        CheckOutDate:    res.CheckOutDate,
+    }
+}
+
+func (re ReservationEvent) text() string {
+    var action string
+    switch re.Type {
+    case ReservationEventBooking:
+        action = "New booking"
+        if re.PreviousStatus != "" {
+            action = conversionAction(re.PreviousStatus)
+        }
+    case ReservationEventModification:
+        action = "Modified booking"
+    case ReservationEventCancellation:
+        action = "Cancelled booking"
+    case ReservationEventInquiry:
+        action = "New inquiry"
+    default:
+        action = "Reservation update"
+    }
+    text := fmt.Sprintf("%s: %s at %s (%s)\nStay: %s to %s, %d guests\nConfirmation code: %s",
+        action, re.GuestName, re.PropertyTitle, re.ChannelType,
+        re.CheckInDate, re.CheckOutDate, re.NumberOfGuests, re.ReservationCode)
+    if re.Type == ReservationEventModification {
+        text += fmt.Sprintf("\nPreviously: %s to %s", re.PreviousCheckInDate, re.PreviousCheckOutDate)
+    }
+    return text
+}
+
+// reservationEventType returns the lifecycle event of a reservation compared
+// to its previously seen state, or an empty string if nothing notable changed.
+func reservationEventType(previous *database.Reservation, res hostexapi.Reservation) string {
+    stage := reservationStage(res.Status)
+    cancelled := stage == reservationStatusCancelled
+    pending := isPendingStage(stage)
+    switch {
+    case previous == nil && pending:
+        return ReservationEventInquiry
+    case previous == nil && !cancelled:
+        return ReservationEventBooking
+    case previous == nil:
+        return ""
+    case cancelled && reservationStage(previous.Status) != reservationStatusCancelled:
+        return ReservationEventCancellation
+    case !pending && !cancelled && isPendingStage(reservationStage(previous.Status)):
+        return ReservationEventBooking
+    case !cancelled && (previous.CheckInDate != res.CheckInDate || previous.CheckOutDate != res.CheckOutDate):
+        return ReservationEventModification
+    default:
+        return ""
+    }
+}
+
+// notifyReservationEvent posts a lifecycle notice to the management room and,
+// if the guest's conversation is bridged, to its portal.
+func (b *Bridge) notifyReservationEvent(ctx context.Context, re ReservationEvent) {
+    b.sendReservationEvent(ctx, b.managementRoom, re)
+    if portal, ok := b.getPortalByID(re.ConversationID); ok && portal.RoomID != "" {
+        b.sendReservationEvent(ctx, portal.RoomID, re)
+    }
+}
+
+func (b *Bridge) sendReservationEvent(ctx context.Context, roomID id.RoomID, re ReservationEvent) {
+    content := &event.Content{
+        Parsed: &event.MessageEventContent{
+            MsgType: ReservationEventMsgType,
+            Body:    re.text(),
+        },
+        Raw: map[string]interface{}{
+            string(ReservationEventMsgType): re,
+        },
+    }
+    _, err := b.MatrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+    if err != nil {
+        b.Logger.Error("Failed to send reservation notice", zap.String("reservation_code", re.ReservationCode), zap.Error(err))
+    }
+}
+
+func (b *Bridge) startReservationWatcher() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(b.Config().ReservationNotices.Interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            b.checkReservations(b.ctx)
+        }
+    }
+}
+
+// checkReservations compares the current reservations with the ones seen
+// before and posts a notice for every new booking, inquiry, modification and
+// cancellation. The first check of an account only records the existing
+// reservations.
+func (b *Bridge) checkReservations(ctx context.Context) {
+    if !b.IsLeader() {
+        return
+    }
+
+    known, err := b.DB.GetReservations()
+    if err != nil {
+        b.Logger.Error("Failed to get known reservations", zap.Error(err))
+        return
+    }
+    for _, account := range b.accountNames() {
+        b.checkAccountReservations(ctx, account, known)
+    }
+}
+
+func (b *Bridge) checkAccountReservations(ctx context.Context, account string, known map[string]*database.Reservation) {
+    now := time.Now().In(b.location())
+    start := now.AddDate(0, -1, 0).Format(dateLayout)
+    end := now.AddDate(0, 0, b.Config().ReservationNotices.HorizonDays).Format(dateLayout)
+    reservations, err := b.getAccountReservations(ctx, account, start, end)
+    if errors.Is(err, hostexapi.ErrRateLimited) {
+        b.Logger.Warn("Skipping reservation check while rate limited by Hostex", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    } else if err != nil {
+        b.Logger.Error("Failed to get reservations", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    }
+
+    firstCheck, err := b.isFirstAccountCheck("reservations", account, len(known))
+    if err != nil {
+        b.Logger.Error("Failed to check if reservations were recorded before", zap.Error(err))
+        return
+    }
+    for _, res := range reservations {
+        previous, seen := known[res.ReservationCode]
+        if eventType := reservationEventType(previous, res); eventType != "" && !firstCheck {
+            re := newReservationEvent(eventType, res)
+            if eventType == ReservationEventModification {
+                re.PreviousCheckInDate = previous.CheckInDate
+                re.PreviousCheckOutDate = previous.CheckOutDate
+            } else if eventType == ReservationEventBooking && previous != nil {
+                re.PreviousStatus = previous.Status
+            }
+            b.notifyReservationEvent(ctx, re)
+        }
+
+        if seen && previous.Status == res.Status && previous.CheckInDate == res.CheckInDate && previous.CheckOutDate == res.CheckOutDate {
+            continue
+        }
+        if portal, ok := b.getPortalByID(res.ConversationID); ok && portal.RoomID != "" {
+            portal.updateReservationState(ctx, &res)
+        }
+        err = b.DB.StoreReservation(&database.Reservation{
+            Code:         res.ReservationCode,
+            HostexID:     res.ConversationID,
+            Status:       res.Status,
+            CheckInDate:  res.CheckInDate,
+            CheckOutDate: res.CheckOutDate,
+            UpdatedAt:    time.Now(),
+        })
+        if err != nil {
+            b.Logger.Error("Failed to store reservation", zap.String("reservation_code", res.ReservationCode), zap.Error(err))
+        }
+    }
+    if firstCheck {
+        err = b.markAccountChecked("reservations", account)
+        if err != nil {
+            b.Logger.Error("Failed to store reservation check", zap.Error(err))
+        }
+    }
+}
+
+// conversationReservation returns the reservation linked to the portal's
+// conversation, or nil if Hostex doesn't have one.
+func (p *Portal) conversationReservation(ctx context.Context) (*hostexapi.Reservation, error) {
+    start, end := p.Info.CheckInDate, p.Info.CheckInDate
+    if start == "" {
+        now := time.Now()
+        start = now.AddDate(-1, 0, 0).Format(dateLayout)
+        end = now.AddDate(1, 0, 0).Format(dateLayout)
+    }
+    reservations, err := p.client().GetReservations(ctx, start, end)
+    if err != nil {
+        return nil, err
+    }
+    for _, res := range reservations {
+        if res.ConversationID == p.conversationID() {
+            return &res, nil
+        }
+    }
+    return nil, nil
+}
+
+func formatReservation(res *hostexapi.Reservation) string {
+    nights := "?"
+    checkIn, err1 := time.Parse(dateLayout, res.CheckInDate)
+    checkOut, err2 := time.Parse(dateLayout, res.CheckOutDate)
+    if err1 == nil && err2 == nil {
+        nights = fmt.Sprintf("%d", nightsBetween(checkIn, checkOut))
+    }
+    return fmt.Sprintf(`Reservation %s
+Guest: %s (%d guests)
+Property: %s
+Channel: %s
+Status: %s
+Stay: %s to %s (%s nights)
+Payout: %.2f %s`,
+        res.ReservationCode,
+        res.GuestName, res.NumberOfGuests,
+        res.PropertyTitle,
+        res.ChannelType,
+        res.Status,
+        res.CheckInDate, res.CheckOutDate, nights,
+        res.Payout, res.Currency)
+}
+
+// findPortal returns the portal whose conversation ID, room ID or guest name
+// matches the query.
+func (b *Bridge) findPortal(query string) *Portal {
+    if portal, ok := b.getPortalByID(query); ok {
+        return portal
+    }
+    if portal, ok := b.getPortalByMXID(id.RoomID(query)); ok {
+        return portal
+    }
+    for _, portal := range b.allPortals() {
+        if portal.Info.Guest.Name != "" && strings.EqualFold(portal.Info.Guest.Name, query) {
+            return portal
+        }
+    }
+    return nil
+}
+
+func (u *User) showReservation(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !reservation <conversation ID|guest name|reservation code>")
+        return
+    }
+    query := strings.Join(args, " ")
+
+    var res *hostexapi.Reservation
+    var err error
+    if portal := u.bridge.findPortal(query); portal != nil {
+        res, err = portal.conversationReservation(ctx)
+    } else {
+        res, err = u.bridge.getReservation(ctx, query)
+    }
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get reservation: %v", err))
+        return
+    } else if res == nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No reservation found for %q.", query))
+        return
+    }
+    u.sendNotice(ctx, roomID, formatReservation(res))
+}
+
+func (p *Portal) showReservation(ctx context.Context) {
+    res, err := p.conversationReservation(ctx)
+    if err != nil {
+        p.sendNotice(ctx, fmt.Sprintf("Failed to get reservation: %v", err))
+        return
+    } else if res == nil {
+        p.sendNotice(ctx, "This conversation has no linked reservation.")
+        return
+    }
+    p.sendNotice(ctx, formatReservation(res))
+}
+
+// checkConversion tells the management room when the portal's inquiry or
+// booking request turned into a confirmed booking. With reservation notices,
+// the reservation watcher reports it instead.
+func (p *Portal) checkConversion(ctx context.Context, previous hostexapi.Conversation) {
+    if p.bridge.Config().ReservationNotices.Enable {
+        return
+    }
+    stage := reservationStage(p.Info.ReservationStatus)
+    if stage != reservationStatusConfirmed || !isPendingStage(reservationStage(previous.ReservationStatus)) {
+        return
+    }
+    p.bridge.sendManagementNotice(ctx, fmt.Sprintf("%s: %s at %s (%s)\nStay: %s to %s",
+        conversionAction(previous.ReservationStatus), p.Info.Guest.Name, p.Info.PropertyTitle, p.Info.ChannelType, p.Info.CheckInDate, p.Info.CheckOutDate))
+}
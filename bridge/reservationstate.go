@@ -0,0 +1,81 @@
+package bridge
+
+import (
+    "context"
+
+    "maunium.net/go/mautrix/event"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// StateHostexReservation is the portal room state with the details of the
+// conversation's reservation, for dashboards built from room state.
+var StateHostexReservation = event.Type{Type: "com.hostex.reservation", Class: event.StateEventType}
+
+type reservationStateContent struct {
+    ReservationCode string  `json:"reservation_code,omitempty"`
+    PropertyID      string  `json:"property_id,omitempty"`
+    PropertyTitle   string  `json:"property_title"`
+    Status          string  `json:"status"`
+    CheckInDate     string  `json:"check_in_date"`
+    CheckOutDate    string  `json:"check_out_date"`
+    NumberOfGuests  int     `json:"number_of_guests,omitempty"`
+    Payout          float64 `json:"payout,omitempty"`
+    Currency        string  `json:"currency,omitempty"`
+}
+
+// reservationState returns the reservation state of the portal room. Without
+// a reservation, only the stay details of the conversation are known.
+func (p *Portal) reservationState(res *hostexapi.Reservation) *reservationStateContent {
+    if res == nil {
+        return &reservationStateContent{
+            PropertyTitle: p.Info.PropertyTitle,
+            Status:        p.Info.ReservationStatus,
+            CheckInDate:   p.Info.CheckInDate,
+            CheckOutDate:  p.Info.CheckOutDate,
+        }
+    }
+    return &reservationStateContent{
+        ReservationCode: res.ReservationCode,
+        PropertyID:      res.PropertyID,
+        PropertyTitle:   res.PropertyTitle,
+        Status:          res.Status,
+        CheckInDate:     res.CheckInDate,
+        CheckOutDate:    res.CheckOutDate,
+        NumberOfGuests:  res.NumberOfGuests,
+        Payout:          res.Payout,
+        Currency:        res.Currency,
+    }
+}
+
+// refreshReservationState fetches the conversation's reservation and updates
+// the reservation state with it.
+func (p *Portal) refreshReservationState(ctx context.Context) {
+    res, err := p.conversationReservation(ctx)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to get reservation for reservation state", zap.String("hostex_id", p.ID), zap.Error(err))
+        return
+    }
+    p.updateReservationState(ctx, res)
+}
+
+// updateReservationState sends the reservation state to the portal room if it
+// changed since it was last sent.
+func (p *Portal) updateReservationState(ctx context.Context, res *hostexapi.Reservation) {
+    content := p.reservationState(res)
+    hash := stateHash(content)
+    if hash == p.reservationStateHash {
+        return
+    }
+    _, err := p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, StateHostexReservation, "", content)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to update reservation state", zap.String("room_id", p.RoomID.String()), zap.Error(err))
+        return
+    }
+    err = p.bridge.DB.SetPortalReservationStateHash(p.ID, hash)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store reservation state hash", zap.Error(err))
+    }
+    p.reservationStateHash = hash
+}
@@ -0,0 +1,124 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+func (u *User) resyncPortal(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !resync <conversation ID|room ID|guest name> [since YYYY-MM-DD]")
+        return
+    }
+
+    var since time.Time
+    if len(args) > 1 {
+        parsed, err := time.ParseInLocation(dateLayout, args[len(args)-1], u.bridge.location())
+        if err == nil {
+            since = parsed
+            args = args[:len(args)-1]
+        }
+    }
+    query := strings.Join(args, " ")
+    portal := u.bridge.findPortal(query)
+    if portal == nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No portal found for %q.", query))
+        return
+    }
+
+    started := u.bridge.goTask(func() {
+        ctx := hostexapi.WithPriority(ctx, hostexapi.PriorityBackfill)
+        err := portal.resync(ctx, since)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to resync %s: %v", portal.Info.Guest.Name, err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Resynced the conversation with %s.", portal.Info.Guest.Name))
+    })
+    if !started {
+        u.sendNotice(ctx, roomID, "The bridge is shutting down, try again after the restart.")
+        return
+    }
+    u.sendNotice(ctx, roomID, fmt.Sprintf("Resyncing the conversation with %s...", portal.Info.Guest.Name))
+}
+
+// resync re-fetches the conversation from Hostex, refreshes the room name,
+// topic and avatar, fixes the stored room mapping and backfills the messages
+// since the given time that are missing from the room.
+func (p *Portal) resync(ctx context.Context, since time.Time) error {
+    conversations, err := p.client().GetConversations(ctx)
+    if err != nil {
+        return fmt.Errorf("failed to get conversations: %w", err)
+    }
+    for _, conv := range conversations {
+        if conv.ID == p.conversationID() {
+            conv.ID = p.ID
+            p.Info = conv
+            break
+        }
+    }
+
+    if p.RoomID == "" {
+        p.bridge.clearPortalRetry(p.ID)
+        err = p.CreateMatrixRoom(ctx)
+        if err != nil {
+            return err
+        }
+    }
+    err = p.reconcileMapping()
+    if err != nil {
+        return err
+    }
+
+    name := p.roomName()
+    if p.archived {
+        name = p.bridge.Config().PortalArchive.Prefix + name
+    }
+    _, err = p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, event.StateRoomName, "", &event.RoomNameEventContent{Name: name})
+    if err != nil {
+        return fmt.Errorf("failed to update room name: %w", err)
+    }
+    p.name = p.roomName()
+    err = p.bridge.DB.SetPortalName(p.ID, p.name)
+    if err != nil {
+        return fmt.Errorf("failed to store room name: %w", err)
+    }
+    p.topic, p.avatar, p.guestStateHash, p.reservationStateHash = "", "", "", ""
+    p.updateTopic(ctx)
+    p.updateAvatar(ctx)
+    p.updateGuestState(ctx)
+    if p.ghost != nil {
+        p.ghost.displayname = ""
+        p.updateGhostProfile(ctx)
+    }
+    p.refreshReservationState(ctx)
+    p.syncRoomTags(ctx)
+    p.updateBridgeInfo(ctx, true)
+
+    return p.backfillSince(ctx, since)
+}
+
+// reconcileMapping makes sure the database, the Redis cache and the
+// in-memory maps all point to the portal's current room.
+func (p *Portal) reconcileMapping() error {
+    storedRoomID, err := p.bridge.DB.GetPortal(p.ID)
+    if err != nil {
+        return err
+    }
+    if storedRoomID != p.RoomID {
+        err = p.bridge.DB.StorePortal(p.ID, p.RoomID, p.roomName(), p.topic, p.avatar, false)
+        if err != nil {
+            return fmt.Errorf("failed to store portal: %w", err)
+        }
+    }
+    p.cacheState()
+    p.bridge.setPortalRoom(p)
+    return nil
+}
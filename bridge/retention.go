@@ -0,0 +1,53 @@
+package bridge
+
+import (
+    "context"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "go.uber.org/zap"
+)
+
+// pruneMessages deletes messages past the retention period, after redacting
+// them in their portal rooms if configured.
+func (b *Bridge) pruneMessages(ctx context.Context) {
+    before := time.Now().AddDate(0, 0, -b.Config().Retention.AfterDays)
+
+    if b.Config().Retention.RedactMatrix {
+        messages, err := b.DB.GetMessagesBetween("", time.Time{}, before)
+        if err != nil {
+            b.Logger.Error("Failed to get messages to redact", zap.Error(err))
+            return
+        }
+        var redacted int
+        for _, msg := range messages {
+            if ctx.Err() != nil {
+                return
+            }
+            // Messages kept to continue backfill from were cleared and
+            // redacted by an earlier run
+            portal, ok := b.getPortalByID(msg.HostexID)
+            if !ok || portal.RoomID == "" || msg.MatrixEventID == "" || msg.Content == "" {
+                continue
+            }
+            _, err = b.MatrixClient.RedactEvent(ctx, portal.RoomID, msg.MatrixEventID, mautrix.ReqRedact{Reason: "Retention period expired"})
+            if err != nil {
+                b.Logger.Warn("Failed to redact expired message", zap.String("event_id", msg.MatrixEventID.String()), zap.Error(err))
+                continue
+            }
+            redacted++
+        }
+        if redacted > 0 {
+            b.Logger.Info("Redacted expired messages", zap.Int("count", redacted))
+        }
+    }
+
+    pruned, err := b.DB.PruneMessages(before)
+    if err != nil {
+        b.Logger.Error("Failed to delete expired messages", zap.Error(err))
+        return
+    }
+    if pruned > 0 {
+        b.Logger.Info("Deleted expired messages", zap.Int64("count", pruned), zap.Time("before", before))
+    }
+}
@@ -0,0 +1,185 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+func (b *Bridge) createOrFindReviewsRoom(ctx context.Context) (id.RoomID, error) {
+    roomID, err := b.findRoomByName(ctx, "Hostex Reviews")
+    if err != nil || roomID != "" {
+        return roomID, err
+    }
+
+    resp, err := b.MatrixClient.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+        Visibility: "private",
+        Name:       "Hostex Reviews",
+        Topic:      "Guest reviews from all channels. Use !review <reservation code> <reply> to respond.",
+        Invite:     b.managementInvites(),
+    })
+    if err != nil {
+        return "", err
+    }
+    return resp.RoomID, nil
+}
+
+func (b *Bridge) startReviewWatcher() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(b.Config().Reviews.Interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            b.checkReviews(b.ctx)
+        }
+    }
+}
+
+// checkReviews posts reviews that haven't been seen before. The first check
+// only records the existing reviews.
+func (b *Bridge) checkReviews(ctx context.Context) {
+    if !b.IsLeader() {
+        return
+    }
+
+    known, err := b.DB.CountNotifiedReviews()
+    if err != nil {
+        b.Logger.Error("Failed to count known reviews", zap.Error(err))
+        return
+    }
+    for _, account := range b.accountNames() {
+        b.checkAccountReviews(ctx, account, known)
+    }
+}
+
+func (b *Bridge) checkAccountReviews(ctx context.Context, account string, known int) {
+    firstCheck, err := b.isFirstAccountCheck("reviews", account, known)
+    if err != nil {
+        b.Logger.Error("Failed to check if reviews were recorded before", zap.Error(err))
+        return
+    }
+
+    now := time.Now().In(b.location())
+    reviews, err := b.hostexClient(account).GetReviews(ctx, now.AddDate(0, 0, -30).Format(dateLayout), now.Format(dateLayout))
+    if errors.Is(err, hostexapi.ErrRateLimited) {
+        b.Logger.Warn("Skipping review check while rate limited by Hostex", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    } else if err != nil {
+        b.Logger.Error("Failed to get reviews", zap.String("account", accountLabel(account)), zap.Error(err))
+        return
+    }
+
+    for _, review := range reviews {
+        notified, err := b.DB.IsReviewNotified(review.ReservationCode)
+        if err != nil {
+            b.Logger.Error("Failed to check review", zap.Error(err))
+            continue
+        } else if notified {
+            // Hostex may only add the rating later
+            if review.Rating != nil {
+                err = b.DB.SetReviewRating(review.ReservationCode, *review.Rating)
+                if err != nil {
+                    b.Logger.Error("Failed to store review rating", zap.Error(err))
+                }
+            }
+            continue
+        }
+        if !firstCheck {
+            b.postReview(ctx, account, review)
+        }
+        err = b.DB.SetReviewNotified(review.ReservationCode, review.Rating)
+        if err != nil {
+            b.Logger.Error("Failed to store review", zap.Error(err))
+        }
+    }
+    if firstCheck {
+        err = b.markAccountChecked("reviews", account)
+        if err != nil {
+            b.Logger.Error("Failed to store review check", zap.Error(err))
+        }
+    }
+}
+
+func formatReview(review hostexapi.Review) string {
+    rating := "no rating"
+    if review.Rating != nil {
+        rating = fmt.Sprintf("%.1f/5", *review.Rating)
+    }
+    text := fmt.Sprintf("New %s review from %s at %s: %s\n\n%s\n\n",
+        review.ChannelType, review.GuestName, review.PropertyTitle, rating, review.Content)
+    if review.HostReply != "" {
+        return text + "Your reply: " + review.HostReply
+    }
+    return text + fmt.Sprintf("Reply with !review %s <reply>", review.ReservationCode)
+}
+
+// postReview posts a review to the Reviews room if it's enabled, or to the
+// portal of the guest's conversation, falling back to the management room.
+func (b *Bridge) postReview(ctx context.Context, account string, review hostexapi.Review) {
+    roomID := b.reviewsRoom
+    if roomID == "" {
+        roomID = b.managementRoom
+        if portal := b.reviewPortal(ctx, account, review.ReservationCode); portal != nil {
+            roomID = portal.RoomID
+        }
+    }
+    b.sendNotice(ctx, roomID, formatReview(review))
+}
+
+func (b *Bridge) reviewPortal(ctx context.Context, account, reservationCode string) *Portal {
+    hostexID, err := b.DB.GetReservationConversation(reservationCode)
+    if err != nil {
+        b.Logger.Warn("Failed to get reservation conversation", zap.Error(err))
+    }
+    if hostexID == "" {
+        res, err := b.hostexClient(account).GetReservation(ctx, reservationCode)
+        if err != nil {
+            b.Logger.Warn("Failed to get reservation of review", zap.Error(err))
+            return nil
+        } else if res == nil {
+            return nil
+        }
+        hostexID = portalKey(account, res.ConversationID)
+    }
+    portal, ok := b.getPortalByID(hostexID)
+    if !ok || portal.RoomID == "" {
+        return nil
+    }
+    return portal
+}
+
+func (u *User) replyToReview(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) < 2 {
+        u.sendNotice(ctx, roomID, "Usage: !review <reservation code> <reply>")
+        return
+    }
+    code := args[0]
+    reply := strings.Join(args[1:], " ")
+
+    u.requestConfirmation(ctx, roomID, fmt.Sprintf("Publish this public reply to the review of %s?\n\n%s", code, reply), func(ctx context.Context) {
+        account, err := u.bridge.reservationAccount(code)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to find the account of the reservation: %v", err))
+            return
+        }
+        err = u.bridge.hostexClient(account).ReplyToReview(ctx, code, reply)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to reply to review: %v", err))
+            return
+        }
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Replied to the review of %s.", code))
+    })
+}
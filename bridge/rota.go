@@ -0,0 +1,105 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/config"
+)
+
+// onDuty returns the rota shift active at the given time, or nil if nobody
+// is on duty.
+func (b *Bridge) onDuty(now time.Time) *config.RotaShift {
+    now = now.In(b.location())
+    clock := now.Format("15:04")
+    for i, shift := range b.Config().Rota.Shifts {
+        if !isNight(now, shift.Start, shift.End) {
+            continue
+        }
+        day := now.Weekday()
+        if shift.Start > shift.End && clock < shift.End {
+            // The shift started the day before
+            day = now.AddDate(0, 0, -1).Weekday()
+        }
+        if shiftOnDay(shift, day) {
+            return &b.Config().Rota.Shifts[i]
+        }
+    }
+    return nil
+}
+
+func shiftOnDay(shift config.RotaShift, day time.Weekday) bool {
+    if len(shift.Days) == 0 {
+        return true
+    }
+    for _, name := range shift.Days {
+        if config.Weekdays[strings.ToLower(name)] == day {
+            return true
+        }
+    }
+    return false
+}
+
+// alertRecipient returns the user who should be alerted right now: the one
+// on duty, or the admin if nobody is.
+func (b *Bridge) alertRecipient() id.UserID {
+    if shift := b.onDuty(time.Now()); shift != nil {
+        return shift.UserID
+    }
+    return b.Config().Admin.UserID
+}
+
+// attribution returns the prefix of replies sent by the user, or an empty
+// string if attribution is disabled or the user isn't on the rota.
+func (b *Bridge) attribution(sender id.UserID) string {
+    if !b.Config().Rota.Attribution {
+        return ""
+    }
+    for _, shift := range b.Config().Rota.Shifts {
+        if shift.UserID == sender {
+            return fmt.Sprintf("[%s] ", shift.Name)
+        }
+    }
+    return ""
+}
+
+func (u *User) showRota(ctx context.Context, roomID id.RoomID) {
+    if len(u.bridge.Config().Rota.Shifts) == 0 {
+        u.sendNotice(ctx, roomID, "No rota is configured. Alerts go to "+u.bridge.Config().Admin.UserID.String())
+        return
+    }
+
+    var rota strings.Builder
+    rota.WriteString("Rota:\n")
+    for _, shift := range u.bridge.Config().Rota.Shifts {
+        days := "every day"
+        if len(shift.Days) > 0 {
+            days = strings.Join(shift.Days, ", ")
+        }
+        rota.WriteString(fmt.Sprintf("- %s (%s): %s, %s to %s\n", shift.Name, shift.UserID, days, shift.Start, shift.End))
+    }
+    if shift := u.bridge.onDuty(time.Now()); shift != nil {
+        rota.WriteString(fmt.Sprintf("\nOn duty now: %s", shift.Name))
+    } else {
+        rota.WriteString(fmt.Sprintf("\nNobody is on duty now, alerts go to %s", u.bridge.Config().Admin.UserID))
+    }
+    u.sendNotice(ctx, roomID, rota.String())
+}
+
+// getAssignee returns the user the unanswered conversation is assigned to.
+// The assignee is set by the poller and read by commands, so it's locked.
+func (p *Portal) getAssignee() id.UserID {
+    p.assigneeLock.Lock()
+    defer p.assigneeLock.Unlock()
+    return p.assignee
+}
+
+func (p *Portal) setAssignee(userID id.UserID) {
+    p.assigneeLock.Lock()
+    defer p.assigneeLock.Unlock()
+    p.assignee = userID
+}
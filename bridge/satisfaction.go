@@ -0,0 +1,166 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "text/template"
+    "time"
+    "unicode"
+
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+const (
+    SatisfactionHappy   = "happy"
+    SatisfactionUnhappy = "unhappy"
+    SatisfactionUnclear = "unclear"
+)
+
+var (
+    satisfactionPositiveWords = []string{"yes", "yeah", "yep", "good", "great", "fine", "perfect", "ok", "okay", "lovely", "wonderful", "amazing", "excellent", "everything is okay", "all good", "👍"}
+    satisfactionNegativeWords = []string{"not", "no", "problem", "issue", "broken", "dirty", "missing", "cold", "noisy", "noise", "doesn't", "isn't", "can't", "wrong", "bad", "leak", "smell"}
+)
+
+// classifySatisfaction turns a guest's reply to the satisfaction question
+// into a flag. Any sign of a problem wins over positive words, as a missed
+// complaint is worse than a false alarm.
+func classifySatisfaction(reply string) string {
+    lower := strings.ToLower(reply)
+    words := make(map[string]bool)
+    for _, word := range strings.FieldsFunc(lower, func(r rune) bool {
+        return !unicode.IsLetter(r) && r != '\''
+    }) {
+        words[word] = true
+    }
+    // Single words must match a whole word, so "no" doesn't match "know",
+    // while phrases and emoji match anywhere in the reply.
+    contains := func(list []string) bool {
+        for _, phrase := range list {
+            if words[phrase] || (strings.IndexFunc(phrase, unicode.IsLetter) != 0 || strings.Contains(phrase, " ")) && strings.Contains(lower, phrase) {
+                return true
+            }
+        }
+        return false
+    }
+    switch {
+    case contains(satisfactionNegativeWords):
+        return SatisfactionUnhappy
+    case contains(satisfactionPositiveWords):
+        return SatisfactionHappy
+    default:
+        return SatisfactionUnclear
+    }
+}
+
+// midStayDate returns the day halfway through the portal's stay, or an empty
+// string if the stay is too short for a mid-stay question.
+func (p *Portal) midStayDate() string {
+    checkIn, err := time.Parse(dateLayout, p.Info.CheckInDate)
+    if err != nil {
+        return ""
+    }
+    checkOut, err := time.Parse(dateLayout, p.Info.CheckOutDate)
+    if err != nil {
+        return ""
+    }
+    nights := nightsBetween(checkIn, checkOut)
+    if nights < 2 {
+        return ""
+    }
+    return checkIn.AddDate(0, 0, nights/2).Format(dateLayout)
+}
+
+// sendSatisfactionPulses asks every guest who is halfway through their stay
+// today whether everything is okay.
+func (b *Bridge) sendSatisfactionPulses(ctx context.Context) {
+    tmpl, err := template.New("satisfaction").Parse(b.Config().SatisfactionPulse.Message)
+    if err != nil {
+        b.Logger.Error("Invalid satisfaction pulse message template", zap.Error(err))
+        return
+    }
+
+    today := time.Now().In(b.location()).Format(dateLayout)
+    for _, portal := range b.allPortals() {
+        if portal.RoomID == "" || portal.midStayDate() != today {
+            continue
+        }
+        existing, err := b.DB.GetSatisfaction(portal.ID, portal.Info.CheckInDate)
+        if err != nil {
+            b.Logger.Error("Failed to get satisfaction", zap.Error(err))
+            continue
+        } else if existing != nil {
+            continue
+        }
+
+        var message strings.Builder
+        err = tmpl.Execute(&message, portal.Info)
+        if err != nil {
+            b.Logger.Error("Failed to render satisfaction pulse message", zap.Error(err))
+            return
+        }
+        err = portal.sendAutomatedMessage(ctx, message.String())
+        if err != nil {
+            b.Logger.Error("Failed to send satisfaction pulse", zap.String("hostex_id", portal.ID), zap.Error(err))
+            continue
+        }
+        err = b.DB.StoreSatisfaction(&database.Satisfaction{
+            HostexID:    portal.ID,
+            CheckInDate: portal.Info.CheckInDate,
+            AskedAt:     time.Now(),
+        })
+        if err != nil {
+            b.Logger.Error("Failed to store satisfaction pulse", zap.Error(err))
+        }
+    }
+}
+
+// handleSatisfactionReply records the first guest message after the
+// satisfaction question as the answer, and alerts the management room if the
+// guest seems unhappy.
+func (p *Portal) handleSatisfactionReply(ctx context.Context, reply string) {
+    satisfaction, err := p.bridge.DB.GetSatisfaction(p.ID, p.Info.CheckInDate)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to get satisfaction", zap.Error(err))
+        return
+    } else if satisfaction == nil || satisfaction.Flag != "" {
+        return
+    }
+
+    satisfaction.Flag = classifySatisfaction(reply)
+    satisfaction.Response = reply
+    err = p.bridge.DB.StoreSatisfaction(satisfaction)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store satisfaction", zap.Error(err))
+        return
+    }
+    if satisfaction.Flag == SatisfactionUnhappy {
+        p.bridge.sendManagementNotice(ctx, fmt.Sprintf("⚠️ %s at %s may be unhappy with their stay: %s", p.Info.Guest.Name, p.Info.PropertyTitle, reply))
+    }
+}
+
+// buildSatisfactionDigest lists the satisfaction of guests currently staying.
+func (b *Bridge) buildSatisfactionDigest() string {
+    now := time.Now().In(b.location())
+    var digest strings.Builder
+    for _, portal := range b.allPortals() {
+        if portal.RoomID == "" || !portal.isCurrentGuest(now) {
+            continue
+        }
+        satisfaction, err := b.DB.GetSatisfaction(portal.ID, portal.Info.CheckInDate)
+        if err != nil || satisfaction == nil {
+            continue
+        }
+        flag := satisfaction.Flag
+        if flag == "" {
+            flag = "no reply yet"
+        }
+        digest.WriteString(fmt.Sprintf("- %s at %s: %s\n", portal.Info.Guest.Name, portal.Info.PropertyTitle, flag))
+    }
+    if digest.Len() == 0 {
+        return "Guest satisfaction: no mid-stay check-ins for current guests.\n"
+    }
+    return "Guest satisfaction:\n" + digest.String()
+}
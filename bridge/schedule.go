@@ -0,0 +1,251 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+const (
+    scheduleInterval = 30 * time.Second
+    // maxScheduleDelay is how late a scheduled message that keeps failing
+    // to send may still be sent before it's dropped.
+    maxScheduleDelay = time.Hour
+    scheduleLayout   = "Mon Jan 2 15:04"
+)
+
+var clockLayouts = []string{"15:04", "3pm", "3:04pm"}
+
+func parseClock(value string) (hour, minute int, ok bool) {
+    for _, layout := range clockLayouts {
+        parsed, err := time.Parse(layout, strings.ToLower(value))
+        if err == nil {
+            return parsed.Hour(), parsed.Minute(), true
+        }
+    }
+    return 0, 0, false
+}
+
+// parseScheduleTime parses when a scheduled message is sent: an offset like
+// 2h or +30m, a time like 15:00 or 3pm (the next one), a date and time like
+// 2024-06-01T15:00, or checkin@15:00 and checkout@10am for the stay dates of
+// the portal.
+func (p *Portal) parseScheduleTime(value string, now time.Time) (time.Time, error) {
+    loc := p.bridge.location()
+    now = now.In(loc)
+
+    if offset, err := time.ParseDuration(strings.TrimPrefix(value, "+")); err == nil {
+        if offset <= 0 {
+            return time.Time{}, fmt.Errorf("offset must be positive")
+        }
+        return now.Add(offset), nil
+    }
+    if at, err := time.ParseInLocation("2006-01-02T15:04", value, loc); err == nil {
+        return at, nil
+    }
+
+    date := now.Format(dateLayout)
+    clock := value
+    if event, eventClock, found := strings.Cut(value, "@"); found {
+        switch strings.ToLower(event) {
+        case "checkin":
+            date = p.Info.CheckInDate
+        case "checkout":
+            date = p.Info.CheckOutDate
+        default:
+            return time.Time{}, fmt.Errorf("unknown day %q, use checkin or checkout", event)
+        }
+        if date == "" {
+            return time.Time{}, fmt.Errorf("the conversation has no %s date", event)
+        }
+        clock = eventClock
+    }
+    hour, minute, ok := parseClock(clock)
+    if !ok {
+        return time.Time{}, fmt.Errorf("invalid time %q", value)
+    }
+    day, err := time.ParseInLocation(dateLayout, date, loc)
+    if err != nil {
+        return time.Time{}, err
+    }
+    at := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+    if clock == value && !at.After(now) {
+        at = at.AddDate(0, 0, 1)
+    }
+    return at, nil
+}
+
+// scheduleMessage stores a message to the portal's guest that is sent on
+// behalf of sender at the given time, and returns the confirmation.
+func (b *Bridge) scheduleMessage(portal *Portal, sender id.UserID, sendAt time.Time, text string) string {
+    if text == "" {
+        return "The message is empty."
+    } else if !sendAt.After(time.Now()) {
+        return fmt.Sprintf("%s is in the past.", sendAt.In(b.location()).Format(scheduleLayout))
+    }
+    messageID, err := b.DB.ScheduleMessage(&database.ScheduledMessage{
+        HostexID:  portal.ID,
+        Sender:    sender,
+        Content:   text,
+        SendAt:    sendAt,
+        CreatedAt: time.Now(),
+    })
+    if err != nil {
+        return fmt.Sprintf("Failed to schedule the message: %v", err)
+    }
+    return fmt.Sprintf("Scheduled message %d to %s for %s. Cancel it with !schedule cancel %d.",
+        messageID, portal.Info.Guest.Name, sendAt.In(b.location()).Format(scheduleLayout), messageID)
+}
+
+// listScheduledMessages lists the scheduled messages, only the ones of one
+// conversation if hostexID isn't empty.
+func (b *Bridge) listScheduledMessages(hostexID string) string {
+    messages, err := b.DB.GetScheduledMessages(time.Time{})
+    if err != nil {
+        return fmt.Sprintf("Failed to get scheduled messages: %v", err)
+    }
+    var list strings.Builder
+    for _, msg := range messages {
+        if hostexID != "" && msg.HostexID != hostexID {
+            continue
+        }
+        name := msg.HostexID
+        if portal, ok := b.getPortalByID(msg.HostexID); ok {
+            name = portal.Info.Guest.Name
+        }
+        list.WriteString(fmt.Sprintf("%d. %s to %s: %s\n", msg.ID, msg.SendAt.In(b.location()).Format(scheduleLayout), name, truncate(strings.ReplaceAll(msg.Content, "\n", " "), 80)))
+    }
+    if list.Len() == 0 {
+        return "No scheduled messages."
+    }
+    return "Scheduled messages:\n" + list.String()
+}
+
+func (b *Bridge) cancelScheduledMessage(args []string) string {
+    if len(args) < 2 {
+        return "Usage: !schedule cancel <number>"
+    }
+    messageID, err := strconv.ParseInt(args[1], 10, 64)
+    if err != nil {
+        return "Invalid message number."
+    }
+    deleted, err := b.DB.DeleteScheduledMessage(messageID)
+    if err != nil {
+        return fmt.Sprintf("Failed to cancel the message: %v", err)
+    } else if !deleted {
+        return fmt.Sprintf("No scheduled message %d.", messageID)
+    }
+    return fmt.Sprintf("Cancelled scheduled message %d.", messageID)
+}
+
+func (u *User) scheduleCommand(ctx context.Context, roomID id.RoomID, args []string, body string) {
+    const usage = "Usage: !schedule <conversation ID|room ID|guest name> <2h|15:00|checkin@15:00|YYYY-MM-DDTHH:MM> <message>, !schedule list or !schedule cancel <number>"
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, usage)
+        return
+    }
+    switch strings.ToLower(args[0]) {
+    case "list":
+        u.sendNotice(ctx, roomID, u.bridge.listScheduledMessages(""))
+        return
+    case "cancel":
+        u.sendNotice(ctx, roomID, u.bridge.cancelScheduledMessage(args))
+        return
+    }
+
+    // Guest names can have several words, so the time is the first word
+    // after a known conversation that parses as one
+    now := time.Now()
+    for i := 1; i < len(args)-1; i++ {
+        portal := u.bridge.findPortal(strings.Join(args[:i], " "))
+        if portal == nil || portal.RoomID == "" {
+            continue
+        }
+        sendAt, err := portal.parseScheduleTime(args[i], now)
+        if err != nil {
+            continue
+        }
+        u.sendNotice(ctx, roomID, u.bridge.scheduleMessage(portal, u.MXID, sendAt, commandText(body, i+2)))
+        return
+    }
+    u.sendNotice(ctx, roomID, usage)
+}
+
+func (p *Portal) scheduleCommand(ctx context.Context, sender id.UserID, args []string, body string) {
+    const usage = "Usage: !schedule <2h|15:00|checkin@15:00|YYYY-MM-DDTHH:MM> <message>, !schedule list or !schedule cancel <number>"
+    if len(args) == 0 {
+        p.sendNotice(ctx, usage)
+        return
+    }
+    switch strings.ToLower(args[0]) {
+    case "list":
+        p.sendNotice(ctx, p.bridge.listScheduledMessages(p.ID))
+        return
+    case "cancel":
+        p.sendNotice(ctx, p.bridge.cancelScheduledMessage(args))
+        return
+    }
+    sendAt, err := p.parseScheduleTime(args[0], time.Now())
+    if err != nil {
+        p.sendNotice(ctx, fmt.Sprintf("%v. %s", err, usage))
+        return
+    }
+    p.sendNotice(ctx, p.bridge.scheduleMessage(p, sender, sendAt, commandText(body, 2)))
+}
+
+func (b *Bridge) startScheduler() {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(scheduleInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            b.sendScheduledMessages(b.ctx)
+        }
+    }
+}
+
+// sendScheduledMessages sends the scheduled messages that are due. A message
+// that fails is retried on the next run until it's too late.
+func (b *Bridge) sendScheduledMessages(ctx context.Context) {
+    if !b.IsLeader() {
+        return
+    }
+    now := time.Now()
+    messages, err := b.DB.GetScheduledMessages(now)
+    if err != nil {
+        b.Logger.Error("Failed to get scheduled messages", zap.Error(err))
+        return
+    }
+
+    for _, msg := range messages {
+        portal, ok := b.getPortalByID(msg.HostexID)
+        if ok && portal.RoomID != "" {
+            err = portal.sendBridgeMessage(ctx, msg.Sender, msg.Content)
+        } else {
+            err = fmt.Errorf("conversation %s isn't bridged", msg.HostexID)
+        }
+        if err != nil && now.Sub(msg.SendAt) < maxScheduleDelay {
+            b.Logger.Warn("Failed to send scheduled message, retrying later", zap.Int64("message_id", msg.ID), zap.Error(err))
+            continue
+        } else if err != nil {
+            b.Logger.Error("Giving up on scheduled message", zap.Int64("message_id", msg.ID), zap.Error(err))
+            b.sendManagementNotice(ctx, fmt.Sprintf("Failed to send scheduled message %d: %v", msg.ID, err))
+        }
+        _, err = b.DB.DeleteScheduledMessage(msg.ID)
+        if err != nil {
+            b.Logger.Error("Failed to remove sent scheduled message", zap.Int64("message_id", msg.ID), zap.Error(err))
+        }
+    }
+}
@@ -0,0 +1,48 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "maunium.net/go/mautrix/id"
+)
+
+const searchResultLimit = 10
+
+func (u *User) searchMessages(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !search <words>")
+        return
+    }
+    query := strings.Join(args, " ")
+    results, err := u.bridge.DB.SearchMessages(query, searchResultLimit)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to search messages: %v", err))
+        return
+    }
+    if len(results) == 0 {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No messages found for %q.", query))
+        return
+    }
+
+    var list strings.Builder
+    list.WriteString(fmt.Sprintf("Messages matching %q:\n", query))
+    for _, result := range results {
+        guest := result.HostexID
+        link := ""
+        if portal, ok := u.bridge.getPortalByID(result.HostexID); ok {
+            guest = portal.Info.Guest.Name
+            if portal.RoomID != "" {
+                link = " " + portal.RoomID.EventURI(result.MatrixEventID, u.bridge.Config().Homeserver.Domain).MatrixToURL()
+            }
+        }
+        list.WriteString(fmt.Sprintf("- %s, %s (%s): %s%s\n",
+            guest,
+            result.Timestamp.In(u.bridge.location()).Format("2006-01-02 15:04"),
+            result.Sender,
+            truncate(result.Snippet, 200),
+            link))
+    }
+    u.sendNotice(ctx, roomID, list.String())
+}
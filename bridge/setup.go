@@ -0,0 +1,201 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/config"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// Settings changed at runtime, stored in the database as overrides of the
+// config file.
+const (
+    SettingHostexToken         = "hostex.token"
+    SettingPersonalSpaceEnable = "personal_filtering_spaces"
+)
+
+type setupStep int
+
+const (
+    setupStepToken setupStep = iota
+    setupStepProperties
+    setupStepSpaces
+    setupStepTimezone
+)
+
+// setupWizard is the state of a !setup run in the management room.
+type setupWizard struct {
+    step       setupStep
+    properties []hostexapi.Property
+}
+
+// applySettings loads the settings made in the setup wizard on top of the
+// config file.
+func (b *Bridge) applySettings() error {
+    token, err := b.DB.GetSetting(SettingHostexToken)
+    if err != nil {
+        return err
+    } else if token != "" {
+        b.HostexClient.SetToken(token)
+    }
+
+    spaces, err := b.DB.GetSetting(SettingPersonalSpaceEnable)
+    if err != nil {
+        return err
+    } else if spaces != "" {
+        b.updateConfig(func(cfg *config.Config) {
+            cfg.PersonalSpaceEnable = spaces == "true"
+        })
+    }
+
+    return b.applyConfigOverrides()
+}
+
+// isPropertySelected reports whether conversations about the property should
+// be bridged. Conversations only include the property title, so the selection
+// is matched by title. Without a selection, every property is bridged.
+func (b *Bridge) isPropertySelected(title string) bool {
+    selectedProperties := b.getSelectedProperties()
+    if len(selectedProperties) == 0 {
+        return true
+    }
+    for _, selected := range selectedProperties {
+        if strings.EqualFold(selected, title) {
+            return true
+        }
+    }
+    return false
+}
+
+func (u *User) startSetup(ctx context.Context, roomID id.RoomID) {
+    u.setup = &setupWizard{step: setupStepToken}
+    u.sendNotice(ctx, roomID, `Welcome to the Hostex bridge setup! Type !cancel at any time to stop.
+
+Step 1/4: Send your Hostex API access token. You can create one in Hostex under Settings > API. The message will be redacted after it's read.`)
+}
+
+func (u *User) handleSetupAnswer(ctx context.Context, evt *event.Event, answer string) {
+    answer = strings.TrimSpace(answer)
+    switch u.setup.step {
+    case setupStepToken:
+        u.setupToken(ctx, evt, answer)
+    case setupStepProperties:
+        u.setupProperties(ctx, evt.RoomID, answer)
+    case setupStepSpaces:
+        u.setupSpaces(ctx, evt.RoomID, answer)
+    case setupStepTimezone:
+        u.setupTimezone(ctx, evt.RoomID, answer)
+    }
+}
+
+func (u *User) setupToken(ctx context.Context, evt *event.Event, token string) {
+    _, err := u.bridge.MatrixClient.RedactEvent(ctx, evt.RoomID, evt.ID)
+    if err != nil {
+        u.bridge.Logger.Warn("Failed to redact Hostex token message", zap.Error(err))
+        u.sendNotice(ctx, evt.RoomID, "Couldn't redact the message with your token, please delete it yourself.")
+    }
+
+    probe := hostexapi.NewClient(u.bridge.Config().Hostex.APIURL, token, u.bridge.Config().Hostex.Timeout, u.bridge.Logger)
+    properties, err := probe.GetProperties(ctx)
+    if err != nil {
+        u.sendNotice(ctx, evt.RoomID, fmt.Sprintf("That token didn't work (%v). Please send it again.", err))
+        return
+    }
+    err = u.bridge.DB.SetSetting(SettingHostexToken, token)
+    if err != nil {
+        u.sendNotice(ctx, evt.RoomID, fmt.Sprintf("Failed to save the token: %v", err))
+        return
+    }
+    u.bridge.HostexClient.SetToken(token)
+
+    u.setup.properties = properties
+    u.setup.step = setupStepProperties
+    var prompt strings.Builder
+    prompt.WriteString("Token saved.\n\nStep 2/4: Which properties should be bridged? Reply with the numbers separated by spaces, or \"all\".\n")
+    for i, property := range properties {
+        prompt.WriteString(fmt.Sprintf("%d. %s\n", i+1, property.Title))
+    }
+    u.sendNotice(ctx, evt.RoomID, prompt.String())
+}
+
+func (u *User) setupProperties(ctx context.Context, roomID id.RoomID, answer string) {
+    selected := "all"
+    if !strings.EqualFold(answer, "all") {
+        var titles []string
+        for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ' ' || r == ',' }) {
+            number, err := strconv.Atoi(field)
+            if err != nil || number < 1 || number > len(u.setup.properties) {
+                u.sendNotice(ctx, roomID, fmt.Sprintf("%q isn't a property number, please try again.", field))
+                return
+            }
+            titles = append(titles, u.setup.properties[number-1].Title)
+        }
+        selected = formatList(titles)
+    }
+
+    err := u.bridge.setConfigOption("properties", selected)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to save the property selection: %v", err))
+        return
+    }
+
+    u.setup.step = setupStepSpaces
+    u.sendNotice(ctx, roomID, "Step 3/4: Should conversations be grouped in a \"Hostex Conversations\" space? Reply yes or no.")
+}
+
+func (u *User) setupSpaces(ctx context.Context, roomID id.RoomID, answer string) {
+    var enable bool
+    switch strings.ToLower(answer) {
+    case "yes", "y":
+        enable = true
+    case "no", "n":
+    default:
+        u.sendNotice(ctx, roomID, "Please reply yes or no.")
+        return
+    }
+
+    err := u.bridge.DB.SetSetting(SettingPersonalSpaceEnable, strconv.FormatBool(enable))
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to save the space setting: %v", err))
+        return
+    }
+    u.bridge.updateConfig(func(cfg *config.Config) {
+        cfg.PersonalSpaceEnable = enable
+    })
+    if enable && u.bridge.spaceRoom == "" {
+        u.bridge.spaceRoom, err = u.bridge.createOrFindPersonalSpace(ctx)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to create the space: %v", err))
+        }
+    }
+
+    u.setup.step = setupStepTimezone
+    u.sendNotice(ctx, roomID, fmt.Sprintf("Step 4/4: Which timezone are your properties in? Reply with a name like Europe/Lisbon, or \"keep\" to use %s.", u.bridge.location()))
+}
+
+func (u *User) setupTimezone(ctx context.Context, roomID id.RoomID, answer string) {
+    if !strings.EqualFold(answer, "keep") {
+        _, err := time.LoadLocation(answer)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Invalid timezone (%v), please try again.", err))
+            return
+        }
+        u.Preferences.Timezone = answer
+        err = u.savePreferences(ctx)
+        if err != nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to save the timezone: %v", err))
+            return
+        }
+    }
+
+    u.setup = nil
+    u.sendNotice(ctx, roomID, "Setup complete! Conversations will be bridged on the next poll, or type !sync to start now.")
+}
@@ -0,0 +1,53 @@
+package bridge
+
+import (
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// goTask runs fn in the background as in-flight work that Stop waits for,
+// e.g. a command's backfill. Once the bridge is stopping, fn isn't run and
+// goTask returns false.
+func (b *Bridge) goTask(fn func()) bool {
+    b.drainLock.Lock()
+    defer b.drainLock.Unlock()
+    if b.draining {
+        return false
+    }
+    b.tasks.Add(1)
+    go func() {
+        defer b.tasks.Done()
+        fn()
+    }()
+    return true
+}
+
+// drain stops the workers from picking up new work and waits for them and
+// the running tasks to finish. Whatever is still running after the shutdown
+// timeout is cancelled.
+func (b *Bridge) drain() {
+    b.drainLock.Lock()
+    b.draining = true
+    b.drainLock.Unlock()
+    close(b.stop)
+
+    done := make(chan struct{})
+    go func() {
+        b.wg.Wait()
+        b.tasks.Wait()
+        close(done)
+    }()
+
+    timer := time.NewTimer(b.Config().Shutdown.Timeout)
+    defer timer.Stop()
+    select {
+    case <-done:
+    case <-timer.C:
+        b.Logger.Warn("In-flight work didn't finish before the shutdown timeout, cancelling it",
+            zap.Duration("timeout", b.Config().Shutdown.Timeout))
+        b.cancel()
+        <-done
+    }
+    b.cancel()
+}
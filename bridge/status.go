@@ -0,0 +1,45 @@
+package bridge
+
+import (
+    "context"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// Delivery status reactions the bot puts on replies sent from portal rooms.
+const (
+    StatusPending = "⏳"
+    StatusSent    = "✅"
+    StatusFailed  = "⚠️"
+)
+
+// setMessageStatus replaces the bot's status reaction on a reply with the
+// given one, so the reply shows whether it reached the guest.
+func (p *Portal) setMessageStatus(ctx context.Context, eventID id.EventID, status string) {
+    if eventID == "" || p.RoomID == "" {
+        return
+    }
+
+    p.statusLock.Lock()
+    defer p.statusLock.Unlock()
+
+    if previous, ok := p.statusReactions[eventID]; ok {
+        _, err := p.bridge.MatrixClient.RedactEvent(ctx, p.RoomID, previous)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to redact previous status reaction", zap.Error(err))
+        }
+        delete(p.statusReactions, eventID)
+    }
+
+    resp, err := p.bridge.MatrixClient.SendReaction(ctx, p.RoomID, eventID, status)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to send status reaction", zap.String("event_id", eventID.String()), zap.Error(err))
+        return
+    }
+    if status == StatusSent {
+        // Nothing replaces the sent status, so there's no need to remember it
+        return
+    }
+    p.statusReactions[eventID] = resp.EventID
+}
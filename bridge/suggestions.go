@@ -0,0 +1,182 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+const maxReplySuggestions = 3
+
+// isHostSender reports whether a stored message was written by the host,
+// either in Matrix (sender is a Matrix user ID) or in Hostex itself.
+func isHostSender(sender string) bool {
+    return strings.HasPrefix(sender, "@") || strings.EqualFold(sender, "host")
+}
+
+var suggestionStopWords = map[string]bool{
+    "the": true, "and": true, "for": true, "are": true, "you": true, "your": true,
+    "this": true, "that": true, "with": true, "have": true, "has": true, "was": true,
+    "can": true, "will": true, "would": true, "could": true, "there": true, "what": true,
+    "when": true, "where": true, "how": true, "our": true, "any": true, "from": true,
+    "thanks": true, "thank": true, "hello": true, "please": true,
+}
+
+// termFrequencies splits text into lowercase words, ignoring short and very
+// common words, and counts how often each one occurs.
+func termFrequencies(text string) map[string]float64 {
+    terms := make(map[string]float64)
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+    for _, word := range words {
+        if len(word) < 3 || suggestionStopWords[word] {
+            continue
+        }
+        terms[word]++
+    }
+    return terms
+}
+
+type replyPair struct {
+    question map[string]float64
+    answer   string
+}
+
+type scoredReply struct {
+    answer string
+    score  float64
+}
+
+// findSimilarReplies compares the question with earlier guest messages and
+// returns the host's answers to the most similar ones, using TF-IDF weighted
+// cosine similarity.
+func (b *Bridge) findSimilarReplies(question string) ([]string, error) {
+    history, err := b.DB.GetRecentMessages(b.Config().ReplySuggestions.History)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get message history: %w", err)
+    }
+
+    // Pair every run of guest messages with the host message that answered it
+    var pairs []replyPair
+    var pending strings.Builder
+    var conversation string
+    for _, msg := range history {
+        if msg.HostexID != conversation {
+            conversation = msg.HostexID
+            pending.Reset()
+        }
+        if !isHostSender(msg.Sender) {
+            pending.WriteString(msg.Content)
+            pending.WriteString("\n")
+        } else if pending.Len() > 0 {
+            pairs = append(pairs, replyPair{question: termFrequencies(pending.String()), answer: msg.Content})
+            pending.Reset()
+        }
+    }
+    if len(pairs) == 0 {
+        return nil, nil
+    }
+
+    documentFrequency := make(map[string]float64)
+    for _, pair := range pairs {
+        for term := range pair.question {
+            documentFrequency[term]++
+        }
+    }
+    weigh := func(terms map[string]float64) (map[string]float64, float64) {
+        weights := make(map[string]float64, len(terms))
+        var norm float64
+        for term, count := range terms {
+            weight := count * math.Log(1+float64(len(pairs))/(1+documentFrequency[term]))
+            weights[term] = weight
+            norm += weight * weight
+        }
+        return weights, math.Sqrt(norm)
+    }
+
+    queryWeights, queryNorm := weigh(termFrequencies(question))
+    if queryNorm == 0 {
+        return nil, nil
+    }
+
+    var scored []scoredReply
+    for _, pair := range pairs {
+        weights, norm := weigh(pair.question)
+        if norm == 0 {
+            continue
+        }
+        var dot float64
+        for term, weight := range queryWeights {
+            dot += weight * weights[term]
+        }
+        score := dot / (queryNorm * norm)
+        if score >= b.Config().ReplySuggestions.MinScore {
+            scored = append(scored, scoredReply{answer: pair.answer, score: score})
+        }
+    }
+    sort.Slice(scored, func(i, j int) bool {
+        return scored[i].score > scored[j].score
+    })
+
+    var suggestions []string
+    seen := make(map[string]bool)
+    for _, reply := range scored {
+        if seen[reply.answer] {
+            continue
+        }
+        seen[reply.answer] = true
+        suggestions = append(suggestions, reply.answer)
+        if len(suggestions) == maxReplySuggestions {
+            break
+        }
+    }
+    return suggestions, nil
+}
+
+// sendReplySuggestions posts earlier answers to similar questions as quick
+// replies that can be sent with !reply.
+func (p *Portal) sendReplySuggestions(ctx context.Context, question string) {
+    suggestions, err := p.bridge.findSimilarReplies(question)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to find reply suggestions", zap.Error(err))
+        return
+    }
+    p.suggestions = suggestions
+    if len(suggestions) == 0 {
+        return
+    }
+
+    var notice strings.Builder
+    notice.WriteString("Suggested replies based on your earlier answers:\n")
+    for i, suggestion := range suggestions {
+        notice.WriteString(fmt.Sprintf("%d. %s\n", i+1, suggestion))
+    }
+    notice.WriteString("Use !reply <number> to send one.")
+    p.sendNotice(ctx, notice.String())
+}
+
+func (p *Portal) sendSuggestedReply(ctx context.Context, sender id.UserID, args []string) {
+    if len(args) == 0 {
+        p.sendNotice(ctx, "Usage: !reply <number>")
+        return
+    }
+    number, err := strconv.Atoi(args[0])
+    if err != nil || number < 1 || number > len(p.suggestions) {
+        p.sendNotice(ctx, "Invalid suggestion number.")
+        return
+    }
+    err = p.sendBridgeMessage(ctx, sender, p.suggestions[number-1])
+    if err != nil {
+        p.sendNotice(ctx, fmt.Sprintf("Failed to send reply: %v", err))
+        return
+    }
+    p.suggestions = nil
+}
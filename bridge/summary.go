@@ -0,0 +1,80 @@
+package bridge
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// postSummary posts the key facts of the reservation and pins them in the
+// portal room, replacing the previously pinned summary.
+func (p *Portal) postSummary(ctx context.Context) {
+    code, status := "unknown", p.Info.ReservationStatus
+    res, err := p.conversationReservation(ctx)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to get reservation for summary", zap.String("hostex_id", p.ID), zap.Error(err))
+    } else if res != nil {
+        code, status = res.ReservationCode, res.Status
+    }
+    if status == "" {
+        status = "unknown"
+    }
+
+    content := &event.MessageEventContent{
+        MsgType: event.MsgNotice,
+        Body: fmt.Sprintf(`📌 %s
+Property: %s
+Channel: %s
+Stay: %s to %s
+Status: %s
+Confirmation code: %s`,
+            p.Info.Guest.Name,
+            p.Info.PropertyTitle,
+            p.Info.ChannelType,
+            p.Info.CheckInDate, p.Info.CheckOutDate,
+            status,
+            code),
+    }
+    resp, err := p.bridge.MatrixClient.SendMessageEvent(ctx, p.RoomID, event.EventMessage, content)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to send reservation summary", zap.String("hostex_id", p.ID), zap.Error(err))
+        return
+    }
+
+    previous, err := p.bridge.DB.GetPortalSummaryEvent(p.ID)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to get previous reservation summary", zap.Error(err))
+    }
+    err = p.pinEvent(ctx, resp.EventID, previous)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to pin reservation summary", zap.String("hostex_id", p.ID), zap.Error(err))
+    }
+    err = p.bridge.DB.SetPortalSummaryEvent(p.ID, resp.EventID)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store reservation summary", zap.Error(err))
+    }
+}
+
+// pinEvent pins an event in the portal room and unpins the event it replaces.
+// Events pinned by users are kept.
+func (p *Portal) pinEvent(ctx context.Context, eventID, replaces id.EventID) error {
+    var pinned event.PinnedEventsEventContent
+    err := p.bridge.MatrixClient.StateEvent(ctx, p.RoomID, event.StatePinnedEvents, "", &pinned)
+    if err != nil && !errors.Is(err, mautrix.MNotFound) {
+        return fmt.Errorf("failed to get pinned events: %w", err)
+    }
+
+    updated := []id.EventID{eventID}
+    for _, pinnedID := range pinned.Pinned {
+        if pinnedID != replaces && pinnedID != eventID {
+            updated = append(updated, pinnedID)
+        }
+    }
+    _, err = p.bridge.MatrixClient.SendStateEvent(ctx, p.RoomID, event.StatePinnedEvents, "", &event.PinnedEventsEventContent{Pinned: updated})
+    return err
+}
@@ -0,0 +1,105 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
+)
+
+// suppressedListLimit is how many suppressed messages !show-suppressed lists.
+const suppressedListLimit = 20
+
+// suppressionCategory returns the suppression category of a Hostex message,
+// or an empty string if it should be bridged.
+func (b *Bridge) suppressionCategory(msg hostexapi.Message) string {
+    suppression := b.Config().Suppression
+    if len(suppression.Categories) == 0 {
+        return ""
+    }
+    fromSender := false
+    for _, sender := range suppression.Senders {
+        if strings.EqualFold(msg.Sender, sender) {
+            fromSender = true
+            break
+        }
+    }
+    if !fromSender {
+        return ""
+    }
+
+    categories := make([]string, 0, len(suppression.Categories))
+    for category := range suppression.Categories {
+        categories = append(categories, category)
+    }
+    sort.Strings(categories)
+
+    content := strings.ToLower(msg.Content)
+    for _, category := range categories {
+        for _, keyword := range suppression.Categories[category] {
+            if keyword != "" && strings.Contains(content, strings.ToLower(keyword)) {
+                return category
+            }
+        }
+    }
+    return ""
+}
+
+// suppress stores a message that isn't bridged into the room.
+func (p *Portal) suppress(msg hostexapi.Message, category string) {
+    p.bridge.Logger.Debug("Suppressing Hostex message",
+        zap.String("portal_id", p.ID),
+        zap.String("message_id", msg.ID),
+        zap.String("category", category))
+    err := p.bridge.DB.StoreSuppressedMessage(&database.SuppressedMessage{
+        HostexID:        p.ID,
+        HostexMessageID: msg.ID,
+        Timestamp:       msg.Timestamp,
+        Sender:          msg.Sender,
+        Category:        category,
+        Content:         msg.Content,
+    })
+    if err != nil {
+        p.bridge.Logger.Error("Failed to store suppressed message", zap.Error(err))
+    }
+}
+
+func (u *User) showSuppressed(ctx context.Context, roomID id.RoomID, args []string) {
+    if len(args) == 0 {
+        u.sendNotice(ctx, roomID, "Usage: !show-suppressed <conversation ID|room ID|guest name>")
+        return
+    }
+    query := strings.Join(args, " ")
+    portal := u.bridge.findPortal(query)
+    if portal == nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No portal found for %q.", query))
+        return
+    }
+
+    messages, err := u.bridge.DB.GetSuppressedMessages(portal.ID, suppressedListLimit)
+    if err != nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("Failed to get suppressed messages: %v", err))
+        return
+    }
+    if len(messages) == 0 {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No messages were suppressed in the conversation with %s.", portal.Info.Guest.Name))
+        return
+    }
+
+    var list strings.Builder
+    list.WriteString(fmt.Sprintf("Suppressed messages in the conversation with %s, newest first:\n", portal.Info.Guest.Name))
+    for _, msg := range messages {
+        list.WriteString(fmt.Sprintf("- %s [%s] %s: %s\n",
+            msg.Timestamp.In(u.bridge.location()).Format("2006-01-02 15:04"),
+            msg.Category,
+            msg.Sender,
+            truncate(msg.Content, 200)))
+    }
+    u.sendNotice(ctx, roomID, list.String())
+}
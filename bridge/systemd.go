@@ -0,0 +1,73 @@
+package bridge
+
+import (
+    "net"
+    "os"
+    "strconv"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// sdNotify sends a state like READY=1 to systemd if the bridge runs as a
+// Type=notify service. Outside of systemd it does nothing.
+func (b *Bridge) sdNotify(state string) {
+    socket := os.Getenv("NOTIFY_SOCKET")
+    if socket == "" {
+        return
+    }
+    // Abstract sockets are prefixed with @ in the environment variable
+    if socket[0] == '@' {
+        socket = "\x00" + socket[1:]
+    }
+    conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+    if err != nil {
+        b.Logger.Warn("Failed to connect to systemd notify socket", zap.Error(err))
+        return
+    }
+    defer conn.Close()
+    _, err = conn.Write([]byte(state))
+    if err != nil {
+        b.Logger.Warn("Failed to notify systemd", zap.String("state", state), zap.Error(err))
+    }
+}
+
+// watchdogInterval returns how often the systemd watchdog has to be pinged,
+// which is half of WatchdogSec, or 0 if the watchdog isn't enabled for this
+// process.
+func watchdogInterval() time.Duration {
+    usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+    if err != nil || usec <= 0 {
+        return 0
+    }
+    if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+        return 0
+    }
+    return time.Duration(usec) * time.Microsecond / 2
+}
+
+// startWatchdog pings the systemd watchdog on its own ticker, so long polls
+// and backfills, like the first sync, don't get the bridge restarted.
+func (b *Bridge) startWatchdog(interval time.Duration) {
+    defer b.wg.Done()
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-b.stop:
+            return
+        case <-ticker.C:
+            b.sdNotify("WATCHDOG=1")
+        }
+    }
+}
+
+// markReady tells systemd that the bridge is up, once Start has set up the
+// rooms and started the workers.
+func (b *Bridge) markReady() {
+    b.readyOnce.Do(func() {
+        b.Logger.Info("Hostex bridge is ready")
+        b.sdNotify("READY=1")
+    })
+}
@@ -0,0 +1,166 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "strings"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// roomTagOrder is the order of the room tags mirrored from Hostex tags.
+const roomTagOrder = 0.5
+
+// roomTag returns the Matrix room tag a Hostex conversation tag is mirrored
+// as.
+func (b *Bridge) roomTag(tag string) event.RoomTag {
+    cfg := b.Config().ConversationTags
+    for name, roomTag := range cfg.Mapping {
+        if strings.EqualFold(name, tag) {
+            return event.RoomTag(roomTag)
+        }
+    }
+    return event.RoomTag(cfg.Prefix + strings.ReplaceAll(strings.ToLower(strings.TrimSpace(tag)), " ", "-"))
+}
+
+// isMirroredRoomTag reports whether a room tag may have been set for a
+// Hostex tag, so it's removed when the conversation no longer has it.
+func (b *Bridge) isMirroredRoomTag(roomTag event.RoomTag) bool {
+    cfg := b.Config().ConversationTags
+    if strings.HasPrefix(string(roomTag), cfg.Prefix) {
+        return true
+    }
+    for _, mapped := range cfg.Mapping {
+        if event.RoomTag(mapped) == roomTag {
+            return true
+        }
+    }
+    return false
+}
+
+func hasTag(tags []string, tag string) bool {
+    return slices.ContainsFunc(tags, func(existing string) bool {
+        return strings.EqualFold(existing, tag)
+    })
+}
+
+// syncRoomTags makes the admin's room tags of the portal room match the
+// Hostex tags of the conversation.
+func (p *Portal) syncRoomTags(ctx context.Context) {
+    client := p.bridge.AdminClient
+    if !p.bridge.Config().ConversationTags.RoomTags || client == nil || p.RoomID == "" {
+        return
+    }
+    current, err := client.GetTags(ctx, p.RoomID)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to get room tags", zap.String("room_id", p.RoomID.String()), zap.Error(err))
+        return
+    }
+
+    wanted := make(map[event.RoomTag]bool, len(p.Info.Tags))
+    for _, tag := range p.Info.Tags {
+        wanted[p.bridge.roomTag(tag)] = true
+    }
+    for roomTag := range current.Tags {
+        if wanted[roomTag] || !p.bridge.isMirroredRoomTag(roomTag) {
+            continue
+        }
+        err = client.RemoveTag(ctx, p.RoomID, roomTag)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to remove room tag", zap.String("tag", string(roomTag)), zap.Error(err))
+        }
+    }
+    for roomTag := range wanted {
+        if _, ok := current.Tags[roomTag]; ok {
+            continue
+        }
+        err = client.AddTag(ctx, p.RoomID, roomTag, roomTagOrder)
+        if err != nil {
+            p.bridge.Logger.Warn("Failed to add room tag", zap.String("tag", string(roomTag)), zap.Error(err))
+        }
+    }
+}
+
+// addTag tags the conversation in Hostex and returns the reply to the
+// command.
+func (p *Portal) addTag(ctx context.Context, tag string) string {
+    if hasTag(p.Info.Tags, tag) {
+        return fmt.Sprintf("The conversation with %s is already tagged %s.", p.Info.Guest.Name, tag)
+    }
+    err := p.client().AddConversationTag(ctx, p.conversationID(), tag)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to add conversation tag", zap.String("hostex_id", p.ID), zap.Error(err))
+        return fmt.Sprintf("Failed to add the tag: %v", err)
+    }
+    p.Info.Tags = append(slices.Clone(p.Info.Tags), tag)
+    p.syncRoomTags(ctx)
+    return fmt.Sprintf("Tagged the conversation with %s as %s.", p.Info.Guest.Name, tag)
+}
+
+// removeTag removes a tag from the conversation in Hostex and returns the
+// reply to the command.
+func (p *Portal) removeTag(ctx context.Context, tag string) string {
+    index := slices.IndexFunc(p.Info.Tags, func(existing string) bool {
+        return strings.EqualFold(existing, tag)
+    })
+    if index < 0 {
+        return fmt.Sprintf("The conversation with %s isn't tagged %s.", p.Info.Guest.Name, tag)
+    }
+    tag = p.Info.Tags[index]
+    err := p.client().RemoveConversationTag(ctx, p.conversationID(), tag)
+    if err != nil {
+        p.bridge.Logger.Error("Failed to remove conversation tag", zap.String("hostex_id", p.ID), zap.Error(err))
+        return fmt.Sprintf("Failed to remove the tag: %v", err)
+    }
+    p.Info.Tags = slices.Delete(slices.Clone(p.Info.Tags), index, index+1)
+    p.syncRoomTags(ctx)
+    return fmt.Sprintf("Removed the tag %s from the conversation with %s.", tag, p.Info.Guest.Name)
+}
+
+func (p *Portal) listTags() string {
+    if len(p.Info.Tags) == 0 {
+        return fmt.Sprintf("The conversation with %s has no tags.", p.Info.Guest.Name)
+    }
+    return fmt.Sprintf("Tags: %s", strings.Join(p.Info.Tags, ", "))
+}
+
+func (p *Portal) tagCommand(ctx context.Context, args []string) {
+    const usage = "Usage: !tag [add|remove <tag>]"
+    switch {
+    case len(args) == 0:
+        p.sendNotice(ctx, p.listTags())
+    case len(args) < 2:
+        p.sendNotice(ctx, usage)
+    case strings.EqualFold(args[0], "add"):
+        p.sendNotice(ctx, p.addTag(ctx, strings.Join(args[1:], " ")))
+    case strings.EqualFold(args[0], "remove"):
+        p.sendNotice(ctx, p.removeTag(ctx, strings.Join(args[1:], " ")))
+    default:
+        p.sendNotice(ctx, usage)
+    }
+}
+
+func (u *User) tagCommand(ctx context.Context, roomID id.RoomID, args []string) {
+    const usage = "Usage: !tag <add|remove> <tag> <conversation ID|room ID|guest name>"
+    if len(args) < 3 {
+        u.sendNotice(ctx, roomID, usage)
+        return
+    }
+    query := strings.Join(args[2:], " ")
+    portal := u.bridge.findPortal(query)
+    if portal == nil {
+        u.sendNotice(ctx, roomID, fmt.Sprintf("No conversation found for %q.", query))
+        return
+    }
+    switch strings.ToLower(args[0]) {
+    case "add":
+        u.sendNotice(ctx, roomID, portal.addTag(ctx, args[1]))
+    case "remove":
+        u.sendNotice(ctx, roomID, portal.removeTag(ctx, args[1]))
+    default:
+        u.sendNotice(ctx, roomID, usage)
+    }
+}
@@ -0,0 +1,47 @@
+package bridge
+
+import (
+    "net/http"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of the poll, backfill and send paths. Without a
+// tracer provider set up, e.g. when tracing is disabled, it does nothing.
+var tracer = otel.Tracer("github.com/keithah/hostex-bridge-go/bridge")
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
+
+// tracingTransport records a span for every Matrix request, as children of
+// the span in the request context.
+type tracingTransport struct {
+    base http.RoundTripper
+}
+
+func (tt *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    ctx, span := tracer.Start(req.Context(), "matrix "+req.Method,
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(
+            attribute.String("http.request.method", req.Method),
+            attribute.String("url.path", req.URL.Path),
+        ))
+    resp, err := tt.base.RoundTrip(req.WithContext(ctx))
+    if err == nil {
+        span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+        if resp.StatusCode >= 400 {
+            span.SetStatus(codes.Error, resp.Status)
+        }
+    }
+    endSpan(span, err)
+    return resp, err
+}
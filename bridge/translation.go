@@ -0,0 +1,69 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// baseLanguage returns the language of a language code without its region,
+// e.g. "pt" for "pt-BR".
+func baseLanguage(code string) string {
+    base, _, _ := strings.Cut(strings.ToLower(code), "-")
+    base, _, _ = strings.Cut(base, "_")
+    return base
+}
+
+// translateInbound returns a translation of a guest message to append to
+// it, or an empty string if the message is in the host's language or
+// couldn't be translated.
+func (p *Portal) translateInbound(ctx context.Context, text string) string {
+    if strings.TrimSpace(text) == "" {
+        return ""
+    }
+    language := p.bridge.Config().Translation.Language
+    result, err := p.bridge.Translator.Translate(ctx, text, language)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to translate guest message", zap.String("hostex_id", p.ID), zap.Error(err))
+        return ""
+    }
+    p.setLanguage(ctx, result.DetectedLanguage)
+    if baseLanguage(result.DetectedLanguage) == baseLanguage(language) || strings.TrimSpace(result.Text) == strings.TrimSpace(text) {
+        return ""
+    }
+    return fmt.Sprintf("\n\n🌐 %s → %s: %s", result.DetectedLanguage, language, result.Text)
+}
+
+// translateOutbound translates a reply to the guest's language if it's
+// known and isn't the host's.
+func (p *Portal) translateOutbound(ctx context.Context, body string) (string, error) {
+    language := p.guestLanguage()
+    if language == "" || baseLanguage(language) == baseLanguage(p.bridge.Config().Translation.Language) {
+        return body, nil
+    }
+    result, err := p.bridge.Translator.Translate(ctx, body, language)
+    if err != nil {
+        return "", err
+    }
+    return result.Text, nil
+}
+
+// sendTranslationNotice shows the translation of a reply that is sent to the
+// guest instead of the original, as a reply to it.
+func (p *Portal) sendTranslationNotice(ctx context.Context, eventID id.EventID, text string) {
+    content := &event.MessageEventContent{
+        MsgType: event.MsgNotice,
+        Body:    fmt.Sprintf("🌐 Sent in %s: %s", p.guestLanguage(), text),
+    }
+    if eventID != "" {
+        content.RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: eventID}}
+    }
+    _, err := p.bridge.MatrixClient.SendMessageEvent(ctx, p.RoomID, event.EventMessage, content)
+    if err != nil {
+        p.bridge.Logger.Warn("Failed to post reply translation", zap.String("hostex_id", p.ID), zap.Error(err))
+    }
+}
@@ -0,0 +1,179 @@
+package bridge
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "text/template"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/config"
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+// maxListedUpsells is how many sent offers !upsells shows.
+const maxListedUpsells = 30
+
+type upsellOffer struct {
+    offer      config.UpsellOffer
+    template   *template.Template
+    properties map[string]*template.Template
+}
+
+// upsellData is what upsell offer templates are rendered with.
+type upsellData struct {
+    replyTemplateData
+    Offer string
+    Price string
+}
+
+func parseUpsellOffers(offers []config.UpsellOffer) ([]upsellOffer, error) {
+    parsed := make([]upsellOffer, len(offers))
+    for i, offer := range offers {
+        tmpl, err := template.New(offer.Name).Parse(offer.Template)
+        if err != nil {
+            return nil, fmt.Errorf("invalid template of upsell offer %s: %w", offer.Name, err)
+        }
+        parsed[i] = upsellOffer{offer: offer, template: tmpl, properties: make(map[string]*template.Template)}
+        for property, price := range offer.Properties {
+            if price.Template == "" {
+                continue
+            }
+            tmpl, err = template.New(offer.Name + "/" + property).Parse(price.Template)
+            if err != nil {
+                return nil, fmt.Errorf("invalid template of upsell offer %s for %s: %w", offer.Name, property, err)
+            }
+            parsed[i].properties[property] = tmpl
+        }
+    }
+    return parsed, nil
+}
+
+// forProperty returns the price and template of the offer for the property
+// with the given title, or false if the property doesn't get the offer.
+func (uo upsellOffer) forProperty(title string) (string, *template.Template, bool) {
+    if len(uo.offer.Properties) == 0 {
+        return uo.offer.Price, uo.template, true
+    }
+    for name, price := range uo.offer.Properties {
+        if !strings.EqualFold(name, title) {
+            continue
+        }
+        tmpl := uo.template
+        if override, ok := uo.properties[name]; ok {
+            tmpl = override
+        }
+        if price.Price == "" {
+            return uo.offer.Price, tmpl, true
+        }
+        return price.Price, tmpl, true
+    }
+    return "", nil, false
+}
+
+// sendDate returns the day the offer should be sent to the portal's guest.
+func (uo upsellOffer) sendDate(p *Portal) (string, error) {
+    date := p.Info.CheckInDate
+    if uo.offer.Event == "check_out" {
+        date = p.Info.CheckOutDate
+    }
+    parsed, err := time.Parse(dateLayout, date)
+    if err != nil {
+        return "", err
+    }
+    return parsed.AddDate(0, 0, -uo.offer.DaysBefore).Format(dateLayout), nil
+}
+
+// sendUpsells sends the offers that are due today to the guests with a
+// confirmed booking, each offer once per stay.
+func (b *Bridge) sendUpsells(ctx context.Context, offers []upsellOffer) {
+    today := time.Now().In(b.location()).Format(dateLayout)
+    for _, portal := range b.allPortals() {
+        if portal.RoomID == "" || reservationStage(portal.Info.ReservationStatus) != reservationStatusConfirmed {
+            continue
+        }
+        for _, uo := range offers {
+            if date, err := uo.sendDate(portal); err != nil || date != today {
+                continue
+            }
+            price, tmpl, ok := uo.forProperty(portal.Info.PropertyTitle)
+            if !ok {
+                continue
+            }
+
+            sent, err := b.DB.IsUpsellSent(portal.ID, portal.Info.CheckInDate, uo.offer.Name)
+            if err != nil {
+                b.Logger.Error("Failed to check upsell offer", zap.Error(err))
+                continue
+            } else if sent {
+                continue
+            }
+
+            var message strings.Builder
+            err = tmpl.Execute(&message, upsellData{
+                replyTemplateData: portal.replyTemplateData(),
+                Offer:             uo.offer.Name,
+                Price:             price,
+            })
+            if err != nil {
+                b.Logger.Error("Failed to render upsell offer", zap.String("offer", uo.offer.Name), zap.Error(err))
+                continue
+            }
+            err = portal.sendAutomatedMessage(ctx, message.String())
+            if err != nil {
+                b.Logger.Error("Failed to send upsell offer", zap.String("hostex_id", portal.ID), zap.String("offer", uo.offer.Name), zap.Error(err))
+                continue
+            }
+            err = b.DB.StoreUpsell(&database.Upsell{
+                HostexID:    portal.ID,
+                CheckInDate: portal.Info.CheckInDate,
+                Offer:       uo.offer.Name,
+                Price:       price,
+                SentAt:      time.Now(),
+            })
+            if err != nil {
+                b.Logger.Error("Failed to store upsell offer", zap.Error(err))
+            }
+        }
+    }
+}
+
+func (b *Bridge) listUpsells(hostexID string) string {
+    upsells, err := b.DB.GetUpsells(hostexID, maxListedUpsells)
+    if err != nil {
+        b.Logger.Error("Failed to get upsell offers", zap.Error(err))
+        return fmt.Sprintf("Failed to get upsell offers: %v", err)
+    }
+    if len(upsells) == 0 {
+        return "No upsell offers sent."
+    }
+
+    var sb strings.Builder
+    sb.WriteString("Upsell offers sent:\n")
+    for _, upsell := range upsells {
+        guest := upsell.HostexID
+        if portal, ok := b.getPortalByID(upsell.HostexID); ok {
+            guest = fmt.Sprintf("%s at %s", portal.Info.Guest.Name, portal.Info.PropertyTitle)
+        }
+        sb.WriteString(fmt.Sprintf("%s: %s (%s) to %s, stay from %s\n",
+            upsell.SentAt.In(b.location()).Format("2006-01-02 15:04"), upsell.Offer, upsell.Price, guest, upsell.CheckInDate))
+    }
+    return sb.String()
+}
+
+func (u *User) listUpsells(ctx context.Context, roomID id.RoomID, args []string) {
+    var hostexID string
+    if len(args) > 0 {
+        query := strings.Join(args, " ")
+        portal := u.bridge.findPortal(query)
+        if portal == nil {
+            u.sendNotice(ctx, roomID, fmt.Sprintf("No conversation found for %q.", query))
+            return
+        }
+        hostexID = portal.ID
+    }
+    u.sendNotice(ctx, roomID, u.bridge.listUpsells(hostexID))
+}
@@ -3,17 +3,33 @@ package bridge
 import (
     "context"
     "fmt"
+    "sort"
     "strings"
     "time"
 
     "maunium.net/go/mautrix/event"
     "maunium.net/go/mautrix/id"
     "go.uber.org/zap"
+
+    "github.com/keithah/hostex-bridge-go/database"
+    "github.com/keithah/hostex-bridge-go/hostexapi"
 )
 
 type User struct {
     bridge *Bridge
     MXID   id.UserID
+
+    Preferences *database.Preferences
+
+    pendingAction *pendingAction
+    setup         *setupWizard
+    pendingImport *pendingImport
+}
+
+// pendingAction is an action that waits for the user to run !confirm.
+type pendingAction struct {
+    description string
+    run         func(ctx context.Context)
 }
 
 func NewUser(bridge *Bridge, mxid id.UserID) *User {
@@ -30,19 +46,99 @@ func (u *User) HandleCommand(roomID id.RoomID, body string) {
     }
 
     command := strings.ToLower(parts[0])
+    args := parts[1:]
 
-    ctx := context.Background()
+    ctx := u.bridge.ctx
 
     switch command {
     case "!help":
         u.sendHelpMessage(ctx, roomID)
     case "!status":
         u.sendStatusMessage(ctx, roomID)
+    case "!version":
+        u.sendVersion(ctx, roomID)
     case "!list":
         u.listConversations(ctx, roomID)
     case "!sync":
         u.forceSyncConversations(ctx, roomID)
+    case "!digest":
+        u.bridge.SendDigest(ctx)
+    case "!set":
+        u.setPreference(ctx, roomID, args)
+    case "!settings":
+        u.sendSettings(ctx, roomID)
+    case "!broadcast":
+        u.broadcast(ctx, roomID, args)
+    case "!gaps":
+        u.listVacancyGaps(ctx, roomID)
+    case "!gap-discount":
+        u.gapDiscount(ctx, roomID, args)
+    case "!properties":
+        u.listProperties(ctx, roomID)
+    case "!payouts":
+        u.listPayouts(ctx, roomID, args)
+    case "!review":
+        u.replyToReview(ctx, roomID, args)
+    case "!calendar":
+        u.showCalendar(ctx, roomID, args)
+    case "!reservation":
+        u.showReservation(ctx, roomID, args)
+    case "!template":
+        u.handleTemplateCommand(ctx, roomID, args, body)
+    case "!schedule":
+        u.scheduleCommand(ctx, roomID, args, body)
+    case "!sendcode":
+        u.sendCheckInInstructions(ctx, roomID, args)
+    case "!upsells":
+        u.listUpsells(ctx, roomID, args)
+    case "!tag":
+        u.tagCommand(ctx, roomID, args)
+    case "!block":
+        u.blockCommand(ctx, roomID, args, true)
+    case "!unblock":
+        u.blockCommand(ctx, roomID, args, false)
+    case "!blocked":
+        u.sendNotice(ctx, roomID, u.bridge.listBlockedGuests())
+    case "!merge-guest":
+        u.mergeGuest(ctx, roomID, args)
+    case "!guest":
+        u.guestCommand(ctx, roomID, args, body)
+    case "!drafts":
+        u.sendNotice(ctx, roomID, u.bridge.listDrafts(""))
+    case "!send-draft":
+        u.sendNotice(ctx, roomID, u.bridge.sendDraftCommand(ctx, "", args))
+    case "!import-history":
+        u.startHistoryImport(ctx, roomID, args)
+    case "!resync":
+        u.resyncPortal(ctx, roomID, args)
+    case "!backfill":
+        u.enqueueBackfill(ctx, roomID, args)
+    case "!export":
+        u.exportTranscript(ctx, roomID, args)
+    case "!search":
+        u.searchMessages(ctx, roomID, args)
+    case "!show-suppressed":
+        u.showSuppressed(ctx, roomID, args)
+    case "!rota":
+        u.showRota(ctx, roomID)
+    case "!create-portal":
+        u.createPortal(ctx, roomID, args)
+    case "!delete-portal":
+        u.deletePortal(ctx, roomID, args)
+    case "!purge-guest":
+        u.purgeGuest(ctx, roomID, args)
+    case "!config":
+        u.handleConfigCommand(ctx, roomID, args)
+    case "!setup":
+        u.startSetup(ctx, roomID)
+    case "!confirm":
+        u.confirm(ctx, roomID)
+    case "!cancel":
+        u.cancel(ctx, roomID)
     default:
+        if u.bridge.runCommandHook(roomID, u.MXID, command, args, nil) {
+            return
+        }
         u.sendUnknownCommandMessage(ctx, roomID)
     }
 }
@@ -52,9 +148,73 @@ func (u *User) sendHelpMessage(ctx context.Context, roomID id.RoomID) {
         MsgType: event.MsgNotice,
         Body: `Available commands:
 !help - Show this help message
+!setup - Run the setup wizard
 !status - Show bridge status
+!version - Show the bridge version and check for a newer release
 !list - List active conversations
-!sync - Force sync conversations from Hostex`,
+!sync - Force sync conversations from Hostex
+!import-history <conversation|room|guest> - Import older messages from an uploaded CSV or JSON export
+!resync <conversation|room|guest> [since YYYY-MM-DD] - Refresh one conversation and backfill missing messages
+!backfill <conversation|room|guest> [from YYYY-MM-DD] - Queue older history of a conversation
+!export <json|html> <conversation|room|guest|all> [from YYYY-MM-DD] [to YYYY-MM-DD] - Upload a transcript
+!search <words> - Find bridged messages containing all the words
+!show-suppressed <conversation|room|guest> - Show Hostex system messages that weren't bridged
+!create-portal <conversation|guest> - Create the room of a conversation now
+!delete-portal <conversation|room|guest> - Delete a portal room and its bridged messages
+!purge-guest <conversation|room|guest|email|phone> [--redact] - Delete all data of a guest who asked for it
+!digest - Send the daily digest now
+!settings - Show your settings
+!rota - Show the rota and who is on duty
+!set <timezone|digest-time|notifications> <value> - Change a setting
+!config <list|get|set> [key] [value] - Show or change bridge options without editing the config file
+!broadcast <audience> <message> - Send a message to several guests, audiences:
+  current-guests, arriving:<today|tomorrow|YYYY-MM-DD>, property:<name>
+!gaps - List orphan 1-2 night gaps between bookings
+!gap-discount <number> [percent] - Discount the prices of a vacancy gap
+!properties - List your properties and their IDs
+!calendar <property> [month] - Show bookings and blocked dates of a property
+!reservation <conversation|guest|code> - Show reservation details
+!payouts [month] - Summarize the payouts of a month
+!review <reservation code> <reply> - Publicly reply to a guest review
+!template <add|list|remove|send> - Manage quick reply templates, e.g. !template add checkin Hi {{.GuestName}}, ...
+!schedule <conversation|room|guest> <2h|15:00|checkin@15:00|YYYY-MM-DDTHH:MM> <message> - Send a message later
+!schedule <list|cancel <number>> - Show or cancel scheduled messages
+!sendcode <conversation|room|guest> - Send the door code and check-in instructions to the guest
+!block <conversation|room|guest> - Stop bridging a guest's messages and warn about their email or phone in new conversations
+!unblock <conversation|room|guest> - Bridge a blocked guest's messages again
+!blocked - List the blocked guests
+!guest history <conversation|room|guest|email|phone> - Show all conversations and stays of a guest
+!guest note <conversation|room|email|phone> [notes] - Set or clear the notes about a guest
+!merge-guest <conversation|room|email|phone> <conversation|room|email|phone> - Merge the second guest into the first, e.g. an Airbnb guest who later booked direct
+!tag <add|remove> <tag> <conversation|room|guest> - Change the Hostex tags of a conversation
+!upsells [conversation|room|guest] - List the upsell offers sent to guests
+!drafts - List replies that failed to send
+!send-draft <number> - Retry sending a draft
+!confirm - Run the action waiting for confirmation
+!cancel - Discard the action waiting for confirmation
+
+Portal room commands:
+!info - Show the reservation linked to the conversation
+!contact - Show WhatsApp, phone and email links for the guest
+!reply <number> - Send one of the suggested replies
+!send-suggestion - Send the AI suggested reply
+!preapprove - Pre-approve an Airbnb inquiry
+!specialoffer <total price> [check-in] [check-out] - Send an Airbnb special offer
+!sendcode - Send the door code and check-in instructions to the guest
+!upsells - List the upsell offers sent to the guest
+!tag [add|remove <tag>] - Show or change the Hostex tags of the conversation
+!block [reason] - Stop bridging the guest's messages
+!unblock - Bridge the guest's messages again
+!guest [history] - Show all conversations and stays of the guest
+!guest note [notes] - Set or clear the notes about the guest
+!done - Archive the conversation in Hostex and the room until the guest writes again
+!template <name> - Send a quick reply template
+!schedule <2h|15:00|checkin@15:00|YYYY-MM-DDTHH:MM> <message> - Send a message later
+!check <item> - Mark a checklist item as done
+!uncheck <item> - Mark a checklist item as not done
+!drafts - List this conversation's unsent replies
+!send-draft <number> - Retry sending a draft
+!email <message> - Reply to the guest by email` + u.bridge.customCommandsHelp(),
     }
     _, err := u.bridge.MatrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
     if err != nil {
@@ -64,27 +224,89 @@ func (u *User) sendHelpMessage(ctx context.Context, roomID id.RoomID) {
 
 func (u *User) sendStatusMessage(ctx context.Context, roomID id.RoomID) {
     var bridgedRooms int
-    lastPollTime := u.bridge.GetLastPollTime()
 
-    for _, portal := range u.bridge.portalsByID {
+    roomsByAccount := make(map[string]int)
+    for _, portal := range u.bridge.allPortals() {
         if portal.RoomID != "" {
             bridgedRooms++
+            roomsByAccount[portal.Account()]++
+        }
+    }
+
+    queued, err := u.bridge.DB.CountOutbox()
+    if err != nil {
+        u.bridge.Logger.Error("Failed to count queued messages", zap.Error(err))
+    }
+
+    budget := "unlimited"
+    if used, limit := u.bridge.HostexClient.BudgetUsage(); limit > 0 {
+        budget = fmt.Sprintf("%d/%d requests in the last minute", used, limit)
+    }
+
+    rateLimit := "OK"
+    rateLimitedUntil, rateLimitHits := u.bridge.HostexClient.RateLimitState()
+    if !rateLimitedUntil.IsZero() {
+        rateLimit = fmt.Sprintf("rate limited until %s", rateLimitedUntil.Format(time.RFC3339))
+    }
+
+    endpoints := "single endpoint"
+    if health := u.bridge.HostexClient.EndpointHealth(); len(health) > 1 {
+        var states []string
+        for url, healthy := range health {
+            state := "up"
+            if !healthy {
+                state = "down"
+            }
+            states = append(states, fmt.Sprintf("%s (%s)", url, state))
+        }
+        sort.Strings(states)
+        endpoints = strings.Join(states, ", ")
+    }
+
+    accounts := make([]string, 0, len(u.bridge.accounts)+1)
+    lastPolls := make([]string, 0, len(u.bridge.accounts)+1)
+    for _, account := range u.bridge.accountNames() {
+        accounts = append(accounts, fmt.Sprintf("%s (%d)", accountLabel(account), roomsByAccount[account]))
+        lastPoll := "never"
+        if polledAt := u.bridge.GetLastPollTime(account); !polledAt.IsZero() {
+            lastPoll = polledAt.Format(time.RFC3339)
         }
+        if len(u.bridge.accounts) > 0 {
+            lastPoll = fmt.Sprintf("%s (%s)", lastPoll, accountLabel(account))
+        }
+        lastPolls = append(lastPolls, lastPoll)
     }
 
     content := &event.MessageEventContent{
         MsgType: event.MsgNotice,
         Body: fmt.Sprintf(`Bridge Status:
+Instance: %s
 Connected to Hostex: %v
+Hostex accounts: %s
+Hostex API: %s (%d rate limit responses since start)
+Hostex API budget: %s
+Hostex API endpoints: %s
 Bridged conversations: %d
+Queued outbound messages: %d
+Backfill queue: %s
 Last poll time: %s
+%s
 Timezone: %s`,
+            u.bridge.instanceStatus(),
             u.bridge.HostexClient != nil,
+            strings.Join(accounts, ", "),
+            rateLimit,
+            rateLimitHits,
+            budget,
+            endpoints,
             bridgedRooms,
-            lastPollTime.Format(time.RFC3339),
-            u.bridge.Config.Timezone),
+            queued,
+            u.bridge.backfillQueueStatus(),
+            strings.Join(lastPolls, ", "),
+            u.bridge.pollReliability(),
+            u.bridge.location().String()),
     }
-    _, err := u.bridge.MatrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+    _, err = u.bridge.MatrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
     if err != nil {
         u.bridge.Logger.Error("Failed to send status message", zap.Error(err))
     }
@@ -94,13 +316,33 @@ func (u *User) listConversations(ctx context.Context, roomID id.RoomID) {
     var conversationList strings.Builder
     conversationList.WriteString("Active conversations:\n\n")
 
-    for _, portal := range u.bridge.portalsByID {
+    for _, portal := range u.bridge.allPortals() {
         if portal.RoomID != "" {
-            conversationList.WriteString(fmt.Sprintf("- %s (%s)\n  Room: %s\n  Last activity: %s\n\n",
+            conversationList.WriteString(fmt.Sprintf("- %s (%s)\n  Room: %s\n  Last activity: %s\n  Checklist: %s\n",
                 portal.Info.Guest.Name,
                 portal.Info.ChannelType,
                 portal.RoomID,
-                portal.Info.LastMessageAt.Format(time.RFC3339)))
+                portal.Info.LastMessageAt.Format(time.RFC3339),
+                portal.checklistProgress()))
+            if len(u.bridge.accounts) > 0 {
+                conversationList.WriteString(fmt.Sprintf("  Hostex account: %s\n", accountLabel(portal.Account())))
+            }
+            if portal.Info.ReservationStatus != "" {
+                conversationList.WriteString(fmt.Sprintf("  Status: %s\n", reservationStage(portal.Info.ReservationStatus)))
+            }
+            if language := portal.guestLanguage(); language != "" {
+                conversationList.WriteString(fmt.Sprintf("  Language: %s\n", language))
+            }
+            if portal.blocked {
+                conversationList.WriteString("  Blocked\n")
+            }
+            if len(portal.Info.Tags) > 0 {
+                conversationList.WriteString(fmt.Sprintf("  Tags: %s\n", strings.Join(portal.Info.Tags, ", ")))
+            }
+            if assignee := portal.getAssignee(); assignee != "" {
+                conversationList.WriteString(fmt.Sprintf("  Waiting for reply, assigned to %s\n", assignee))
+            }
+            conversationList.WriteString("\n")
         }
     }
 
@@ -115,12 +357,52 @@ func (u *User) listConversations(ctx context.Context, roomID id.RoomID) {
 }
 
 func (u *User) forceSyncConversations(ctx context.Context, roomID id.RoomID) {
+    started := u.bridge.goTask(func() {
+        u.bridge.ForceSyncConversations(hostexapi.WithPriority(ctx, hostexapi.PriorityBackfill))
+        u.sendNotice(ctx, roomID, "Sync complete. Use !list to see updated conversations.")
+    })
+    if !started {
+        u.sendNotice(ctx, roomID, "The bridge is shutting down, try again after the restart.")
+        return
+    }
     u.sendNotice(ctx, roomID, "Forcing sync of conversations from Hostex...")
+}
 
-    go func() {
-        u.bridge.ForceSyncConversations()
-        u.sendNotice(ctx, roomID, "Sync complete. Use !list to see updated conversations.")
-    }()
+func (u *User) requestConfirmation(ctx context.Context, roomID id.RoomID, description string, run func(ctx context.Context)) {
+    u.pendingAction = &pendingAction{
+        description: description,
+        run:         run,
+    }
+    u.sendNotice(ctx, roomID, description+"\n\nType !confirm to continue or !cancel to discard.")
+}
+
+func (u *User) confirm(ctx context.Context, roomID id.RoomID) {
+    action := u.pendingAction
+    if action == nil {
+        u.sendNotice(ctx, roomID, "There is nothing waiting for confirmation.")
+        return
+    }
+    u.pendingAction = nil
+    action.run(ctx)
+}
+
+func (u *User) cancel(ctx context.Context, roomID id.RoomID) {
+    if u.pendingImport != nil {
+        u.pendingImport = nil
+        u.sendNotice(ctx, roomID, "Import cancelled.")
+        return
+    }
+    if u.setup != nil {
+        u.setup = nil
+        u.sendNotice(ctx, roomID, "Setup cancelled. Type !setup to start again.")
+        return
+    }
+    if u.pendingAction == nil {
+        u.sendNotice(ctx, roomID, "There is nothing waiting for confirmation.")
+        return
+    }
+    u.pendingAction = nil
+    u.sendNotice(ctx, roomID, "Cancelled.")
 }
 
 func (u *User) sendUnknownCommandMessage(ctx context.Context, roomID id.RoomID) {
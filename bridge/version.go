@@ -0,0 +1,134 @@
+package bridge
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "runtime"
+    "runtime/debug"
+    "strconv"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+// Build information, set with e.g.
+//
+//  go build -ldflags "-X github.com/keithah/hostex-bridge-go/bridge.Version=v1.2.0 \
+//      -X github.com/keithah/hostex-bridge-go/bridge.Commit=$(git rev-parse HEAD) \
+//      -X github.com/keithah/hostex-bridge-go/bridge.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// The commit and build time fall back to the VCS information Go embeds.
+var (
+    Version   = "dev"
+    Commit    = "unknown"
+    BuildTime = "unknown"
+)
+
+const latestReleaseURL = "https://api.github.com/repos/keithah/hostex-bridge-go/releases/latest"
+
+func init() {
+    info, ok := debug.ReadBuildInfo()
+    if !ok {
+        return
+    }
+    for _, setting := range info.Settings {
+        switch {
+        case setting.Key == "vcs.revision" && Commit == "unknown":
+            Commit = setting.Value
+        case setting.Key == "vcs.time" && BuildTime == "unknown":
+            BuildTime = setting.Value
+        }
+    }
+}
+
+// VersionString describes the build, e.g. "v1.2.0 (commit 0123abcd, built
+// 2024-05-01T12:00:00Z)".
+func VersionString() string {
+    commit := Commit
+    if len(commit) > 8 {
+        commit = commit[:8]
+    }
+    return fmt.Sprintf("%s (commit %s, built %s)", Version, commit, BuildTime)
+}
+
+// latestRelease returns the tag of the newest published release.
+func latestRelease(ctx context.Context) (string, error) {
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, latestReleaseURL, nil)
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Accept", "application/vnd.github+json")
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("release request failed with status code: %d", resp.StatusCode)
+    }
+    var release struct {
+        TagName string `json:"tag_name"`
+    }
+    err = json.NewDecoder(resp.Body).Decode(&release)
+    if err != nil {
+        return "", err
+    }
+    return release.TagName, nil
+}
+
+// isNewerVersion reports whether the vX.Y.Z version a is newer than b. Other
+// version strings, like dev builds, are never newer or older.
+func isNewerVersion(a, b string) bool {
+    parse := func(version string) []int {
+        parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+        if len(parts) != 3 {
+            return nil
+        }
+        numbers := make([]int, 3)
+        for i, part := range parts {
+            n, err := strconv.Atoi(part)
+            if err != nil {
+                return nil
+            }
+            numbers[i] = n
+        }
+        return numbers
+    }
+    va, vb := parse(a), parse(b)
+    if va == nil || vb == nil {
+        return false
+    }
+    for i := range va {
+        if va[i] != vb[i] {
+            return va[i] > vb[i]
+        }
+    }
+    return false
+}
+
+func (u *User) sendVersion(ctx context.Context, roomID id.RoomID) {
+    var msg strings.Builder
+    msg.WriteString(fmt.Sprintf("Hostex bridge %s\n", VersionString()))
+    msg.WriteString(fmt.Sprintf("mautrix-go %s, %s\n", mautrix.VersionWithCommit, runtime.Version()))
+
+    latest, err := latestRelease(ctx)
+    switch {
+    case err != nil:
+        u.bridge.Logger.Warn("Failed to check for a newer release", zap.Error(err))
+        msg.WriteString("Couldn't check for a newer release.")
+    case isNewerVersion(latest, Version):
+        msg.WriteString(fmt.Sprintf("A newer release is available: %s", latest))
+    case Version == "dev":
+        msg.WriteString(fmt.Sprintf("This is a development build, the latest release is %s.", latest))
+    default:
+        msg.WriteString("This is the latest release.")
+    }
+    u.sendNotice(ctx, roomID, msg.String())
+}
@@ -1,9 +1,15 @@
 package config
 
 import (
+    "encoding/base64"
+    "fmt"
     "io/ioutil"
+    "net"
+    "os"
+    "strings"
     "time"
 
+    "go.uber.org/zap/zapcore"
     "gopkg.in/yaml.v2"
     "maunium.net/go/mautrix/id"
 )
@@ -19,32 +25,515 @@ type Config struct {
     } `yaml:"user"`
 
     Hostex struct {
-        APIURL string `yaml:"api_url"`
-        Token  string `yaml:"token"`
+        APIURL  string        `yaml:"api_url"`
+        // FallbackURLs are other API base URLs, like regional endpoints,
+        // that requests fail over to while api_url is down.
+        FallbackURLs []string `yaml:"fallback_urls"`
+        Token   string        `yaml:"token"`
+        Timeout time.Duration `yaml:"timeout"`
+
+        // RequestsPerMinute limits API usage, with backfill and bulk jobs
+        // only allowed to use a share of it. Zero means unlimited.
+        RequestsPerMinute int     `yaml:"requests_per_minute"`
+        BackfillShare     float64 `yaml:"backfill_share"`
+        BulkShare         float64 `yaml:"bulk_share"`
+
+        // Accounts are additional Hostex accounts whose conversations are
+        // bridged too, with portal IDs prefixed by the account name.
+        // The watchers, like reservation notices and reviews, check every
+        // account. The property listing and !availability only use the main
+        // account.
+        Accounts []HostexAccount `yaml:"accounts"`
     } `yaml:"hostex"`
 
+    // Suppression lists categories of Hostex system messages, like payout
+    // notices or marketing prompts, that are stored but not bridged. A
+    // message from one of the senders is suppressed when its content
+    // contains one of the keywords of a category.
+    Suppression struct {
+        Senders    []string            `yaml:"senders"`
+        Categories map[string][]string `yaml:"categories"`
+    } `yaml:"suppression"`
+
     Appservice struct {
         URL     string `yaml:"url"`
         ASToken string `yaml:"as_token"`
+        HSToken string `yaml:"hs_token"`
+
+        // Listen is the address of the HTTP listener that receives events
+        // pushed by the homeserver. When empty, the bot uses /sync instead.
+        Listen string `yaml:"listen"`
+
+        // ID is the appservice ID in the registration file. The ghost users
+        // it claims are those with the localpart prefix Bridge.UserPrefix.
+        ID string `yaml:"id"`
     } `yaml:"appservice"`
 
     Admin struct {
         UserID id.UserID `yaml:"user_id"`
+        // AccessToken is an optional access token of the admin's account.
+        // Room tags are per user, so the bridge can only tag rooms for the
+        // admin, e.g. archived rooms as low priority, by acting as them.
+        AccessToken string `yaml:"access_token"`
     } `yaml:"admin"`
 
+    // Permissions maps user IDs, homeserver domains, room IDs and "*" to a
+    // permission level: user (may send messages to guests), admin (may also
+    // run commands) or owner (may also change the bridge setup). The admin
+    // above is always an owner.
+    Permissions map[string]string `yaml:"permissions"`
+
+    // Bridge has the templates of guest ghost users and portal room names,
+    // which get the guest's .Name, .Channel and .Property. The username
+    // template gets the conversation ID as {{.}}.
     Bridge struct {
         UserPrefix        string `yaml:"user_prefix"`
         UsernameTemplate  string `yaml:"username_template"`
         DisplaynameFormat string `yaml:"displayname_format"`
+        RoomNameFormat    string `yaml:"room_name_format"`
+        // Ghosts sends guest messages as a ghost user per guest instead of
+        // the bridge bot.
+        Ghosts bool `yaml:"ghosts"`
     } `yaml:"bridge"`
 
     Timezone            string        `yaml:"timezone"`
     PollInterval        time.Duration `yaml:"poll_interval"`
     PersonalSpaceEnable bool          `yaml:"personal_filtering_spaces"`
+    // PropertySpaces files portals under a child space per property inside
+    // the personal space.
+    PropertySpaces bool `yaml:"property_spaces"`
+
+    // ChannelAvatars are the MXC URIs of the room avatars used for portals
+    // whose guest has no profile photo, keyed by channel type, e.g. airbnb,
+    // booking.com or vrbo.
+    ChannelAvatars map[string]id.ContentURIString `yaml:"channel_avatars"`
+
+    AdaptivePolling struct {
+        Enable       bool          `yaml:"enable"`
+        MinInterval  time.Duration `yaml:"min_interval"`
+        MaxInterval  time.Duration `yaml:"max_interval"`
+        ActiveWindow time.Duration `yaml:"active_window"`
+        IdleAfter    time.Duration `yaml:"idle_after"`
+        NightStart   string        `yaml:"night_start"`
+        NightEnd     string        `yaml:"night_end"`
+    } `yaml:"adaptive_polling"`
+
+    Digest struct {
+        Enable bool   `yaml:"enable"`
+        Time   string `yaml:"time"`
+    } `yaml:"digest"`
+
+    VacancyGaps struct {
+        Enable          bool    `yaml:"enable"`
+        MaxNights       int     `yaml:"max_nights"`
+        HorizonDays     int     `yaml:"horizon_days"`
+        DiscountPercent float64 `yaml:"discount_percent"`
+    } `yaml:"vacancy_gaps"`
+
+    CalendarFeed struct {
+        Enable      bool   `yaml:"enable"`
+        HorizonDays int    `yaml:"horizon_days"`
+        Time        string `yaml:"time"`
+    } `yaml:"calendar_feed"`
+
+    ReservationNotices struct {
+        Enable      bool          `yaml:"enable"`
+        Interval    time.Duration `yaml:"interval"`
+        HorizonDays int           `yaml:"horizon_days"`
+    } `yaml:"reservation_notices"`
+
+    // PaymentNotices posts failed payments, security deposit changes and
+    // payouts.
+    PaymentNotices struct {
+        Enable   bool          `yaml:"enable"`
+        Interval time.Duration `yaml:"interval"`
+    } `yaml:"payment_notices"`
+
+    Reviews struct {
+        Enable        bool          `yaml:"enable"`
+        DedicatedRoom bool          `yaml:"dedicated_room"`
+        Interval      time.Duration `yaml:"interval"`
+    } `yaml:"reviews"`
+
+    Email struct {
+        Enable       bool          `yaml:"enable"`
+        IMAPAddress  string        `yaml:"imap_address"`
+        SMTPAddress  string        `yaml:"smtp_address"`
+        Username     string        `yaml:"username"`
+        Password     string        `yaml:"password"`
+        From         string        `yaml:"from"`
+        Mailbox      string        `yaml:"mailbox"`
+        PollInterval time.Duration `yaml:"poll_interval"`
+        Timeout      time.Duration `yaml:"timeout"`
+    } `yaml:"email"`
+
+    ReplySuggestions struct {
+        Enable   bool    `yaml:"enable"`
+        MinScore float64 `yaml:"min_score"`
+        History  int     `yaml:"history"`
+    } `yaml:"reply_suggestions"`
+
+    // AISuggestions asks an OpenAI-compatible chat completions API for a
+    // reply to new guest messages. The reply is posted as a notice in a
+    // thread of the guest message and only sent once someone approves it.
+    AISuggestions struct {
+        Enable       bool   `yaml:"enable"`
+        URL          string `yaml:"url"`
+        APIKey       string `yaml:"api_key"`
+        Model        string `yaml:"model"`
+        SystemPrompt string `yaml:"system_prompt"`
+        // History is how many of the latest messages of the conversation
+        // are sent along.
+        History int           `yaml:"history"`
+        Timeout time.Duration `yaml:"timeout"`
+        // MaxAge is how old a guest message may be to get a suggestion, so
+        // backfilled history doesn't.
+        MaxAge          time.Duration `yaml:"max_age"`
+        ApproveReaction string        `yaml:"approve_reaction"`
+    } `yaml:"ai_suggestions"`
+
+    // Translation appends a translation to guest messages in other languages
+    // and, with Outbound, translates replies to the guest's language.
+    Translation struct {
+        Enable bool `yaml:"enable"`
+        // Provider is deepl, google or libretranslate.
+        Provider string `yaml:"provider"`
+        URL      string `yaml:"url"`
+        APIKey   string `yaml:"api_key"`
+        // Language is the host's language, which guest messages are
+        // translated to.
+        Language string        `yaml:"language"`
+        Outbound bool          `yaml:"outbound"`
+        Timeout  time.Duration `yaml:"timeout"`
+    } `yaml:"translation"`
+
+    Archive struct {
+        Enable    bool   `yaml:"enable"`
+        AfterDays int    `yaml:"after_days"`
+        Time      string `yaml:"time"`
+    } `yaml:"archive"`
+
+    // Retention deletes messages, including archived ones, older than
+    // AfterDays, and optionally redacts them in Matrix too.
+    Retention struct {
+        Enable       bool   `yaml:"enable"`
+        AfterDays    int    `yaml:"after_days"`
+        RedactMatrix bool   `yaml:"redact_matrix"`
+        Time         string `yaml:"time"`
+    } `yaml:"retention"`
+
+    // PortalArchive archives portal rooms some days after checkout if the
+    // guest hasn't written since. Archived rooms are renamed, tagged as low
+    // priority and removed from the conversation space, and are restored
+    // when the guest writes again.
+    PortalArchive struct {
+        Enable    bool   `yaml:"enable"`
+        AfterDays int    `yaml:"after_days"`
+        Time      string `yaml:"time"`
+        Prefix    string `yaml:"prefix"`
+        // Space moves archived rooms to an "Archive" space instead of only
+        // removing them from the conversation space.
+        Space bool `yaml:"space"`
+        // Leave makes the bridge leave archived rooms. A new room is
+        // created if the guest writes again.
+        Leave bool `yaml:"leave"`
+    } `yaml:"portal_archive"`
+
+    // DoneReaction makes reacting with Key to a message in a portal room do
+    // what !done does.
+    DoneReaction struct {
+        Enable bool   `yaml:"enable"`
+        Key    string `yaml:"key"`
+    } `yaml:"done_reaction"`
+
+    // ConversationTags mirrors the Hostex tags of conversations as Matrix
+    // room tags of the admin, named Prefix followed by the tag unless Mapping
+    // has a room tag for it, e.g. m.favourite. It needs Admin.AccessToken.
+    ConversationTags struct {
+        RoomTags bool              `yaml:"room_tags"`
+        Prefix   string            `yaml:"prefix"`
+        Mapping  map[string]string `yaml:"mapping"`
+    } `yaml:"conversation_tags"`
+
+    Reminders struct {
+        Enable       bool           `yaml:"enable"`
+        CheckInTime  string         `yaml:"check_in_time"`
+        CheckOutTime string         `yaml:"check_out_time"`
+        Rules        []ReminderRule `yaml:"rules"`
+    } `yaml:"reminders"`
+
+    // AutomationDisclosure appends a footer to the guest messages sent by
+    // automations, e.g. the satisfaction pulse, but never to replies typed
+    // by a person.
+    AutomationDisclosure struct {
+        Enable bool   `yaml:"enable"`
+        Footer string `yaml:"footer"`
+    } `yaml:"automation_disclosure"`
+
+    // AutoResponder replies to guest messages that match a rule, e.g. to
+    // acknowledge inquiries at night. Only the first matching rule replies,
+    // and each rule at most once per conversation within Cooldown.
+    AutoResponder struct {
+        Enable bool `yaml:"enable"`
+        // MaxAge is how old a guest message may be to get a reply, so
+        // history and messages from a downtime aren't answered.
+        MaxAge   time.Duration   `yaml:"max_age"`
+        Cooldown time.Duration   `yaml:"cooldown"`
+        Rules    []AutoReplyRule `yaml:"rules"`
+    } `yaml:"auto_responder"`
+
+    // CheckInInstructions are sent to the guest with !sendcode. The door
+    // code and instructions come from the reservation's Hostex custom fields
+    // named CodeField and InstructionsField, or else from the entry of the
+    // property in Properties.
+    CheckInInstructions struct {
+        CodeField         string                          `yaml:"code_field"`
+        InstructionsField string                          `yaml:"instructions_field"`
+        Template          string                          `yaml:"template"`
+        Properties        map[string]PropertyInstructions `yaml:"properties"`
+    } `yaml:"check_in_instructions"`
+
+    // Upsells offers extras like early check-in and late check-out to guests
+    // with a confirmed booking. Offers are sent daily at Time.
+    Upsells struct {
+        Enable bool          `yaml:"enable"`
+        Time   string        `yaml:"time"`
+        Offers []UpsellOffer `yaml:"offers"`
+    } `yaml:"upsells"`
+
+    SatisfactionPulse struct {
+        Enable  bool   `yaml:"enable"`
+        Time    string `yaml:"time"`
+        Message string `yaml:"message"`
+    } `yaml:"satisfaction_pulse"`
+
+    // NotificationHints makes guest messages that need attention mention the
+    // admin, which triggers a noisy notification, while routine platform
+    // messages are sent as silent notices.
+    NotificationHints struct {
+        Enable              bool     `yaml:"enable"`
+        HighValueProperties []string `yaml:"high_value_properties"`
+        PlatformSenders     []string `yaml:"platform_senders"`
+    } `yaml:"notification_hints"`
+
+    // Rota is the weekly schedule of who is responsible for guest
+    // conversations. The user on duty gets the alerts, is assigned the
+    // conversations that need a reply, and with Attribution, replies are
+    // prefixed with the name of the shift member who sent them.
+    Rota struct {
+        Shifts      []RotaShift `yaml:"shifts"`
+        Attribution bool        `yaml:"attribution"`
+    } `yaml:"rota"`
+
+    QuietHours struct {
+        Enable         bool     `yaml:"enable"`
+        Start          string   `yaml:"start"`
+        End            string   `yaml:"end"`
+        UrgentKeywords []string `yaml:"urgent_keywords"`
+    } `yaml:"quiet_hours"`
+
+    // CommandHooks maps custom command names (without the !) to webhook
+    // URLs. The webhook receives the command with its context and its
+    // response is posted back to the room.
+    CommandHooks struct {
+        Timeout  time.Duration     `yaml:"timeout"`
+        Secret   string            `yaml:"secret"`
+        Commands map[string]string `yaml:"commands"`
+    } `yaml:"command_hooks"`
+
+    // Invariants cross-checks after every poll that each Hostex message
+    // seen has a database row and a Matrix event. It costs a homeserver
+    // request per message, so it's meant for testing.
+    Invariants struct {
+        Enable bool `yaml:"enable"`
+    } `yaml:"invariants"`
+
+    // Downtime posts a recovery summary to the management room on startup
+    // after an unclean shutdown or a downtime longer than NoticeAfter.
+    Downtime struct {
+        NoticeAfter time.Duration `yaml:"notice_after"`
+    } `yaml:"downtime"`
+
+    // Backfill controls how much history new portals get and how many
+    // messages are fetched per request.
+    Backfill struct {
+        // InitialMessages is how many of the newest messages a new portal
+        // gets, -1 for the whole history.
+        InitialMessages int `yaml:"initial_messages"`
+        // InitialAge limits the initial backfill to messages newer than this,
+        // 0 for no limit.
+        InitialAge time.Duration `yaml:"initial_age"`
+        PageSize   int           `yaml:"page_size"`
+        // BatchSend sends the initial history as MSC2716 historical events
+        // in appservice mode, if the homeserver supports it.
+        BatchSend bool `yaml:"batch_send"`
+    } `yaml:"backfill"`
+
+    // Shutdown is how long stopping the bridge waits for in-flight
+    // backfills and sends before cancelling them.
+    Shutdown struct {
+        Timeout time.Duration `yaml:"timeout"`
+    } `yaml:"shutdown"`
+
+    Outbox struct {
+        MaxAttempts   int           `yaml:"max_attempts"`
+        RetryInterval time.Duration `yaml:"retry_interval"`
+    } `yaml:"outbox"`
+
+    HA struct {
+        Enable        bool          `yaml:"enable"`
+        InstanceID    string        `yaml:"instance_id"`
+        Secret        string        `yaml:"secret"`
+        LeaseDuration time.Duration `yaml:"lease_duration"`
+        RenewInterval time.Duration `yaml:"renew_interval"`
+    } `yaml:"ha"`
+
+    Redis struct {
+        URL       string        `yaml:"url"`
+        KeyPrefix string        `yaml:"key_prefix"`
+        Timeout   time.Duration `yaml:"timeout"`
+    } `yaml:"redis"`
+
+    AccessLog struct {
+        Path       string `yaml:"path"`
+        MaxSize    int    `yaml:"max_size"`
+        MaxBackups int    `yaml:"max_backups"`
+        // TrustedProxies are the IP addresses or CIDR ranges of reverse
+        // proxies whose X-Forwarded-For header is used for the caller.
+        TrustedProxies []string `yaml:"trusted_proxies"`
+    } `yaml:"access_log"`
 
     Database struct {
         Path string `yaml:"path"`
+        // EncryptionKey is a base64 encoded 32 byte key to encrypt message
+        // contents and guest contact details with, or EncryptionKeyFile the
+        // file containing it.
+        EncryptionKey     string `yaml:"encryption_key"`
+        EncryptionKeyFile string `yaml:"encryption_key_file"`
+        // EncryptionKeyBytes is the decoded key, set by Load.
+        EncryptionKeyBytes []byte `yaml:"-"`
     } `yaml:"database"`
+
+    // Tracing exports OpenTelemetry spans of the poll, backfill and send
+    // paths to an OTLP/HTTP collector.
+    Tracing struct {
+        Enable bool `yaml:"enable"`
+        // Endpoint is the host:port of the collector.
+        Endpoint    string  `yaml:"endpoint"`
+        Insecure    bool    `yaml:"insecure"`
+        ServiceName string  `yaml:"service_name"`
+        SampleRatio float64 `yaml:"sample_ratio"`
+    } `yaml:"tracing"`
+
+    // PProf serves net/http/pprof for profiling on a loopback address.
+    PProf struct {
+        Enable bool   `yaml:"enable"`
+        Listen string `yaml:"listen"`
+    } `yaml:"pprof"`
+
+    Logging struct {
+        // Format is console for human readable lines or json for log
+        // shippers.
+        Format string `yaml:"format"`
+        // Level is debug, info, warn or error. The -v flag forces debug.
+        Level string `yaml:"level"`
+        // Output is stderr, stdout or a file path.
+        Output string `yaml:"output"`
+        // A file output is rotated once it grows beyond MaxSize MB or is
+        // older than MaxAge, keeping MaxBackups old files.
+        MaxSize    int           `yaml:"max_size"`
+        MaxBackups int           `yaml:"max_backups"`
+        MaxAge     time.Duration `yaml:"max_age"`
+    } `yaml:"logging"`
+}
+
+// ReminderRule posts a reminder Offset before a guest's check-in or
+// check-out. Template is a text/template rendered with the reservation.
+type ReminderRule struct {
+    Event    string        `yaml:"event"`
+    Offset   time.Duration `yaml:"offset"`
+    Template string        `yaml:"template"`
+}
+
+// AutoReplyRule replies to a guest message with Template, which is rendered
+// like reply templates. Trigger is keyword (the message contains one of
+// Keywords), first_message (the first message of the conversation) or any.
+// Start and End (HH:MM, may wrap past midnight) limit the rule to a time of
+// day, Properties to the conversations of some properties and Languages to
+// guests writing in one of the languages.
+type AutoReplyRule struct {
+    Name       string   `yaml:"name"`
+    Trigger    string   `yaml:"trigger"`
+    Keywords   []string `yaml:"keywords"`
+    Start      string   `yaml:"start"`
+    End        string   `yaml:"end"`
+    Properties []string `yaml:"properties"`
+    Languages  []string `yaml:"languages"`
+    Template   string   `yaml:"template"`
+}
+
+// UpsellOffer is sent DaysBefore days before the check-in or check-out
+// (Event check_in or check_out). Template is rendered like reply templates,
+// plus .Offer and .Price. Properties overrides the price and template by
+// property title; if it's set, only those properties get the offer.
+type UpsellOffer struct {
+    Name       string                 `yaml:"name"`
+    Event      string                 `yaml:"event"`
+    DaysBefore int                    `yaml:"days_before"`
+    Price      string                 `yaml:"price"`
+    Template   string                 `yaml:"template"`
+    Properties map[string]UpsellPrice `yaml:"properties"`
+}
+
+// UpsellPrice is the price and optionally the template of an upsell offer
+// for one property.
+type UpsellPrice struct {
+    Price    string `yaml:"price"`
+    Template string `yaml:"template"`
+}
+
+// PropertyInstructions are the check-in details of a property, keyed by its
+// ID or title in CheckInInstructions.Properties.
+type PropertyInstructions struct {
+    DoorCode     string `yaml:"door_code"`
+    Instructions string `yaml:"instructions"`
+}
+
+// HostexAccount is an additional Hostex account.
+type HostexAccount struct {
+    Name  string `yaml:"name"`
+    Token string `yaml:"token"`
+}
+
+// RotaShift makes UserID responsible on the given days between Start and End
+// (HH:MM). Shifts may wrap past midnight, in which case the day is the one the
+// shift starts on. No days means every day.
+type RotaShift struct {
+    Name   string    `yaml:"name"`
+    UserID id.UserID `yaml:"user_id"`
+    Days   []string  `yaml:"days"`
+    Start  string    `yaml:"start"`
+    End    string    `yaml:"end"`
+}
+
+// Weekdays are the valid day names of rota shifts.
+var Weekdays = map[string]time.Weekday{
+    "sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+    "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// isLoopbackAddress reports whether a host:port listen address only accepts
+// local connections.
+func isLoopbackAddress(address string) bool {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return false
+    }
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
 }
 
 func Load(path string) (*Config, error) {
@@ -58,14 +547,463 @@ func Load(path string) (*Config, error) {
     if err != nil {
         return nil, err
     }
+    err = applyEnvOverrides(&cfg)
+    if err != nil {
+        return nil, err
+    }
 
     // Set defaults
     if cfg.Timezone == "" {
         cfg.Timezone = "America/Los_Angeles"
     }
+    if len(cfg.Suppression.Senders) == 0 {
+        cfg.Suppression.Senders = []string{"system"}
+    }
+    if cfg.Appservice.ID == "" {
+        cfg.Appservice.ID = "hostex"
+    }
+    if cfg.Bridge.UserPrefix == "" {
+        cfg.Bridge.UserPrefix = "hostex_"
+    }
+    if cfg.Bridge.UsernameTemplate == "" {
+        cfg.Bridge.UsernameTemplate = cfg.Bridge.UserPrefix + "{{.}}"
+    }
+    if cfg.Bridge.DisplaynameFormat == "" {
+        cfg.Bridge.DisplaynameFormat = "{{.Name}} (Hostex)"
+    }
+    if cfg.Bridge.RoomNameFormat == "" {
+        cfg.Bridge.RoomNameFormat = `{{.Channel}} - {{.Name}}{{if and .Status (ne .Status "confirmed")}} ({{.Status}}){{end}}`
+    }
+    if cfg.Hostex.Timeout == 0 {
+        cfg.Hostex.Timeout = 30 * time.Second
+    }
+    if cfg.Hostex.BackfillShare == 0 {
+        cfg.Hostex.BackfillShare = 0.7
+    }
+    if cfg.Hostex.BulkShare == 0 {
+        cfg.Hostex.BulkShare = 0.4
+    }
     if cfg.PollInterval == 0 {
         cfg.PollInterval = 10 * time.Second
     }
+    if cfg.AdaptivePolling.MinInterval == 0 {
+        cfg.AdaptivePolling.MinInterval = 5 * time.Second
+    }
+    if cfg.AdaptivePolling.MaxInterval == 0 {
+        cfg.AdaptivePolling.MaxInterval = 60 * time.Second
+    }
+    if cfg.AdaptivePolling.ActiveWindow == 0 {
+        cfg.AdaptivePolling.ActiveWindow = 10 * time.Minute
+    }
+    if cfg.AdaptivePolling.IdleAfter == 0 {
+        cfg.AdaptivePolling.IdleAfter = time.Hour
+    }
+    if cfg.AdaptivePolling.NightStart == "" {
+        cfg.AdaptivePolling.NightStart = "23:00"
+    }
+    if cfg.AdaptivePolling.NightEnd == "" {
+        cfg.AdaptivePolling.NightEnd = "07:00"
+    }
+    if cfg.Digest.Time == "" {
+        cfg.Digest.Time = "08:00"
+    }
+    if cfg.VacancyGaps.MaxNights == 0 {
+        cfg.VacancyGaps.MaxNights = 2
+    }
+    if cfg.VacancyGaps.HorizonDays == 0 {
+        cfg.VacancyGaps.HorizonDays = 60
+    }
+    if cfg.VacancyGaps.DiscountPercent == 0 {
+        cfg.VacancyGaps.DiscountPercent = 15
+    }
+    if cfg.CalendarFeed.HorizonDays == 0 {
+        cfg.CalendarFeed.HorizonDays = 14
+    }
+    if cfg.CalendarFeed.Time == "" {
+        cfg.CalendarFeed.Time = "07:00"
+    }
+    if cfg.ReservationNotices.Interval == 0 {
+        cfg.ReservationNotices.Interval = 5 * time.Minute
+    }
+    if cfg.ReservationNotices.HorizonDays == 0 {
+        cfg.ReservationNotices.HorizonDays = 365
+    }
+    if cfg.PaymentNotices.Interval == 0 {
+        cfg.PaymentNotices.Interval = 30 * time.Minute
+    }
+    if cfg.Reviews.Interval == 0 {
+        cfg.Reviews.Interval = 30 * time.Minute
+    }
+    if cfg.Email.Mailbox == "" {
+        cfg.Email.Mailbox = "INBOX"
+    }
+    if cfg.Email.PollInterval == 0 {
+        cfg.Email.PollInterval = time.Minute
+    }
+    if cfg.Email.Timeout == 0 {
+        cfg.Email.Timeout = 30 * time.Second
+    }
+    if cfg.Email.Enable && (cfg.Email.IMAPAddress == "" || cfg.Email.SMTPAddress == "" || cfg.Email.From == "") {
+        return nil, fmt.Errorf("email.imap_address, email.smtp_address and email.from are required when the email gateway is enabled")
+    }
+    if cfg.ReplySuggestions.MinScore == 0 {
+        cfg.ReplySuggestions.MinScore = 0.3
+    }
+    if cfg.ReplySuggestions.History == 0 {
+        cfg.ReplySuggestions.History = 5000
+    }
+    if cfg.AISuggestions.URL == "" {
+        cfg.AISuggestions.URL = "https://api.openai.com/v1"
+    }
+    cfg.AISuggestions.URL = strings.TrimSuffix(cfg.AISuggestions.URL, "/")
+    if cfg.AISuggestions.Model == "" {
+        cfg.AISuggestions.Model = "gpt-4o-mini"
+    }
+    if cfg.AISuggestions.SystemPrompt == "" {
+        cfg.AISuggestions.SystemPrompt = "You are the friendly host of a vacation rental, answering messages from guests. Write a short reply to the guest's last message in the language they wrote in. Don't make up facts about the property, say that you'll check instead."
+    }
+    if cfg.AISuggestions.History == 0 {
+        cfg.AISuggestions.History = 20
+    }
+    if cfg.AISuggestions.Timeout == 0 {
+        cfg.AISuggestions.Timeout = 30 * time.Second
+    }
+    if cfg.AISuggestions.MaxAge == 0 {
+        cfg.AISuggestions.MaxAge = time.Hour
+    }
+    if cfg.AISuggestions.ApproveReaction == "" {
+        cfg.AISuggestions.ApproveReaction = "👍"
+    }
+    if cfg.Translation.Language == "" {
+        cfg.Translation.Language = "en"
+    }
+    if cfg.Translation.Timeout == 0 {
+        cfg.Translation.Timeout = 10 * time.Second
+    }
+    if cfg.Translation.Enable {
+        switch cfg.Translation.Provider {
+        case "deepl", "google":
+            if cfg.Translation.APIKey == "" {
+                return nil, fmt.Errorf("translation provider %s requires an api_key", cfg.Translation.Provider)
+            }
+        case "libretranslate":
+        default:
+            return nil, fmt.Errorf("invalid translation provider %q, expected deepl, google or libretranslate", cfg.Translation.Provider)
+        }
+    }
+    if cfg.Archive.AfterDays == 0 {
+        cfg.Archive.AfterDays = 365
+    }
+    if cfg.Archive.Time == "" {
+        cfg.Archive.Time = "03:00"
+    }
+    if cfg.Retention.AfterDays == 0 {
+        cfg.Retention.AfterDays = 548
+    }
+    if cfg.Retention.Time == "" {
+        cfg.Retention.Time = "05:00"
+    }
+    if cfg.PortalArchive.AfterDays == 0 {
+        cfg.PortalArchive.AfterDays = 7
+    }
+    if cfg.PortalArchive.Time == "" {
+        cfg.PortalArchive.Time = "04:00"
+    }
+    if cfg.PortalArchive.Prefix == "" {
+        cfg.PortalArchive.Prefix = "[Archived] "
+    }
+    if cfg.Reminders.CheckInTime == "" {
+        cfg.Reminders.CheckInTime = "15:00"
+    }
+    if cfg.Reminders.CheckOutTime == "" {
+        cfg.Reminders.CheckOutTime = "11:00"
+    }
+    if len(cfg.Reminders.Rules) == 0 {
+        cfg.Reminders.Rules = []ReminderRule{
+            {Event: "check_in", Offset: 24 * time.Hour, Template: "Reminder: {{.GuestName}} checks in at {{.PropertyTitle}} on {{.Date}} at {{.Time}}."},
+            {Event: "check_out", Offset: 12 * time.Hour, Template: "Reminder: {{.GuestName}} checks out of {{.PropertyTitle}} on {{.Date}} at {{.Time}}."},
+        }
+    }
+    for _, rule := range cfg.Reminders.Rules {
+        if rule.Event != "check_in" && rule.Event != "check_out" {
+            return nil, fmt.Errorf("invalid reminder event %q, expected check_in or check_out", rule.Event)
+        }
+    }
+    if cfg.AutomationDisclosure.Footer == "" {
+        cfg.AutomationDisclosure.Footer = "This is an automated message."
+    }
+    if cfg.AutoResponder.MaxAge == 0 {
+        cfg.AutoResponder.MaxAge = 15 * time.Minute
+    }
+    if cfg.AutoResponder.Cooldown == 0 {
+        cfg.AutoResponder.Cooldown = 12 * time.Hour
+    }
+    if len(cfg.AutoResponder.Rules) == 0 {
+        cfg.AutoResponder.Rules = []AutoReplyRule{
+            {Name: "night", Trigger: "any", Start: "22:00", End: "08:00", Template: "Hi {{.GuestName}}, thanks for your message! We'll get back to you first thing in the morning."},
+        }
+    }
+    if cfg.CheckInInstructions.CodeField == "" {
+        cfg.CheckInInstructions.CodeField = "door_code"
+    }
+    if cfg.CheckInInstructions.InstructionsField == "" {
+        cfg.CheckInInstructions.InstructionsField = "check_in_instructions"
+    }
+    if cfg.CheckInInstructions.Template == "" {
+        cfg.CheckInInstructions.Template = "Hi {{.GuestName}}, here's how to check in at {{.Property}} on {{.CheckIn}}.\n{{if .DoorCode}}\nDoor code: {{.DoorCode}}\n{{end}}{{if .Instructions}}\n{{.Instructions}}{{end}}"
+    }
+    if cfg.DoneReaction.Key == "" {
+        cfg.DoneReaction.Key = "✅"
+    }
+    if cfg.ConversationTags.RoomTags && cfg.Admin.AccessToken == "" {
+        return nil, fmt.Errorf("conversation_tags room_tags needs admin access_token, room tags can only be set by the admin's account")
+    }
+    if cfg.ConversationTags.Prefix == "" {
+        cfg.ConversationTags.Prefix = "u.hostex."
+    } else if !strings.HasPrefix(cfg.ConversationTags.Prefix, "u.") {
+        return nil, fmt.Errorf("invalid conversation_tags prefix %q, custom room tags must start with u.", cfg.ConversationTags.Prefix)
+    }
+    if cfg.Upsells.Time == "" {
+        cfg.Upsells.Time = "10:00"
+    } else if _, err := time.Parse("15:04", cfg.Upsells.Time); err != nil {
+        return nil, fmt.Errorf("invalid upsells time %q, expected HH:MM", cfg.Upsells.Time)
+    }
+    if len(cfg.Upsells.Offers) == 0 {
+        cfg.Upsells.Offers = []UpsellOffer{
+            {Name: "early_check_in", Event: "check_in", DaysBefore: 2, Price: "$25", Template: "Hi {{.GuestName}}, would you like to check in early at {{.Property}} on {{.CheckIn}}? Early check-in from 12:00 is available for {{.Price}}. Just reply if you're interested!"},
+            {Name: "late_check_out", Event: "check_out", DaysBefore: 1, Price: "$25", Template: "Hi {{.GuestName}}, would you like to stay a little longer tomorrow? Late check-out until 14:00 is available for {{.Price}}. Just reply if you're interested!"},
+        }
+    }
+    offerNames := make(map[string]bool)
+    for i, offer := range cfg.Upsells.Offers {
+        if offer.Name == "" {
+            offer.Name = fmt.Sprintf("offer%d", i+1)
+            cfg.Upsells.Offers[i].Name = offer.Name
+        }
+        if offerNames[offer.Name] {
+            return nil, fmt.Errorf("duplicate upsell offer name %q", offer.Name)
+        }
+        offerNames[offer.Name] = true
+        if offer.Event != "check_in" && offer.Event != "check_out" {
+            return nil, fmt.Errorf("invalid event %q of upsell offer %s, expected check_in or check_out", offer.Event, offer.Name)
+        }
+        if offer.DaysBefore < 0 {
+            return nil, fmt.Errorf("days_before of upsell offer %s can't be negative", offer.Name)
+        }
+        if offer.Template == "" {
+            return nil, fmt.Errorf("upsell offer %s has no template", offer.Name)
+        }
+        if offer.Price == "" && len(offer.Properties) == 0 {
+            return nil, fmt.Errorf("upsell offer %s needs a price or properties", offer.Name)
+        }
+    }
+    ruleNames := make(map[string]bool)
+    for i, rule := range cfg.AutoResponder.Rules {
+        if rule.Name == "" {
+            rule.Name = fmt.Sprintf("rule%d", i+1)
+            cfg.AutoResponder.Rules[i].Name = rule.Name
+        }
+        if ruleNames[rule.Name] {
+            return nil, fmt.Errorf("duplicate auto_responder rule name %q", rule.Name)
+        }
+        ruleNames[rule.Name] = true
+        switch rule.Trigger {
+        case "keyword":
+            if len(rule.Keywords) == 0 {
+                return nil, fmt.Errorf("auto_responder rule %s has the keyword trigger but no keywords", rule.Name)
+            }
+        case "first_message", "any":
+        default:
+            return nil, fmt.Errorf("invalid trigger %q of auto_responder rule %s, expected keyword, first_message or any", rule.Trigger, rule.Name)
+        }
+        if (rule.Start == "") != (rule.End == "") {
+            return nil, fmt.Errorf("auto_responder rule %s needs both start and end or neither", rule.Name)
+        } else if rule.Start != "" {
+            if _, err := time.Parse("15:04", rule.Start); err != nil {
+                return nil, fmt.Errorf("invalid start of auto_responder rule %s, expected HH:MM", rule.Name)
+            }
+            if _, err := time.Parse("15:04", rule.End); err != nil {
+                return nil, fmt.Errorf("invalid end of auto_responder rule %s, expected HH:MM", rule.Name)
+            }
+        }
+        if rule.Template == "" {
+            return nil, fmt.Errorf("auto_responder rule %s has no template", rule.Name)
+        }
+    }
+    if cfg.SatisfactionPulse.Time == "" {
+        cfg.SatisfactionPulse.Time = "12:00"
+    }
+    if cfg.SatisfactionPulse.Message == "" {
+        cfg.SatisfactionPulse.Message = "Hi {{.Guest.Name}}, is everything okay with the apartment? Just reply yes or let us know if anything is missing."
+    }
+    for channel, avatar := range cfg.ChannelAvatars {
+        if _, err := avatar.Parse(); err != nil {
+            return nil, fmt.Errorf("invalid channel_avatars.%s: %w", channel, err)
+        }
+    }
+    if cfg.CommandHooks.Timeout == 0 {
+        cfg.CommandHooks.Timeout = 10 * time.Second
+    }
+    for command, url := range cfg.CommandHooks.Commands {
+        if url == "" {
+            return nil, fmt.Errorf("command_hooks.commands.%s has no URL", command)
+        }
+    }
+    if len(cfg.NotificationHints.PlatformSenders) == 0 {
+        cfg.NotificationHints.PlatformSenders = []string{"system"}
+    }
+    if cfg.Downtime.NoticeAfter == 0 {
+        cfg.Downtime.NoticeAfter = 10 * time.Minute
+    }
+    accountNames := make(map[string]bool)
+    for i, account := range cfg.Hostex.Accounts {
+        if account.Name == "" || account.Token == "" {
+            return nil, fmt.Errorf("hostex.accounts[%d] needs a name and a token", i)
+        } else if strings.Contains(account.Name, "/") {
+            return nil, fmt.Errorf("hostex.accounts[%d].name can't contain /", i)
+        } else if accountNames[account.Name] {
+            return nil, fmt.Errorf("duplicate Hostex account name %q", account.Name)
+        }
+        accountNames[account.Name] = true
+    }
+    for key, level := range cfg.Permissions {
+        if level != "user" && level != "admin" && level != "owner" {
+            return nil, fmt.Errorf("invalid permission level %q for %s, expected user, admin or owner", level, key)
+        }
+    }
+    for i, shift := range cfg.Rota.Shifts {
+        if shift.UserID == "" {
+            return nil, fmt.Errorf("rota.shifts[%d].user_id is required", i)
+        }
+        if _, err := time.Parse("15:04", shift.Start); err != nil {
+            return nil, fmt.Errorf("invalid rota.shifts[%d].start, expected HH:MM", i)
+        }
+        if _, err := time.Parse("15:04", shift.End); err != nil {
+            return nil, fmt.Errorf("invalid rota.shifts[%d].end, expected HH:MM", i)
+        }
+        for _, day := range shift.Days {
+            if _, ok := Weekdays[strings.ToLower(day)]; !ok {
+                return nil, fmt.Errorf("invalid day %q in rota.shifts[%d], expected mon, tue, wed, thu, fri, sat or sun", day, i)
+            }
+        }
+        if shift.Name == "" {
+            cfg.Rota.Shifts[i].Name = shift.UserID.Localpart()
+        }
+    }
+    if cfg.QuietHours.Start == "" {
+        cfg.QuietHours.Start = "22:00"
+    }
+    if cfg.QuietHours.End == "" {
+        cfg.QuietHours.End = "08:00"
+    }
+    if cfg.QuietHours.UrgentKeywords == nil {
+        cfg.QuietHours.UrgentKeywords = []string{"urgent", "emergency", "locked out", "asap", "fire", "flood", "leak", "police"}
+    }
+    if cfg.Backfill.InitialMessages == 0 {
+        cfg.Backfill.InitialMessages = 10
+    }
+    if cfg.Backfill.PageSize == 0 {
+        cfg.Backfill.PageSize = 50
+    }
+    if cfg.Backfill.InitialMessages < -1 || cfg.Backfill.PageSize < 0 || cfg.Backfill.InitialAge < 0 {
+        return nil, fmt.Errorf("backfill limits must not be negative, except initial_messages: -1 for the whole history")
+    }
+    if cfg.Backfill.BatchSend && cfg.Appservice.Listen == "" {
+        return nil, fmt.Errorf("backfill.batch_send requires appservice mode (appservice.listen)")
+    }
+    if cfg.Shutdown.Timeout == 0 {
+        cfg.Shutdown.Timeout = 30 * time.Second
+    }
+    if cfg.Outbox.MaxAttempts == 0 {
+        cfg.Outbox.MaxAttempts = 5
+    }
+    if cfg.Outbox.RetryInterval == 0 {
+        cfg.Outbox.RetryInterval = 10 * time.Second
+    }
+    if cfg.HA.InstanceID == "" {
+        cfg.HA.InstanceID, _ = os.Hostname()
+    }
+    if cfg.HA.LeaseDuration == 0 {
+        cfg.HA.LeaseDuration = 30 * time.Second
+    }
+    if cfg.HA.RenewInterval == 0 {
+        cfg.HA.RenewInterval = 10 * time.Second
+    }
+    if cfg.HA.Enable && cfg.HA.Secret == "" {
+        return nil, fmt.Errorf("ha.secret is required when HA mode is enabled")
+    }
+    if cfg.Appservice.Listen != "" && cfg.Appservice.HSToken == "" {
+        return nil, fmt.Errorf("appservice.hs_token is required when appservice.listen is set")
+    }
+    if cfg.Redis.KeyPrefix == "" {
+        cfg.Redis.KeyPrefix = "hostex-bridge:"
+    }
+    if cfg.Redis.Timeout == 0 {
+        cfg.Redis.Timeout = 5 * time.Second
+    }
+    if cfg.Tracing.Endpoint == "" {
+        cfg.Tracing.Endpoint = "localhost:4318"
+    }
+    if cfg.Tracing.ServiceName == "" {
+        cfg.Tracing.ServiceName = "hostex-bridge"
+    }
+    if cfg.Tracing.SampleRatio == 0 {
+        cfg.Tracing.SampleRatio = 1
+    }
+    if cfg.PProf.Listen == "" {
+        cfg.PProf.Listen = "127.0.0.1:6060"
+    }
+    if cfg.PProf.Enable && !isLoopbackAddress(cfg.PProf.Listen) {
+        return nil, fmt.Errorf("pprof.listen must be a loopback address, got %q", cfg.PProf.Listen)
+    }
+    if cfg.Database.EncryptionKey == "" && cfg.Database.EncryptionKeyFile != "" {
+        key, err := ioutil.ReadFile(cfg.Database.EncryptionKeyFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read database encryption key: %w", err)
+        }
+        cfg.Database.EncryptionKey = strings.TrimSpace(string(key))
+    }
+    if cfg.Database.EncryptionKey != "" {
+        key, err := base64.StdEncoding.DecodeString(cfg.Database.EncryptionKey)
+        if err != nil || len(key) != 32 {
+            return nil, fmt.Errorf("database encryption key must be 32 bytes encoded as base64")
+        }
+        cfg.Database.EncryptionKeyBytes = key
+    }
+    if cfg.Logging.Format == "" {
+        cfg.Logging.Format = "console"
+    }
+    if cfg.Logging.Format != "console" && cfg.Logging.Format != "json" {
+        return nil, fmt.Errorf("invalid logging.format %q, expected console or json", cfg.Logging.Format)
+    }
+    if cfg.Logging.Level == "" {
+        cfg.Logging.Level = "info"
+    }
+    if _, err := zapcore.ParseLevel(cfg.Logging.Level); err != nil {
+        return nil, fmt.Errorf("invalid logging.level: %w", err)
+    }
+    if cfg.Logging.Output == "" {
+        cfg.Logging.Output = "stderr"
+    }
+    if cfg.Logging.MaxSize == 0 {
+        cfg.Logging.MaxSize = 100
+    }
+    if cfg.Logging.MaxBackups == 0 {
+        cfg.Logging.MaxBackups = 5
+    }
+    if cfg.AccessLog.MaxSize == 0 {
+        cfg.AccessLog.MaxSize = 100
+    }
+    if cfg.AccessLog.MaxBackups == 0 {
+        cfg.AccessLog.MaxBackups = 5
+    }
+    for _, proxy := range cfg.AccessLog.TrustedProxies {
+        if net.ParseIP(proxy) == nil {
+            if _, _, err := net.ParseCIDR(proxy); err != nil {
+                return nil, fmt.Errorf("access_log.trusted_proxies: invalid address or range %q", proxy)
+            }
+        }
+    }
 
     return &cfg, nil
 }
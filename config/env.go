@@ -0,0 +1,61 @@
+package config
+
+import (
+    "fmt"
+    "os"
+    "reflect"
+    "strings"
+
+    "gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of environment variables that override config
+// keys, e.g. HOSTEX_BRIDGE_HOSTEX_TOKEN for hostex.token.
+const EnvPrefix = "HOSTEX_BRIDGE_"
+
+// applyEnvOverrides sets every config key that has an environment variable.
+// String keys take the value as is, other keys are parsed as YAML, so lists
+// and maps use the flow syntax, e.g. "[a, b]" or "{key: value}".
+func applyEnvOverrides(cfg *Config) error {
+    return applyEnvToStruct(reflect.ValueOf(cfg).Elem(), EnvPrefix)
+}
+
+func applyEnvToStruct(value reflect.Value, prefix string) error {
+    valueType := value.Type()
+    for i := 0; i < valueType.NumField(); i++ {
+        field := valueType.Field(i)
+        if field.PkgPath != "" {
+            continue
+        }
+        name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+        if name == "-" || name == "" {
+            continue
+        }
+        envName := prefix + strings.ToUpper(name)
+
+        fieldValue := value.Field(i)
+        if field.Type.Kind() == reflect.Struct {
+            err := applyEnvToStruct(fieldValue, envName+"_")
+            if err != nil {
+                return err
+            }
+            continue
+        }
+
+        envValue, ok := os.LookupEnv(envName)
+        if !ok {
+            continue
+        }
+        if field.Type.Kind() == reflect.String {
+            fieldValue.SetString(envValue)
+            continue
+        }
+        parsed := reflect.New(field.Type)
+        err := yaml.Unmarshal([]byte(envValue), parsed.Interface())
+        if err != nil {
+            return fmt.Errorf("invalid value in %s: %w", envName, err)
+        }
+        fieldValue.Set(parsed.Elem())
+    }
+    return nil
+}
@@ -0,0 +1,11 @@
+package config
+
+import (
+    _ "embed"
+)
+
+// ExampleConfig is a commented config with every key at its default value,
+// and placeholders for the values that have to be filled in.
+//
+//go:embed example-config.yaml
+var ExampleConfig string
@@ -0,0 +1,28 @@
+package database
+
+import (
+    "time"
+)
+
+// IsTransactionHandled reports whether every event of the appservice
+// transaction with the given ID was handled already.
+func (d *Database) IsTransactionHandled(txnID string) (bool, error) {
+    var handled bool
+    err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM appservice_txn WHERE txn_id = ?)", txnID).Scan(&handled)
+    return handled, err
+}
+
+// MarkTransactionHandled records that the events of the appservice
+// transaction were handled, so a retry of it is ignored.
+func (d *Database) MarkTransactionHandled(txnID string, receivedAt time.Time) error {
+    _, err := d.db.Exec(
+        "INSERT OR IGNORE INTO appservice_txn (txn_id, received_at) VALUES (?, ?)",
+        txnID, receivedAt.UnixMilli(),
+    )
+    return err
+}
+
+func (d *Database) PruneTransactions(before time.Time) error {
+    _, err := d.db.Exec("DELETE FROM appservice_txn WHERE received_at < ?", before.UnixMilli())
+    return err
+}
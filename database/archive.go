@@ -0,0 +1,151 @@
+package database
+
+import (
+    "database/sql"
+    "fmt"
+    "strings"
+    "time"
+)
+
+const archiveTablePrefix = "message_archive_"
+
+// ArchiveMessages moves messages older than the given time into per-year
+// archive tables, keeping the newest message of every conversation in the
+// message table so backfill still knows where to continue from. It returns
+// how many messages were archived.
+func (d *Database) ArchiveMessages(before time.Time) (int64, error) {
+    tx, err := d.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`
+        CREATE TEMP TABLE archive_candidate AS
+        SELECT rowid AS message_rowid, strftime('%Y', timestamp, 'unixepoch') AS year FROM message m
+        WHERE timestamp < ? AND timestamp < (SELECT MAX(timestamp) FROM message WHERE hostex_id = m.hostex_id)
+    `, before.Unix())
+    if err != nil {
+        return 0, err
+    }
+
+    rows, err := tx.Query("SELECT DISTINCT year FROM archive_candidate")
+    if err != nil {
+        return 0, err
+    }
+    var years []string
+    for rows.Next() {
+        var year string
+        err = rows.Scan(&year)
+        if err != nil {
+            rows.Close()
+            return 0, err
+        }
+        years = append(years, year)
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        return 0, err
+    }
+
+    for _, year := range years {
+        table := archiveTablePrefix + year
+        _, err = tx.Exec(fmt.Sprintf(`
+            CREATE TABLE IF NOT EXISTS %s (
+                hostex_id TEXT,
+                matrix_event_id TEXT UNIQUE,
+                hostex_message_id TEXT,
+                timestamp INTEGER,
+                sender TEXT,
+                content TEXT
+            )
+        `, table))
+        if err != nil {
+            return 0, err
+        }
+        err = createArchiveIndex(tx, table)
+        if err != nil {
+            return 0, err
+        }
+        _, err = tx.Exec(fmt.Sprintf(`
+            INSERT OR IGNORE INTO %s (hostex_id, matrix_event_id, hostex_message_id, timestamp, sender, content)
+            SELECT hostex_id, matrix_event_id, hostex_message_id, timestamp, sender, content FROM message
+            WHERE rowid IN (SELECT message_rowid FROM archive_candidate WHERE year = ?)
+        `, table), year)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    result, err := tx.Exec("DELETE FROM message WHERE rowid IN (SELECT message_rowid FROM archive_candidate)")
+    if err != nil {
+        return 0, err
+    }
+    archived, err := result.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+    _, err = tx.Exec("DROP TABLE temp.archive_candidate")
+    if err != nil {
+        return 0, err
+    }
+    err = d.updateAllMessagesView(tx)
+    if err != nil {
+        return 0, err
+    }
+    return archived, tx.Commit()
+}
+
+func createArchiveIndex(tx execer, table string) error {
+    _, err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_hostex_id_timestamp ON %s (hostex_id, timestamp)", table, table))
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_hostex_message_id ON %s (hostex_message_id)", table, table))
+    return err
+}
+
+type execer interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// updateAllMessagesView (re)creates the message_all view, which combines the
+// message table with every archive table so exports and searches can read
+// archived messages like current ones.
+func (d *Database) updateAllMessagesView(tx execer) error {
+    tables, err := archiveTables(tx)
+    if err != nil {
+        return err
+    }
+
+    selects := []string{"SELECT hostex_id, matrix_event_id, hostex_message_id, timestamp, sender, content FROM message"}
+    for _, table := range tables {
+        selects = append(selects, fmt.Sprintf("SELECT hostex_id, matrix_event_id, hostex_message_id, timestamp, sender, content FROM %s", table))
+    }
+    _, err = tx.Exec("DROP VIEW IF EXISTS message_all")
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec("CREATE VIEW message_all AS " + strings.Join(selects, " UNION ALL "))
+    return err
+}
+
+func archiveTables(tx execer) ([]string, error) {
+    rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE ? ORDER BY name", archiveTablePrefix+"%")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var tables []string
+    for rows.Next() {
+        var name string
+        err = rows.Scan(&name)
+        if err != nil {
+            return nil, err
+        }
+        tables = append(tables, name)
+    }
+    return tables, rows.Err()
+}
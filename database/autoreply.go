@@ -0,0 +1,27 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+// GetAutoReplySentAt returns when the auto-responder rule last replied in
+// the conversation, or the zero time if it never did.
+func (d *Database) GetAutoReplySentAt(hostexID, rule string) (time.Time, error) {
+    var sentAt int64
+    err := d.db.QueryRow("SELECT sent_at FROM auto_reply WHERE hostex_id = ? AND rule = ?", hostexID, rule).Scan(&sentAt)
+    if err == sql.ErrNoRows {
+        return time.Time{}, nil
+    } else if err != nil {
+        return time.Time{}, err
+    }
+    return time.Unix(sentAt, 0), nil
+}
+
+func (d *Database) SetAutoReplySent(hostexID, rule string, sentAt time.Time) error {
+    _, err := d.db.Exec(`
+        INSERT INTO auto_reply (hostex_id, rule, sent_at) VALUES (?, ?, ?)
+        ON CONFLICT (hostex_id, rule) DO UPDATE SET sent_at = excluded.sent_at
+    `, hostexID, rule, sentAt.Unix())
+    return err
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+// BackfillState tracks the initial backfill of a portal, so it resumes where
+// it stopped after a restart.
+type BackfillState struct {
+    HostexID string
+    // Cursor is the time of the newest message of the last bridged page.
+    Cursor   time.Time
+    Complete bool
+}
+
+// GetBackfillState returns the backfill state of a portal, or nil if its
+// backfill hasn't started.
+func (d *Database) GetBackfillState(hostexID string) (*BackfillState, error) {
+    state := BackfillState{HostexID: hostexID}
+    var cursor sql.NullInt64
+    err := d.db.QueryRow("SELECT cursor, complete FROM backfill_state WHERE hostex_id = ?", hostexID).Scan(&cursor, &state.Complete)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    if cursor.Valid {
+        state.Cursor = time.Unix(cursor.Int64, 0)
+    }
+    return &state, nil
+}
+
+func (d *Database) SetBackfillState(state *BackfillState) error {
+    var cursor sql.NullInt64
+    if !state.Cursor.IsZero() {
+        cursor = sql.NullInt64{Int64: state.Cursor.Unix(), Valid: true}
+    }
+    _, err := d.db.Exec(`
+        INSERT INTO backfill_state (hostex_id, cursor, complete) VALUES (?, ?, ?)
+        ON CONFLICT (hostex_id) DO UPDATE SET cursor = excluded.cursor, complete = excluded.complete
+    `, state.HostexID, cursor, state.Complete)
+    return err
+}
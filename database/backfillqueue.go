@@ -0,0 +1,92 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+// Statuses of backfill tasks.
+const (
+    BackfillPending = "pending"
+    BackfillRunning = "running"
+    BackfillDone    = "done"
+    BackfillFailed  = "failed"
+)
+
+// BackfillTask is a range of older history of a conversation that was
+// requested to be bridged. From moves forward as pages are bridged, so a
+// task resumes where it stopped after a crash.
+type BackfillTask struct {
+    ID        int64
+    HostexID  string
+    From      time.Time
+    To        time.Time
+    Status    string
+    CreatedAt time.Time
+}
+
+func (d *Database) EnqueueBackfill(task *BackfillTask) (int64, error) {
+    result, err := d.db.Exec(`
+        INSERT INTO backfill_queue (hostex_id, from_ts, to_ts, status, created_at) VALUES (?, ?, ?, ?, ?)
+    `, task.HostexID, task.From.Unix(), task.To.Unix(), BackfillPending, task.CreatedAt.Unix())
+    if err != nil {
+        return 0, err
+    }
+    return result.LastInsertId()
+}
+
+// GetBackfillTasks returns the pending and running backfill tasks, oldest
+// first.
+func (d *Database) GetBackfillTasks() ([]*BackfillTask, error) {
+    rows, err := d.db.Query(`
+        SELECT id, hostex_id, from_ts, to_ts, status, created_at FROM backfill_queue
+        WHERE status IN (?, ?) ORDER BY id
+    `, BackfillPending, BackfillRunning)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var tasks []*BackfillTask
+    for rows.Next() {
+        var task BackfillTask
+        var from, to, createdAt int64
+        err = rows.Scan(&task.ID, &task.HostexID, &from, &to, &task.Status, &createdAt)
+        if err != nil {
+            return nil, err
+        }
+        task.From = time.Unix(from, 0)
+        task.To = time.Unix(to, 0)
+        task.CreatedAt = time.Unix(createdAt, 0)
+        tasks = append(tasks, &task)
+    }
+    return tasks, rows.Err()
+}
+
+// UpdateBackfillTask stores the progress and status of a backfill task.
+func (d *Database) UpdateBackfillTask(taskID int64, from time.Time, status string) error {
+    _, err := d.db.Exec("UPDATE backfill_queue SET from_ts = ?, status = ? WHERE id = ?", from.Unix(), status, taskID)
+    return err
+}
+
+// ResetBackfillQueue requeues the tasks that were running when the bridge
+// stopped and forgets the finished ones.
+func (d *Database) ResetBackfillQueue() error {
+    _, err := d.db.Exec("UPDATE backfill_queue SET status = ? WHERE status = ?", BackfillPending, BackfillRunning)
+    if err != nil {
+        return err
+    }
+    _, err = d.db.Exec("DELETE FROM backfill_queue WHERE status IN (?, ?)", BackfillDone, BackfillFailed)
+    return err
+}
+
+// GetFirstMessageTimestamp returns the time of the oldest bridged message of
+// a conversation, including archived ones.
+func (d *Database) GetFirstMessageTimestamp(hostexID string) (time.Time, error) {
+    var timestamp sql.NullInt64
+    err := d.db.QueryRow("SELECT MIN(timestamp) FROM message_all WHERE hostex_id = ?", hostexID).Scan(&timestamp)
+    if err != nil || !timestamp.Valid {
+        return time.Time{}, err
+    }
+    return time.Unix(timestamp.Int64, 0), nil
+}
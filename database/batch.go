@@ -0,0 +1,81 @@
+package database
+
+import (
+    "fmt"
+)
+
+// MessageBatch collects bridged messages and stores them together in one
+// transaction, which is much faster than a transaction per message when
+// backfilling or importing long histories.
+type MessageBatch struct {
+    d       *Database
+    size    int
+    pending []*Message
+}
+
+// NewMessageBatch returns a batch that's written whenever it has size
+// messages. Call Flush to write the rest.
+func (d *Database) NewMessageBatch(size int) *MessageBatch {
+    return &MessageBatch{d: d, size: size}
+}
+
+// Add queues a message, writing the batch if it's full.
+func (b *MessageBatch) Add(msg *Message) error {
+    b.pending = append(b.pending, msg)
+    if len(b.pending) >= b.size {
+        return b.Flush()
+    }
+    return nil
+}
+
+// HasMessage is like Database.HasMessage, but also checks the queued
+// messages.
+func (b *MessageBatch) HasMessage(hostexMessageID string) (bool, error) {
+    for _, msg := range b.pending {
+        if msg.HostexMessageID == hostexMessageID {
+            return true, nil
+        }
+    }
+    return b.d.HasMessage(hostexMessageID)
+}
+
+// Flush writes the queued messages. They're dropped from the batch even if
+// writing them fails, so one bad message doesn't fail every later flush.
+func (b *MessageBatch) Flush() error {
+    pending := b.pending
+    b.pending = nil
+    if len(pending) == 0 {
+        return nil
+    }
+    tx, err := b.d.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    stmt, err := tx.Prepare(`
+        INSERT INTO message (hostex_id, matrix_event_id, hostex_message_id, timestamp, sender, content)
+        VALUES (?, ?, NULLIF(?, ''), ?, ?, ?)
+    `)
+    if err != nil {
+        return err
+    }
+    defer stmt.Close()
+    for _, msg := range pending {
+        _, err = stmt.Exec(msg.HostexID, msg.MatrixEventID, msg.HostexMessageID, msg.Timestamp.Unix(), msg.Sender, b.d.encrypt(msg.Content))
+        if err != nil {
+            return fmt.Errorf("failed to store message %s: %w", msg.MatrixEventID, err)
+        }
+    }
+    err = tx.Commit()
+    if err != nil {
+        return err
+    }
+    for _, msg := range pending {
+        if isImportedMessage(msg.HostexMessageID) {
+            continue
+        }
+        b.d.updateLastTimestamp(msg.HostexID, msg.Timestamp)
+    }
+    return nil
+}
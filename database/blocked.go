@@ -0,0 +1,75 @@
+package database
+
+import (
+    "time"
+
+    "maunium.net/go/mautrix/id"
+)
+
+// BlockedGuest is a guest whose messages aren't bridged anymore. The email
+// and phone are kept to recognize the guest in other conversations.
+type BlockedGuest struct {
+    HostexID  string
+    GuestName string
+    Email     string
+    Phone     string
+    Reason    string
+    BlockedBy id.UserID
+    BlockedAt time.Time
+}
+
+func (d *Database) BlockGuest(guest *BlockedGuest) error {
+    _, err := d.db.Exec(`
+        INSERT INTO blocked_guest (hostex_id, guest_name, email, phone, reason, blocked_by, blocked_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (hostex_id) DO UPDATE SET
+            guest_name = excluded.guest_name,
+            email = excluded.email,
+            phone = excluded.phone,
+            reason = excluded.reason,
+            blocked_by = excluded.blocked_by,
+            blocked_at = excluded.blocked_at
+    `, guest.HostexID, d.encrypt(guest.GuestName), d.encrypt(guest.Email), d.encrypt(guest.Phone), d.encrypt(guest.Reason), guest.BlockedBy, guest.BlockedAt.Unix())
+    return err
+}
+
+func (d *Database) UnblockGuest(hostexID string) error {
+    _, err := d.db.Exec("DELETE FROM blocked_guest WHERE hostex_id = ?", hostexID)
+    return err
+}
+
+func (d *Database) IsGuestBlocked(hostexID string) (bool, error) {
+    var exists bool
+    err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM blocked_guest WHERE hostex_id = ?)", hostexID).Scan(&exists)
+    return exists, err
+}
+
+func (d *Database) GetBlockedGuests() ([]*BlockedGuest, error) {
+    rows, err := d.db.Query(`
+        SELECT hostex_id, guest_name, email, phone, reason, blocked_by, blocked_at
+        FROM blocked_guest ORDER BY blocked_at DESC
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var guests []*BlockedGuest
+    for rows.Next() {
+        var guest BlockedGuest
+        var blockedAt int64
+        err = rows.Scan(&guest.HostexID, &guest.GuestName, &guest.Email, &guest.Phone, &guest.Reason, &guest.BlockedBy, &blockedAt)
+        if err != nil {
+            return nil, err
+        }
+        guest.BlockedAt = time.Unix(blockedAt, 0)
+        for _, field := range []*string{&guest.GuestName, &guest.Email, &guest.Phone, &guest.Reason} {
+            *field, err = d.decrypt(*field)
+            if err != nil {
+                return nil, err
+            }
+        }
+        guests = append(guests, &guest)
+    }
+    return guests, rows.Err()
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+    "time"
+)
+
+func (d *Database) SetChecklistItem(hostexID, item string, checked bool) error {
+    if !checked {
+        _, err := d.db.Exec("DELETE FROM checklist WHERE hostex_id = ? AND item = ?", hostexID, item)
+        return err
+    }
+    _, err := d.db.Exec(`
+        INSERT INTO checklist (hostex_id, item, checked_at)
+        VALUES (?, ?, ?)
+        ON CONFLICT (hostex_id, item) DO UPDATE SET checked_at = excluded.checked_at
+    `, hostexID, item, time.Now().Unix())
+    return err
+}
+
+func (d *Database) GetChecklist(hostexID string) (map[string]time.Time, error) {
+    rows, err := d.db.Query("SELECT item, checked_at FROM checklist WHERE hostex_id = ?", hostexID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    checklist := make(map[string]time.Time)
+    for rows.Next() {
+        var item string
+        var checkedAt int64
+        err = rows.Scan(&item, &checkedAt)
+        if err != nil {
+            return nil, err
+        }
+        checklist[item] = time.Unix(checkedAt, 0)
+    }
+    return checklist, rows.Err()
+}
@@ -3,6 +3,8 @@ package database
 import (
     "database/sql"
     "fmt"
+    "strings"
+    "sync"
     "time"
 
     _ "github.com/mattn/go-sqlite3"
@@ -10,26 +12,99 @@ import (
     "go.uber.org/zap"
 )
 
+// Store is the part of *sql.DB the database uses, so an application that
+// embeds the bridge can share its connection or wrap it, e.g. for metrics.
+type Store interface {
+    Exec(query string, args ...any) (sql.Result, error)
+    Query(query string, args ...any) (*sql.Rows, error)
+    QueryRow(query string, args ...any) *sql.Row
+    Begin() (*sql.Tx, error)
+    Close() error
+}
+
+var _ Store = (*sql.DB)(nil)
+
 type Database struct {
-    db  *sql.DB
+    db  Store
     log *zap.Logger
+
+    fullTextSearch bool
+    cipher         *fieldCipher
+
+    // lastTimestamps caches GetLastMessageTimestamp, which runs for every
+    // conversation on every poll
+    lastTimestamps     map[string]time.Time
+    lastTimestampsLock sync.Mutex
+}
+
+// busyTimeout is how long a write waits for another connection's write to
+// finish instead of failing with "database is locked".
+const busyTimeout = 10 * time.Second
+
+// DSN returns the connection string for the SQLite database at path with the
+// options the bridge relies on: WAL so reads don't block on writes, a busy
+// timeout, foreign key enforcement, and transactions that take the write lock
+// when they begin, so concurrent writers queue up on the busy timeout instead
+// of deadlocking. Use it when opening a connection for NewWithDB.
+func DSN(path string) string {
+    if !strings.HasPrefix(path, "file:") {
+        path = "file:" + path
+    }
+    separator := "?"
+    if strings.Contains(path, "?") {
+        separator = "&"
+    }
+    return fmt.Sprintf("%s%s_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d&_foreign_keys=on&_txlock=immediate",
+        path, separator, busyTimeout.Milliseconds())
 }
 
-func New(path string, log *zap.Logger) (*Database, error) {
-    db, err := sql.Open("sqlite3", path)
+// New opens the SQLite database at path. With an encryption key, message
+// contents and guest contact details are encrypted at rest.
+func New(path string, log *zap.Logger, encryptionKey []byte) (*Database, error) {
+    db, err := sql.Open("sqlite3", DSN(path))
     if err != nil {
         return nil, fmt.Errorf("failed to open database: %w", err)
     }
+    return NewWithDB(db, log, encryptionKey)
+}
 
-    database := &Database{db: db, log: log}
-    err = database.createTables()
+// NewWithDB wraps an already open SQLite connection, e.g. one shared with
+// the application the bridge is embedded in, and creates the tables. The
+// connection should be opened with the options of DSN.
+func NewWithDB(db Store, log *zap.Logger, encryptionKey []byte) (*Database, error) {
+    database := &Database{db: db, log: log, lastTimestamps: make(map[string]time.Time)}
+    if encryptionKey != nil {
+        c, err := newFieldCipher(encryptionKey)
+        if err != nil {
+            return nil, err
+        }
+        database.cipher = c
+    }
+    err := database.createTables()
     if err != nil {
         return nil, fmt.Errorf("failed to create tables: %w", err)
     }
+    if database.cipher != nil {
+        err = database.encryptStoredValues()
+        if err != nil {
+            return nil, fmt.Errorf("failed to encrypt stored values: %w", err)
+        }
+    }
 
     return database, nil
 }
 
+// Flush checkpoints the write-ahead log, if there is one, into the database
+// file.
+func (d *Database) Flush() error {
+    _, err := d.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+    return err
+}
+
+func (d *Database) Close() error {
+    return d.db.Close()
+}
+
 func (d *Database) createTables() error {
     _, err := d.db.Exec(`
         CREATE TABLE IF NOT EXISTS portal (
@@ -45,6 +120,7 @@ func (d *Database) createTables() error {
         CREATE TABLE IF NOT EXISTS message (
             hostex_id TEXT,
             matrix_event_id TEXT UNIQUE,
+            hostex_message_id TEXT,
             timestamp INTEGER,
             sender TEXT,
             content TEXT,
@@ -55,17 +131,369 @@ func (d *Database) createTables() error {
             mxid TEXT PRIMARY KEY,
             hostex_id TEXT UNIQUE
         );
+
+        CREATE TABLE IF NOT EXISTS user_preferences (
+            mxid TEXT PRIMARY KEY,
+            timezone TEXT,
+            digest_time TEXT,
+            notification_level TEXT
+        );
+
+        CREATE TABLE IF NOT EXISTS draft (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            hostex_id TEXT,
+            matrix_event_id TEXT,
+            sender TEXT,
+            content TEXT,
+            error TEXT,
+            created_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS outbox (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            hostex_id TEXT,
+            matrix_event_id TEXT,
+            sender TEXT,
+            content TEXT,
+            attempts INTEGER DEFAULT 0,
+            next_attempt_at INTEGER,
+            last_error TEXT,
+            created_at INTEGER,
+            immediate INTEGER NOT NULL DEFAULT 0
+        );
+
+        CREATE TABLE IF NOT EXISTS leader_lease (
+            name TEXT PRIMARY KEY,
+            instance_id TEXT,
+            expires_at INTEGER,
+            signature TEXT
+        );
+
+        CREATE TABLE IF NOT EXISTS reservation (
+            reservation_code TEXT PRIMARY KEY,
+            hostex_id TEXT,
+            status TEXT,
+            check_in_date TEXT,
+            check_out_date TEXT,
+            updated_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS setting (
+            key TEXT PRIMARY KEY,
+            value TEXT
+        );
+
+        CREATE TABLE IF NOT EXISTS property_space (
+            property_title TEXT PRIMARY KEY,
+            space_room_id TEXT
+        );
+
+        CREATE TABLE IF NOT EXISTS satisfaction (
+            hostex_id TEXT,
+            check_in_date TEXT,
+            asked_at INTEGER,
+            flag TEXT,
+            response TEXT,
+            PRIMARY KEY (hostex_id, check_in_date)
+        );
+
+        CREATE TABLE IF NOT EXISTS reminder (
+            reservation_code TEXT,
+            event TEXT,
+            offset_seconds INTEGER,
+            sent_at INTEGER,
+            PRIMARY KEY (reservation_code, event, offset_seconds)
+        );
+
+        CREATE TABLE IF NOT EXISTS poll_history (
+            timestamp INTEGER,
+            success BOOLEAN,
+            error TEXT
+        );
+        CREATE INDEX IF NOT EXISTS poll_history_timestamp ON poll_history (timestamp);
+
+        CREATE TABLE IF NOT EXISTS review (
+            reservation_code TEXT PRIMARY KEY,
+            notified_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS payment_state (
+            reservation_code TEXT PRIMARY KEY,
+            payment_status TEXT NOT NULL,
+            deposit_status TEXT NOT NULL,
+            updated_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS payout (
+            payout_id TEXT PRIMARY KEY,
+            notified_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS email_thread (
+            address TEXT PRIMARY KEY,
+            matrix_room_id TEXT,
+            name TEXT,
+            subject TEXT,
+            last_message_id TEXT
+        );
+
+        CREATE TABLE IF NOT EXISTS checklist (
+            hostex_id TEXT,
+            item TEXT,
+            checked_at INTEGER,
+            PRIMARY KEY (hostex_id, item)
+        );
+
+        CREATE TABLE IF NOT EXISTS portal_alias (
+            old_hostex_id TEXT PRIMARY KEY,
+            hostex_id TEXT
+        );
+
+        CREATE TABLE IF NOT EXISTS deleted_portal (
+            hostex_id TEXT PRIMARY KEY,
+            deleted_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS suppressed_message (
+            hostex_id TEXT,
+            hostex_message_id TEXT,
+            timestamp INTEGER,
+            sender TEXT,
+            category TEXT,
+            content TEXT,
+            PRIMARY KEY (hostex_id, timestamp, content)
+        );
+
+        CREATE TABLE IF NOT EXISTS appservice_txn (
+            txn_id TEXT PRIMARY KEY,
+            received_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS backfill_state (
+            hostex_id TEXT PRIMARY KEY,
+            cursor INTEGER,
+            complete BOOLEAN NOT NULL DEFAULT false
+        );
+
+        CREATE TABLE IF NOT EXISTS backfill_queue (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            hostex_id TEXT NOT NULL,
+            from_ts INTEGER NOT NULL,
+            to_ts INTEGER NOT NULL,
+            status TEXT NOT NULL,
+            created_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS scheduled_message (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            hostex_id TEXT NOT NULL,
+            sender TEXT NOT NULL,
+            content TEXT NOT NULL,
+            send_at INTEGER NOT NULL,
+            created_at INTEGER
+        );
+
+        CREATE TABLE IF NOT EXISTS auto_reply (
+            hostex_id TEXT NOT NULL,
+            rule TEXT NOT NULL,
+            sent_at INTEGER NOT NULL,
+            PRIMARY KEY (hostex_id, rule)
+        );
+
+        CREATE TABLE IF NOT EXISTS guest (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            created_at INTEGER NOT NULL
+        );
+
+        CREATE TABLE IF NOT EXISTS guest_key (
+            key TEXT PRIMARY KEY,
+            guest_id INTEGER NOT NULL,
+            contact TEXT NOT NULL DEFAULT ''
+        );
+
+        CREATE TABLE IF NOT EXISTS guest_conversation (
+            hostex_id TEXT PRIMARY KEY,
+            guest_id INTEGER NOT NULL
+        );
+
+        CREATE TABLE IF NOT EXISTS blocked_guest (
+            hostex_id TEXT PRIMARY KEY,
+            guest_name TEXT NOT NULL,
+            email TEXT NOT NULL,
+            phone TEXT NOT NULL,
+            reason TEXT NOT NULL,
+            blocked_by TEXT NOT NULL,
+            blocked_at INTEGER NOT NULL
+        );
+
+        CREATE TABLE IF NOT EXISTS upsell (
+            hostex_id TEXT NOT NULL,
+            check_in_date TEXT NOT NULL,
+            offer TEXT NOT NULL,
+            price TEXT NOT NULL,
+            sent_at INTEGER NOT NULL,
+            PRIMARY KEY (hostex_id, check_in_date, offer)
+        );
+
+        CREATE TABLE IF NOT EXISTS instructions_sent (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            hostex_id TEXT NOT NULL,
+            reservation_code TEXT NOT NULL,
+            sender TEXT NOT NULL,
+            sent_at INTEGER NOT NULL
+        );
+
+        CREATE TABLE IF NOT EXISTS reply_template (
+            name TEXT PRIMARY KEY,
+            content TEXT NOT NULL,
+            created_by TEXT,
+            updated_at INTEGER
+        );
     `)
+    if err != nil {
+        return err
+    }
+
+    return d.upgradeTables()
+}
+
+// upgradeTables brings tables created by older versions up to date.
+func (d *Database) upgradeTables() error {
+    err := d.addColumnIfMissing("message", "hostex_message_id", "TEXT")
+    if err != nil {
+        return err
+    }
+    _, err = d.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS message_hostex_message_id ON message (hostex_message_id)")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("portal", "summary_event_id", "TEXT")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("portal", "archived", "BOOLEAN NOT NULL DEFAULT false")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("portal", "bridge_info_version", "INTEGER NOT NULL DEFAULT 0")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("portal", "guest_state_hash", "TEXT NOT NULL DEFAULT ''")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("portal", "reservation_state_hash", "TEXT NOT NULL DEFAULT ''")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("portal", "ghost_user_id", "TEXT NOT NULL DEFAULT ''")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("portal", "language", "TEXT NOT NULL DEFAULT ''")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("outbox", "immediate", "INTEGER NOT NULL DEFAULT 0")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("review", "rating", "REAL")
+    if err != nil {
+        return err
+    }
+    // Reviews without a rating used to be stored as 0, which isn't a valid
+    // rating, so they're backfilled by the next review check
+    _, err = d.db.Exec("UPDATE review SET rating = NULL WHERE rating = 0")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("guest", "notes", "TEXT NOT NULL DEFAULT ''")
+    if err != nil {
+        return err
+    }
+    err = d.addColumnIfMissing("guest_key", "contact", "TEXT NOT NULL DEFAULT ''")
+    if err != nil {
+        return err
+    }
+    _, err = d.db.Exec("CREATE INDEX IF NOT EXISTS message_hostex_id_timestamp ON message (hostex_id, timestamp)")
+    if err != nil {
+        return err
+    }
+    tables, err := archiveTables(d.db)
+    if err != nil {
+        return err
+    }
+    for _, table := range tables {
+        err = createArchiveIndex(d.db, table)
+        if err != nil {
+            return err
+        }
+    }
+    err = d.updateAllMessagesView(d.db)
+    if err != nil {
+        return err
+    }
+    return d.setupSearch()
+}
+
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+    rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var cid, notNull, pk int
+        var name, columnType string
+        var defaultValue sql.NullString
+        err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+        if err != nil {
+            return err
+        }
+        if name == column {
+            return nil
+        }
+    }
+    if err = rows.Err(); err != nil {
+        return err
+    }
+
+    _, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
     return err
 }
 
 func (d *Database) GetPortal(hostexID string) (id.RoomID, error) {
-    var roomID id.RoomID
+    var roomID sql.NullString
     err := d.db.QueryRow("SELECT matrix_room_id FROM portal WHERE hostex_id = ?", hostexID).Scan(&roomID)
     if err == sql.ErrNoRows {
         return "", nil
     }
-    return roomID, err
+    return id.RoomID(roomID.String), err
+}
+
+func (d *Database) IsPortalArchived(hostexID string) (bool, error) {
+    var archived bool
+    err := d.db.QueryRow("SELECT archived FROM portal WHERE hostex_id = ?", hostexID).Scan(&archived)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    return archived, err
+}
+
+func (d *Database) SetPortalArchived(hostexID string, archived bool) error {
+    _, err := d.db.Exec("UPDATE portal SET archived = ? WHERE hostex_id = ?", archived, hostexID)
+    return err
+}
+
+// ClearPortalRoom forgets the Matrix room of a portal after the bridge left
+// it, so that a new room is created when the conversation continues.
+func (d *Database) ClearPortalRoom(hostexID string) error {
+    _, err := d.db.Exec("UPDATE portal SET matrix_room_id = NULL, summary_event_id = NULL WHERE hostex_id = ?", hostexID)
+    return err
 }
 
 func (d *Database) StorePortal(hostexID string, roomID id.RoomID, name, topic, avatarURL string, encrypted bool) error {
@@ -78,25 +506,322 @@ func (d *Database) StorePortal(hostexID string, roomID id.RoomID, name, topic, a
             topic = excluded.topic,
             avatar_url = excluded.avatar_url,
             encrypted = excluded.encrypted
-    `, hostexID, roomID, name, topic, avatarURL, encrypted)
+    `, hostexID, roomID, d.encrypt(name), topic, avatarURL, encrypted)
     return err
 }
 
-func (d *Database) StoreMessage(hostexID string, eventID id.EventID, timestamp time.Time, sender string, content string) error {
-    _, err := d.db.Exec(`
-        INSERT INTO message (hostex_id, matrix_event_id, timestamp, sender, content)
-        VALUES (?, ?, ?, ?, ?)
-    `, hostexID, eventID, timestamp.Unix(), sender, content)
+// GetPortalName returns the room name last set for the portal, without the
+// archive prefix.
+func (d *Database) GetPortalName(hostexID string) (string, error) {
+    var name sql.NullString
+    err := d.db.QueryRow("SELECT name FROM portal WHERE hostex_id = ?", hostexID).Scan(&name)
+    if err == sql.ErrNoRows {
+        return "", nil
+    } else if err != nil {
+        return "", err
+    }
+    return d.decrypt(name.String)
+}
+
+func (d *Database) SetPortalName(hostexID, name string) error {
+    _, err := d.db.Exec("UPDATE portal SET name = ? WHERE hostex_id = ?", d.encrypt(name), hostexID)
     return err
 }
 
-func (d *Database) GetLastMessageTimestamp(hostexID string) (time.Time, error) {
-    var timestamp int64
-    err := d.db.QueryRow("SELECT MAX(timestamp) FROM message WHERE hostex_id = ?", hostexID).Scan(&timestamp)
+// GetPortalGhost returns the user ID of the ghost the guest's messages are
+// sent as, or an empty string if it hasn't been created.
+func (d *Database) GetPortalGhost(hostexID string) (id.UserID, error) {
+    var userID id.UserID
+    err := d.db.QueryRow("SELECT ghost_user_id FROM portal WHERE hostex_id = ?", hostexID).Scan(&userID)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return userID, err
+}
+
+func (d *Database) SetPortalGhost(hostexID string, userID id.UserID) error {
+    _, err := d.db.Exec("UPDATE portal SET ghost_user_id = ? WHERE hostex_id = ?", userID, hostexID)
+    return err
+}
+
+func (d *Database) GetPortalTopic(hostexID string) (string, error) {
+    var topic sql.NullString
+    err := d.db.QueryRow("SELECT topic FROM portal WHERE hostex_id = ?", hostexID).Scan(&topic)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return topic.String, err
+}
+
+func (d *Database) SetPortalTopic(hostexID, topic string) error {
+    _, err := d.db.Exec("UPDATE portal SET topic = ? WHERE hostex_id = ?", topic, hostexID)
+    return err
+}
+
+// GetPortalAvatar returns the source of the portal room avatar, i.e. the
+// guest photo URL or the channel avatar MXC URI.
+func (d *Database) GetPortalAvatar(hostexID string) (string, error) {
+    var avatarURL sql.NullString
+    err := d.db.QueryRow("SELECT avatar_url FROM portal WHERE hostex_id = ?", hostexID).Scan(&avatarURL)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return avatarURL.String, err
+}
+
+func (d *Database) SetPortalAvatar(hostexID, avatarURL string) error {
+    _, err := d.db.Exec("UPDATE portal SET avatar_url = ? WHERE hostex_id = ?", avatarURL, hostexID)
+    return err
+}
+
+// GetPortalSummaryEvent returns the event ID of the pinned reservation
+// summary in the portal room.
+func (d *Database) GetPortalSummaryEvent(hostexID string) (id.EventID, error) {
+    var eventID sql.NullString
+    err := d.db.QueryRow("SELECT summary_event_id FROM portal WHERE hostex_id = ?", hostexID).Scan(&eventID)
     if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return id.EventID(eventID.String), err
+}
+
+func (d *Database) SetPortalSummaryEvent(hostexID string, eventID id.EventID) error {
+    _, err := d.db.Exec("UPDATE portal SET summary_event_id = ? WHERE hostex_id = ?", eventID, hostexID)
+    return err
+}
+
+// GetPortalBridgeInfoVersion returns the version of the bridge info state
+// last sent to the portal room.
+func (d *Database) GetPortalBridgeInfoVersion(hostexID string) (int, error) {
+    var version int
+    err := d.db.QueryRow("SELECT bridge_info_version FROM portal WHERE hostex_id = ?", hostexID).Scan(&version)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    return version, err
+}
+
+func (d *Database) SetPortalBridgeInfoVersion(hostexID string, version int) error {
+    _, err := d.db.Exec("UPDATE portal SET bridge_info_version = ? WHERE hostex_id = ?", version, hostexID)
+    return err
+}
+
+// GetPortalGuestStateHash returns the hash of the guest state last sent to
+// the portal room.
+func (d *Database) GetPortalGuestStateHash(hostexID string) (string, error) {
+    var hash string
+    err := d.db.QueryRow("SELECT guest_state_hash FROM portal WHERE hostex_id = ?", hostexID).Scan(&hash)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return hash, err
+}
+
+func (d *Database) SetPortalGuestStateHash(hostexID, hash string) error {
+    _, err := d.db.Exec("UPDATE portal SET guest_state_hash = ? WHERE hostex_id = ?", hash, hostexID)
+    return err
+}
+
+// GetPortalReservationStateHash returns the hash of the reservation state
+// last sent to the portal room.
+func (d *Database) GetPortalReservationStateHash(hostexID string) (string, error) {
+    var hash string
+    err := d.db.QueryRow("SELECT reservation_state_hash FROM portal WHERE hostex_id = ?", hostexID).Scan(&hash)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return hash, err
+}
+
+func (d *Database) SetPortalReservationStateHash(hostexID, hash string) error {
+    _, err := d.db.Exec("UPDATE portal SET reservation_state_hash = ? WHERE hostex_id = ?", hash, hostexID)
+    return err
+}
+
+// GetPortalLanguage returns the language the guest's messages were detected
+// to be in, or an empty string if it isn't known.
+func (d *Database) GetPortalLanguage(hostexID string) (string, error) {
+    var language string
+    err := d.db.QueryRow("SELECT language FROM portal WHERE hostex_id = ?", hostexID).Scan(&language)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return language, err
+}
+
+func (d *Database) SetPortalLanguage(hostexID, language string) error {
+    _, err := d.db.Exec("UPDATE portal SET language = ? WHERE hostex_id = ?", language, hostexID)
+    return err
+}
+
+func (d *Database) GetPortalLastMessageAt(hostexID string) (time.Time, error) {
+    var timestamp sql.NullInt64
+    err := d.db.QueryRow("SELECT last_message_timestamp FROM portal WHERE hostex_id = ?", hostexID).Scan(&timestamp)
+    if err == sql.ErrNoRows || (err == nil && !timestamp.Valid) {
         return time.Time{}, nil
     }
-    return time.Unix(timestamp, 0), err
+    return time.Unix(timestamp.Int64, 0), err
+}
+
+func (d *Database) SetPortalLastMessageAt(hostexID string, timestamp time.Time) error {
+    _, err := d.db.Exec("UPDATE portal SET last_message_timestamp = ? WHERE hostex_id = ?", timestamp.Unix(), hostexID)
+    return err
+}
+
+func (d *Database) StoreMessage(hostexID string, eventID id.EventID, hostexMessageID string, timestamp time.Time, sender string, content string) error {
+    _, err := d.db.Exec(`
+        INSERT INTO message (hostex_id, matrix_event_id, hostex_message_id, timestamp, sender, content)
+        VALUES (?, ?, NULLIF(?, ''), ?, ?, ?)
+    `, hostexID, eventID, hostexMessageID, timestamp.Unix(), sender, d.encrypt(content))
+    if err == nil && !isImportedMessage(hostexMessageID) {
+        d.updateLastTimestamp(hostexID, timestamp)
+    }
+    return err
+}
+
+// HasMessage reports whether a Hostex message was bridged, including
+// messages moved to the archive tables.
+func (d *Database) HasMessage(hostexMessageID string) (bool, error) {
+    var exists bool
+    err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM message_all WHERE hostex_message_id = ?)", hostexMessageID).Scan(&exists)
+    return exists, err
+}
+
+// GetMessageEventID returns the Matrix event ID of a bridged Hostex message,
+// including archived ones, or an empty string if there's no row for it.
+func (d *Database) GetMessageEventID(hostexMessageID string) (id.EventID, error) {
+    var eventID id.EventID
+    err := d.db.QueryRow("SELECT matrix_event_id FROM message_all WHERE hostex_message_id = ? LIMIT 1", hostexMessageID).Scan(&eventID)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return eventID, err
+}
+
+// GetFirstMessageEventAfter returns the Matrix event ID of the oldest bridged
+// message of the conversation after the given time, or an empty string if
+// there's none.
+func (d *Database) GetFirstMessageEventAfter(hostexID string, after time.Time) (id.EventID, error) {
+    var eventID id.EventID
+    err := d.db.QueryRow(
+        "SELECT matrix_event_id FROM message WHERE hostex_id = ? AND timestamp > ? ORDER BY timestamp, rowid LIMIT 1",
+        hostexID, after.Unix(),
+    ).Scan(&eventID)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return eventID, err
+}
+
+type Message struct {
+    HostexID        string
+    MatrixEventID   id.EventID
+    HostexMessageID string
+    Timestamp       time.Time
+    Sender          string
+    Content         string
+}
+
+// GetRecentMessages returns up to limit of the newest messages across all
+// conversations, grouped by conversation and in chronological order.
+func (d *Database) GetRecentMessages(limit int) ([]*Message, error) {
+    rows, err := d.db.Query(`
+        SELECT hostex_id, matrix_event_id, COALESCE(hostex_message_id, ''), timestamp, sender, content FROM (
+            SELECT rowid, * FROM message ORDER BY timestamp DESC LIMIT ?
+        ) ORDER BY hostex_id, timestamp, rowid
+    `, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var messages []*Message
+    for rows.Next() {
+        msg, err := d.scanMessage(rows)
+        if err != nil {
+            return nil, err
+        }
+        messages = append(messages, msg)
+    }
+    return messages, rows.Err()
+}
+
+// scanMessage scans a row of hostex_id, matrix_event_id, hostex_message_id,
+// timestamp, sender and content.
+func (d *Database) scanMessage(row scannable) (*Message, error) {
+    var msg Message
+    var timestamp int64
+    err := row.Scan(&msg.HostexID, &msg.MatrixEventID, &msg.HostexMessageID, &timestamp, &msg.Sender, &msg.Content)
+    if err != nil {
+        return nil, err
+    }
+    msg.Timestamp = time.Unix(timestamp, 0)
+    msg.Content, err = d.decrypt(msg.Content)
+    if err != nil {
+        return nil, err
+    }
+    return &msg, nil
+}
+
+// GetLastMessageTimestamp returns the time of the newest bridged or
+// suppressed message of a conversation, ignoring imported messages. It's
+// cached in memory and kept up to date by the writes.
+func (d *Database) GetLastMessageTimestamp(hostexID string) (time.Time, error) {
+    d.lastTimestampsLock.Lock()
+    cached, ok := d.lastTimestamps[hostexID]
+    d.lastTimestampsLock.Unlock()
+    if ok {
+        return cached, nil
+    }
+
+    var timestamp sql.NullInt64
+    err := d.db.QueryRow(`
+        SELECT MAX(timestamp) FROM (
+            SELECT timestamp FROM message WHERE hostex_id = ?1 AND COALESCE(hostex_message_id, '') NOT LIKE ?2
+            UNION ALL SELECT timestamp FROM suppressed_message WHERE hostex_id = ?1
+        )
+    `, hostexID, importedMessagePrefix+"%").Scan(&timestamp)
+    if err != nil {
+        return time.Time{}, err
+    }
+    var last time.Time
+    if timestamp.Valid {
+        last = time.Unix(timestamp.Int64, 0)
+    }
+    d.lastTimestampsLock.Lock()
+    d.lastTimestamps[hostexID] = last
+    d.lastTimestampsLock.Unlock()
+    return last, nil
+}
+
+// ClearCache drops the cached values, e.g. when another bridge instance may
+// have written to the database in the meantime.
+func (d *Database) ClearCache() {
+    d.forgetLastTimestamps()
+}
+
+// updateLastTimestamp moves the cached last message timestamp forward after
+// a message was stored.
+func (d *Database) updateLastTimestamp(hostexID string, timestamp time.Time) {
+    d.lastTimestampsLock.Lock()
+    defer d.lastTimestampsLock.Unlock()
+    if cached, ok := d.lastTimestamps[hostexID]; ok && timestamp.Unix() > cached.Unix() {
+        d.lastTimestamps[hostexID] = time.Unix(timestamp.Unix(), 0)
+    }
+}
+
+// forgetLastTimestamps drops the cached last message timestamps of the
+// conversations, or all of them if none are given, after messages were
+// deleted or moved.
+func (d *Database) forgetLastTimestamps(hostexIDs ...string) {
+    d.lastTimestampsLock.Lock()
+    defer d.lastTimestampsLock.Unlock()
+    if len(hostexIDs) == 0 {
+        d.lastTimestamps = make(map[string]time.Time)
+    }
+    for _, hostexID := range hostexIDs {
+        delete(d.lastTimestamps, hostexID)
+    }
 }
 
 func (d *Database) StoreUser(mxid id.UserID, hostexID string) error {
@@ -0,0 +1,96 @@
+package database
+
+import (
+    "fmt"
+    "time"
+)
+
+// portalTables are the tables with per-conversation rows that are removed
+// when a portal is deleted.
+var portalTables = []string{"portal", "reservation", "portal_alias", "message", "draft", "outbox", "satisfaction", "checklist", "suppressed_message", "backfill_state", "backfill_queue", "scheduled_message", "auto_reply", "instructions_sent", "upsell", "blocked_guest", "guest_conversation"}
+
+// reservationTables are the tables with per-reservation rows that are
+// removed with the reservations of a deleted portal.
+var reservationTables = []string{"review", "payment_state", "reminder"}
+
+// DeletePortal removes a portal and everything stored about its conversation,
+// including archived messages, and remembers that the conversation shouldn't
+// be bridged again.
+func (d *Database) DeletePortal(hostexID string) error {
+    tx, err := d.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    tables, err := archiveTables(tx)
+    if err != nil {
+        return err
+    }
+    // Email threads that were moved into the portal room go with it
+    _, err = tx.Exec(`
+        DELETE FROM email_thread WHERE matrix_room_id IN (
+            SELECT matrix_room_id FROM portal WHERE hostex_id = ? AND matrix_room_id <> ''
+        )
+    `, hostexID)
+    if err != nil {
+        return fmt.Errorf("failed to delete from email_thread: %w", err)
+    }
+    // The conversations merged into the portal mustn't be bridged again
+    // once their alias is gone
+    _, err = tx.Exec(`
+        INSERT INTO deleted_portal (hostex_id, deleted_at)
+        SELECT old_hostex_id, ? FROM portal_alias WHERE hostex_id = ?
+        ON CONFLICT (hostex_id) DO UPDATE SET deleted_at = excluded.deleted_at
+    `, time.Now().Unix(), hostexID)
+    if err != nil {
+        return fmt.Errorf("failed to delete merged conversations: %w", err)
+    }
+    // Rows keyed by reservation code go first, while the reservations of the
+    // conversation are still known
+    for _, table := range reservationTables {
+        _, err = tx.Exec(fmt.Sprintf(`
+            DELETE FROM %s WHERE reservation_code IN (SELECT reservation_code FROM reservation WHERE hostex_id = ?)
+        `, table), hostexID)
+        if err != nil {
+            return fmt.Errorf("failed to delete from %s: %w", table, err)
+        }
+    }
+    for _, table := range append(portalTables, tables...) {
+        _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE hostex_id = ?", table), hostexID)
+        if err != nil {
+            return fmt.Errorf("failed to delete from %s: %w", table, err)
+        }
+    }
+    err = d.pruneSearchIndex(tx)
+    if err != nil {
+        return fmt.Errorf("failed to prune search index: %w", err)
+    }
+    err = pruneGuests(tx)
+    if err != nil {
+        return fmt.Errorf("failed to prune guests: %w", err)
+    }
+    _, err = tx.Exec(`
+        INSERT INTO deleted_portal (hostex_id, deleted_at) VALUES (?, ?)
+        ON CONFLICT (hostex_id) DO UPDATE SET deleted_at = excluded.deleted_at
+    `, hostexID, time.Now().Unix())
+    if err != nil {
+        return err
+    }
+    defer d.forgetLastTimestamps(hostexID)
+    return tx.Commit()
+}
+
+// IsPortalDeleted reports whether the portal of the conversation was deleted
+// with !delete-portal.
+func (d *Database) IsPortalDeleted(hostexID string) (bool, error) {
+    var deleted bool
+    err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM deleted_portal WHERE hostex_id = ?)", hostexID).Scan(&deleted)
+    return deleted, err
+}
+
+// UndeletePortal allows a deleted conversation to be bridged again.
+func (d *Database) UndeletePortal(hostexID string) error {
+    _, err := d.db.Exec("DELETE FROM deleted_portal WHERE hostex_id = ?", hostexID)
+    return err
+}
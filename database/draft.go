@@ -0,0 +1,88 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+)
+
+type Draft struct {
+    ID            int64
+    HostexID      string
+    MatrixEventID id.EventID
+    Sender        id.UserID
+    Content       string
+    Error         string
+    CreatedAt     time.Time
+}
+
+func (d *Database) StoreDraft(draft *Draft) (int64, error) {
+    result, err := d.db.Exec(`
+        INSERT INTO draft (hostex_id, matrix_event_id, sender, content, error, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, draft.HostexID, draft.MatrixEventID, draft.Sender, d.encrypt(draft.Content), draft.Error, draft.CreatedAt.Unix())
+    if err != nil {
+        return 0, err
+    }
+    return result.LastInsertId()
+}
+
+// GetDrafts returns the drafts of a conversation, or of all conversations if
+// hostexID is empty, oldest first.
+func (d *Database) GetDrafts(hostexID string) ([]*Draft, error) {
+    rows, err := d.db.Query(`
+        SELECT id, hostex_id, matrix_event_id, sender, content, error, created_at
+        FROM draft WHERE ? = '' OR hostex_id = ? ORDER BY id
+    `, hostexID, hostexID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var drafts []*Draft
+    for rows.Next() {
+        draft, err := d.scanDraft(rows)
+        if err != nil {
+            return nil, err
+        }
+        drafts = append(drafts, draft)
+    }
+    return drafts, rows.Err()
+}
+
+func (d *Database) GetDraft(draftID int64) (*Draft, error) {
+    row := d.db.QueryRow(`
+        SELECT id, hostex_id, matrix_event_id, sender, content, error, created_at
+        FROM draft WHERE id = ?
+    `, draftID)
+    draft, err := d.scanDraft(row)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    return draft, err
+}
+
+func (d *Database) DeleteDraft(draftID int64) error {
+    _, err := d.db.Exec("DELETE FROM draft WHERE id = ?", draftID)
+    return err
+}
+
+type scannable interface {
+    Scan(dest ...interface{}) error
+}
+
+func (d *Database) scanDraft(row scannable) (*Draft, error) {
+    var draft Draft
+    var createdAt int64
+    err := row.Scan(&draft.ID, &draft.HostexID, &draft.MatrixEventID, &draft.Sender, &draft.Content, &draft.Error, &createdAt)
+    if err != nil {
+        return nil, err
+    }
+    draft.CreatedAt = time.Unix(createdAt, 0)
+    draft.Content, err = d.decrypt(draft.Content)
+    if err != nil {
+        return nil, err
+    }
+    return &draft, nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+    "maunium.net/go/mautrix/id"
+)
+
+// EmailThread links a guest's email address to the Matrix room their emails
+// are bridged to. The room is either a dedicated email room or the portal of
+// a Hostex conversation with the same guest.
+type EmailThread struct {
+    Address       string
+    RoomID        id.RoomID
+    Name          string
+    Subject       string
+    LastMessageID string
+}
+
+func (d *Database) GetEmailThreads() ([]*EmailThread, error) {
+    rows, err := d.db.Query("SELECT address, matrix_room_id, name, subject, last_message_id FROM email_thread")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var threads []*EmailThread
+    for rows.Next() {
+        var thread EmailThread
+        err = rows.Scan(&thread.Address, &thread.RoomID, &thread.Name, &thread.Subject, &thread.LastMessageID)
+        if err != nil {
+            return nil, err
+        }
+        for _, field := range []*string{&thread.Address, &thread.Name, &thread.Subject} {
+            *field, err = d.decrypt(*field)
+            if err != nil {
+                return nil, err
+            }
+        }
+        threads = append(threads, &thread)
+    }
+    return threads, rows.Err()
+}
+
+func (d *Database) StoreEmailThread(thread *EmailThread) error {
+    _, err := d.db.Exec(`
+        INSERT INTO email_thread (address, matrix_room_id, name, subject, last_message_id)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (address) DO UPDATE SET
+            matrix_room_id = excluded.matrix_room_id,
+            name = excluded.name,
+            subject = excluded.subject,
+            last_message_id = excluded.last_message_id
+    `, d.encrypt(thread.Address), thread.RoomID, d.encrypt(thread.Name), d.encrypt(thread.Subject), thread.LastMessageID)
+    return err
+}
+
+func (d *Database) DeleteEmailThread(address string) error {
+    _, err := d.db.Exec("DELETE FROM email_thread WHERE address = ?", d.encrypt(address))
+    return err
+}
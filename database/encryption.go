@@ -0,0 +1,229 @@
+package database
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "strings"
+)
+
+const (
+    encryptedPrefix = "enc:v1:"
+    hashedPrefix    = "hmac:v1:"
+)
+
+// ErrNoEncryptionKey is returned when reading a value that was encrypted
+// while no encryption key is configured.
+var ErrNoEncryptionKey = errors.New("value is encrypted but no encryption key is configured")
+
+// encryptedColumns are the columns with message contents and guest contact
+// details that are encrypted at rest. Archive tables are added on the fly.
+var encryptedColumns = map[string][]string{
+    "message":            {"content"},
+    "draft":              {"content"},
+    "outbox":             {"content"},
+    "scheduled_message":  {"content"},
+    "suppressed_message": {"content"},
+    "email_thread":       {"address", "name", "subject"},
+    "portal":             {"name"},
+    "guest":              {"name", "notes"},
+    "blocked_guest":      {"guest_name", "email", "phone", "reason"},
+}
+
+// fieldCipher encrypts column values with AES-GCM. The nonce is derived from
+// the value, so encryption is deterministic: equal values have equal
+// ciphertexts and can still be compared in queries and unique keys, at the
+// cost of revealing which stored values are equal.
+type fieldCipher struct {
+    aead      cipher.AEAD
+    nonceKey  []byte
+    lookupKey []byte
+}
+
+func newFieldCipher(key []byte) (*fieldCipher, error) {
+    if len(key) != 32 {
+        return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+    }
+    block, err := aes.NewCipher(deriveKey(key, "hostex-bridge column encryption"))
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return &fieldCipher{
+        aead:      aead,
+        nonceKey:  deriveKey(key, "hostex-bridge column nonce"),
+        lookupKey: deriveKey(key, "hostex-bridge lookup key"),
+    }, nil
+}
+
+func deriveKey(key []byte, label string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(label))
+    return mac.Sum(nil)
+}
+
+func (c *fieldCipher) encrypt(plaintext string) string {
+    mac := hmac.New(sha256.New, c.nonceKey)
+    mac.Write([]byte(plaintext))
+    nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+    sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+    return encryptedPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// hash returns a keyed hash of a value that is only ever looked up, never
+// read back, like the email addresses and phone numbers of guest keys.
+func (c *fieldCipher) hash(value string) string {
+    mac := hmac.New(sha256.New, c.lookupKey)
+    mac.Write([]byte(value))
+    return hashedPrefix + base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *fieldCipher) decrypt(value string) (string, error) {
+    sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+    if err != nil {
+        return "", fmt.Errorf("failed to decode encrypted value: %w", err)
+    }
+    nonceSize := c.aead.NonceSize()
+    if len(sealed) < nonceSize {
+        return "", errors.New("encrypted value is too short")
+    }
+    plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+    if err != nil {
+        return "", fmt.Errorf("failed to decrypt value, is the encryption key right? %w", err)
+    }
+    return string(plaintext), nil
+}
+
+// encryptStoredValues encrypts the plaintext values stored before encryption
+// was enabled. Once it's enabled, the key is needed to read them.
+func (d *Database) encryptStoredValues() error {
+    tables, err := archiveTables(d.db)
+    if err != nil {
+        return err
+    }
+    columns := make(map[string][]string, len(encryptedColumns)+len(tables))
+    for table, tableColumns := range encryptedColumns {
+        columns[table] = tableColumns
+    }
+    for _, table := range tables {
+        columns[table] = []string{"content"}
+    }
+
+    tx, err := d.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    for table, tableColumns := range columns {
+        for _, column := range tableColumns {
+            err = d.encryptColumn(tx, table, column)
+            if err != nil {
+                return fmt.Errorf("failed to encrypt %s.%s: %w", table, column, err)
+            }
+        }
+    }
+    err = d.hashGuestKeys(tx)
+    if err != nil {
+        return fmt.Errorf("failed to hash guest keys: %w", err)
+    }
+    return tx.Commit()
+}
+
+// hashGuestKeys replaces the plaintext guest keys stored before encryption
+// was enabled with their hashes, keeping the contact details encrypted for
+// display.
+func (d *Database) hashGuestKeys(tx execer) error {
+    rows, err := tx.Query(fmt.Sprintf("SELECT key FROM guest_key WHERE key NOT LIKE '%s%%'", hashedPrefix))
+    if err != nil {
+        return err
+    }
+    var keys []string
+    for rows.Next() {
+        var key string
+        err = rows.Scan(&key)
+        if err != nil {
+            rows.Close()
+            return err
+        }
+        keys = append(keys, key)
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        return err
+    }
+
+    for _, key := range keys {
+        _, err = tx.Exec("UPDATE guest_key SET key = ?, contact = ? WHERE key = ?", d.cipher.hash(key), d.cipher.encrypt(key), key)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (d *Database) encryptColumn(tx execer, table, column string) error {
+    rows, err := tx.Query(fmt.Sprintf("SELECT rowid, %s FROM %s WHERE %s <> '' AND %s NOT LIKE '%s%%'", column, table, column, column, encryptedPrefix))
+    if err != nil {
+        return err
+    }
+    values := make(map[int64]string)
+    for rows.Next() {
+        var rowID int64
+        var value string
+        err = rows.Scan(&rowID, &value)
+        if err != nil {
+            rows.Close()
+            return err
+        }
+        values[rowID] = value
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        return err
+    }
+
+    for rowID, value := range values {
+        _, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", table, column), d.cipher.encrypt(value), rowID)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// encrypt returns the value to store for a plaintext. Empty values are
+// stored as they are.
+func (d *Database) encrypt(plaintext string) string {
+    if d.cipher == nil || plaintext == "" {
+        return plaintext
+    }
+    return d.cipher.encrypt(plaintext)
+}
+
+// lookupKey returns the value to store for a column that is only matched
+// against, which is a keyed hash when encryption is enabled.
+func (d *Database) lookupKey(value string) string {
+    if d.cipher == nil {
+        return value
+    }
+    return d.cipher.hash(value)
+}
+
+// decrypt returns the plaintext of a stored value. Values stored before
+// encryption was enabled are returned as they are.
+func (d *Database) decrypt(value string) (string, error) {
+    if !strings.HasPrefix(value, encryptedPrefix) {
+        return value, nil
+    }
+    if d.cipher == nil {
+        return "", ErrNoEncryptionKey
+    }
+    return d.cipher.decrypt(value)
+}
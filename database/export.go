@@ -0,0 +1,48 @@
+package database
+
+import (
+    "strings"
+    "time"
+)
+
+// GetMessagesBetween returns the messages of a conversation, or of all
+// conversations if hostexID is empty, including archived ones, grouped by
+// conversation and in chronological order. A zero from or to leaves that end
+// of the range open.
+func (d *Database) GetMessagesBetween(hostexID string, from, to time.Time) ([]*Message, error) {
+    var conditions []string
+    var args []interface{}
+    if hostexID != "" {
+        conditions = append(conditions, "hostex_id = ?")
+        args = append(args, hostexID)
+    }
+    if !from.IsZero() {
+        conditions = append(conditions, "timestamp >= ?")
+        args = append(args, from.Unix())
+    }
+    if !to.IsZero() {
+        conditions = append(conditions, "timestamp < ?")
+        args = append(args, to.Unix())
+    }
+    query := "SELECT hostex_id, matrix_event_id, COALESCE(hostex_message_id, ''), timestamp, sender, content FROM message_all"
+    if len(conditions) > 0 {
+        query += " WHERE " + strings.Join(conditions, " AND ")
+    }
+    query += " ORDER BY hostex_id, timestamp"
+
+    rows, err := d.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var messages []*Message
+    for rows.Next() {
+        msg, err := d.scanMessage(rows)
+        if err != nil {
+            return nil, err
+        }
+        messages = append(messages, msg)
+    }
+    return messages, rows.Err()
+}
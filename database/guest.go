@@ -0,0 +1,204 @@
+package database
+
+import (
+    "database/sql"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Guest is a person who may have several conversations, e.g. an inquiry on
+// Airbnb and a later direct booking. Keys are the normalized email addresses
+// and phone numbers that identify them. With encryption enabled, the keys
+// are stored as keyed hashes and the contact details encrypted.
+type Guest struct {
+    ID        int64
+    Name      string
+    Notes     string
+    CreatedAt time.Time
+    Keys      []string
+}
+
+func (d *Database) CreateGuest(name string) (int64, error) {
+    result, err := d.db.Exec("INSERT INTO guest (name, created_at) VALUES (?, ?)", d.encrypt(name), time.Now().Unix())
+    if err != nil {
+        return 0, err
+    }
+    return result.LastInsertId()
+}
+
+func (d *Database) GetGuest(guestID int64) (*Guest, error) {
+    guest := Guest{ID: guestID}
+    var createdAt int64
+    err := d.db.QueryRow("SELECT name, notes, created_at FROM guest WHERE id = ?", guestID).Scan(&guest.Name, &guest.Notes, &createdAt)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    guest.CreatedAt = time.Unix(createdAt, 0)
+    guest.Name, err = d.decrypt(guest.Name)
+    if err != nil {
+        return nil, err
+    }
+    guest.Notes, err = d.decrypt(guest.Notes)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := d.db.Query("SELECT key, contact FROM guest_key WHERE guest_id = ?", guestID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var key, contact string
+        err = rows.Scan(&key, &contact)
+        if err != nil {
+            return nil, err
+        }
+        if contact != "" {
+            key, err = d.decrypt(contact)
+            if err != nil {
+                return nil, err
+            }
+        }
+        guest.Keys = append(guest.Keys, key)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    sort.Strings(guest.Keys)
+    return &guest, nil
+}
+
+func (d *Database) SetGuestNotes(guestID int64, notes string) error {
+    _, err := d.db.Exec("UPDATE guest SET notes = ? WHERE id = ?", d.encrypt(notes), guestID)
+    return err
+}
+
+// FindGuestByKeys returns the ID of the guest with any of the keys, or 0 if
+// there is none.
+func (d *Database) FindGuestByKeys(keys []string) (int64, error) {
+    for _, key := range keys {
+        var guestID int64
+        err := d.db.QueryRow("SELECT guest_id FROM guest_key WHERE key = ?", d.lookupKey(key)).Scan(&guestID)
+        if err == sql.ErrNoRows {
+            continue
+        } else if err != nil {
+            return 0, err
+        }
+        return guestID, nil
+    }
+    return 0, nil
+}
+
+// AddGuestKeys adds keys to a guest. Keys that already belong to another
+// guest are left alone, as only !merge-guest merges guests.
+func (d *Database) AddGuestKeys(guestID int64, keys []string) error {
+    for _, key := range keys {
+        _, err := d.db.Exec("INSERT INTO guest_key (key, guest_id, contact) VALUES (?, ?, ?) ON CONFLICT (key) DO NOTHING", d.lookupKey(key), guestID, d.encrypt(key))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// GetConversationGuest returns the ID of the guest of a conversation, or 0 if
+// it isn't linked to one yet.
+func (d *Database) GetConversationGuest(hostexID string) (int64, error) {
+    var guestID int64
+    err := d.db.QueryRow("SELECT guest_id FROM guest_conversation WHERE hostex_id = ?", hostexID).Scan(&guestID)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    }
+    return guestID, err
+}
+
+func (d *Database) LinkConversationGuest(hostexID string, guestID int64) error {
+    _, err := d.db.Exec(`
+        INSERT INTO guest_conversation (hostex_id, guest_id) VALUES (?, ?)
+        ON CONFLICT (hostex_id) DO UPDATE SET guest_id = excluded.guest_id
+    `, hostexID, guestID)
+    return err
+}
+
+// GetGuestConversations returns the IDs of the conversations of a guest.
+func (d *Database) GetGuestConversations(guestID int64) ([]string, error) {
+    rows, err := d.db.Query("SELECT hostex_id FROM guest_conversation WHERE guest_id = ? ORDER BY hostex_id", guestID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var hostexIDs []string
+    for rows.Next() {
+        var hostexID string
+        err = rows.Scan(&hostexID)
+        if err != nil {
+            return nil, err
+        }
+        hostexIDs = append(hostexIDs, hostexID)
+    }
+    return hostexIDs, rows.Err()
+}
+
+// MergeGuests moves the keys, conversations and notes of one guest to another
+// and deletes the merged guest.
+func (d *Database) MergeGuests(guestID, mergedID int64) error {
+    tx, err := d.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // The notes may be encrypted, so they're combined here rather than in SQL
+    var notes, mergedNotes string
+    err = tx.QueryRow("SELECT notes FROM guest WHERE id = ?", guestID).Scan(&notes)
+    if err != nil {
+        return err
+    }
+    err = tx.QueryRow("SELECT notes FROM guest WHERE id = ?", mergedID).Scan(&mergedNotes)
+    if err != nil {
+        return err
+    }
+    if mergedNotes != "" {
+        notes, err = d.decrypt(notes)
+        if err != nil {
+            return err
+        }
+        mergedNotes, err = d.decrypt(mergedNotes)
+        if err != nil {
+            return err
+        }
+        _, err = tx.Exec("UPDATE guest SET notes = ? WHERE id = ?", d.encrypt(strings.TrimSpace(notes+"\n"+mergedNotes)), guestID)
+        if err != nil {
+            return err
+        }
+    }
+    _, err = tx.Exec("UPDATE guest_key SET guest_id = ? WHERE guest_id = ?", guestID, mergedID)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec("UPDATE guest_conversation SET guest_id = ? WHERE guest_id = ?", guestID, mergedID)
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec("DELETE FROM guest WHERE id = ?", mergedID)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// pruneGuests deletes the guests that have no conversations left, e.g. after
+// their data was purged.
+func pruneGuests(tx execer) error {
+    _, err := tx.Exec("DELETE FROM guest_key WHERE guest_id NOT IN (SELECT guest_id FROM guest_conversation)")
+    if err != nil {
+        return err
+    }
+    _, err = tx.Exec("DELETE FROM guest WHERE id NOT IN (SELECT guest_id FROM guest_conversation)")
+    return err
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// importedMessagePrefix marks the Hostex message IDs of messages imported
+// from an export. They have no real Hostex ID, so one is derived from the
+// message itself.
+const importedMessagePrefix = "import:"
+
+// ImportedMessageID returns the stable ID of an imported message, so
+// importing the same export again skips the messages that are already there.
+// With encryption enabled, it's derived with the lookup key, so it doesn't
+// reveal the content.
+func (d *Database) ImportedMessageID(hostexID string, timestamp time.Time, sender, content string) string {
+    key := fmt.Sprintf("%s\x00%d\x00%s\x00%s", hostexID, timestamp.Unix(), sender, content)
+    sum := sha256.Sum256([]byte(d.lookupKey(key)))
+    return importedMessagePrefix + hex.EncodeToString(sum[:16])
+}
+
+// isImportedMessage reports whether the Hostex message ID is that of an
+// imported message. Imported messages are older history, so they don't move
+// the last message timestamp the poller continues from.
+func isImportedMessage(hostexMessageID string) bool {
+    return strings.HasPrefix(hostexMessageID, importedMessagePrefix)
+}
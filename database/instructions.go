@@ -0,0 +1,42 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+)
+
+// InstructionsSent records that the check-in instructions of a reservation
+// were sent to the guest.
+type InstructionsSent struct {
+    HostexID        string
+    ReservationCode string
+    Sender          id.UserID
+    SentAt          time.Time
+}
+
+func (d *Database) LogInstructionsSent(sent *InstructionsSent) error {
+    _, err := d.db.Exec(`
+        INSERT INTO instructions_sent (hostex_id, reservation_code, sender, sent_at) VALUES (?, ?, ?, ?)
+    `, sent.HostexID, sent.ReservationCode, sent.Sender, sent.SentAt.Unix())
+    return err
+}
+
+// GetLastInstructionsSent returns when the check-in instructions were last
+// sent in the conversation, or nil if they never were.
+func (d *Database) GetLastInstructionsSent(hostexID string) (*InstructionsSent, error) {
+    sent := InstructionsSent{HostexID: hostexID}
+    var sentAt int64
+    err := d.db.QueryRow(`
+        SELECT reservation_code, sender, sent_at FROM instructions_sent
+        WHERE hostex_id = ? ORDER BY sent_at DESC, id DESC LIMIT 1
+    `, hostexID).Scan(&sent.ReservationCode, &sent.Sender, &sentAt)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    sent.SentAt = time.Unix(sentAt, 0)
+    return &sent, nil
+}
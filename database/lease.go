@@ -0,0 +1,55 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+type Lease struct {
+    Name       string
+    InstanceID string
+    ExpiresAt  time.Time
+    Signature  string
+}
+
+func (d *Database) GetLease(name string) (*Lease, error) {
+    var lease Lease
+    var expiresAt int64
+    err := d.db.QueryRow(`
+        SELECT name, instance_id, expires_at, signature FROM leader_lease WHERE name = ?
+    `, name).Scan(&lease.Name, &lease.InstanceID, &expiresAt, &lease.Signature)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    lease.ExpiresAt = time.UnixMilli(expiresAt)
+    return &lease, nil
+}
+
+// AcquireLease stores the lease if it's free, already held by the same
+// instance, expired, or still has the signature the caller last observed.
+// It reports whether the lease was written.
+func (d *Database) AcquireLease(lease *Lease, observedSignature string) (bool, error) {
+    result, err := d.db.Exec(`
+        INSERT INTO leader_lease (name, instance_id, expires_at, signature)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (name) DO UPDATE SET
+            instance_id = excluded.instance_id,
+            expires_at = excluded.expires_at,
+            signature = excluded.signature
+        WHERE leader_lease.instance_id = excluded.instance_id
+            OR leader_lease.expires_at < ?
+            OR leader_lease.signature = ?
+    `, lease.Name, lease.InstanceID, lease.ExpiresAt.UnixMilli(), lease.Signature, time.Now().UnixMilli(), observedSignature)
+    if err != nil {
+        return false, err
+    }
+    affected, err := result.RowsAffected()
+    return affected > 0, err
+}
+
+func (d *Database) ReleaseLease(name, instanceID string) error {
+    _, err := d.db.Exec("DELETE FROM leader_lease WHERE name = ? AND instance_id = ?", name, instanceID)
+    return err
+}
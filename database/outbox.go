@@ -0,0 +1,108 @@
+package database
+
+import (
+    "math"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+    "go.uber.org/zap"
+)
+
+type OutboxMessage struct {
+    ID            int64
+    HostexID      string
+    MatrixEventID id.EventID
+    Sender        id.UserID
+    Content       string
+    Attempts      int
+    NextAttemptAt time.Time
+    LastError     string
+    CreatedAt     time.Time
+    // Immediate messages are delivered during quiet hours too, e.g.
+    // auto-replies to a guest who just wrote.
+    Immediate bool
+}
+
+func (d *Database) EnqueueOutbox(msg *OutboxMessage) (int64, error) {
+    result, err := d.db.Exec(`
+        INSERT INTO outbox (hostex_id, matrix_event_id, sender, content, attempts, next_attempt_at, last_error, created_at, immediate)
+        VALUES (?, ?, ?, ?, 0, ?, '', ?, ?)
+    `, msg.HostexID, msg.MatrixEventID, msg.Sender, d.encrypt(msg.Content), msg.NextAttemptAt.UnixMilli(), msg.CreatedAt.Unix(), msg.Immediate)
+    if err != nil {
+        return 0, err
+    }
+    return result.LastInsertId()
+}
+
+// GetDueOutbox returns queued messages whose next attempt is due, oldest
+// first.
+func (d *Database) GetDueOutbox(now time.Time, limit int) ([]*OutboxMessage, error) {
+    rows, err := d.db.Query(`
+        SELECT id, hostex_id, matrix_event_id, sender, content, attempts, next_attempt_at, last_error, created_at, immediate
+        FROM outbox WHERE next_attempt_at <= ? ORDER BY id LIMIT ?
+    `, now.UnixMilli(), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var messages []*OutboxMessage
+    var undecryptable []undecryptableOutbox
+    for rows.Next() {
+        var msg OutboxMessage
+        var nextAttemptAt, createdAt int64
+        err = rows.Scan(&msg.ID, &msg.HostexID, &msg.MatrixEventID, &msg.Sender, &msg.Content, &msg.Attempts, &nextAttemptAt, &msg.LastError, &createdAt, &msg.Immediate)
+        if err != nil {
+            return nil, err
+        }
+        msg.NextAttemptAt = time.UnixMilli(nextAttemptAt)
+        msg.CreatedAt = time.Unix(createdAt, 0)
+        msg.Content, err = d.decrypt(msg.Content)
+        if err != nil {
+            d.log.Error("Failed to decrypt queued message, parking it", zap.Int64("outbox_id", msg.ID), zap.String("hostex_id", msg.HostexID), zap.Error(err))
+            undecryptable = append(undecryptable, undecryptableOutbox{msg.ID, err})
+            continue
+        }
+        messages = append(messages, &msg)
+    }
+    err = rows.Err()
+    if err != nil {
+        return nil, err
+    }
+    rows.Close()
+
+    // A message that can't be decrypted would be due again in every batch,
+    // so it's parked with the error instead of being retried
+    for _, bad := range undecryptable {
+        _, err = d.db.Exec(`
+            UPDATE outbox SET next_attempt_at = ?, last_error = ? WHERE id = ?
+        `, int64(math.MaxInt64), "failed to decrypt: "+bad.err.Error(), bad.id)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return messages, nil
+}
+
+type undecryptableOutbox struct {
+    id  int64
+    err error
+}
+
+func (d *Database) RescheduleOutbox(outboxID int64, attempts int, nextAttemptAt time.Time, lastError string) error {
+    _, err := d.db.Exec(`
+        UPDATE outbox SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?
+    `, attempts, nextAttemptAt.UnixMilli(), lastError, outboxID)
+    return err
+}
+
+func (d *Database) DeleteOutbox(outboxID int64) error {
+    _, err := d.db.Exec("DELETE FROM outbox WHERE id = ?", outboxID)
+    return err
+}
+
+func (d *Database) CountOutbox() (int, error) {
+    var count int
+    err := d.db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&count)
+    return count, err
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+    "time"
+)
+
+// PaymentState is the last seen payment and security deposit status of a
+// reservation.
+type PaymentState struct {
+    Code          string
+    PaymentStatus string
+    DepositStatus string
+}
+
+func (d *Database) GetPaymentStates() (map[string]*PaymentState, error) {
+    rows, err := d.db.Query("SELECT reservation_code, payment_status, deposit_status FROM payment_state")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    states := make(map[string]*PaymentState)
+    for rows.Next() {
+        var state PaymentState
+        err = rows.Scan(&state.Code, &state.PaymentStatus, &state.DepositStatus)
+        if err != nil {
+            return nil, err
+        }
+        states[state.Code] = &state
+    }
+    return states, rows.Err()
+}
+
+func (d *Database) StorePaymentState(state *PaymentState) error {
+    _, err := d.db.Exec(`
+        INSERT INTO payment_state (reservation_code, payment_status, deposit_status, updated_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (reservation_code) DO UPDATE SET
+            payment_status = excluded.payment_status,
+            deposit_status = excluded.deposit_status,
+            updated_at = excluded.updated_at
+    `, state.Code, state.PaymentStatus, state.DepositStatus, time.Now().Unix())
+    return err
+}
+
+func (d *Database) CountNotifiedPayouts() (int, error) {
+    var count int
+    err := d.db.QueryRow("SELECT COUNT(*) FROM payout").Scan(&count)
+    return count, err
+}
+
+func (d *Database) IsPayoutNotified(payoutID string) (bool, error) {
+    var exists bool
+    err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM payout WHERE payout_id = ?)", payoutID).Scan(&exists)
+    return exists, err
+}
+
+func (d *Database) SetPayoutNotified(payoutID string) error {
+    _, err := d.db.Exec(`
+        INSERT INTO payout (payout_id, notified_at) VALUES (?, ?)
+        ON CONFLICT (payout_id) DO NOTHING
+    `, payoutID, time.Now().Unix())
+    return err
+}
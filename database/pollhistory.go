@@ -0,0 +1,61 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+type PollRecord struct {
+    Timestamp time.Time
+    Success   bool
+    Error     string
+}
+
+func (d *Database) RecordPoll(record *PollRecord) error {
+    _, err := d.db.Exec(
+        "INSERT INTO poll_history (timestamp, success, error) VALUES (?, ?, ?)",
+        record.Timestamp.UnixMilli(), record.Success, record.Error,
+    )
+    return err
+}
+
+// GetLastPollTime returns the time of the most recent poll, or the zero time
+// if there is none.
+func (d *Database) GetLastPollTime() (time.Time, error) {
+    var timestamp sql.NullInt64
+    err := d.db.QueryRow("SELECT MAX(timestamp) FROM poll_history").Scan(&timestamp)
+    if err != nil || !timestamp.Valid {
+        return time.Time{}, err
+    }
+    return time.UnixMilli(timestamp.Int64), nil
+}
+
+// GetPollHistory returns the polls made since the given time, oldest first.
+func (d *Database) GetPollHistory(since time.Time) ([]*PollRecord, error) {
+    rows, err := d.db.Query(
+        "SELECT timestamp, success, error FROM poll_history WHERE timestamp >= ? ORDER BY timestamp",
+        since.UnixMilli(),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var records []*PollRecord
+    for rows.Next() {
+        var record PollRecord
+        var timestamp int64
+        err = rows.Scan(&timestamp, &record.Success, &record.Error)
+        if err != nil {
+            return nil, err
+        }
+        record.Timestamp = time.UnixMilli(timestamp)
+        records = append(records, &record)
+    }
+    return records, rows.Err()
+}
+
+func (d *Database) PrunePollHistory(before time.Time) error {
+    _, err := d.db.Exec("DELETE FROM poll_history WHERE timestamp < ?", before.UnixMilli())
+    return err
+}
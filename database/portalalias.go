@@ -0,0 +1,51 @@
+package database
+
+import (
+    "database/sql"
+    "fmt"
+)
+
+// mergeTables are the tables whose rows are moved to the new conversation ID
+// when a conversation continues under a new ID.
+var mergeTables = []string{"portal", "message", "draft", "outbox", "reservation", "satisfaction", "checklist", "suppressed_message", "backfill_state", "backfill_queue", "scheduled_message", "auto_reply", "instructions_sent", "upsell", "blocked_guest", "guest_conversation"}
+
+// MergePortal moves a portal and everything stored about its conversation to
+// a new Hostex conversation ID, and records the old ID as an alias.
+func (d *Database) MergePortal(oldHostexID, hostexID string) error {
+    tx, err := d.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    tables, err := archiveTables(tx)
+    if err != nil {
+        return err
+    }
+    for _, table := range append(mergeTables, tables...) {
+        _, err = tx.Exec(fmt.Sprintf("UPDATE %s SET hostex_id = ? WHERE hostex_id = ?", table), hostexID, oldHostexID)
+        if err != nil {
+            return fmt.Errorf("failed to update %s: %w", table, err)
+        }
+    }
+    _, err = tx.Exec(`
+        INSERT INTO portal_alias (old_hostex_id, hostex_id) VALUES (?, ?)
+        ON CONFLICT (old_hostex_id) DO UPDATE SET hostex_id = excluded.hostex_id
+    `, oldHostexID, hostexID)
+    if err != nil {
+        return err
+    }
+    defer d.forgetLastTimestamps(oldHostexID, hostexID)
+    return tx.Commit()
+}
+
+// GetPortalAlias returns the conversation ID that an old conversation was
+// merged into, or an empty string if it wasn't merged.
+func (d *Database) GetPortalAlias(oldHostexID string) (string, error) {
+    var hostexID string
+    err := d.db.QueryRow("SELECT hostex_id FROM portal_alias WHERE old_hostex_id = ?", oldHostexID).Scan(&hostexID)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return hostexID, err
+}
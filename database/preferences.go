@@ -0,0 +1,39 @@
+package database
+
+import (
+    "database/sql"
+
+    "maunium.net/go/mautrix/id"
+)
+
+type Preferences struct {
+    Timezone          string `json:"timezone,omitempty"`
+    DigestTime        string `json:"digest_time,omitempty"`
+    NotificationLevel string `json:"notification_level,omitempty"`
+}
+
+func (d *Database) GetPreferences(mxid id.UserID) (*Preferences, error) {
+    var prefs Preferences
+    err := d.db.QueryRow(`
+        SELECT timezone, digest_time, notification_level FROM user_preferences WHERE mxid = ?
+    `, mxid).Scan(&prefs.Timezone, &prefs.DigestTime, &prefs.NotificationLevel)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &prefs, nil
+}
+
+func (d *Database) StorePreferences(mxid id.UserID, prefs *Preferences) error {
+    _, err := d.db.Exec(`
+        INSERT INTO user_preferences (mxid, timezone, digest_time, notification_level)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (mxid) DO UPDATE SET
+            timezone = excluded.timezone,
+            digest_time = excluded.digest_time,
+            notification_level = excluded.notification_level
+    `, mxid, prefs.Timezone, prefs.DigestTime, prefs.NotificationLevel)
+    return err
+}
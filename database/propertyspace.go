@@ -0,0 +1,26 @@
+package database
+
+import (
+    "database/sql"
+
+    "maunium.net/go/mautrix/id"
+)
+
+// GetPropertySpace returns the space room of a property, or an empty string
+// if it hasn't been created.
+func (d *Database) GetPropertySpace(propertyTitle string) (id.RoomID, error) {
+    var roomID id.RoomID
+    err := d.db.QueryRow("SELECT space_room_id FROM property_space WHERE property_title = ?", propertyTitle).Scan(&roomID)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return roomID, err
+}
+
+func (d *Database) StorePropertySpace(propertyTitle string, roomID id.RoomID) error {
+    _, err := d.db.Exec(`
+        INSERT INTO property_space (property_title, space_room_id) VALUES (?, ?)
+        ON CONFLICT (property_title) DO UPDATE SET space_room_id = excluded.space_room_id
+    `, propertyTitle, roomID)
+    return err
+}
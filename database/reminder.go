@@ -0,0 +1,22 @@
+package database
+
+import (
+    "time"
+)
+
+func (d *Database) IsReminderSent(reservationCode, event string, offset time.Duration) (bool, error) {
+    var exists bool
+    err := d.db.QueryRow(
+        "SELECT EXISTS(SELECT 1 FROM reminder WHERE reservation_code = ? AND event = ? AND offset_seconds = ?)",
+        reservationCode, event, int64(offset.Seconds()),
+    ).Scan(&exists)
+    return exists, err
+}
+
+func (d *Database) SetReminderSent(reservationCode, event string, offset time.Duration) error {
+    _, err := d.db.Exec(`
+        INSERT INTO reminder (reservation_code, event, offset_seconds, sent_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (reservation_code, event, offset_seconds) DO NOTHING
+    `, reservationCode, event, int64(offset.Seconds()), time.Now().Unix())
+    return err
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+)
+
+// ReplyTemplate is a saved reply that can be sent to guests with !template.
+type ReplyTemplate struct {
+    Name      string
+    Content   string
+    CreatedBy id.UserID
+    UpdatedAt time.Time
+}
+
+// StoreReplyTemplate saves a reply template, replacing the one with the same
+// name.
+func (d *Database) StoreReplyTemplate(tpl *ReplyTemplate) error {
+    _, err := d.db.Exec(`
+        INSERT INTO reply_template (name, content, created_by, updated_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (name) DO UPDATE SET
+            content = excluded.content,
+            created_by = excluded.created_by,
+            updated_at = excluded.updated_at
+    `, tpl.Name, tpl.Content, tpl.CreatedBy, tpl.UpdatedAt.Unix())
+    return err
+}
+
+// GetReplyTemplate returns the reply template with the given name, or nil if
+// there is none.
+func (d *Database) GetReplyTemplate(name string) (*ReplyTemplate, error) {
+    tpl := ReplyTemplate{Name: name}
+    var updatedAt int64
+    err := d.db.QueryRow("SELECT content, created_by, updated_at FROM reply_template WHERE name = ?", name).Scan(&tpl.Content, &tpl.CreatedBy, &updatedAt)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    tpl.UpdatedAt = time.Unix(updatedAt, 0)
+    return &tpl, nil
+}
+
+// GetReplyTemplates returns all reply templates ordered by name.
+func (d *Database) GetReplyTemplates() ([]*ReplyTemplate, error) {
+    rows, err := d.db.Query("SELECT name, content, created_by, updated_at FROM reply_template ORDER BY name")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var templates []*ReplyTemplate
+    for rows.Next() {
+        var tpl ReplyTemplate
+        var updatedAt int64
+        err = rows.Scan(&tpl.Name, &tpl.Content, &tpl.CreatedBy, &updatedAt)
+        if err != nil {
+            return nil, err
+        }
+        tpl.UpdatedAt = time.Unix(updatedAt, 0)
+        templates = append(templates, &tpl)
+    }
+    return templates, rows.Err()
+}
+
+// DeleteReplyTemplate deletes a reply template and reports whether it
+// existed.
+func (d *Database) DeleteReplyTemplate(name string) (bool, error) {
+    result, err := d.db.Exec("DELETE FROM reply_template WHERE name = ?", name)
+    if err != nil {
+        return false, err
+    }
+    deleted, err := result.RowsAffected()
+    return deleted > 0, err
+}
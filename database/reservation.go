@@ -0,0 +1,91 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+// Reservation is the last seen state of a Hostex reservation, used to notice
+// new bookings and status changes between polls.
+type Reservation struct {
+    Code         string
+    HostexID     string
+    Status       string
+    CheckInDate  string
+    CheckOutDate string
+    UpdatedAt    time.Time
+}
+
+func (d *Database) GetReservations() (map[string]*Reservation, error) {
+    rows, err := d.db.Query(`
+        SELECT reservation_code, hostex_id, status, check_in_date, check_out_date, updated_at FROM reservation
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    reservations := make(map[string]*Reservation)
+    for rows.Next() {
+        var res Reservation
+        var updatedAt int64
+        err = rows.Scan(&res.Code, &res.HostexID, &res.Status, &res.CheckInDate, &res.CheckOutDate, &updatedAt)
+        if err != nil {
+            return nil, err
+        }
+        res.UpdatedAt = time.Unix(updatedAt, 0)
+        reservations[res.Code] = &res
+    }
+    return reservations, rows.Err()
+}
+
+func (d *Database) StoreReservation(res *Reservation) error {
+    _, err := d.db.Exec(`
+        INSERT INTO reservation (reservation_code, hostex_id, status, check_in_date, check_out_date, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (reservation_code) DO UPDATE SET
+            hostex_id = excluded.hostex_id,
+            status = excluded.status,
+            check_in_date = excluded.check_in_date,
+            check_out_date = excluded.check_out_date,
+            updated_at = excluded.updated_at
+    `, res.Code, res.HostexID, res.Status, res.CheckInDate, res.CheckOutDate, res.UpdatedAt.Unix())
+    return err
+}
+
+// GetReservationConversation returns the Hostex conversation ID of a known
+// reservation, or an empty string if the reservation hasn't been seen.
+func (d *Database) GetReservationConversation(reservationCode string) (string, error) {
+    var hostexID string
+    err := d.db.QueryRow("SELECT hostex_id FROM reservation WHERE reservation_code = ?", reservationCode).Scan(&hostexID)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return hostexID, err
+}
+
+// GetConversationReservations returns the known reservations of a
+// conversation, newest stay first.
+func (d *Database) GetConversationReservations(hostexID string) ([]*Reservation, error) {
+    rows, err := d.db.Query(`
+        SELECT reservation_code, hostex_id, status, check_in_date, check_out_date, updated_at FROM reservation
+        WHERE hostex_id = ? ORDER BY check_in_date DESC
+    `, hostexID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var reservations []*Reservation
+    for rows.Next() {
+        var res Reservation
+        var updatedAt int64
+        err = rows.Scan(&res.Code, &res.HostexID, &res.Status, &res.CheckInDate, &res.CheckOutDate, &updatedAt)
+        if err != nil {
+            return nil, err
+        }
+        res.UpdatedAt = time.Unix(updatedAt, 0)
+        reservations = append(reservations, &res)
+    }
+    return reservations, rows.Err()
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+    "fmt"
+    "time"
+)
+
+// PruneMessages deletes the messages older than the given time from the
+// message, archive and suppressed message tables. The newest message of every
+// conversation is kept with its content cleared, so backfill still knows
+// where to continue from. It returns how many messages were deleted.
+func (d *Database) PruneMessages(before time.Time) (int64, error) {
+    tx, err := d.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.Exec(`
+        DELETE FROM message
+        WHERE timestamp < ? AND rowid NOT IN (
+            SELECT (SELECT rowid FROM message WHERE hostex_id = p.hostex_id ORDER BY timestamp DESC, rowid DESC LIMIT 1)
+            FROM (SELECT DISTINCT hostex_id FROM message) p
+        )
+    `, before.Unix())
+    if err != nil {
+        return 0, err
+    }
+    pruned, err := result.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+    _, err = tx.Exec("UPDATE message SET content = '' WHERE timestamp < ?", before.Unix())
+    if err != nil {
+        return 0, err
+    }
+
+    tables, err := archiveTables(tx)
+    if err != nil {
+        return 0, err
+    }
+    for _, table := range tables {
+        result, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE timestamp < ?", table), before.Unix())
+        if err != nil {
+            return 0, err
+        }
+        count, err := result.RowsAffected()
+        if err != nil {
+            return 0, err
+        }
+        pruned += count
+    }
+
+    _, err = tx.Exec("DELETE FROM suppressed_message WHERE timestamp < ?", before.Unix())
+    if err != nil {
+        return 0, err
+    }
+
+    if d.fullTextSearch {
+        _, err = tx.Exec("DELETE FROM message_fts WHERE matrix_event_id IN (SELECT matrix_event_id FROM message WHERE timestamp < ?)", before.Unix())
+        if err != nil {
+            return 0, err
+        }
+        err = d.pruneSearchIndex(tx)
+        if err != nil {
+            return 0, err
+        }
+    }
+    defer d.forgetLastTimestamps()
+    return pruned, tx.Commit()
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+func (d *Database) CountNotifiedReviews() (int, error) {
+    var count int
+    err := d.db.QueryRow("SELECT COUNT(*) FROM review").Scan(&count)
+    return count, err
+}
+
+func (d *Database) IsReviewNotified(reservationCode string) (bool, error) {
+    var exists bool
+    err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM review WHERE reservation_code = ?)", reservationCode).Scan(&exists)
+    return exists, err
+}
+
+// SetReviewNotified records a review. The rating is nil if Hostex doesn't
+// have one yet.
+func (d *Database) SetReviewNotified(reservationCode string, rating *float64) error {
+    _, err := d.db.Exec(`
+        INSERT INTO review (reservation_code, notified_at, rating) VALUES (?, ?, ?)
+        ON CONFLICT (reservation_code) DO NOTHING
+    `, reservationCode, time.Now().Unix(), rating)
+    return err
+}
+
+// SetReviewRating stores the rating of a review that was recorded without
+// one.
+func (d *Database) SetReviewRating(reservationCode string, rating float64) error {
+    _, err := d.db.Exec(
+        "UPDATE review SET rating = ? WHERE reservation_code = ? AND rating IS NULL",
+        rating, reservationCode,
+    )
+    return err
+}
+
+// GetReviewRating returns the rating of the guest's review of a reservation,
+// or false if there is no known review or it was stored without a rating.
+func (d *Database) GetReviewRating(reservationCode string) (float64, bool, error) {
+    var rating sql.NullFloat64
+    err := d.db.QueryRow("SELECT rating FROM review WHERE reservation_code = ?", reservationCode).Scan(&rating)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    } else if err != nil {
+        return 0, false, err
+    }
+    return rating.Float64, rating.Valid, nil
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+    "database/sql"
+    "time"
+)
+
+// Satisfaction is the result of asking a guest mid-stay whether everything
+// is okay. Flag is empty until the guest replies.
+type Satisfaction struct {
+    HostexID    string
+    CheckInDate string
+    AskedAt     time.Time
+    Flag        string
+    Response    string
+}
+
+func (d *Database) GetSatisfaction(hostexID, checkInDate string) (*Satisfaction, error) {
+    satisfaction := Satisfaction{HostexID: hostexID, CheckInDate: checkInDate}
+    var askedAt int64
+    err := d.db.QueryRow(
+        "SELECT asked_at, flag, response FROM satisfaction WHERE hostex_id = ? AND check_in_date = ?",
+        hostexID, checkInDate,
+    ).Scan(&askedAt, &satisfaction.Flag, &satisfaction.Response)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    satisfaction.AskedAt = time.Unix(askedAt, 0)
+    return &satisfaction, nil
+}
+
+func (d *Database) StoreSatisfaction(satisfaction *Satisfaction) error {
+    _, err := d.db.Exec(`
+        INSERT INTO satisfaction (hostex_id, check_in_date, asked_at, flag, response)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (hostex_id, check_in_date) DO UPDATE SET
+            flag = excluded.flag,
+            response = excluded.response
+    `, satisfaction.HostexID, satisfaction.CheckInDate, satisfaction.AskedAt.Unix(), satisfaction.Flag, satisfaction.Response)
+    return err
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+    "time"
+
+    "maunium.net/go/mautrix/id"
+)
+
+// ScheduledMessage is a message to a guest that is sent at a later time.
+type ScheduledMessage struct {
+    ID        int64
+    HostexID  string
+    Sender    id.UserID
+    Content   string
+    SendAt    time.Time
+    CreatedAt time.Time
+}
+
+func (d *Database) ScheduleMessage(msg *ScheduledMessage) (int64, error) {
+    result, err := d.db.Exec(`
+        INSERT INTO scheduled_message (hostex_id, sender, content, send_at, created_at) VALUES (?, ?, ?, ?, ?)
+    `, msg.HostexID, msg.Sender, d.encrypt(msg.Content), msg.SendAt.Unix(), msg.CreatedAt.Unix())
+    if err != nil {
+        return 0, err
+    }
+    return result.LastInsertId()
+}
+
+// GetScheduledMessages returns the scheduled messages that are due at the
+// given time, or all of them if before is zero, in the order they're sent.
+func (d *Database) GetScheduledMessages(before time.Time) ([]*ScheduledMessage, error) {
+    query := "SELECT id, hostex_id, sender, content, send_at, created_at FROM scheduled_message"
+    var args []interface{}
+    if !before.IsZero() {
+        query += " WHERE send_at <= ?"
+        args = append(args, before.Unix())
+    }
+    rows, err := d.db.Query(query+" ORDER BY send_at, id", args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var messages []*ScheduledMessage
+    for rows.Next() {
+        var msg ScheduledMessage
+        var sendAt, createdAt int64
+        err = rows.Scan(&msg.ID, &msg.HostexID, &msg.Sender, &msg.Content, &sendAt, &createdAt)
+        if err != nil {
+            return nil, err
+        }
+        msg.SendAt = time.Unix(sendAt, 0)
+        msg.CreatedAt = time.Unix(createdAt, 0)
+        msg.Content, err = d.decrypt(msg.Content)
+        if err != nil {
+            return nil, err
+        }
+        messages = append(messages, &msg)
+    }
+    return messages, rows.Err()
+}
+
+// DeleteScheduledMessage deletes a scheduled message and reports whether it
+// was still scheduled.
+func (d *Database) DeleteScheduledMessage(messageID int64) (bool, error) {
+    result, err := d.db.Exec("DELETE FROM scheduled_message WHERE id = ?", messageID)
+    if err != nil {
+        return false, err
+    }
+    deleted, err := result.RowsAffected()
+    return deleted > 0, err
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix/id"
+)
+
+type SearchResult struct {
+    HostexID      string
+    MatrixEventID id.EventID
+    Timestamp     time.Time
+    Sender        string
+    Snippet       string
+}
+
+// setupSearch creates the FTS5 index of message contents, which needs the
+// SQLite driver built with -tags sqlite_fts5. Without it, SearchMessages
+// falls back to a slower substring search.
+//
+// The index is keyed by event ID and only added to, so archived messages stay
+// searchable. Rows of deleted messages are skipped by joining message_all and
+// removed by DeletePortal. With encryption, there's no index and messages are
+// searched by decrypting them.
+func (d *Database) setupSearch() error {
+    // The index would keep a plaintext copy of encrypted messages
+    if d.cipher != nil {
+        _, err := d.db.Exec("DROP TRIGGER IF EXISTS message_fts_insert; DROP TABLE IF EXISTS message_fts")
+        return err
+    }
+
+    var exists bool
+    err := d.db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'message_fts')").Scan(&exists)
+    if err != nil {
+        return err
+    }
+    _, err = d.db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS message_fts USING fts5(content, matrix_event_id UNINDEXED)")
+    if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+        d.log.Warn("SQLite was built without FTS5, message search will be slow")
+        return nil
+    } else if err != nil {
+        return err
+    }
+    _, err = d.db.Exec(`
+        CREATE TRIGGER IF NOT EXISTS message_fts_insert AFTER INSERT ON message BEGIN
+            INSERT INTO message_fts (content, matrix_event_id) VALUES (new.content, new.matrix_event_id);
+        END
+    `)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        _, err = d.db.Exec("INSERT INTO message_fts (content, matrix_event_id) SELECT content, matrix_event_id FROM message_all")
+        if err != nil {
+            return fmt.Errorf("failed to index existing messages: %w", err)
+        }
+    }
+    d.fullTextSearch = true
+    return nil
+}
+
+// pruneSearchIndex removes index rows of messages that were deleted.
+func (d *Database) pruneSearchIndex(tx execer) error {
+    if !d.fullTextSearch {
+        return nil
+    }
+    _, err := tx.Exec("DELETE FROM message_fts WHERE matrix_event_id NOT IN (SELECT matrix_event_id FROM message_all)")
+    return err
+}
+
+// SearchMessages returns the bridged messages, including archived ones, that
+// contain all words of the query, best matches first.
+func (d *Database) SearchMessages(query string, limit int) ([]*SearchResult, error) {
+    words := strings.Fields(query)
+    if len(words) == 0 {
+        return nil, nil
+    }
+
+    if d.cipher != nil {
+        return d.searchEncryptedMessages(words, limit)
+    }
+
+    var sqlQuery string
+    var args []interface{}
+    if d.fullTextSearch {
+        // Quote the words, so punctuation isn't parsed as FTS5 query syntax
+        quoted := make([]string, len(words))
+        for i, word := range words {
+            quoted[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+        }
+        sqlQuery = `
+            SELECT m.hostex_id, m.matrix_event_id, m.timestamp, m.sender, snippet(message_fts, 0, '*', '*', '...', 16)
+            FROM message_fts JOIN message_all m ON m.matrix_event_id = message_fts.matrix_event_id
+            WHERE message_fts MATCH ? ORDER BY rank LIMIT ?
+        `
+        args = []interface{}{strings.Join(quoted, " "), limit}
+    } else {
+        conditions := make([]string, len(words))
+        for i, word := range words {
+            conditions[i] = `content LIKE ? ESCAPE '\'`
+            args = append(args, "%"+likeEscaper.Replace(word)+"%")
+        }
+        sqlQuery = fmt.Sprintf(`
+            SELECT hostex_id, matrix_event_id, timestamp, sender, content
+            FROM message_all WHERE %s ORDER BY timestamp DESC LIMIT ?
+        `, strings.Join(conditions, " AND "))
+        args = append(args, limit)
+    }
+
+    rows, err := d.db.Query(sqlQuery, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var results []*SearchResult
+    for rows.Next() {
+        var result SearchResult
+        var timestamp int64
+        err = rows.Scan(&result.HostexID, &result.MatrixEventID, &timestamp, &result.Sender, &result.Snippet)
+        if err != nil {
+            return nil, err
+        }
+        result.Timestamp = time.Unix(timestamp, 0)
+        results = append(results, &result)
+    }
+    return results, rows.Err()
+}
+
+// searchEncryptedMessages decrypts the messages, newest first, until it found
+// limit messages containing all the words.
+func (d *Database) searchEncryptedMessages(words []string, limit int) ([]*SearchResult, error) {
+    rows, err := d.db.Query(`
+        SELECT hostex_id, matrix_event_id, COALESCE(hostex_message_id, ''), timestamp, sender, content
+        FROM message_all ORDER BY timestamp DESC
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var results []*SearchResult
+    for rows.Next() && len(results) < limit {
+        msg, err := d.scanMessage(rows)
+        if err != nil {
+            return nil, err
+        }
+        content := strings.ToLower(msg.Content)
+        matches := true
+        for _, word := range words {
+            if !strings.Contains(content, strings.ToLower(word)) {
+                matches = false
+                break
+            }
+        }
+        if matches {
+            results = append(results, &SearchResult{
+                HostexID:      msg.HostexID,
+                MatrixEventID: msg.MatrixEventID,
+                Timestamp:     msg.Timestamp,
+                Sender:        msg.Sender,
+                Snippet:       msg.Content,
+            })
+        }
+    }
+    return results, rows.Err()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
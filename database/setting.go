@@ -0,0 +1,24 @@
+package database
+
+import (
+    "database/sql"
+)
+
+// GetSetting returns a setting changed at runtime, or an empty string if it
+// hasn't been set.
+func (d *Database) GetSetting(key string) (string, error) {
+    var value string
+    err := d.db.QueryRow("SELECT value FROM setting WHERE key = ?", key).Scan(&value)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return value, err
+}
+
+func (d *Database) SetSetting(key, value string) error {
+    _, err := d.db.Exec(`
+        INSERT INTO setting (key, value) VALUES (?, ?)
+        ON CONFLICT (key) DO UPDATE SET value = excluded.value
+    `, key, value)
+    return err
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+    "time"
+)
+
+type SuppressedMessage struct {
+    HostexID        string
+    HostexMessageID string
+    Timestamp       time.Time
+    Sender          string
+    Category        string
+    Content         string
+}
+
+func (d *Database) StoreSuppressedMessage(msg *SuppressedMessage) error {
+    _, err := d.db.Exec(`
+        INSERT OR IGNORE INTO suppressed_message (hostex_id, hostex_message_id, timestamp, sender, category, content)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, msg.HostexID, msg.HostexMessageID, msg.Timestamp.Unix(), msg.Sender, msg.Category, d.encrypt(msg.Content))
+    if err == nil {
+        d.updateLastTimestamp(msg.HostexID, msg.Timestamp)
+    }
+    return err
+}
+
+// GetSuppressedMessages returns the most recent suppressed messages of a
+// conversation, newest first.
+func (d *Database) GetSuppressedMessages(hostexID string, limit int) ([]*SuppressedMessage, error) {
+    rows, err := d.db.Query(`
+        SELECT hostex_id, hostex_message_id, timestamp, sender, category, content
+        FROM suppressed_message WHERE hostex_id = ? ORDER BY timestamp DESC LIMIT ?
+    `, hostexID, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var messages []*SuppressedMessage
+    for rows.Next() {
+        var msg SuppressedMessage
+        var timestamp int64
+        err = rows.Scan(&msg.HostexID, &msg.HostexMessageID, &timestamp, &msg.Sender, &msg.Category, &msg.Content)
+        if err != nil {
+            return nil, err
+        }
+        msg.Timestamp = time.Unix(timestamp, 0)
+        msg.Content, err = d.decrypt(msg.Content)
+        if err != nil {
+            return nil, err
+        }
+        messages = append(messages, &msg)
+    }
+    return messages, rows.Err()
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+    "time"
+)
+
+// Upsell records that an upsell offer was sent to the guest of a stay.
+type Upsell struct {
+    HostexID    string
+    CheckInDate string
+    Offer       string
+    Price       string
+    SentAt      time.Time
+}
+
+func (d *Database) IsUpsellSent(hostexID, checkInDate, offer string) (bool, error) {
+    var exists bool
+    err := d.db.QueryRow(
+        "SELECT EXISTS(SELECT 1 FROM upsell WHERE hostex_id = ? AND check_in_date = ? AND offer = ?)",
+        hostexID, checkInDate, offer,
+    ).Scan(&exists)
+    return exists, err
+}
+
+func (d *Database) StoreUpsell(upsell *Upsell) error {
+    _, err := d.db.Exec(`
+        INSERT INTO upsell (hostex_id, check_in_date, offer, price, sent_at) VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (hostex_id, check_in_date, offer) DO NOTHING
+    `, upsell.HostexID, upsell.CheckInDate, upsell.Offer, upsell.Price, upsell.SentAt.Unix())
+    return err
+}
+
+// GetUpsells returns the most recently sent upsell offers, only those of one
+// conversation if hostexID isn't empty.
+func (d *Database) GetUpsells(hostexID string, limit int) ([]*Upsell, error) {
+    query := "SELECT hostex_id, check_in_date, offer, price, sent_at FROM upsell"
+    args := []interface{}{}
+    if hostexID != "" {
+        query += " WHERE hostex_id = ?"
+        args = append(args, hostexID)
+    }
+    query += " ORDER BY sent_at DESC LIMIT ?"
+    args = append(args, limit)
+
+    rows, err := d.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var upsells []*Upsell
+    for rows.Next() {
+        var upsell Upsell
+        var sentAt int64
+        err = rows.Scan(&upsell.HostexID, &upsell.CheckInDate, &upsell.Offer, &upsell.Price, &sentAt)
+        if err != nil {
+            return nil, err
+        }
+        upsell.SentAt = time.Unix(sentAt, 0)
+        upsells = append(upsells, &upsell)
+    }
+    return upsells, rows.Err()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "maunium.net/go/mautrix"
+    "maunium.net/go/mautrix/event"
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/bridge"
+)
+
+// doctorCheck is the result of probing one homeserver capability.
+type doctorCheck struct {
+    Name     string
+    OK       bool
+    Detail   string
+    Degraded string
+}
+
+var doctorProbeEvent = event.Type{Type: "com.hostex.doctor", Class: event.StateEventType}
+
+// checkHomeserver probes the homeserver for the optional features the bridge
+// relies on, in a temporary room that is left afterwards.
+func checkHomeserver(ctx context.Context, client *mautrix.Client) ([]doctorCheck, error) {
+    versions, err := client.Versions(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to reach homeserver: %w", err)
+    }
+    checks := []doctorCheck{{
+        Name:   "Client-server API",
+        OK:     true,
+        Detail: fmt.Sprintf("spec versions up to %s", versions.GetLatest()),
+    }}
+
+    checks = append(checks, doctorCheck{
+        Name:     "Batch send",
+        OK:       bridge.SupportsBatchSend(versions),
+        Degraded: "history is backfilled one message at a time",
+    })
+
+    room, err := client.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+        Visibility: "private",
+        Name:       "Hostex bridge doctor",
+    })
+    if err != nil {
+        return checks, fmt.Errorf("failed to create probe room: %w", err)
+    }
+    defer func() {
+        _, _ = client.LeaveRoom(ctx, room.RoomID)
+        _, _ = client.ForgetRoom(ctx, room.RoomID)
+    }()
+
+    checks = append(checks, checkTimestampMassaging(ctx, client, room.RoomID))
+    checks = append(checks, checkCustomState(ctx, client, room.RoomID))
+    checks = append(checks, checkSpaces(ctx, client, room.RoomID))
+    return checks, nil
+}
+
+func checkTimestampMassaging(ctx context.Context, client *mautrix.Client, roomID id.RoomID) doctorCheck {
+    check := doctorCheck{
+        Name:     "Timestamp massaging",
+        Degraded: "backfilled and imported messages show the time they were bridged instead of when they were sent",
+    }
+    ts := time.Now().Add(-24 * time.Hour).UnixMilli()
+    resp, err := client.SendMessageEvent(ctx, roomID, event.EventMessage, &event.MessageEventContent{
+        MsgType: event.MsgNotice,
+        Body:    "Checking timestamp massaging",
+    }, mautrix.ReqSendEvent{Timestamp: ts})
+    if err != nil {
+        check.Detail = err.Error()
+        return check
+    }
+    evt, err := client.GetEvent(ctx, roomID, resp.EventID)
+    if err != nil {
+        check.Detail = err.Error()
+        return check
+    }
+    check.OK = evt.Timestamp == ts
+    return check
+}
+
+func checkCustomState(ctx context.Context, client *mautrix.Client, roomID id.RoomID) doctorCheck {
+    check := doctorCheck{
+        Name:     "Custom state events",
+        Degraded: "bridge info and reservation state events can't be set",
+    }
+    _, err := client.SendStateEvent(ctx, roomID, doctorProbeEvent, "", map[string]string{"probe": "ok"})
+    if err != nil {
+        check.Detail = err.Error()
+        return check
+    }
+    var content map[string]string
+    err = client.StateEvent(ctx, roomID, doctorProbeEvent, "", &content)
+    if err != nil {
+        check.Detail = err.Error()
+        return check
+    }
+    check.OK = content["probe"] == "ok"
+    return check
+}
+
+func checkSpaces(ctx context.Context, client *mautrix.Client, roomID id.RoomID) doctorCheck {
+    check := doctorCheck{
+        Name:     "Spaces",
+        Degraded: "personal, property and archive spaces won't group the bridged rooms",
+    }
+    space, err := client.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+        Visibility:      "private",
+        Name:            "Hostex bridge doctor space",
+        CreationContent: map[string]interface{}{"type": event.RoomTypeSpace},
+    })
+    if err != nil {
+        check.Detail = err.Error()
+        return check
+    }
+    defer func() {
+        _, _ = client.LeaveRoom(ctx, space.RoomID)
+        _, _ = client.ForgetRoom(ctx, space.RoomID)
+    }()
+
+    _, err = client.SendStateEvent(ctx, space.RoomID, event.StateSpaceChild, roomID.String(), &event.SpaceChildEventContent{
+        Via: []string{client.UserID.Homeserver()},
+    })
+    if err != nil {
+        check.Detail = err.Error()
+        return check
+    }
+    hierarchy, err := client.Hierarchy(ctx, space.RoomID, &mautrix.ReqHierarchy{})
+    if err != nil {
+        check.Detail = err.Error()
+        return check
+    }
+    for _, child := range hierarchy.Rooms {
+        if child.RoomID == roomID {
+            check.OK = true
+        }
+    }
+    return check
+}
+
+// formatDoctorReport lists the checks, with what is degraded by the failed
+// ones.
+func formatDoctorReport(checks []doctorCheck) string {
+    var report strings.Builder
+    degraded := 0
+    for _, check := range checks {
+        status := "OK"
+        if !check.OK {
+            status = "UNSUPPORTED"
+            degraded++
+        }
+        report.WriteString(fmt.Sprintf("%-22s %s", check.Name, status))
+        if check.Detail != "" {
+            report.WriteString(" (" + check.Detail + ")")
+        }
+        report.WriteString("\n")
+        if !check.OK && check.Degraded != "" {
+            report.WriteString(fmt.Sprintf("%-22s -> %s\n", "", check.Degraded))
+        }
+    }
+    if degraded == 0 {
+        report.WriteString("\nAll optional features are available.\n")
+    } else {
+        report.WriteString(fmt.Sprintf("\n%d optional feature(s) will be degraded.\n", degraded))
+    }
+    return report.String()
+}
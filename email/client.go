@@ -0,0 +1,213 @@
+package email
+
+import (
+    "bytes"
+    "encoding/base64"
+    "fmt"
+    "io"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net"
+    "net/mail"
+    "net/smtp"
+    "strings"
+    "time"
+
+    "github.com/emersion/go-imap"
+    imapclient "github.com/emersion/go-imap/client"
+)
+
+type Message struct {
+    MessageID string
+    InReplyTo string
+    From      string
+    FromName  string
+    Subject   string
+    Body      string
+    Date      time.Time
+}
+
+// Client fetches guest emails from an IMAP mailbox and sends replies over
+// SMTP. It connects for every operation, so it holds no open connections.
+type Client struct {
+    imapAddress string
+    smtpAddress string
+    username    string
+    password    string
+    from        string
+    mailbox     string
+    timeout     time.Duration
+}
+
+func NewClient(imapAddress, smtpAddress, username, password, from, mailbox string, timeout time.Duration) *Client {
+    return &Client{
+        imapAddress: imapAddress,
+        smtpAddress: smtpAddress,
+        username:    username,
+        password:    password,
+        from:        from,
+        mailbox:     mailbox,
+        timeout:     timeout,
+    }
+}
+
+// FetchUnseen returns the unseen messages in the mailbox and marks them as
+// seen.
+func (c *Client) FetchUnseen() ([]Message, error) {
+    conn, err := imapclient.DialWithDialerTLS(&net.Dialer{Timeout: c.timeout}, c.imapAddress, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+    }
+    defer conn.Logout()
+    conn.Timeout = c.timeout
+
+    err = conn.Login(c.username, c.password)
+    if err != nil {
+        return nil, fmt.Errorf("failed to log in to IMAP server: %w", err)
+    }
+    _, err = conn.Select(c.mailbox, false)
+    if err != nil {
+        return nil, fmt.Errorf("failed to select mailbox: %w", err)
+    }
+
+    criteria := imap.NewSearchCriteria()
+    criteria.WithoutFlags = []string{imap.SeenFlag}
+    uids, err := conn.UidSearch(criteria)
+    if err != nil {
+        return nil, fmt.Errorf("failed to search mailbox: %w", err)
+    } else if len(uids) == 0 {
+        return nil, nil
+    }
+
+    seqSet := new(imap.SeqSet)
+    seqSet.AddNum(uids...)
+    // Fetching the body without PEEK marks the messages as seen
+    section := &imap.BodySectionName{}
+    fetched := make(chan *imap.Message, len(uids))
+    err = conn.UidFetch(seqSet, []imap.FetchItem{section.FetchItem()}, fetched)
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch messages: %w", err)
+    }
+
+    var messages []Message
+    for msg := range fetched {
+        body := msg.GetBody(section)
+        if body == nil {
+            continue
+        }
+        parsed, err := parseMessage(body)
+        if err != nil {
+            return messages, fmt.Errorf("failed to parse message: %w", err)
+        }
+        messages = append(messages, *parsed)
+    }
+    return messages, nil
+}
+
+func parseMessage(r io.Reader) (*Message, error) {
+    raw, err := mail.ReadMessage(r)
+    if err != nil {
+        return nil, err
+    }
+
+    decoder := new(mime.WordDecoder)
+    subject, err := decoder.DecodeHeader(raw.Header.Get("Subject"))
+    if err != nil {
+        subject = raw.Header.Get("Subject")
+    }
+    msg := &Message{
+        MessageID: raw.Header.Get("Message-ID"),
+        InReplyTo: raw.Header.Get("In-Reply-To"),
+        Subject:   subject,
+    }
+    from, err := raw.Header.AddressList("From")
+    if err != nil || len(from) == 0 {
+        return nil, fmt.Errorf("invalid From header: %v", err)
+    }
+    msg.From = strings.ToLower(from[0].Address)
+    msg.FromName = from[0].Name
+    msg.Date, err = raw.Header.Date()
+    if err != nil {
+        msg.Date = time.Now()
+    }
+
+    msg.Body, err = textBody(raw.Header.Get("Content-Type"), raw.Header.Get("Content-Transfer-Encoding"), raw.Body)
+    if err != nil {
+        return nil, err
+    }
+    return msg, nil
+}
+
+// textBody returns the first text/plain part of a message body.
+func textBody(contentType, transferEncoding string, body io.Reader) (string, error) {
+    mediaType, params, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        mediaType = "text/plain"
+    }
+
+    if strings.HasPrefix(mediaType, "multipart/") {
+        reader := multipart.NewReader(body, params["boundary"])
+        for {
+            part, err := reader.NextPart()
+            if err == io.EOF {
+                return "", nil
+            } else if err != nil {
+                return "", err
+            }
+            text, err := textBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+            if err != nil || text != "" {
+                return text, err
+            }
+        }
+    } else if mediaType != "text/plain" {
+        return "", nil
+    }
+
+    switch strings.ToLower(transferEncoding) {
+    case "quoted-printable":
+        body = quotedprintable.NewReader(body)
+    case "base64":
+        body = base64.NewDecoder(base64.StdEncoding, body)
+    }
+    data, err := io.ReadAll(body)
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSpace(string(data)), nil
+}
+
+// Send sends a plaintext email. If inReplyTo is set, the email is threaded
+// as a reply to that message.
+func (c *Client) Send(to, subject, body, inReplyTo string) error {
+    host, _, err := net.SplitHostPort(c.smtpAddress)
+    if err != nil {
+        return fmt.Errorf("invalid SMTP address: %w", err)
+    }
+
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\n", c.from)
+    fmt.Fprintf(&msg, "To: %s\r\n", to)
+    fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+    fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    if inReplyTo != "" {
+        fmt.Fprintf(&msg, "In-Reply-To: %s\r\nReferences: %s\r\n", inReplyTo, inReplyTo)
+    }
+    msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+    writer := quotedprintable.NewWriter(&msg)
+    _, err = writer.Write([]byte(body))
+    if err != nil {
+        return err
+    }
+    err = writer.Close()
+    if err != nil {
+        return err
+    }
+
+    sender, err := mail.ParseAddress(c.from)
+    if err != nil {
+        return fmt.Errorf("invalid from address: %w", err)
+    }
+    auth := smtp.PlainAuth("", c.username, c.password, host)
+    return smtp.SendMail(c.smtpAddress, auth, sender.Address, []string{to}, msg.Bytes())
+}
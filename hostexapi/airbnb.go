@@ -0,0 +1,25 @@
+package hostexapi
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+)
+
+// PreapproveInquiry pre-approves the Airbnb inquiry of a conversation, so
+// the guest can book without waiting for another confirmation.
+func (c *Client) PreapproveInquiry(ctx context.Context, conversationID string) error {
+    return c.do(ctx, "POST", fmt.Sprintf("/conversations/%s/preapprove", url.PathEscape(conversationID)), nil, struct{}{}, nil)
+}
+
+// SendSpecialOffer offers the guest of an Airbnb inquiry a stay between
+// checkInDate and checkOutDate (formatted as YYYY-MM-DD) for a total price in
+// the listing's currency.
+func (c *Client) SendSpecialOffer(ctx context.Context, conversationID, checkInDate, checkOutDate string, price float64) error {
+    payload := map[string]interface{}{
+        "check_in_date":  checkInDate,
+        "check_out_date": checkOutDate,
+        "price":          price,
+    }
+    return c.do(ctx, "POST", fmt.Sprintf("/conversations/%s/special_offer", url.PathEscape(conversationID)), nil, payload, nil)
+}
@@ -0,0 +1,111 @@
+package hostexapi
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// Priority decides how much of the API budget a request may use, so bulk
+// jobs can't starve the live poll loop.
+type Priority int
+
+const (
+    // PriorityLive is for polling and sending guest messages, and is the
+    // default for requests without a priority.
+    PriorityLive Priority = iota
+    // PriorityBackfill is for catching up on history, e.g. forced syncs.
+    PriorityBackfill
+    // PriorityBulk is for jobs touching many conversations or dates, like
+    // broadcasts and price updates.
+    PriorityBulk
+)
+
+type priorityContextKey struct{}
+
+// WithPriority returns a context that makes client requests use the given
+// priority.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+    return context.WithValue(ctx, priorityContextKey{}, priority)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+    priority, ok := ctx.Value(priorityContextKey{}).(Priority)
+    if !ok {
+        return PriorityLive
+    }
+    return priority
+}
+
+// budgetWindow is the sliding window the request budget applies to.
+const budgetWindow = time.Minute
+
+// budget limits the requests made per window. Lower priorities may only use
+// a share of it, leaving the rest for live requests.
+type budget struct {
+    lock   sync.Mutex
+    limit  int
+    shares [3]float64
+    sent   []time.Time
+}
+
+// SetBudget limits the client to requestsPerMinute requests. Backfill and
+// bulk requests may only use the given share (0-1) of the budget. A limit of
+// zero disables the budget.
+func (c *Client) SetBudget(requestsPerMinute int, backfillShare, bulkShare float64) {
+    if requestsPerMinute <= 0 {
+        c.budget = nil
+        return
+    }
+    c.budget = &budget{
+        limit:  requestsPerMinute,
+        shares: [3]float64{1, backfillShare, bulkShare},
+    }
+}
+
+// BudgetUsage returns how many requests were made in the current window and
+// the limit, or zeros if there's no budget.
+func (c *Client) BudgetUsage() (int, int) {
+    if c.budget == nil {
+        return 0, 0
+    }
+    c.budget.lock.Lock()
+    defer c.budget.lock.Unlock()
+    c.budget.prune(time.Now())
+    return len(c.budget.sent), c.budget.limit
+}
+
+func (b *budget) prune(now time.Time) {
+    var expired int
+    for expired < len(b.sent) && now.Sub(b.sent[expired]) >= budgetWindow {
+        expired++
+    }
+    b.sent = b.sent[expired:]
+}
+
+// wait blocks until a request with the given priority fits in the budget,
+// and then reserves it.
+func (b *budget) wait(ctx context.Context, priority Priority) error {
+    allowed := int(float64(b.limit) * b.shares[priority])
+    if allowed < 1 {
+        allowed = 1
+    }
+    for {
+        b.lock.Lock()
+        now := time.Now()
+        b.prune(now)
+        if len(b.sent) < allowed {
+            b.sent = append(b.sent, now)
+            b.lock.Unlock()
+            return nil
+        }
+        wait := b.sent[len(b.sent)-allowed].Add(budgetWindow).Sub(now)
+        b.lock.Unlock()
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(wait):
+        }
+    }
+}
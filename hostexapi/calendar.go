@@ -0,0 +1,31 @@
+package hostexapi
+
+import (
+    "context"
+    "net/url"
+)
+
+type CalendarDay struct {
+    Date      string  `json:"date"`
+    Available bool    `json:"available"`
+    Price     float64 `json:"price"`
+    MinStay   int     `json:"min_stay"`
+}
+
+// GetCalendar returns the availability of a property between startDate and
+// endDate (inclusive, formatted as YYYY-MM-DD).
+func (c *Client) GetCalendar(ctx context.Context, propertyID, startDate, endDate string) ([]CalendarDay, error) {
+    query := url.Values{}
+    query.Set("property_id", propertyID)
+    query.Set("start_date", startDate)
+    query.Set("end_date", endDate)
+
+    var data struct {
+        Days []CalendarDay `json:"days"`
+    }
+    err := c.do(ctx, "GET", "/listings/calendar", query, nil, &data)
+    if err != nil {
+        return nil, err
+    }
+    return data.Days, nil
+}
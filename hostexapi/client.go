@@ -1,20 +1,39 @@
 package hostexapi
 
 import (
-    "bytes"
-    "encoding/json"
+    "context"
+    "errors"
     "fmt"
     "net/http"
+    "net/url"
+    "strconv"
+    "sync"
     "time"
 
     "go.uber.org/zap"
 )
 
 type Client struct {
-    baseURL    string
+    endpoints  *endpoints
     token      string
+    tokenLock  sync.Mutex
     httpClient *http.Client
+    timeout    time.Duration
     logger     *zap.Logger
+
+    rateLimitLock    sync.Mutex
+    rateLimitedUntil time.Time
+    rateLimitHits    int
+    rateLimitStore   RateLimitStore
+
+    budget *budget
+}
+
+// RateLimitStore shares the rate limit state between several clients using
+// the same Hostex account, e.g. bridge instances in HA mode.
+type RateLimitStore interface {
+    RateLimitedUntil() (time.Time, error)
+    SetRateLimitedUntil(until time.Time) error
 }
 
 type Conversation struct {
@@ -24,11 +43,15 @@ type Conversation struct {
     Guest         struct {
         Name  string `json:"name"`
         Phone string `json:"phone"`
-        Email string `json:"email"`
+        Email    string `json:"email"`
+        Avatar   string `json:"avatar"`
+        Language string `json:"language"`
     } `json:"guest"`
-    PropertyTitle string `json:"property_title"`
-    CheckInDate   string `json:"check_in_date"`
-    CheckOutDate  string `json:"check_out_date"`
+    PropertyTitle     string   `json:"property_title"`
+    CheckInDate       string   `json:"check_in_date"`
+    CheckOutDate      string   `json:"check_out_date"`
+    ReservationStatus string   `json:"reservation_status"`
+    Tags              []string `json:"tags"`
 }
 
 type Message struct {
@@ -38,140 +61,152 @@ type Message struct {
     Sender    string    `json:"sender"`
 }
 
-type ConversationsResponse struct {
-    RequestID string `json:"request_id"`
-    ErrorCode int    `json:"error_code"`
-    ErrorMsg  string `json:"error_msg"`
-    Data      struct {
-        Conversations []Conversation `json:"conversations"`
-    } `json:"data"`
+type BatchMessage struct {
+    ConversationID string `json:"conversation_id"`
+    Message        string `json:"message"`
 }
 
-type MessagesResponse struct {
-    RequestID string `json:"request_id"`
-    ErrorCode int    `json:"error_code"`
-    ErrorMsg  string `json:"error_msg"`
-    Data      struct {
-        Messages []Message `json:"messages"`
-    } `json:"data"`
+type BatchMessageResult struct {
+    ConversationID string `json:"conversation_id"`
+    MessageID      string `json:"message_id"`
+    ErrorCode      int    `json:"error_code"`
+    ErrorMsg       string `json:"error_msg"`
 }
 
-func NewClient(baseURL, token string, logger *zap.Logger) *Client {
-    return &Client{
-        baseURL: baseURL,
-        token:   token,
-        httpClient: &http.Client{
-            Timeout: 30 * time.Second,
-        },
-        logger: logger,
-    }
-}
+// ErrBatchUnsupported is returned by SendMessageBatch when the API doesn't
+// provide the batch message endpoint.
+var ErrBatchUnsupported = errors.New("batch message endpoint not supported")
 
-func (c *Client) GetConversations() ([]Conversation, error) {
-    req, err := http.NewRequest("GET", fmt.Sprintf("%s/conversations", c.baseURL), nil)
-    if err != nil {
-        return nil, err
-    }
+// ErrRateLimited is returned when the Hostex API rate limit was hit and the
+// request wasn't retried, or when a request is made before the rate limit
+// has expired.
+var ErrRateLimited = errors.New("rate limited by Hostex API")
 
-    req.Header.Set("Hostex-Access-Token", c.token)
-    req.Header.Set("User-Agent", "HostexBridge/1.0")
+// HTTPError is returned when the Hostex API responds with a non-200 status.
+type HTTPError struct {
+    StatusCode int
+    RetryAfter time.Duration
+}
 
-    resp, err := c.httpClient.Do(req)
-    if err != nil {
-        return nil, err
-    }
-    defer resp.Body.Close()
+func (e *HTTPError) Error() string {
+    return fmt.Sprintf("API request failed with status code: %d", e.StatusCode)
+}
 
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
+// NewClient creates a Hostex API client. The timeout applies to each
+// individual HTTP request, including retries.
+func NewClient(baseURL, token string, timeout time.Duration, logger *zap.Logger) *Client {
+    return &Client{
+        endpoints:  newEndpoints(baseURL),
+        token:      token,
+        httpClient: &http.Client{},
+        timeout:    timeout,
+        logger:     logger,
     }
+}
 
-    var conversationsResp ConversationsResponse
-    err = json.NewDecoder(resp.Body).Decode(&conversationsResp)
-    if err != nil {
-        return nil, err
-    }
+// SetToken replaces the access token, e.g. after it was entered in the setup
+// wizard.
+func (c *Client) SetToken(token string) {
+    c.tokenLock.Lock()
+    defer c.tokenLock.Unlock()
+    c.token = token
+}
 
-    if conversationsResp.ErrorCode != 200 {
-        return nil, fmt.Errorf("API error: %s", conversationsResp.ErrorMsg)
-    }
+// HasToken reports whether the client has an access token.
+func (c *Client) HasToken() bool {
+    return c.getToken() != ""
+}
 
-    return conversationsResp.Data.Conversations, nil
+func (c *Client) getToken() string {
+    c.tokenLock.Lock()
+    defer c.tokenLock.Unlock()
+    return c.token
 }
 
-func (c *Client) GetMessages(conversationID string, since time.Time, limit int) ([]Message, error) {
-    url := fmt.Sprintf("%s/conversations/%s/messages?since=%s&limit=%d", c.baseURL, conversationID, since.Format(time.RFC3339), limit)
-    req, err := http.NewRequest("GET", url, nil)
-    if err != nil {
-        return nil, err
-    }
+// SetRateLimitStore makes the client share its rate limit state through the
+// given store in addition to tracking it locally.
+func (c *Client) SetRateLimitStore(store RateLimitStore) {
+    c.rateLimitLock.Lock()
+    defer c.rateLimitLock.Unlock()
+    c.rateLimitStore = store
+}
 
-    req.Header.Set("Hostex-Access-Token", c.token)
-    req.Header.Set("User-Agent", "HostexBridge/1.0")
+// RateLimitState returns the time until which the client is rate limited
+// (zero if it isn't) and how many 429 responses it has received in total.
+func (c *Client) RateLimitState() (time.Time, int) {
+    c.rateLimitLock.Lock()
+    defer c.rateLimitLock.Unlock()
+    if c.rateLimitStore != nil {
+        until, err := c.rateLimitStore.RateLimitedUntil()
+        if err != nil {
+            c.logger.Warn("Failed to get shared rate limit state", zap.Error(err))
+        } else if until.After(c.rateLimitedUntil) {
+            c.rateLimitedUntil = until
+        }
+    }
+    if time.Now().After(c.rateLimitedUntil) {
+        return time.Time{}, c.rateLimitHits
+    }
+    return c.rateLimitedUntil, c.rateLimitHits
+}
 
-    resp, err := c.httpClient.Do(req)
+func (c *Client) GetConversations(ctx context.Context) ([]Conversation, error) {
+    var data struct {
+        Conversations []Conversation `json:"conversations"`
+    }
+    err := c.do(ctx, "GET", "/conversations", nil, nil, &data)
     if err != nil {
         return nil, err
     }
-    defer resp.Body.Close()
+    return data.Conversations, nil
+}
 
-    if resp.StatusCode != http.StatusOK {
-        return nil, fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
-    }
+func (c *Client) GetMessages(ctx context.Context, conversationID string, since time.Time, limit int) ([]Message, error) {
+    query := url.Values{}
+    query.Set("since", since.Format(time.RFC3339))
+    query.Set("limit", strconv.Itoa(limit))
 
-    var messagesResp MessagesResponse
-    err = json.NewDecoder(resp.Body).Decode(&messagesResp)
+    var data struct {
+        Messages []Message `json:"messages"`
+    }
+    err := c.do(ctx, "GET", fmt.Sprintf("/conversations/%s/messages", conversationID), query, nil, &data)
     if err != nil {
         return nil, err
     }
-
-    if messagesResp.ErrorCode != 200 {
-        return nil, fmt.Errorf("API error: %s", messagesResp.ErrorMsg)
-    }
-
-    return messagesResp.Data.Messages, nil
+    return data.Messages, nil
 }
 
-func (c *Client) SendMessage(conversationID, content string) error {
-    url := fmt.Sprintf("%s/conversations/%s/messages", c.baseURL, conversationID)
+func (c *Client) SendMessage(ctx context.Context, conversationID, content string) (string, error) {
     payload := map[string]string{"message": content}
-    jsonPayload, err := json.Marshal(payload)
-    if err != nil {
-        return err
-    }
 
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
-    if err != nil {
-        return err
+    var data struct {
+        MessageID string `json:"message_id"`
     }
-
-    req.Header.Set("Hostex-Access-Token", c.token)
-    req.Header.Set("User-Agent", "HostexBridge/1.0")
-    req.Header.Set("Content-Type", "application/json")
-
-    resp, err := c.httpClient.Do(req)
+    err := c.do(ctx, "POST", fmt.Sprintf("/conversations/%s/messages", conversationID), nil, payload, &data)
     if err != nil {
-        return err
+        return "", err
     }
-    defer resp.Body.Close()
+    return data.MessageID, nil
+}
 
-    if resp.StatusCode != http.StatusOK {
-        return fmt.Errorf("API request failed with status code: %d", resp.StatusCode)
-    }
+// ArchiveConversation marks a conversation as handled, which moves it out of
+// the Hostex inbox until the guest writes again.
+func (c *Client) ArchiveConversation(ctx context.Context, conversationID string) error {
+    return c.do(ctx, "POST", fmt.Sprintf("/conversations/%s/archive", url.PathEscape(conversationID)), nil, struct{}{}, nil)
+}
 
-    var response struct {
-        RequestID string `json:"request_id"`
-        ErrorCode int    `json:"error_code"`
-        ErrorMsg  string `json:"error_msg"`
-    }
-    err = json.NewDecoder(resp.Body).Decode(&response)
-    if err != nil {
-        return err
-    }
+func (c *Client) SendMessageBatch(ctx context.Context, messages []BatchMessage) ([]BatchMessageResult, error) {
+    payload := map[string][]BatchMessage{"messages": messages}
 
-    if response.ErrorCode != 200 {
-        return fmt.Errorf("API error: %s", response.ErrorMsg)
+    var data struct {
+        Results []BatchMessageResult `json:"results"`
     }
-
-    return nil
+    err := c.do(ctx, "POST", "/conversations/messages/batch", nil, payload, &data)
+    var httpErr *HTTPError
+    if errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusNotFound || httpErr.StatusCode == http.StatusMethodNotAllowed) {
+        return nil, ErrBatchUnsupported
+    } else if err != nil {
+        return nil, err
+    }
+    return data.Results, nil
 }
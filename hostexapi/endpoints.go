@@ -0,0 +1,121 @@
+package hostexapi
+
+import (
+    "context"
+    "errors"
+    "net/url"
+    "sync"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// endpointCooldown is how long a failed endpoint is skipped before requests
+// try it again.
+const endpointCooldown = time.Minute
+
+// endpoint is an API base URL with its health, tracked from the outcome of
+// the requests sent to it.
+type endpoint struct {
+    url       string
+    downUntil time.Time
+    failures  int
+}
+
+type endpoints struct {
+    lock sync.Mutex
+    list []*endpoint
+}
+
+func newEndpoints(primary string) *endpoints {
+    return &endpoints{list: []*endpoint{{url: primary}}}
+}
+
+// SetFallbackURLs adds API base URLs that requests fail over to while the
+// primary one is unreachable or returning server errors. The primary URL is
+// preferred again once its cooldown is over.
+func (c *Client) SetFallbackURLs(urls []string) {
+    c.endpoints.lock.Lock()
+    defer c.endpoints.lock.Unlock()
+    for _, fallback := range urls {
+        c.endpoints.list = append(c.endpoints.list, &endpoint{url: fallback})
+    }
+}
+
+// pick returns the first healthy endpoint, or the one whose cooldown ends
+// first if they're all down.
+func (e *endpoints) pick() *endpoint {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    now := time.Now()
+    best := e.list[0]
+    for _, ep := range e.list {
+        if !ep.downUntil.After(now) {
+            return ep
+        }
+        if ep.downUntil.Before(best.downUntil) {
+            best = ep
+        }
+    }
+    return best
+}
+
+// markDown skips the endpoint until its cooldown is over and reports whether
+// there's a healthy endpoint to fail over to.
+func (e *endpoints) markDown(failed *endpoint) bool {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    now := time.Now()
+    failed.failures++
+    failed.downUntil = now.Add(endpointCooldown)
+    if len(e.list) == 1 {
+        return false
+    }
+    for _, ep := range e.list {
+        if !ep.downUntil.After(now) {
+            return true
+        }
+    }
+    return false
+}
+
+func (e *endpoints) markUp(ep *endpoint) {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    ep.failures = 0
+    ep.downUntil = time.Time{}
+}
+
+// EndpointHealth returns the API base URLs with whether each is currently
+// considered healthy.
+func (c *Client) EndpointHealth() map[string]bool {
+    c.endpoints.lock.Lock()
+    defer c.endpoints.lock.Unlock()
+    now := time.Now()
+    health := make(map[string]bool, len(c.endpoints.list))
+    for _, ep := range c.endpoints.list {
+        health[ep.url] = !ep.downUntil.After(now)
+    }
+    return health
+}
+
+// isEndpointFailure reports whether an error means the endpoint is down, as
+// opposed to the request being wrong or cancelled.
+func isEndpointFailure(ctx context.Context, err error) bool {
+    if err == nil || ctx.Err() != nil {
+        return false
+    }
+    var httpErr *HTTPError
+    if errors.As(err, &httpErr) {
+        return httpErr.StatusCode >= 500
+    }
+    var urlErr *url.Error
+    return errors.As(err, &urlErr)
+}
+
+func (c *Client) logFailover(failed *endpoint, path string, err error) {
+    c.logger.Warn("Hostex API endpoint failed, failing over",
+        zap.String("endpoint", failed.url),
+        zap.String("path", path),
+        zap.Error(err))
+}
@@ -0,0 +1,46 @@
+package hostexapi
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "net/http"
+)
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey returns a context that makes client requests send the
+// given Idempotency-Key header, so the API can drop repeated deliveries of
+// the same message.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+    return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+func idempotencyKeyFromContext(ctx context.Context) string {
+    key, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+    return key
+}
+
+// withRequestIdempotencyKey makes sure a mutating request carries an
+// Idempotency-Key, generating a random one if the caller didn't set one.
+// The key stays the same for all attempts of the request, so a retry after
+// a timeout or failover can't apply the change twice. Only GET and HEAD
+// requests are sent without one.
+func withRequestIdempotencyKey(ctx context.Context, method string) context.Context {
+    if method == http.MethodGet || method == http.MethodHead || idempotencyKeyFromContext(ctx) != "" {
+        return ctx
+    }
+    var buf [16]byte
+    _, err := rand.Read(buf[:])
+    if err != nil {
+        return ctx
+    }
+    return WithIdempotencyKey(ctx, hex.EncodeToString(buf[:]))
+}
+
+// isRetrySafe reports whether a request may be sent again after it might
+// already have reached the API, i.e. it's read-only or carries an
+// Idempotency-Key.
+func isRetrySafe(ctx context.Context, method string) bool {
+    return method == http.MethodGet || method == http.MethodHead || idempotencyKeyFromContext(ctx) != ""
+}
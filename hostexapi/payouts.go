@@ -0,0 +1,36 @@
+package hostexapi
+
+import (
+    "context"
+    "net/url"
+)
+
+// Payout is a payment from a channel to the host for a reservation.
+type Payout struct {
+    ID              string  `json:"id"`
+    ReservationCode string  `json:"reservation_code"`
+    PropertyTitle   string  `json:"property_title"`
+    ChannelType     string  `json:"channel_type"`
+    GuestName       string  `json:"guest_name"`
+    Amount          float64 `json:"amount"`
+    Currency        string  `json:"currency"`
+    Status          string  `json:"status"`
+    PayoutDate      string  `json:"payout_date"`
+}
+
+// GetPayouts returns the payouts with a payout date between startDate and
+// endDate (inclusive, formatted as YYYY-MM-DD).
+func (c *Client) GetPayouts(ctx context.Context, startDate, endDate string) ([]Payout, error) {
+    query := url.Values{}
+    query.Set("start_date", startDate)
+    query.Set("end_date", endDate)
+
+    var data struct {
+        Payouts []Payout `json:"payouts"`
+    }
+    err := c.do(ctx, "GET", "/payouts", query, nil, &data)
+    if err != nil {
+        return nil, err
+    }
+    return data.Payouts, nil
+}
@@ -0,0 +1,41 @@
+package hostexapi
+
+import (
+    "context"
+    "net/url"
+)
+
+type Price struct {
+    Date  string  `json:"date"`
+    Price float64 `json:"price"`
+}
+
+// GetPrices returns the nightly prices of a property between startDate and
+// endDate (inclusive, formatted as YYYY-MM-DD).
+func (c *Client) GetPrices(ctx context.Context, propertyID, startDate, endDate string) ([]Price, error) {
+    query := url.Values{}
+    query.Set("property_id", propertyID)
+    query.Set("start_date", startDate)
+    query.Set("end_date", endDate)
+
+    var data struct {
+        Prices []Price `json:"prices"`
+    }
+    err := c.do(ctx, "GET", "/listings/prices", query, nil, &data)
+    if err != nil {
+        return nil, err
+    }
+    return data.Prices, nil
+}
+
+// UpdatePrice sets the nightly price of a property between startDate and
+// endDate (inclusive, formatted as YYYY-MM-DD).
+func (c *Client) UpdatePrice(ctx context.Context, propertyID, startDate, endDate string, price float64) error {
+    payload := map[string]interface{}{
+        "property_id": propertyID,
+        "start_date":  startDate,
+        "end_date":    endDate,
+        "price":       price,
+    }
+    return c.do(ctx, "POST", "/listings/prices", nil, payload, nil)
+}
@@ -0,0 +1,27 @@
+package hostexapi
+
+import (
+    "context"
+)
+
+type Property struct {
+    ID       string `json:"id"`
+    Title    string `json:"title"`
+    Address  string `json:"address"`
+    Timezone string `json:"timezone"`
+    Channels []struct {
+        ChannelType string `json:"channel_type"`
+        ListingID   string `json:"listing_id"`
+    } `json:"channels"`
+}
+
+func (c *Client) GetProperties(ctx context.Context) ([]Property, error) {
+    var data struct {
+        Properties []Property `json:"properties"`
+    }
+    err := c.do(ctx, "GET", "/properties", nil, nil, &data)
+    if err != nil {
+        return nil, err
+    }
+    return data.Properties, nil
+}
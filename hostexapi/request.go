@@ -0,0 +1,227 @@
+package hostexapi
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
+    "go.uber.org/zap"
+)
+
+// tracer records a span for every API request, including its retries.
+var tracer = otel.Tracer("github.com/keithah/hostex-bridge-go/hostexapi")
+
+const (
+    // maxRetries is how many times a request is retried after a 429 or 5xx.
+    maxRetries = 3
+    // baseBackoff is the delay before the first retry of a 5xx response,
+    // doubled for every further attempt.
+    baseBackoff = time.Second
+    // maxRetryWait is the longest Retry-After the client will sleep through
+    // before giving up on a request.
+    maxRetryWait = time.Minute
+)
+
+type apiResponse struct {
+    RequestID string          `json:"request_id"`
+    ErrorCode int             `json:"error_code"`
+    ErrorMsg  string          `json:"error_msg"`
+    Data      json.RawMessage `json:"data"`
+}
+
+// do sends a request to the Hostex API and decodes the data field of the
+// response envelope into data, if it's not nil. Rate limited and 5xx
+// responses are retried with backoff. Mutating requests always carry an
+// Idempotency-Key, and are only retried after a failure that may have
+// reached the API if they do. Each attempt is limited to the
+// client's request timeout, and waiting between attempts stops when ctx is
+// cancelled.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, payload, data interface{}) (err error) {
+    ctx, span := tracer.Start(ctx, "hostex "+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+        attribute.String("http.request.method", method),
+        attribute.String("url.path", path),
+    ))
+    defer func() {
+        if err != nil {
+            span.RecordError(err)
+            span.SetStatus(codes.Error, err.Error())
+        }
+        span.End()
+    }()
+
+    if until, _ := c.RateLimitState(); !until.IsZero() {
+        return fmt.Errorf("%w until %s", ErrRateLimited, until.Format(time.RFC3339))
+    }
+
+    ctx = withRequestIdempotencyKey(ctx, method)
+    retrySafe := isRetrySafe(ctx, method)
+
+    reqPath := path
+    if len(query) > 0 {
+        reqPath += "?" + query.Encode()
+    }
+
+    var body []byte
+    if payload != nil {
+        var err error
+        body, err = json.Marshal(payload)
+        if err != nil {
+            return err
+        }
+    }
+
+    for attempt := 0; ; attempt++ {
+        if c.budget != nil {
+            err := c.budget.wait(ctx, priorityFromContext(ctx))
+            if err != nil {
+                return err
+            }
+        }
+        ep := c.endpoints.pick()
+        err := c.doOnce(ctx, method, ep.url+reqPath, body, data)
+        if isEndpointFailure(ctx, err) {
+            if c.endpoints.markDown(ep) && attempt < maxRetries && retrySafe {
+                c.logFailover(ep, path, err)
+                continue
+            }
+        } else if err == nil {
+            c.endpoints.markUp(ep)
+        }
+        httpErr, ok := err.(*HTTPError)
+        if !ok || attempt >= maxRetries {
+            if ok && httpErr.StatusCode == http.StatusTooManyRequests {
+                return fmt.Errorf("%w: %v", ErrRateLimited, err)
+            }
+            return err
+        }
+
+        var wait time.Duration
+        switch {
+        case httpErr.StatusCode == http.StatusTooManyRequests:
+            wait = httpErr.RetryAfter
+            if wait == 0 {
+                wait = backoff(attempt)
+            }
+            c.setRateLimited(wait)
+            if wait > maxRetryWait {
+                return fmt.Errorf("%w for %s", ErrRateLimited, wait)
+            }
+        case httpErr.StatusCode >= 500 && retrySafe:
+            wait = backoff(attempt)
+        default:
+            return err
+        }
+
+        c.logger.Warn("Retrying Hostex API request",
+            zap.String("path", path),
+            zap.Int("status_code", httpErr.StatusCode),
+            zap.Duration("wait", wait),
+            zap.Int("attempt", attempt+1))
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(wait):
+        }
+    }
+}
+
+func (c *Client) doOnce(ctx context.Context, method, reqURL string, payload []byte, data interface{}) error {
+    ctx, cancel := context.WithTimeout(ctx, c.timeout)
+    defer cancel()
+
+    var body io.Reader
+    if payload != nil {
+        body = bytes.NewReader(payload)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+    if err != nil {
+        return err
+    }
+
+    req.Header.Set("Hostex-Access-Token", c.getToken())
+    req.Header.Set("User-Agent", "HostexBridge/1.0")
+    if payload != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if key := idempotencyKeyFromContext(ctx); key != "" {
+        req.Header.Set("Idempotency-Key", key)
+    }
+
+    resp, err := c.httpClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return &HTTPError{
+            StatusCode: resp.StatusCode,
+            RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+        }
+    }
+
+    var response apiResponse
+    err = json.NewDecoder(resp.Body).Decode(&response)
+    if err != nil {
+        return err
+    }
+
+    if response.ErrorCode != 200 {
+        return fmt.Errorf("API error: %s", response.ErrorMsg)
+    }
+
+    if data != nil && len(response.Data) > 0 {
+        return json.Unmarshal(response.Data, data)
+    }
+    return nil
+}
+
+func (c *Client) setRateLimited(wait time.Duration) {
+    c.rateLimitLock.Lock()
+    defer c.rateLimitLock.Unlock()
+    c.rateLimitHits++
+    until := time.Now().Add(wait)
+    if until.After(c.rateLimitedUntil) {
+        c.rateLimitedUntil = until
+        if c.rateLimitStore != nil {
+            err := c.rateLimitStore.SetRateLimitedUntil(until)
+            if err != nil {
+                c.logger.Warn("Failed to share rate limit state", zap.Error(err))
+            }
+        }
+    }
+}
+
+// backoff returns the exponential backoff delay for the given attempt with
+// up to 50% random jitter added.
+func backoff(attempt int) time.Duration {
+    delay := baseBackoff << attempt
+    return delay + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(header string) time.Duration {
+    if header == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(header); err == nil {
+        return time.Duration(seconds) * time.Second
+    }
+    if date, err := http.ParseTime(header); err == nil {
+        return time.Until(date)
+    }
+    return 0
+}
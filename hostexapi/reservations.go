@@ -0,0 +1,63 @@
+package hostexapi
+
+import (
+    "context"
+    "net/url"
+)
+
+type Reservation struct {
+    ReservationCode string  `json:"reservation_code"`
+    ConversationID  string  `json:"conversation_id"`
+    PropertyID      string  `json:"property_id"`
+    PropertyTitle   string  `json:"property_title"`
+    ChannelType     string  `json:"channel_type"`
+    Status          string  `json:"status"`
+    CheckInDate     string  `json:"check_in_date"`
+    CheckOutDate    string  `json:"check_out_date"`
+    GuestName       string  `json:"guest_name"`
+    NumberOfGuests  int     `json:"number_of_guests"`
+    Payout          float64 `json:"payout"`
+    Currency        string  `json:"currency"`
+    // PaymentStatus is whether the guest paid, e.g. paid, pending or
+    // failed.
+    PaymentStatus string `json:"payment_status"`
+    // DepositStatus is the state of the security deposit: held, released
+    // or claimed, or empty if there is none.
+    DepositStatus string  `json:"deposit_status"`
+    DepositAmount float64 `json:"deposit_amount"`
+    // CustomFields are the custom fields set on the reservation in Hostex,
+    // e.g. a door code.
+    CustomFields map[string]string `json:"custom_fields"`
+}
+
+// GetReservations returns reservations with a check-in date between
+// startDate and endDate (inclusive, formatted as YYYY-MM-DD).
+func (c *Client) GetReservations(ctx context.Context, startDate, endDate string) ([]Reservation, error) {
+    query := url.Values{}
+    query.Set("start_check_in_date", startDate)
+    query.Set("end_check_in_date", endDate)
+    return c.getReservations(ctx, query)
+}
+
+// GetReservation returns the reservation with the given reservation
+// (confirmation) code, or nil if there is none.
+func (c *Client) GetReservation(ctx context.Context, code string) (*Reservation, error) {
+    query := url.Values{}
+    query.Set("reservation_code", code)
+    reservations, err := c.getReservations(ctx, query)
+    if err != nil || len(reservations) == 0 {
+        return nil, err
+    }
+    return &reservations[0], nil
+}
+
+func (c *Client) getReservations(ctx context.Context, query url.Values) ([]Reservation, error) {
+    var data struct {
+        Reservations []Reservation `json:"reservations"`
+    }
+    err := c.do(ctx, "GET", "/reservations", query, nil, &data)
+    if err != nil {
+        return nil, err
+    }
+    return data.Reservations, nil
+}
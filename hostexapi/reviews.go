@@ -0,0 +1,46 @@
+package hostexapi
+
+import (
+    "context"
+    "net/url"
+    "time"
+)
+
+type Review struct {
+    ReservationCode string    `json:"reservation_code"`
+    PropertyID      string    `json:"property_id"`
+    PropertyTitle   string    `json:"property_title"`
+    ChannelType     string    `json:"channel_type"`
+    GuestName       string    `json:"guest_name"`
+    // Rating is nil if the guest hasn't rated the stay (yet).
+    Rating          *float64  `json:"rating"`
+    Content         string    `json:"content"`
+    HostReply       string    `json:"host_reply"`
+    CreatedAt       time.Time `json:"created_at"`
+}
+
+// GetReviews returns guest reviews of reservations with a check-out date
+// between startDate and endDate (inclusive, formatted as YYYY-MM-DD).
+func (c *Client) GetReviews(ctx context.Context, startDate, endDate string) ([]Review, error) {
+    query := url.Values{}
+    query.Set("start_check_out_date", startDate)
+    query.Set("end_check_out_date", endDate)
+
+    var data struct {
+        Reviews []Review `json:"reviews"`
+    }
+    err := c.do(ctx, "GET", "/reviews", query, nil, &data)
+    if err != nil {
+        return nil, err
+    }
+    return data.Reviews, nil
+}
+
+// ReplyToReview publishes the host's public reply to the guest review of a
+// reservation.
+func (c *Client) ReplyToReview(ctx context.Context, reservationCode, reply string) error {
+    payload := map[string]string{
+        "host_reply_content": reply,
+    }
+    return c.do(ctx, "POST", "/reviews/"+url.PathEscape(reservationCode), nil, payload, nil)
+}
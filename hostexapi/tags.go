@@ -0,0 +1,18 @@
+package hostexapi
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+)
+
+// AddConversationTag adds a tag, e.g. "maintenance", to a conversation.
+func (c *Client) AddConversationTag(ctx context.Context, conversationID, tag string) error {
+    payload := map[string]string{"tag": tag}
+    return c.do(ctx, "POST", fmt.Sprintf("/conversations/%s/tags", url.PathEscape(conversationID)), nil, payload, nil)
+}
+
+// RemoveConversationTag removes a tag from a conversation.
+func (c *Client) RemoveConversationTag(ctx context.Context, conversationID, tag string) error {
+    return c.do(ctx, "DELETE", fmt.Sprintf("/conversations/%s/tags/%s", url.PathEscape(conversationID), url.PathEscape(tag)), nil, nil, nil)
+}
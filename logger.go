@@ -0,0 +1,57 @@
+package main
+
+import (
+    "io"
+
+    "go.uber.org/zap"
+    "go.uber.org/zap/zapcore"
+
+    "github.com/keithah/hostex-bridge-go/config"
+    "github.com/keithah/hostex-bridge-go/logging"
+)
+
+// newLogger builds the logger described by the logging section of the config.
+// File output goes through a rotating file, which is returned so it can be
+// closed on exit.
+func newLogger(cfg *config.Config, verbose bool) (*zap.Logger, io.Closer, error) {
+    logConfig := zap.NewDevelopmentConfig()
+    if cfg.Logging.Format == "json" {
+        logConfig = zap.NewProductionConfig()
+        logConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+    }
+
+    level, err := zapcore.ParseLevel(cfg.Logging.Level)
+    if err != nil {
+        return nil, nil, err
+    }
+    if verbose {
+        level = zapcore.DebugLevel
+    }
+    logConfig.Level = zap.NewAtomicLevelAt(level)
+
+    if cfg.Logging.Output == "stderr" || cfg.Logging.Output == "stdout" {
+        logConfig.OutputPaths = []string{cfg.Logging.Output}
+        logger, err := logConfig.Build()
+        return logger, nil, err
+    }
+
+    file, err := logging.NewRotatingFile(cfg.Logging.Output, int64(cfg.Logging.MaxSize)*1024*1024, cfg.Logging.MaxBackups)
+    if err != nil {
+        return nil, nil, err
+    }
+    file.SetMaxAge(cfg.Logging.MaxAge)
+
+    encoder := zapcore.NewConsoleEncoder(logConfig.EncoderConfig)
+    if cfg.Logging.Format == "json" {
+        encoder = zapcore.NewJSONEncoder(logConfig.EncoderConfig)
+    }
+    logConfig.OutputPaths = nil
+    logger, err := logConfig.Build(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+        return zapcore.NewCore(encoder, file, logConfig.Level)
+    }))
+    if err != nil {
+        file.Close()
+        return nil, nil, err
+    }
+    return logger, file, nil
+}
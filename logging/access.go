@@ -0,0 +1,163 @@
+package logging
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// AccessLog writes one JSON object per HTTP request to an append-only log,
+// separate from the application log.
+type AccessLog struct {
+    lock sync.Mutex
+    out  io.Writer
+    // trustedProxies are the peers whose X-Forwarded-For header is used for
+    // the caller.
+    trustedProxies []*net.IPNet
+}
+
+type accessLogEntry struct {
+    Time      string  `json:"time"`
+    Subsystem string  `json:"subsystem"`
+    Method    string  `json:"method"`
+    Path      string  `json:"path"`
+    Status    int     `json:"status"`
+    LatencyMS float64 `json:"latency_ms"`
+    Caller    string  `json:"caller"`
+    UserAgent string  `json:"user_agent,omitempty"`
+}
+
+func NewAccessLog(out io.Writer) *AccessLog {
+    return &AccessLog{out: out}
+}
+
+// SetTrustedProxies sets the IP addresses or CIDR ranges of the reverse
+// proxies whose X-Forwarded-For header is trusted. Without any, the caller
+// is always the peer address, as the header can be set by anyone.
+func (al *AccessLog) SetTrustedProxies(proxies []string) error {
+    networks, err := parseTrustedProxies(proxies)
+    if err != nil {
+        return err
+    }
+    al.trustedProxies = networks
+    return nil
+}
+
+// parseTrustedProxies parses IP addresses and CIDR ranges.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+    networks := make([]*net.IPNet, 0, len(proxies))
+    for _, proxy := range proxies {
+        if !strings.Contains(proxy, "/") {
+            ip := net.ParseIP(proxy)
+            if ip == nil {
+                return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+            }
+            bits := 8 * net.IPv6len
+            if ip.To4() != nil {
+                ip, bits = ip.To4(), 8*net.IPv4len
+            }
+            networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+            continue
+        }
+        _, network, err := net.ParseCIDR(proxy)
+        if err != nil {
+            return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+        }
+        networks = append(networks, network)
+    }
+    return networks, nil
+}
+
+func (al *AccessLog) isTrustedProxy(addr string) bool {
+    ip := net.ParseIP(strings.TrimSpace(addr))
+    if ip == nil {
+        return false
+    }
+    for _, network := range al.trustedProxies {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+    sr.status = status
+    sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+    if sr.status == 0 {
+        sr.status = http.StatusOK
+    }
+    return sr.ResponseWriter.Write(p)
+}
+
+// Wrap returns a handler that logs every request handled by next. Subsystem
+// identifies the HTTP surface, e.g. "webhook", "provisioning" or "appservice".
+func (al *AccessLog) Wrap(subsystem string, next http.Handler) http.Handler {
+    if al == nil {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        recorder := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(recorder, r)
+        if recorder.status == 0 {
+            recorder.status = http.StatusOK
+        }
+
+        al.write(&accessLogEntry{
+            Time:      start.UTC().Format(time.RFC3339Nano),
+            Subsystem: subsystem,
+            Method:    r.Method,
+            Path:      r.URL.Path,
+            Status:    recorder.status,
+            LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+            Caller:    al.requestCaller(r),
+            UserAgent: r.UserAgent(),
+        })
+    })
+}
+
+func (al *AccessLog) write(entry *accessLogEntry) {
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return
+    }
+    al.lock.Lock()
+    defer al.lock.Unlock()
+    _, _ = al.out.Write(append(data, '\n'))
+}
+
+// requestCaller returns the address of the client. X-Forwarded-For is only
+// used when the request comes from a trusted proxy, and then the caller is
+// the last address in it that isn't a trusted proxy itself.
+func (al *AccessLog) requestCaller(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+    forwarded := r.Header.Get("X-Forwarded-For")
+    if forwarded == "" || !al.isTrustedProxy(host) {
+        return host
+    }
+    hops := strings.Split(forwarded, ",")
+    for i := len(hops) - 1; i >= 0; i-- {
+        hop := strings.TrimSpace(hops[i])
+        if !al.isTrustedProxy(hop) || i == 0 {
+            return hop
+        }
+    }
+    return host
+}
@@ -0,0 +1,117 @@
+package logging
+
+import (
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// RotatingFile is an append-only file writer that rotates the file once it
+// grows beyond maxSize bytes, or is older than maxAge if set, keeping up to
+// maxBackups old files named path.1, path.2 and so on.
+type RotatingFile struct {
+    path       string
+    maxSize    int64
+    maxBackups int
+    maxAge     time.Duration
+
+    lock     sync.Mutex
+    file     *os.File
+    size     int64
+    openedAt time.Time
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+    rf := &RotatingFile{
+        path:       path,
+        maxSize:    maxSize,
+        maxBackups: maxBackups,
+    }
+    err := rf.open()
+    if err != nil {
+        return nil, err
+    }
+    return rf, nil
+}
+
+// SetMaxAge makes the file rotate once it has been written to for longer
+// than maxAge, e.g. daily. Zero disables age based rotation.
+func (rf *RotatingFile) SetMaxAge(maxAge time.Duration) {
+    rf.lock.Lock()
+    defer rf.lock.Unlock()
+    rf.maxAge = maxAge
+}
+
+func (rf *RotatingFile) open() error {
+    file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to open %s: %w", rf.path, err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return fmt.Errorf("failed to stat %s: %w", rf.path, err)
+    }
+    rf.file = file
+    rf.size = info.Size()
+    rf.openedAt = time.Now()
+    if rf.size > 0 {
+        rf.openedAt = info.ModTime()
+    }
+    return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+    err := rf.file.Close()
+    if err != nil {
+        return err
+    }
+
+    for i := rf.maxBackups - 1; i >= 1; i-- {
+        err = os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+        if err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    if rf.maxBackups > 0 {
+        err = os.Rename(rf.path, rf.path+".1")
+    } else {
+        err = os.Remove(rf.path)
+    }
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+
+    return rf.open()
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+    rf.lock.Lock()
+    defer rf.lock.Unlock()
+
+    tooBig := rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize
+    tooOld := rf.maxAge > 0 && time.Since(rf.openedAt) > rf.maxAge
+    if rf.size > 0 && (tooBig || tooOld) {
+        err := rf.rotate()
+        if err != nil {
+            return 0, fmt.Errorf("failed to rotate %s: %w", rf.path, err)
+        }
+    }
+
+    n, err := rf.file.Write(p)
+    rf.size += int64(n)
+    return n, err
+}
+
+func (rf *RotatingFile) Sync() error {
+    rf.lock.Lock()
+    defer rf.lock.Unlock()
+    return rf.file.Sync()
+}
+
+func (rf *RotatingFile) Close() error {
+    rf.lock.Lock()
+    defer rf.lock.Unlock()
+    return rf.file.Close()
+}
@@ -1,28 +1,37 @@
 package main
 
 import (
+    "context"
     "flag"
+    "fmt"
     "os"
     "os/signal"
+    "strings"
     "syscall"
 
     "go.uber.org/zap"
 
     "github.com/keithah/hostex-bridge-go/bridge"
     "github.com/keithah/hostex-bridge-go/config"
-    "github.com/keithah/hostex-bridge-go/database"
-    "github.com/keithah/hostex-bridge-go/hostexapi"
 )
 
 var (
     configPath = flag.String("config", "config.yaml", "Path to config file")
     verbose    = flag.Bool("v", false, "Enable verbose logging")
+    version    = flag.Bool("version", false, "Print the version and exit")
+
+    registrationPath = flag.String("registration", "registration.yaml", "Path to write the appservice registration to, for generate-registration")
 )
 
 func main() {
     flag.Parse()
 
-    // Initialize logging
+    if *version {
+        fmt.Printf("hostex-bridge %s\n", bridge.VersionString())
+        return
+    }
+
+    // Initialize logging, until the config is loaded
     logConfig := zap.NewDevelopmentConfig()
     if *verbose {
         logConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
@@ -33,29 +42,88 @@ func main() {
     }
     defer logger.Sync()
 
+    if flag.Arg(0) == "generate-config" {
+        generateFlags := flag.NewFlagSet("generate-config", flag.ExitOnError)
+        force := generateFlags.Bool("force", false, "Overwrite an existing config file")
+        generateFlags.Parse(flag.Args()[1:])
+        if _, err := os.Stat(*configPath); err == nil && !*force {
+            logger.Fatal("Config file already exists, use generate-config --force to overwrite it", zap.String("path", *configPath))
+        }
+        err = os.WriteFile(*configPath, []byte(config.ExampleConfig), 0600)
+        if err != nil {
+            logger.Fatal("Failed to write config", zap.Error(err))
+        }
+        fmt.Printf("Wrote an example config to %s, fill in the placeholders before starting the bridge\n", *configPath)
+        return
+    }
+
     // Load config
     cfg, err := config.Load(*configPath)
     if err != nil {
         logger.Fatal("Failed to load config", zap.Error(err))
     }
 
-    // Initialize database
-    db, err := database.New(cfg.Database.Path, logger)
+    // Switch to the configured logger
+    logger, logFile, err := newLogger(cfg, *verbose)
     if err != nil {
-        logger.Fatal("Failed to initialize database", zap.Error(err))
+        panic(err)
+    }
+    if logFile != nil {
+        defer logFile.Close()
     }
+    defer logger.Sync()
 
-    // Initialize Hostex API client
-    hostexClient := hostexapi.NewClient(cfg.Hostex.APIURL, cfg.Hostex.Token, logger)
+    switch flag.Arg(0) {
+    case "":
+    case "generate-registration":
+        generated, err := generateRegistration(cfg, *registrationPath)
+        if err != nil {
+            logger.Fatal("Failed to generate registration", zap.Error(err))
+        }
+        fmt.Printf("Wrote the appservice registration to %s\n", *registrationPath)
+        if len(generated) > 0 {
+            fmt.Printf("Add the generated tokens to %s:\n  %s\n", *configPath, strings.Join(generated, "\n  "))
+        }
+        return
+    case "doctor":
+        doctorFlags := flag.NewFlagSet("doctor", flag.ExitOnError)
+        homeserver := doctorFlags.Bool("homeserver", false, "Check which optional homeserver features are available")
+        doctorFlags.Parse(flag.Args()[1:])
+        if !*homeserver {
+            logger.Fatal("Nothing to check, use doctor --homeserver")
+        }
+        matrixClient, err := bridge.NewMatrixClient(cfg.Homeserver.Address, cfg.User.UserID, cfg.Appservice.ASToken)
+        if err != nil {
+            logger.Fatal("Failed to create Matrix client", zap.Error(err))
+        }
+        checks, err := checkHomeserver(context.Background(), matrixClient)
+        if len(checks) > 0 {
+            fmt.Print(formatDoctorReport(checks))
+        }
+        if err != nil {
+            logger.Fatal("Homeserver check failed", zap.Error(err))
+        }
+        return
+    default:
+        logger.Fatal("Unknown subcommand", zap.String("subcommand", flag.Arg(0)))
+    }
 
-    // Initialize Matrix client
-    matrixClient, err := bridge.NewMatrixClient(cfg.Homeserver.Address, cfg.User.UserID, cfg.Appservice.ASToken)
-    if err != nil {
-        logger.Fatal("Failed to create Matrix client", zap.Error(err))
+    // Initialize tracing
+    if cfg.Tracing.Enable {
+        shutdownTracing, err := setupTracing(context.Background(), cfg)
+        if err != nil {
+            logger.Fatal("Failed to set up tracing", zap.Error(err))
+        }
+        defer shutdownTracing(context.Background())
     }
 
+    logger.Info("Hostex bridge version", zap.String("version", bridge.Version), zap.String("commit", bridge.Commit), zap.String("build_time", bridge.BuildTime))
+
     // Initialize bridge
-    b := bridge.NewBridge(cfg, db, hostexClient, matrixClient, logger)
+    b, err := bridge.New(cfg, bridge.Options{Logger: logger})
+    if err != nil {
+        logger.Fatal("Failed to initialize bridge", zap.Error(err))
+    }
 
     // Start the bridge
     err = b.Start()
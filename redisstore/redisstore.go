@@ -0,0 +1,222 @@
+package redisstore
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/database"
+)
+
+// Store keeps the bridge's hot state in Redis, so that several instances can
+// share it and the SQLite database is only used as the durable record.
+type Store struct {
+    client  *redis.Client
+    prefix  string
+    timeout time.Duration
+}
+
+// New connects to the Redis server at the given redis:// URL. Every key is
+// prefixed with keyPrefix so several bridges can share a server.
+func New(url, keyPrefix string, timeout time.Duration) (*Store, error) {
+    opts, err := redis.ParseURL(url)
+    if err != nil {
+        return nil, fmt.Errorf("invalid Redis URL: %w", err)
+    }
+    store := &Store{
+        client:  redis.NewClient(opts),
+        prefix:  keyPrefix,
+        timeout: timeout,
+    }
+
+    ctx, cancel := store.context()
+    defer cancel()
+    err = store.client.Ping(ctx).Err()
+    if err != nil {
+        store.client.Close()
+        return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+    }
+    return store, nil
+}
+
+func (s *Store) Close() error {
+    return s.client.Close()
+}
+
+func (s *Store) context() (context.Context, context.CancelFunc) {
+    return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *Store) key(parts ...string) string {
+    key := s.prefix
+    for i, part := range parts {
+        if i > 0 {
+            key += ":"
+        }
+        key += part
+    }
+    return key
+}
+
+// PortalState is the cached metadata of a bridged conversation.
+type PortalState struct {
+    RoomID        id.RoomID
+    LastMessageAt time.Time
+}
+
+// GetPortalState returns the cached metadata of a conversation, or nil if
+// nothing is cached for it.
+func (s *Store) GetPortalState(hostexID string) (*PortalState, error) {
+    ctx, cancel := s.context()
+    defer cancel()
+
+    fields, err := s.client.HGetAll(ctx, s.key("portal", hostexID)).Result()
+    if err != nil {
+        return nil, err
+    } else if len(fields) == 0 {
+        return nil, nil
+    }
+
+    state := &PortalState{RoomID: id.RoomID(fields["room_id"])}
+    if lastMessageAt, err := strconv.ParseInt(fields["last_message_at"], 10, 64); err == nil {
+        state.LastMessageAt = time.UnixMilli(lastMessageAt)
+    }
+    return state, nil
+}
+
+func (s *Store) SetPortalRoomID(hostexID string, roomID id.RoomID) error {
+    ctx, cancel := s.context()
+    defer cancel()
+    return s.client.HSet(ctx, s.key("portal", hostexID), "room_id", roomID.String()).Err()
+}
+
+func (s *Store) SetPortalLastMessageAt(hostexID string, lastMessageAt time.Time) error {
+    ctx, cancel := s.context()
+    defer cancel()
+    return s.client.HSet(ctx, s.key("portal", hostexID), "last_message_at", lastMessageAt.UnixMilli()).Err()
+}
+
+// RateLimitedUntil returns the time until which the Hostex API is rate
+// limited for all instances, or zero if it isn't.
+func (s *Store) RateLimitedUntil() (time.Time, error) {
+    return s.rateLimitedUntil(s.key("rate_limited_until"))
+}
+
+// SetRateLimitedUntil shares a rate limit with the other instances. The key
+// expires together with the rate limit.
+func (s *Store) SetRateLimitedUntil(until time.Time) error {
+    return s.setRateLimitedUntil(s.key("rate_limited_until"), until)
+}
+
+// AccountRateLimit is the shared rate limit of an additional Hostex account,
+// which has its own token and so its own rate limit.
+type AccountRateLimit struct {
+    store *Store
+    key   string
+}
+
+// AccountRateLimit returns the shared rate limit of an additional Hostex
+// account. The main account uses the methods of the store itself.
+func (s *Store) AccountRateLimit(account string) *AccountRateLimit {
+    return &AccountRateLimit{store: s, key: s.key("rate_limited_until." + account)}
+}
+
+func (a *AccountRateLimit) RateLimitedUntil() (time.Time, error) {
+    return a.store.rateLimitedUntil(a.key)
+}
+
+func (a *AccountRateLimit) SetRateLimitedUntil(until time.Time) error {
+    return a.store.setRateLimitedUntil(a.key, until)
+}
+
+func (s *Store) rateLimitedUntil(key string) (time.Time, error) {
+    ctx, cancel := s.context()
+    defer cancel()
+
+    until, err := s.client.Get(ctx, key).Int64()
+    if errors.Is(err, redis.Nil) {
+        return time.Time{}, nil
+    } else if err != nil {
+        return time.Time{}, err
+    }
+    return time.UnixMilli(until), nil
+}
+
+func (s *Store) setRateLimitedUntil(key string, until time.Time) error {
+    ctx, cancel := s.context()
+    defer cancel()
+
+    err := s.client.Set(ctx, key, until.UnixMilli(), 0).Err()
+    if err != nil {
+        return err
+    }
+    return s.client.PExpireAt(ctx, key, until).Err()
+}
+
+func (s *Store) GetLease(name string) (*database.Lease, error) {
+    ctx, cancel := s.context()
+    defer cancel()
+
+    fields, err := s.client.HGetAll(ctx, s.key("lease", name)).Result()
+    if err != nil {
+        return nil, err
+    } else if len(fields) == 0 {
+        return nil, nil
+    }
+
+    expiresAt, err := strconv.ParseInt(fields["expires_at"], 10, 64)
+    if err != nil {
+        return nil, fmt.Errorf("invalid lease expiry: %w", err)
+    }
+    return &database.Lease{
+        Name:       name,
+        InstanceID: fields["instance_id"],
+        ExpiresAt:  time.UnixMilli(expiresAt),
+        Signature:  fields["signature"],
+    }, nil
+}
+
+// acquireLeaseScript writes the lease if it's free, already held by the same
+// instance, or still has the signature the caller last observed. Expired
+// leases are removed by Redis itself.
+var acquireLeaseScript = redis.NewScript(`
+local current = redis.call('HMGET', KEYS[1], 'instance_id', 'signature')
+if current[1] and current[1] ~= ARGV[1] and current[2] ~= ARGV[4] then
+    return 0
+end
+redis.call('HSET', KEYS[1], 'instance_id', ARGV[1], 'expires_at', ARGV[2], 'signature', ARGV[3])
+redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+return 1
+`)
+
+var releaseLeaseScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'instance_id') == ARGV[1] then
+    return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// AcquireLease behaves like database.Database.AcquireLease, but is atomic
+// across every instance connected to the same Redis server.
+func (s *Store) AcquireLease(lease *database.Lease, observedSignature string) (bool, error) {
+    ctx, cancel := s.context()
+    defer cancel()
+
+    acquired, err := acquireLeaseScript.Run(ctx, s.client, []string{s.key("lease", lease.Name)},
+        lease.InstanceID, lease.ExpiresAt.UnixMilli(), lease.Signature, observedSignature).Int()
+    if err != nil {
+        return false, err
+    }
+    return acquired == 1, nil
+}
+
+func (s *Store) ReleaseLease(name, instanceID string) error {
+    ctx, cancel := s.context()
+    defer cancel()
+    return releaseLeaseScript.Run(ctx, s.client, []string{s.key("lease", name)}, instanceID).Err()
+}
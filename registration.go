@@ -0,0 +1,47 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+
+    "maunium.net/go/mautrix/appservice"
+    "maunium.net/go/mautrix/id"
+
+    "github.com/keithah/hostex-bridge-go/config"
+)
+
+// generateRegistration writes the appservice registration for the config to
+// path. Tokens missing from the config are generated, and returned so they
+// can be copied into it.
+func generateRegistration(cfg *config.Config, path string) (generated []string, err error) {
+    localpart, domain, err := id.UserID(cfg.User.UserID).Parse()
+    if err != nil {
+        return nil, fmt.Errorf("invalid user.user_id: %w", err)
+    }
+    if cfg.Homeserver.Domain != "" {
+        domain = cfg.Homeserver.Domain
+    }
+
+    reg := appservice.CreateRegistration()
+    reg.ID = cfg.Appservice.ID
+    reg.URL = cfg.Appservice.URL
+    reg.SenderLocalpart = localpart
+    rateLimited := false
+    reg.RateLimited = &rateLimited
+    if cfg.Appservice.ASToken != "" {
+        reg.AppToken = cfg.Appservice.ASToken
+    } else {
+        generated = append(generated, "appservice.as_token: "+reg.AppToken)
+    }
+    if cfg.Appservice.HSToken != "" {
+        reg.ServerToken = cfg.Appservice.HSToken
+    } else {
+        generated = append(generated, "appservice.hs_token: "+reg.ServerToken)
+    }
+
+    escapedDomain := regexp.QuoteMeta(domain)
+    reg.Namespaces.UserIDs.Register(regexp.MustCompile(fmt.Sprintf("^@%s:%s$", regexp.QuoteMeta(localpart), escapedDomain)), true)
+    reg.Namespaces.UserIDs.Register(regexp.MustCompile(fmt.Sprintf("^@%s.+:%s$", regexp.QuoteMeta(cfg.Bridge.UserPrefix), escapedDomain)), true)
+
+    return generated, reg.Save(path)
+}
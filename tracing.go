@@ -0,0 +1,36 @@
+package main
+
+import (
+    "context"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+    "github.com/keithah/hostex-bridge-go/config"
+)
+
+// setupTracing installs a tracer provider that exports spans over OTLP/HTTP.
+// The returned function flushes the remaining spans on shutdown.
+func setupTracing(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+    options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Tracing.Endpoint)}
+    if cfg.Tracing.Insecure {
+        options = append(options, otlptracehttp.WithInsecure())
+    }
+    exporter, err := otlptracehttp.New(ctx, options...)
+    if err != nil {
+        return nil, err
+    }
+
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+        sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.Tracing.ServiceName))),
+    )
+    otel.SetTracerProvider(provider)
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+    return provider.Shutdown, nil
+}
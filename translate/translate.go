@@ -0,0 +1,188 @@
+// Package translate translates messages with a machine translation API:
+// DeepL, Google Cloud Translation or LibreTranslate.
+package translate
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// maxResponseSize limits how much of an API response is read.
+const maxResponseSize = 1024 * 1024
+
+// Translator translates text to a target language. The source language is
+// detected by the provider.
+type Translator interface {
+    Translate(ctx context.Context, text, target string) (*Result, error)
+}
+
+// Result is a translation with the language the text was detected to be in,
+// as a lowercase language code like "es".
+type Result struct {
+    Text             string
+    DetectedLanguage string
+}
+
+// New creates the translator of a provider, using its public API URL if url
+// is empty.
+func New(provider, url, apiKey string, timeout time.Duration) (Translator, error) {
+    api := &client{url: strings.TrimSuffix(url, "/"), apiKey: apiKey, timeout: timeout}
+    switch provider {
+    case "deepl":
+        if api.url == "" {
+            // Free API keys end with :fx and use their own endpoint
+            api.url = "https://api.deepl.com"
+            if strings.HasSuffix(apiKey, ":fx") {
+                api.url = "https://api-free.deepl.com"
+            }
+        }
+        return &deepL{api}, nil
+    case "google":
+        if api.url == "" {
+            api.url = "https://translation.googleapis.com"
+        }
+        return &google{api}, nil
+    case "libretranslate":
+        if api.url == "" {
+            api.url = "https://libretranslate.com"
+        }
+        return &libreTranslate{api}, nil
+    default:
+        return nil, fmt.Errorf("unknown translation provider %q", provider)
+    }
+}
+
+type client struct {
+    url     string
+    apiKey  string
+    timeout time.Duration
+}
+
+func (c *client) post(ctx context.Context, path string, header http.Header, payload, result interface{}) error {
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(ctx, c.timeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    for key, values := range header {
+        req.Header[key] = values
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+    if err != nil {
+        return fmt.Errorf("failed to read response: %w", err)
+    }
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("translation API returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+    }
+    err = json.Unmarshal(data, result)
+    if err != nil {
+        return fmt.Errorf("invalid response: %w", err)
+    }
+    return nil
+}
+
+type deepL struct {
+    *client
+}
+
+func (d *deepL) Translate(ctx context.Context, text, target string) (*Result, error) {
+    var data struct {
+        Translations []struct {
+            DetectedSourceLanguage string `json:"detected_source_language"`
+            Text                   string `json:"text"`
+        } `json:"translations"`
+    }
+    header := http.Header{"Authorization": {"DeepL-Auth-Key " + d.apiKey}}
+    err := d.post(ctx, "/v2/translate", header, map[string]interface{}{
+        "text":        []string{text},
+        "target_lang": strings.ToUpper(target),
+    }, &data)
+    if err != nil {
+        return nil, err
+    } else if len(data.Translations) == 0 {
+        return nil, fmt.Errorf("response has no translation")
+    }
+    return &Result{
+        Text:             data.Translations[0].Text,
+        DetectedLanguage: strings.ToLower(data.Translations[0].DetectedSourceLanguage),
+    }, nil
+}
+
+type google struct {
+    *client
+}
+
+func (g *google) Translate(ctx context.Context, text, target string) (*Result, error) {
+    var data struct {
+        Data struct {
+            Translations []struct {
+                TranslatedText         string `json:"translatedText"`
+                DetectedSourceLanguage string `json:"detectedSourceLanguage"`
+            } `json:"translations"`
+        } `json:"data"`
+    }
+    header := http.Header{"X-Goog-Api-Key": {g.apiKey}}
+    err := g.post(ctx, "/language/translate/v2", header, map[string]interface{}{
+        "q":      []string{text},
+        "target": target,
+        "format": "text",
+    }, &data)
+    if err != nil {
+        return nil, err
+    } else if len(data.Data.Translations) == 0 {
+        return nil, fmt.Errorf("response has no translation")
+    }
+    return &Result{
+        Text:             data.Data.Translations[0].TranslatedText,
+        DetectedLanguage: strings.ToLower(data.Data.Translations[0].DetectedSourceLanguage),
+    }, nil
+}
+
+type libreTranslate struct {
+    *client
+}
+
+func (l *libreTranslate) Translate(ctx context.Context, text, target string) (*Result, error) {
+    var data struct {
+        TranslatedText   string `json:"translatedText"`
+        DetectedLanguage struct {
+            Language string `json:"language"`
+        } `json:"detectedLanguage"`
+    }
+    payload := map[string]interface{}{
+        "q":      text,
+        "source": "auto",
+        "target": target,
+        "format": "text",
+    }
+    if l.apiKey != "" {
+        payload["api_key"] = l.apiKey
+    }
+    err := l.post(ctx, "/translate", nil, payload, &data)
+    if err != nil {
+        return nil, err
+    }
+    return &Result{
+        Text:             data.TranslatedText,
+        DetectedLanguage: strings.ToLower(data.DetectedLanguage.Language),
+    }, nil
+}